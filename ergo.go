@@ -25,6 +25,7 @@ import (
 // set via linker flags, either by make or by goreleaser:
 var commit = ""  // git hash
 var version = "" // tagged version
+var date = ""    // build timestamp
 
 // get a password from stdin from the user
 func getPassword() string {
@@ -92,7 +93,7 @@ func doMkcerts(configFile string, quiet bool) {
 }
 
 func main() {
-	irc.SetVersionString(version, commit)
+	irc.SetVersionString(version, commit, date)
 	usage := `ergo.
 Usage:
 	ergo initdb [--conf <filename>] [--quiet]
@@ -155,7 +156,7 @@ Options:
 		}
 	}
 
-	logman, err := logger.NewManager(config.Logging)
+	logman, err := logger.NewManager(config.Logging.Outputs)
 	if err != nil {
 		log.Fatal("Logger did not load successfully:", err.Error())
 	}
@@ -183,6 +183,24 @@ CAPDEFS = [
         url="https://github.com/ircv3/ircv3-specifications/pull/466",
         standard="draft IRCv3",
     ),
+    CapDef(
+        identifier="PreAway",
+        name="draft/pre-away",
+        url="https://github.com/ircv3/ircv3-specifications/pull/514",
+        standard="proposed IRCv3",
+    ),
+    CapDef(
+        identifier="StandardReplies",
+        name="standard-replies",
+        url="https://ircv3.net/specs/extensions/standard-replies",
+        standard="IRCv3",
+    ),
+    CapDef(
+        identifier="Metadata",
+        name="draft/metadata-2",
+        url="https://github.com/ircv3/ircv3-specifications/pull/501",
+        standard="draft IRCv3",
+    ),
 ]
 
 def validate_defs():
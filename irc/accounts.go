@@ -4,27 +4,36 @@
 package irc
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
+	"github.com/ergochat/irc-go/ircfmt"
 	"github.com/ergochat/irc-go/ircutils"
 	"github.com/tidwall/buntdb"
 	"github.com/xdg-go/scram"
 
 	"github.com/ergochat/ergo/irc/connection_limits"
 	"github.com/ergochat/ergo/irc/email"
+	"github.com/ergochat/ergo/irc/ldap"
 	"github.com/ergochat/ergo/irc/migrations"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/oauth2"
 	"github.com/ergochat/ergo/irc/passwd"
+	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webhooks"
 )
 
 const (
@@ -32,6 +41,7 @@ const (
 	keyAccountVerified         = "account.verified %s"
 	keyAccountUnregistered     = "account.unregistered %s"
 	keyAccountVerificationCode = "account.verificationcode %s"
+	keyAccountVerifyResends    = "account.verifyresends %s"
 	keyAccountName             = "account.name %s" // stores the 'preferred name' of the account, not casemapped
 	keyAccountRegTime          = "account.registered.time %s"
 	keyAccountCredentials      = "account.credentials %s"
@@ -39,18 +49,30 @@ const (
 	keyAccountSettings         = "account.settings %s"
 	keyAccountVHost            = "account.vhost %s"
 	keyCertToAccount           = "account.creds.certfp %s"
-	keyAccountChannels         = "account.channels %s" // channels registered to the account
+	keyAccountChannels         = "account.channels %s"      // channels registered to the account
+	keyAccountChannelAmodes    = "account.channelamodes %s" // channels where the account has amodes, maintained by ChannelRegistry
 	keyAccountLastSeen         = "account.lastseen %s"
 	keyAccountModes            = "account.modes %s"     // user modes for the always-on client as a string
 	keyAccountRealname         = "account.realname %s"  // client realname stored as string
 	keyAccountSuspended        = "account.suspended %s" // client realname stored as string
 	keyAccountPwReset          = "account.pwreset %s"
 	keyAccountEmailChange      = "account.emailchange %s"
+	keyAccountReadReceipts     = "account.readreceipts %s" // map of DM correspondents to ReadReceipt
+	keyAccountAccessMasks      = "account.accessmasks %s"  // JSON list of user@host masks for NS ACCESS
+	keyAccountLoginFailures    = "account.loginfailures %s"
+	keyAccountTOTP             = "account.totp %s"         // JSON totpRecord for NS 2FA
+	keyAccountChannelLimit     = "account.channellimit %s" // CS SAREGISTER-LIMIT override of max-channels-per-account
 	// for an always-on client, a map of channel names they're in to their current modes
 	// (not to be confused with their amodes, which a non-always-on client can have):
 	keyAccountChannelToModes = "account.channeltomodes %s"
 
 	maxCertfpsPerAccount = 5
+
+	rehashQueueSize = 128
+
+	// limit on NS VERIFY RESEND:
+	verifyResendMax    = 3
+	verifyResendWindow = time.Hour
 )
 
 // everything about accounts is persistent; therefore, the database is the authoritative
@@ -66,6 +88,17 @@ type AccountManager struct {
 	skeletonToAccount map[string]string
 	accountToMethod   map[string]NickEnforcementMethod
 	registerThrottle  connection_limits.GenericThrottle
+
+	// passwords whose bcrypt cost is below the configured cost,
+	// to be rehashed off the login path:
+	rehashQueue    chan rehashRequest
+	rehashUpgrades uint64 // atomic
+}
+
+type rehashRequest struct {
+	accountName string
+	oldHash     []byte
+	passphrase  string
 }
 
 func (am *AccountManager) Initialize(server *Server) {
@@ -74,6 +107,10 @@ func (am *AccountManager) Initialize(server *Server) {
 	am.skeletonToAccount = make(map[string]string)
 	am.accountToMethod = make(map[string]NickEnforcementMethod)
 	am.server = server
+	if am.rehashQueue == nil {
+		am.rehashQueue = make(chan rehashRequest, rehashQueueSize)
+		go am.rehashWorker()
+	}
 
 	config := server.Config()
 	am.buildNickToAccountIndex(config)
@@ -478,11 +515,28 @@ func (am *AccountManager) Register(client *Client, account string, callbackNames
 			am.server.logger.Info("accounts",
 				fmt.Sprintf("nickname %s registered account %s, pending verification", client.Nick(), account))
 		}
-		return am.server.store.Update(func(tx *buntdb.Tx) error {
+		err = am.server.store.Update(func(tx *buntdb.Tx) error {
 			_, _, err = tx.Set(verificationCodeKey, code, setOptions)
 			return err
 		})
+		if err == nil {
+			var emailAddr string
+			if callbackNamespace == "mailto" {
+				emailAddr = callbackValue
+			}
+			am.dispatchWebhook(webhooks.EventRegister, account, emailAddr, client)
+		}
+		return err
+	}
+}
+
+// dispatchWebhook notifies the configured webhooks of an account event
+func (am *AccountManager) dispatchWebhook(event, accountName, emailAddr string, client *Client) {
+	var ip net.IP
+	if client != nil {
+		ip = client.IP()
 	}
+	am.server.webhooks.Dispatch(webhooks.NewPayload(event, accountName, emailAddr, ip))
 }
 
 type registrationCallbackError struct {
@@ -703,6 +757,56 @@ func (am *AccountManager) loadRealname(account string) (realname string) {
 	return
 }
 
+// ReadReceipt records how far an account has read in a direct message conversation
+type ReadReceipt struct {
+	Msgid string
+	Time  time.Time
+}
+
+func (am *AccountManager) SetReadReceipt(account, correspondent, msgid string) (err error) {
+	key := fmt.Sprintf(keyAccountReadReceipts, account)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		receipts := make(map[string]ReadReceipt)
+		if rText, err := tx.Get(key); err == nil {
+			// if the stored value is corrupt, just overwrite it
+			json.Unmarshal([]byte(rText), &receipts)
+		}
+		receipts[correspondent] = ReadReceipt{
+			Msgid: msgid,
+			Time:  time.Now().UTC(),
+		}
+		text, err := json.Marshal(receipts)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(text), nil)
+		return err
+	})
+	if err != nil {
+		am.server.logger.Error("internal", "error persisting read receipt", account, err.Error())
+		err = errAccountUpdateFailed
+	}
+	return
+}
+
+func (am *AccountManager) LoadReadReceipt(account, correspondent string) (receipt ReadReceipt, found bool) {
+	key := fmt.Sprintf(keyAccountReadReceipts, account)
+	var rText string
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		rText, _ = tx.Get(key)
+		return nil
+	})
+	if rText == "" {
+		return
+	}
+	var receipts map[string]ReadReceipt
+	if err := json.Unmarshal([]byte(rText), &receipts); err != nil {
+		return
+	}
+	receipt, found = receipts[correspondent]
+	return
+}
+
 func (am *AccountManager) addRemoveCertfp(account, certfp string, add bool, hasPrivs bool) (err error) {
 	certfp, err = utils.NormalizeCertfp(certfp)
 	if err != nil {
@@ -811,6 +915,100 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, account string,
 	return
 }
 
+// ResendVerificationCode regenerates the verification code for an unverified
+// account, invalidating the old one, and e-mails it to the address given at
+// registration. Unless privileged, this is limited to verifyResendMax
+// successful resends per account per verifyResendWindow; the resend history
+// is stored with the registration, so it persists across restarts.
+func (am *AccountManager) ResendVerificationCode(client *Client, account string, privileged bool) (err error) {
+	casefoldedAccount, err := CasefoldName(account)
+	if err != nil || account == "*" {
+		return errAccountDoesNotExist
+	}
+	if !am.server.Config().Accounts.Registration.EmailVerification.Enabled {
+		return errFeatureDisabled
+	}
+
+	accountKey := fmt.Sprintf(keyAccountExists, casefoldedAccount)
+	verificationCodeKey := fmt.Sprintf(keyAccountVerificationCode, casefoldedAccount)
+	verifyResendsKey := fmt.Sprintf(keyAccountVerifyResends, casefoldedAccount)
+
+	var raw rawClientAccount
+	var settings AccountSettings
+	var setOptions *buntdb.SetOptions
+	now := time.Now().UTC()
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		raw, err = am.loadRawAccount(tx, casefoldedAccount)
+		if err != nil {
+			return err
+		} else if raw.Verified {
+			return errAccountAlreadyVerified
+		}
+		json.Unmarshal([]byte(raw.Settings), &settings)
+		if settings.Email == "" {
+			return errValidEmailRequired
+		}
+		// the resend history and new code must expire along with the registration
+		if ttl, err := tx.TTL(accountKey); err == nil && 0 < ttl {
+			setOptions = &buntdb.SetOptions{Expires: true, TTL: ttl}
+		}
+
+		var resends []time.Time
+		if rawResends, err := tx.Get(verifyResendsKey); err == nil {
+			json.Unmarshal([]byte(rawResends), &resends)
+		}
+		recent := resends[:0]
+		for _, resend := range resends {
+			if now.Sub(resend) < verifyResendWindow {
+				recent = append(recent, resend)
+			}
+		}
+		if !privileged && verifyResendMax <= len(recent) {
+			return errLimitExceeded
+		}
+		// record the resend now, so concurrent requests can't exceed the limit
+		resendsBytes, _ := json.Marshal(append(recent, now))
+		tx.Set(verifyResendsKey, string(resendsBytes), setOptions)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	code, err := am.dispatchMailtoCallback(client, raw.Name, settings.Email)
+	if err != nil {
+		// only successful sends count against the limit
+		am.server.store.Update(func(tx *buntdb.Tx) error {
+			var resends []time.Time
+			if rawResends, err := tx.Get(verifyResendsKey); err == nil {
+				json.Unmarshal([]byte(rawResends), &resends)
+			}
+			for i, resend := range resends {
+				if resend.Equal(now) {
+					resends = append(resends[:i], resends[i+1:]...)
+					break
+				}
+			}
+			resendsBytes, _ := json.Marshal(resends)
+			tx.Set(verifyResendsKey, string(resendsBytes), setOptions)
+			return nil
+		})
+		return &registrationCallbackError{underlying: err}
+	}
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(accountKey); err != nil {
+			// expired or deleted in the meantime
+			return errAccountDoesNotExist
+		}
+		_, _, err = tx.Set(verificationCodeKey, code, setOptions)
+		return err
+	})
+	if err == nil {
+		am.server.logger.Info("accounts", "resent verification code for account", raw.Name)
+	}
+	return
+}
+
 func (am *AccountManager) Verify(client *Client, account string, code string) error {
 	casefoldedAccount, err := CasefoldName(account)
 	var skeleton string
@@ -892,6 +1090,7 @@ func (am *AccountManager) Verify(client *Client, account string, code string) er
 			tx.Set(verifiedKey, "1", nil)
 			// don't need the code anymore
 			tx.Delete(verificationCodeKey)
+			tx.Delete(fmt.Sprintf(keyAccountVerifyResends, casefoldedAccount))
 			// re-set all other keys, removing the TTL
 			tx.Set(accountKey, "1", nil)
 			tx.Set(accountNameKey, raw.Name, nil)
@@ -933,6 +1132,11 @@ func (am *AccountManager) Verify(client *Client, account string, code string) er
 	if err != nil {
 		return err
 	}
+	am.dispatchWebhook(webhooks.EventVerify, clientAccount.Name, clientAccount.Settings.Email, client)
+	// every path that creates an account ends here, including SAREGISTER
+	// and autocreation by LDAP and the other external auth backends
+	// (via SARegister), so this covers all newly created accounts
+	am.server.MigrateDirectMessages(casefoldedAccount)
 	if client != nil {
 		am.Login(client, clientAccount)
 		if client.AlwaysOn() {
@@ -1209,6 +1413,12 @@ func (am *AccountManager) SetNickReserved(client *Client, nick string, saUnreser
 		return errAccountNotLoggedIn
 	}
 
+	return am.setNickReservedForAccount(account, nick, cfnick, skeleton, saUnreserve, reserve, nrconfig.AdditionalNickLimit)
+}
+
+// setNickReservedForAccount (un)groups a nick to/from an account;
+// the caller must hold serialCacheUpdateMutex.
+func (am *AccountManager) setNickReservedForAccount(account, nick, cfnick, skeleton string, saUnreserve, reserve bool, nickLimit int) (err error) {
 	am.Lock()
 	accountForNick := am.nickToAccount[cfnick]
 	var accountForSkeleton string
@@ -1245,7 +1455,7 @@ func (am *AccountManager) SetNickReserved(client *Client, nick string, saUnreser
 		nicks := unmarshalReservedNicks(rawNicks)
 
 		if reserve {
-			if len(nicks) >= nrconfig.AdditionalNickLimit {
+			if len(nicks) >= nickLimit {
 				return errAccountTooManyNicks
 			}
 			nicks = append(nicks, nick)
@@ -1289,6 +1499,17 @@ func (am *AccountManager) SetNickReserved(client *Client, nick string, saUnreser
 	return nil
 }
 
+// checkAccountUsable checks whether an account whose credentials were
+// (or are about to be) verified is allowed to log in at all.
+func (am *AccountManager) checkAccountUsable(account ClientAccount) error {
+	if !account.Verified {
+		return errAccountUnverified
+	} else if account.Suspended != nil {
+		return &AccountSuspendedError{*account.Suspended}
+	}
+	return am.checkLockout(account.NameCasefolded)
+}
+
 func (am *AccountManager) checkPassphrase(accountName, passphrase string) (account ClientAccount, err error) {
 	account, err = am.LoadAccount(accountName)
 	// #1476: if grouped nicks are allowed, attempt to interpret accountName as a grouped nick
@@ -1310,13 +1531,17 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 		return
 	}
 
-	if !account.Verified {
-		err = errAccountUnverified
-		return
-	} else if account.Suspended != nil {
-		err = errAccountSuspended
+	if err = am.checkAccountUsable(account); err != nil {
 		return
 	}
+	// failures are cleared only once the second factor (if any) is also
+	// verified, in checkTOTP, so that a known password can't be used to
+	// reset the lockout while guessing TOTP codes
+	defer func() {
+		if err == errAccountInvalidCredentials {
+			am.recordLoginFailure(account.NameCasefolded)
+		}
+	}()
 
 	switch account.Credentials.Version {
 	case 0:
@@ -1329,6 +1554,8 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 			// XXX: if the account was created prior to 2.8, it doesn't have SCRAM credentials;
 			// since we temporarily have access to a valid plaintext password, create them:
 			am.rehashPassword(account.Name, passphrase)
+		} else if err == nil {
+			am.queueCostUpgrade(account, passphrase)
 		}
 	case -1:
 		err = am.checkLegacyPassphrase(migrations.CheckAthemePassphrase, accountName, account.Credentials.PassphraseHash, passphrase)
@@ -1340,6 +1567,149 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 	return
 }
 
+// loginFailureRecord tracks failed password logins to an account,
+// for the purposes of the login lockout
+type loginFailureRecord struct {
+	Attempts    int
+	WindowStart time.Time
+	LockedUntil time.Time
+}
+
+func (am *AccountManager) loadLoginFailures(cfAccount string) (record loginFailureRecord) {
+	var recordStr string
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		recordStr, _ = tx.Get(fmt.Sprintf(keyAccountLoginFailures, cfAccount))
+		return nil
+	})
+	if recordStr != "" {
+		json.Unmarshal([]byte(recordStr), &record)
+	}
+	return
+}
+
+// checkLockout returns an error if the account is locked due to
+// repeated authentication failures
+func (am *AccountManager) checkLockout(cfAccount string) error {
+	if !am.server.Config().Accounts.LoginLockout.Enabled {
+		return nil
+	}
+	record := am.loadLoginFailures(cfAccount)
+	if remaining := time.Until(record.LockedUntil); remaining > 0 {
+		return &AccountLockedError{remaining.Round(time.Second)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed password login to the account,
+// locking it if the configured threshold has been reached
+func (am *AccountManager) recordLoginFailure(cfAccount string) {
+	config := am.server.Config().Accounts.LoginLockout
+	if !config.Enabled {
+		return
+	}
+
+	key := fmt.Sprintf(keyAccountLoginFailures, cfAccount)
+	now := time.Now().UTC()
+	locked := false
+	am.server.store.Update(func(tx *buntdb.Tx) error {
+		var record loginFailureRecord
+		if recordStr, err := tx.Get(key); err == nil {
+			json.Unmarshal([]byte(recordStr), &record)
+		}
+		if now.Sub(record.WindowStart) > config.Window || !record.LockedUntil.IsZero() {
+			// start a new window (including after a lockout has expired)
+			record = loginFailureRecord{WindowStart: now}
+		}
+		record.Attempts++
+		ttl := config.Window - now.Sub(record.WindowStart)
+		if record.Attempts >= config.MaxAttempts {
+			record.LockedUntil = now.Add(config.Duration)
+			ttl = config.Duration
+			locked = true
+		}
+		recordBytes, _ := json.Marshal(record)
+		tx.Set(key, string(recordBytes), &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return nil
+	})
+
+	if locked {
+		am.server.logger.Warning("accounts", "locking account due to repeated login failures", cfAccount)
+		am.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Account $c[grey][$r%s$c[grey]] was locked for %v due to repeated login failures"), cfAccount, config.Duration))
+		for _, client := range am.AccountToClients(cfAccount) {
+			client.Notice(fmt.Sprintf(client.t("Your account was locked for %v due to repeated failed login attempts"), config.Duration))
+		}
+	}
+}
+
+// ChannelLimit returns the maximum number of channels the account may register,
+// and whether this is a per-account override (see CS SAREGISTER-LIMIT)
+func (am *AccountManager) ChannelLimit(cfAccount string) (limit int, override bool) {
+	limit = am.server.Config().Channels.Registration.MaxChannelsPerAccount
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		if limitStr, err := tx.Get(fmt.Sprintf(keyAccountChannelLimit, cfAccount)); err == nil {
+			if value, err := strconv.Atoi(limitStr); err == nil {
+				limit, override = value, true
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// SetChannelLimit overrides the maximum number of channels the account may
+// register; a negative limit removes the override.
+func (am *AccountManager) SetChannelLimit(account string, limit int) (err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, cfAccount)); err != nil {
+			return errAccountDoesNotExist
+		}
+		key := fmt.Sprintf(keyAccountChannelLimit, cfAccount)
+		if limit < 0 {
+			tx.Delete(key)
+		} else {
+			tx.Set(key, strconv.Itoa(limit), nil)
+		}
+		return nil
+	})
+}
+
+func (am *AccountManager) clearLoginFailures(cfAccount string) {
+	key := fmt.Sprintf(keyAccountLoginFailures, cfAccount)
+	var exists bool
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(key)
+		exists = err == nil
+		return nil
+	})
+	if exists {
+		am.server.store.Update(func(tx *buntdb.Tx) error {
+			tx.Delete(key)
+			return nil
+		})
+	}
+}
+
+// ClearLockout implements NS UNLOCK, removing a login lockout from an account
+func (am *AccountManager) ClearLockout(account string) (err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	if _, err = am.LoadAccount(cfAccount); err != nil {
+		return
+	}
+	if time.Until(am.loadLoginFailures(cfAccount).LockedUntil) <= 0 {
+		return errNoop
+	}
+	am.clearLoginFailures(cfAccount)
+	return nil
+}
+
 func (am *AccountManager) checkLegacyPassphrase(check migrations.PassphraseCheck, account string, hash []byte, passphrase string) (err error) {
 	err = check(hash, []byte(passphrase))
 	if err != nil {
@@ -1360,6 +1730,81 @@ func (am *AccountManager) rehashPassword(accountName, passphrase string) {
 	}
 }
 
+// queueCostUpgrade schedules a rehash of the passphrase if its stored hash
+// uses a lower bcrypt cost than is currently configured. This is done
+// asynchronously, since bcrypt at the new cost is slow by design.
+func (am *AccountManager) queueCostUpgrade(account ClientAccount, passphrase string) {
+	config := am.server.Config()
+	if !config.Accounts.rehashOnLogin {
+		return
+	}
+	cost, err := passwd.Cost(account.Credentials.PassphraseHash)
+	if err != nil || uint(cost) >= config.Accounts.Registration.BcryptCost {
+		return
+	}
+	select {
+	case am.rehashQueue <- rehashRequest{accountName: account.Name, oldHash: account.Credentials.PassphraseHash, passphrase: passphrase}:
+	default:
+		// the worker is backed up; we'll get another chance at the next login
+	}
+}
+
+func (am *AccountManager) rehashWorker() {
+	defer am.server.HandlePanic()
+
+	for request := range am.rehashQueue {
+		err := am.upgradePasswordHash(request)
+		if err == nil {
+			count := atomic.AddUint64(&am.rehashUpgrades, 1)
+			am.server.logger.Info("accounts", "upgraded bcrypt cost of password hash for account", request.accountName,
+				fmt.Sprintf("(%d upgraded since startup)", count))
+		} else if err != errNoop {
+			am.server.logger.Error("internal", "could not upgrade password hash for account", request.accountName, err.Error())
+		}
+	}
+}
+
+// upgradePasswordHash replaces the password hash with a new one at the current
+// cost, as long as it hasn't been changed since the login that queued the request.
+func (am *AccountManager) upgradePasswordHash(request rehashRequest) (err error) {
+	cfAccount, err := CasefoldName(request.accountName)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	credKey := fmt.Sprintf(keyAccountCredentials, cfAccount)
+
+	var credStr string
+	err = am.server.store.View(func(tx *buntdb.Tx) (err error) {
+		credStr, err = tx.Get(credKey)
+		return
+	})
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	var creds AccountCredentials
+	if err = json.Unmarshal([]byte(credStr), &creds); err != nil {
+		return
+	}
+	if !bytes.Equal(creds.PassphraseHash, request.oldHash) {
+		// password was changed in the meantime
+		return errNoop
+	}
+	if err = creds.SetPassphrase(request.passphrase, am.server.Config().Accounts.Registration.BcryptCost); err != nil {
+		return
+	}
+	newCredStr, err := creds.Serialize()
+	if err != nil {
+		return
+	}
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if curCredStr, _ := tx.Get(credKey); curCredStr != credStr {
+			return errCASFailed
+		}
+		_, _, err := tx.Set(credKey, newCredStr, nil)
+		return err
+	})
+}
+
 func (am *AccountManager) loadWithAutocreation(accountName string, autocreate bool) (account ClientAccount, err error) {
 	account, err = am.LoadAccount(accountName)
 	if err == errAccountDoesNotExist && autocreate {
@@ -1372,7 +1817,7 @@ func (am *AccountManager) loadWithAutocreation(accountName string, autocreate bo
 	return
 }
 
-func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName string, passphrase string) (err error) {
+func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName, passphrase, certfp string) (err error) {
 	// XXX check this now, so we don't allow a redundant login for an always-on client
 	// even for a brief period. the other potential source of nick-account conflicts
 	// is from force-nick-equals-account, but those will be caught later by
@@ -1383,20 +1828,76 @@ func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName s
 		}
 	}
 
-	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
-		return &ThrottleError{remainingTime}
+	return am.AuthenticateByPassphraseAndTOTP(client, accountName, passphrase, certfp, "")
+}
+
+// AuthenticateByPassphraseAndTOTP is AuthenticateByPassphrase with a
+// two-factor authentication code, which is required if the account has
+// 2FA enabled (see NS 2FA).
+func (am *AccountManager) AuthenticateByPassphraseAndTOTP(client *Client, accountName, passphrase, certfp, code string) (err error) {
+	account, err := am.VerifyPassphrase(client, accountName, passphrase, certfp)
+	if err == nil {
+		err = am.checkTOTP(account.NameCasefolded, code)
 	}
+	if err == nil {
+		am.Login(client, account)
+	}
+	return err
+}
 
-	var account ClientAccount
+// VerifyPassphrase checks a passphrase against all configured authentication
+// backends (subject to the client's login throttle), without logging in.
+// certfp is the fingerprint of the client certificate of the session
+// that supplied the passphrase, if any; it is passed to the auth endpoint.
+func (am *AccountManager) VerifyPassphrase(client *Client, accountName, passphrase, certfp string) (account ClientAccount, err error) {
+	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
+		err = &ThrottleError{remainingTime}
+		return
+	}
 
-	defer func() {
+	config := am.server.Config()
+	if config.Accounts.LDAP.Enabled {
+		var result ldap.Result
+		result, err = ldap.CheckPassphrase(&config.Accounts.LDAP, accountName, passphrase)
 		if err == nil {
-			am.Login(client, account)
+			account, err = am.loadWithAutocreation(result.AccountName, config.Accounts.LDAP.Autocreate)
+			if err == nil {
+				err = am.checkAccountUsable(account)
+			}
+			if err == nil && result.Email != "" && result.Email != account.Settings.Email {
+				am.syncEmail(account.NameCasefolded, result.Email)
+				account.Settings.Email = result.Email
+			}
+			return
+		} else if err != ldap.ErrInvalidCredentials {
+			// the directory is down: this shouldn't count against the user
+			am.server.logger.Error("internal", "failed ldap authentication", err.Error())
+			client.refundLoginThrottle()
+			err = errAuthServiceUnavailable
+			return
 		}
-	}()
+		// invalid credentials: fall through to local accounts
+	}
 
-	config := am.server.Config()
-	if config.Accounts.AuthScript.Enabled {
+	if config.Accounts.AuthExternal.Endpoint.Enabled {
+		// fail closed: if the endpoint is unavailable, don't fall back to local credentials
+		var output AuthScriptOutput
+		output, err = am.server.authEndpoint.Check(AuthScriptInput{AccountName: accountName, Passphrase: passphrase, Certfp: certfp, IP: client.IP().String()})
+		if err != nil {
+			am.server.logger.Error("internal", "failed auth endpoint request", err.Error())
+			err = errAuthServiceUnavailable
+			return
+		} else if output.Success {
+			if output.AccountName != "" {
+				accountName = output.AccountName
+			}
+			account, err = am.loadWithAutocreation(accountName, config.Accounts.AuthExternal.Autocreate)
+			if err == nil {
+				err = am.checkAccountUsable(account)
+			}
+			return
+		}
+	} else if config.Accounts.AuthScript.Enabled {
 		var output AuthScriptOutput
 		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
 			AuthScriptInput{AccountName: accountName, Passphrase: passphrase, IP: client.IP().String()})
@@ -1412,11 +1913,134 @@ func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName s
 	}
 
 	account, err = am.checkPassphrase(accountName, passphrase)
-	return err
+	return
 }
 
-// AllNicks returns the uncasefolded nicknames for all accounts, including additional (grouped) nicks.
-func (am *AccountManager) AllNicks() (result []string) {
+// AuthenticateByPassphraseAs implements SASL PLAIN with an authzid that differs
+// from the authcid: the authcid's credentials are verified (with VerifyPassphrase,
+// so external authentication backends apply), and if the authcid
+// account is linked to an oper block with the `impersonate` capability, the
+// client is logged into the authzid account instead.
+func (am *AccountManager) AuthenticateByPassphraseAs(client *Client, authcid, authzid, passphrase, certfp string) (err error) {
+	authcAccount, err := am.VerifyPassphrase(client, authcid, passphrase, certfp)
+	if err != nil {
+		return err
+	}
+	if err = am.checkTOTP(authcAccount.NameCasefolded, ""); err != nil {
+		return err
+	}
+
+	oper := am.server.GetOperatorForAccount(authcAccount.NameCasefolded)
+	if !oper.HasRoleCapab("impersonate") {
+		am.server.logger.Warning("opers", "denied impersonation attempt by account", authcAccount.Name, "for", authzid)
+		return errAuthzidAuthcidMismatch
+	}
+
+	if client.registered {
+		if clientAlready := am.server.clients.Get(authzid); clientAlready != nil && clientAlready.AlwaysOn() {
+			return errNickAccountMismatch
+		}
+	}
+
+	account, err := am.LoadAccount(authzid)
+	if err != nil {
+		return err
+	} else if !account.Verified {
+		return errAccountUnverified
+	} else if account.Suspended != nil {
+		return &AccountSuspendedError{*account.Suspended}
+	}
+
+	am.server.logger.Info("opers", fmt.Sprintf("Account %s (oper %s) authenticated as account %s via SASL authzid, from %s", authcAccount.Name, oper.Name, account.Name, client.IP().String()))
+	am.server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Account $c[grey][$r%s$c[grey]] (oper $c[grey][$r%s$c[grey]]) authenticated as account $c[grey][$r%s$c[grey]] via SASL authzid"), authcAccount.Name, oper.Name, account.Name))
+
+	am.Login(client, account)
+	return nil
+}
+
+// syncEmail records an email address from an external identity provider
+// as the account's (verified) email
+func (am *AccountManager) syncEmail(cfAccount, emailAddr string) {
+	_, err := am.ModifyAccountSettings(cfAccount, func(in AccountSettings) (out AccountSettings, err error) {
+		out = in
+		out.Email = emailAddr
+		return
+	})
+	if err != nil {
+		am.server.logger.Error("internal", "could not sync email for account", cfAccount, err.Error())
+	}
+}
+
+// AuthenticateByBearerToken validates an OAuth2 bearer token issued by the
+// configured identity provider. If authzid is nonempty, it must match the
+// account name the token maps to. Errors that can be detected immediately
+// are returned; otherwise, the IdP is consulted off the session's main
+// goroutine (see Session.runAsync) and the outcome is passed to `complete`.
+// On success, the caller is responsible for calling Login.
+func (am *AccountManager) AuthenticateByBearerToken(session *Session, authzid, token string, complete func(rb *ResponseBuffer, account ClientAccount, err error)) (err error) {
+	client := session.client
+	if throttled, remainingTime := client.checkLoginThrottle(); throttled {
+		return &ThrottleError{remainingTime}
+	}
+
+	config := am.server.Config()
+	oauthConfig := &config.Accounts.AuthExternal.OAuth2
+	if !oauthConfig.Enabled {
+		return errFeatureDisabled
+	}
+
+	var accountName string
+	var introspectErr error
+	session.runAsync(func() {
+		// Introspect bounds the wait for the IdP with oauthConfig.Timeout
+		accountName, introspectErr = oauthConfig.Introspect(context.Background(), token)
+	}, func(rb *ResponseBuffer) {
+		if introspectErr != nil {
+			if introspectErr != oauth2.ErrInvalidToken && introspectErr != oauth2.ErrMissingClaim {
+				am.server.logger.Error("internal", "failed oauth2 token validation", introspectErr.Error())
+			}
+			complete(rb, ClientAccount{}, errAccountInvalidCredentials)
+			return
+		}
+		account, err := am.loadBearerTokenAccount(client, config, authzid, accountName)
+		complete(rb, account, err)
+	})
+	return nil
+}
+
+// loadBearerTokenAccount checks that the account a validated bearer token
+// maps to can be logged into.
+func (am *AccountManager) loadBearerTokenAccount(client *Client, config *Config, authzid, accountName string) (account ClientAccount, err error) {
+	if authzid != "" {
+		cfAuthzid, authzidErr := CasefoldName(authzid)
+		cfAccount, accountErr := CasefoldName(accountName)
+		if authzidErr != nil || accountErr != nil || cfAuthzid != cfAccount {
+			return account, errAuthzidAuthcidMismatch
+		}
+	}
+
+	if client.registered {
+		if clientAlready := am.server.clients.Get(accountName); clientAlready != nil && clientAlready.AlwaysOn() {
+			return account, errNickAccountMismatch
+		}
+	}
+
+	account, err = am.loadWithAutocreation(accountName, config.Accounts.AuthExternal.Autocreate)
+	if err != nil {
+		return
+	}
+	if !account.Verified {
+		return account, errAccountUnverified
+	} else if account.Suspended != nil {
+		return account, &AccountSuspendedError{*account.Suspended}
+	}
+	// a bearer token is a replacement for the password, not for the second factor
+	err = am.checkTOTP(account.NameCasefolded, "")
+	return
+}
+
+// AllNicks returns the uncasefolded nicknames for all accounts, including additional (grouped) nicks.
+func (am *AccountManager) AllNicks() (result []string) {
 	accountNamePrefix := fmt.Sprintf(keyAccountName, "")
 	accountAdditionalNicksPrefix := fmt.Sprintf(keyAccountAdditionalNicks, "")
 
@@ -1611,6 +2235,10 @@ func (am *AccountManager) Suspend(accountName string, duration time.Duration, op
 		am.server.logger.Error("internal", "couldn't persist suspension", account, err.Error())
 	} // keep going
 
+	if suspended, err := am.LoadAccount(account); err == nil {
+		am.dispatchWebhook(webhooks.EventSuspend, suspended.Name, suspended.Settings.Email, nil)
+	}
+
 	am.Lock()
 	clients := am.accountToClients[account]
 	delete(am.accountToClients, account)
@@ -1720,18 +2348,243 @@ func (am *AccountManager) Rename(oldName, newName string) (err error) {
 	return nil
 }
 
-func (am *AccountManager) Unregister(account string, erase bool) error {
+// accountMergeItem records the fate of one item during an account merge
+type accountMergeItem struct {
+	Kind   string // "nick", "certfp", "vhost", "founder", "amode", "role", or "history"
+	Item   string
+	Moved  bool
+	Reason error // why the item was not moved, if it wasn't
+}
+
+// Merge implements NS SAMERGE: it moves grouped nicks, certfps, the vhost,
+// channel founderships, amodes, roles and history from the account `from`
+// into the account `to`, and erases `from`; clients logged into `from` are
+// logged into `to`. Conflicts are resolved in favor of `to`. The credentials
+// are moved (and `from` erased) first, in a single transaction, so that if
+// that fails, nothing else has been changed.
+func (am *AccountManager) Merge(from, to string) (items []accountMergeItem, err error) {
+	source, err := am.LoadAccount(from)
+	if err != nil {
+		return
+	}
+	dest, err := am.LoadAccount(to)
+	if err != nil {
+		return
+	}
+	cfFrom, cfTo := source.NameCasefolded, dest.NameCasefolded
+	if cfFrom == cfTo {
+		return nil, errAccountMergeSelf
+	}
+	if am.hasAlwaysOnClient(cfFrom) || am.hasAlwaysOnClient(cfTo) {
+		return nil, errAccountMergeAlwaysOn
+	}
+
+	accountItems, clients, erased, err := am.mergeCredentials(source, cfTo)
+	if err != nil {
+		return
+	}
+	items = append(items, accountItems...)
+	am.dispatchUnregisterWebhook(erased)
+
+	// `from` is gone; channels and history can now safely refer to `to`
+	for _, channel := range am.server.channels.Channels() {
+		if !channel.IsRegistered() {
+			continue
+		}
+		result := channel.mergeAccount(cfFrom, cfTo)
+		if !result.changed() {
+			continue
+		}
+		chname := channel.Name()
+		if result.founder {
+			items = append(items, accountMergeItem{Kind: "founder", Item: chname, Moved: true})
+		} else if result.fromMode != modes.Mode(0) {
+			item := accountMergeItem{Kind: "amode", Item: fmt.Sprintf("%s +%s", chname, result.fromMode)}
+			if result.toMode != modes.Mode(0) {
+				item.Reason = errNoop
+			} else {
+				item.Moved = true
+			}
+			items = append(items, item)
+		}
+		if result.fromRole != "" {
+			item := accountMergeItem{Kind: "role", Item: fmt.Sprintf("%s %s", chname, result.fromRole)}
+			if result.toRole != "" {
+				item.Reason = errNoop
+			} else {
+				item.Moved = true
+			}
+			items = append(items, item)
+		}
+		if err := channel.Store(IncludeAllAttrs); err != nil {
+			am.server.logger.Error("internal", "couldn't store merged channel", chname, err.Error())
+		}
+	}
+
+	if count, err := am.server.historyDB.UpdateAccountName(cfFrom, cfTo); err != nil {
+		items = append(items, accountMergeItem{Kind: "history", Item: "messages", Reason: err})
+	} else if count != 0 {
+		items = append(items, accountMergeItem{Kind: "history", Item: fmt.Sprintf("%d messages", count), Moved: true})
+	}
+
+	// the source's clients stay connected, logged into the destination
+	destAccount, err := am.LoadAccount(cfTo)
+	if err != nil {
+		am.killClients(clients)
+		return items, nil
+	}
 	config := am.server.Config()
-	casefoldedAccount, err := CasefoldName(account)
+	for _, client := range clients {
+		am.Login(client, destAccount)
+		sessions := client.Sessions()
+		if len(sessions) == 0 {
+			continue
+		}
+		rb := NewResponseBuffer(sessions[0])
+		sendSuccessfulAccountAuth(nil, client, rb, false)
+		fixupNickEqualsAccount(client, rb, config, "")
+		rb.Send(true)
+	}
+
+	return items, nil
+}
+
+// mergeCredentials moves grouped nicks, certfps and the vhost of `source` to
+// the account `cfTo`, and erases `source`, all in a single transaction.
+// It returns the clients that were logged into `source`.
+func (am *AccountManager) mergeCredentials(source ClientAccount, cfTo string) (items []accountMergeItem, clients []*Client, erased erasedAccount, err error) {
+	cfFrom := source.NameCasefolded
+	nickLimit := am.server.Config().Accounts.NickReservation.AdditionalNickLimit
+	var movedNicks []string
+
+	am.serialCacheUpdateMutex.Lock()
+	defer am.serialCacheUpdateMutex.Unlock()
+
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		credKey := fmt.Sprintf(keyAccountCredentials, cfTo)
+		credStr, err := tx.Get(credKey)
+		if err != nil {
+			return errAccountDoesNotExist
+		}
+		var creds AccountCredentials
+		if err := json.Unmarshal([]byte(credStr), &creds); err != nil {
+			return err
+		}
+
+		nicksKey := fmt.Sprintf(keyAccountAdditionalNicks, cfTo)
+		rawNicks, _ := tx.Get(nicksKey)
+		nicks := unmarshalReservedNicks(rawNicks)
+		for _, nick := range append([]string{source.Name}, source.AdditionalNicks...) {
+			item := accountMergeItem{Kind: "nick", Item: nick}
+			if len(nicks) < nickLimit {
+				nicks = append(nicks, nick)
+				movedNicks = append(movedNicks, nick)
+				item.Moved = true
+			} else {
+				item.Reason = errAccountTooManyNicks
+			}
+			items = append(items, item)
+		}
+		tx.Set(nicksKey, marshalReservedNicks(nicks), nil)
+
+		certfpsMoved := false
+		for _, certfp := range source.Credentials.Certfps {
+			item := accountMergeItem{Kind: "certfp", Item: certfp}
+			certfpKey := fmt.Sprintf(keyCertToAccount, certfp)
+			if item.Reason = creds.AddCertfp(certfp); item.Reason == nil {
+				item.Moved = true
+				certfpsMoved = true
+				tx.Set(certfpKey, cfTo, nil)
+			} else if account, err := tx.Get(certfpKey); err == nil && account == cfFrom {
+				tx.Delete(certfpKey)
+			}
+			items = append(items, item)
+		}
+		if certfpsMoved {
+			newCredStr, err := creds.Serialize()
+			if err != nil {
+				return err
+			}
+			tx.Set(credKey, newCredStr, nil)
+		}
+
+		if source.VHost.ApprovedVHost != "" {
+			item := accountMergeItem{Kind: "vhost", Item: source.VHost.ApprovedVHost}
+			vhostKey := fmt.Sprintf(keyAccountVHost, cfTo)
+			var vhost VHostInfo
+			if vhostStr, err := tx.Get(vhostKey); err == nil {
+				json.Unmarshal([]byte(vhostStr), &vhost)
+			}
+			if vhost.ApprovedVHost != "" {
+				item.Reason = errNoop
+			} else {
+				vhost.ApprovedVHost = source.VHost.ApprovedVHost
+				vhost.Enabled = true
+				vhostBytes, _ := json.Marshal(vhost)
+				tx.Set(vhostKey, string(vhostBytes), nil)
+				item.Moved = true
+			}
+			items = append(items, item)
+		}
+
+		erased, err = eraseAccountTx(tx, cfFrom, true)
+		return err
+	})
 	if err != nil {
-		return errAccountDoesNotExist
+		return nil, nil, erased, err
+	}
+
+	am.Lock()
+	defer am.Unlock()
+	for _, nick := range append([]string{source.Name}, source.AdditionalNicks...) {
+		cfnick, _ := CasefoldName(nick)
+		skeleton, _ := Skeleton(nick)
+		delete(am.nickToAccount, cfnick)
+		delete(am.skeletonToAccount, skeleton)
+	}
+	for _, nick := range movedNicks {
+		cfnick, _ := CasefoldName(nick)
+		skeleton, _ := Skeleton(nick)
+		am.nickToAccount[cfnick] = cfTo
+		am.skeletonToAccount[skeleton] = cfTo
+	}
+	clients = am.accountToClients[cfFrom]
+	delete(am.accountToClients, cfFrom)
+	return
+}
+
+func (am *AccountManager) hasAlwaysOnClient(cfAccount string) bool {
+	am.RLock()
+	defer am.RUnlock()
+	for _, client := range am.accountToClients[cfAccount] {
+		if client.AlwaysOn() {
+			return true
+		}
 	}
+	return false
+}
+
+// erasedAccount is the data of an account deleted by eraseAccountTx,
+// as needed to clean up after it
+type erasedAccount struct {
+	accountName     string
+	settingsStr     string
+	rawNicks        string
+	credText        string
+	channelsStr     string
+	keepProtections bool
+}
 
+// eraseAccountTx deletes an account's data within a transaction. Unless erase
+// is set, a verified account's name remains reserved as unregistered.
+// err is non-nil if the account had no credentials (i.e., didn't exist).
+func eraseAccountTx(tx *buntdb.Tx, casefoldedAccount string, erase bool) (result erasedAccount, err error) {
 	accountKey := fmt.Sprintf(keyAccountExists, casefoldedAccount)
 	accountNameKey := fmt.Sprintf(keyAccountName, casefoldedAccount)
 	registeredTimeKey := fmt.Sprintf(keyAccountRegTime, casefoldedAccount)
 	credentialsKey := fmt.Sprintf(keyAccountCredentials, casefoldedAccount)
 	verificationCodeKey := fmt.Sprintf(keyAccountVerificationCode, casefoldedAccount)
+	verifyResendsKey := fmt.Sprintf(keyAccountVerifyResends, casefoldedAccount)
 	verifiedKey := fmt.Sprintf(keyAccountVerified, casefoldedAccount)
 	nicksKey := fmt.Sprintf(keyAccountAdditionalNicks, casefoldedAccount)
 	settingsKey := fmt.Sprintf(keyAccountSettings, casefoldedAccount)
@@ -1745,6 +2598,64 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
 	pwResetKey := fmt.Sprintf(keyAccountPwReset, casefoldedAccount)
 	emailChangeKey := fmt.Sprintf(keyAccountEmailChange, casefoldedAccount)
+	readReceiptsKey := fmt.Sprintf(keyAccountReadReceipts, casefoldedAccount)
+	channelAmodesKey := fmt.Sprintf(keyAccountChannelAmodes, casefoldedAccount)
+	accessMasksKey := fmt.Sprintf(keyAccountAccessMasks, casefoldedAccount)
+	loginFailuresKey := fmt.Sprintf(keyAccountLoginFailures, casefoldedAccount)
+	totpKey := fmt.Sprintf(keyAccountTOTP, casefoldedAccount)
+	channelLimitKey := fmt.Sprintf(keyAccountChannelLimit, casefoldedAccount)
+
+	// get the unfolded account name; for an active account, this is
+	// stored under accountNameKey, for an unregistered account under unregisteredKey
+	result.accountName, _ = tx.Get(accountNameKey)
+	if result.accountName == "" {
+		result.accountName, _ = tx.Get(unregisteredKey)
+	}
+	if erase {
+		tx.Delete(unregisteredKey)
+	} else {
+		if _, err := tx.Get(verifiedKey); err == nil {
+			tx.Set(unregisteredKey, result.accountName, nil)
+			result.keepProtections = true
+		}
+	}
+	tx.Delete(accountKey)
+	tx.Delete(accountNameKey)
+	tx.Delete(verifiedKey)
+	tx.Delete(registeredTimeKey)
+	tx.Delete(verificationCodeKey)
+	tx.Delete(verifyResendsKey)
+	tx.Delete(accessMasksKey)
+	tx.Delete(loginFailuresKey)
+	tx.Delete(totpKey)
+	tx.Delete(channelLimitKey)
+	result.settingsStr, _ = tx.Get(settingsKey)
+	tx.Delete(settingsKey)
+	result.rawNicks, _ = tx.Get(nicksKey)
+	tx.Delete(nicksKey)
+	result.credText, err = tx.Get(credentialsKey)
+	tx.Delete(credentialsKey)
+	tx.Delete(vhostKey)
+	result.channelsStr, _ = tx.Get(channelsKey)
+	tx.Delete(channelsKey)
+	tx.Delete(joinedChannelsKey)
+	tx.Delete(lastSeenKey)
+	tx.Delete(modesKey)
+	tx.Delete(realnameKey)
+	tx.Delete(suspendedKey)
+	tx.Delete(pwResetKey)
+	tx.Delete(emailChangeKey)
+	tx.Delete(readReceiptsKey)
+	tx.Delete(channelAmodesKey)
+	return
+}
+
+func (am *AccountManager) Unregister(account string, erase bool) error {
+	config := am.server.Config()
+	casefoldedAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
 
 	var clients []*Client
 	defer func() {
@@ -1752,9 +2663,13 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	}()
 
 	var registeredChannels []string
-	// on our way out, unregister all the account's channels and delete them from the db
+	// on our way out, pass the account's channels to their successors,
+	// or else unregister them and delete them from the db
 	defer func() {
 		for _, channelName := range registeredChannels {
+			if channel := am.server.channels.Get(channelName); channel != nil && channel.succeed(casefoldedAccount) != "" {
+				continue
+			}
 			err := am.server.channels.SetUnregistered(channelName, casefoldedAccount)
 			if err != nil {
 				am.server.logger.Error("internal", "couldn't unregister channel", channelName, err.Error())
@@ -1762,57 +2677,23 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 		}
 	}()
 
-	var credText string
-	var rawNicks string
-
 	am.serialCacheUpdateMutex.Lock()
 	defer am.serialCacheUpdateMutex.Unlock()
 
-	var accountName string
-	var channelsStr string
-	keepProtections := false
+	var erased erasedAccount
 	am.server.store.Update(func(tx *buntdb.Tx) error {
-		// get the unfolded account name; for an active account, this is
-		// stored under accountNameKey, for an unregistered account under unregisteredKey
-		accountName, _ = tx.Get(accountNameKey)
-		if accountName == "" {
-			accountName, _ = tx.Get(unregisteredKey)
-		}
-		if erase {
-			tx.Delete(unregisteredKey)
-		} else {
-			if _, err := tx.Get(verifiedKey); err == nil {
-				tx.Set(unregisteredKey, accountName, nil)
-				keepProtections = true
-			}
-		}
-		tx.Delete(accountKey)
-		tx.Delete(accountNameKey)
-		tx.Delete(verifiedKey)
-		tx.Delete(registeredTimeKey)
-		tx.Delete(verificationCodeKey)
-		tx.Delete(settingsKey)
-		rawNicks, _ = tx.Get(nicksKey)
-		tx.Delete(nicksKey)
-		credText, err = tx.Get(credentialsKey)
-		tx.Delete(credentialsKey)
-		tx.Delete(vhostKey)
-		channelsStr, _ = tx.Get(channelsKey)
-		tx.Delete(channelsKey)
-		tx.Delete(joinedChannelsKey)
-		tx.Delete(lastSeenKey)
-		tx.Delete(modesKey)
-		tx.Delete(realnameKey)
-		tx.Delete(suspendedKey)
-		tx.Delete(pwResetKey)
-		tx.Delete(emailChangeKey)
-
+		erased, err = eraseAccountTx(tx, casefoldedAccount, erase)
 		return nil
 	})
+	accountName := erased.accountName
+
+	if accountName != "" {
+		am.dispatchUnregisterWebhook(erased)
+	}
 
 	if err == nil {
 		var creds AccountCredentials
-		if err := json.Unmarshal([]byte(credText), &creds); err == nil {
+		if err := json.Unmarshal([]byte(erased.credText), &creds); err == nil {
 			for _, cert := range creds.Certfps {
 				certFPKey := fmt.Sprintf(keyCertToAccount, cert)
 				am.server.store.Update(func(tx *buntdb.Tx) error {
@@ -1826,8 +2707,8 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	}
 
 	skeleton, _ := Skeleton(accountName)
-	additionalNicks := unmarshalReservedNicks(rawNicks)
-	registeredChannels = unmarshalRegisteredChannels(channelsStr)
+	additionalNicks := unmarshalReservedNicks(erased.rawNicks)
+	registeredChannels = unmarshalRegisteredChannels(erased.channelsStr)
 
 	am.Lock()
 	defer am.Unlock()
@@ -1835,7 +2716,7 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	clients = am.accountToClients[casefoldedAccount]
 	delete(am.accountToClients, casefoldedAccount)
 	// protect the account name itself where applicable, but not any grouped nicks
-	if !(keepProtections && config.Accounts.NickReservation.Method == NickEnforcementStrict) {
+	if !(erased.keepProtections && config.Accounts.NickReservation.Method == NickEnforcementStrict) {
 		delete(am.nickToAccount, casefoldedAccount)
 		delete(am.skeletonToAccount, skeleton)
 	}
@@ -1852,6 +2733,12 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	return nil
 }
 
+func (am *AccountManager) dispatchUnregisterWebhook(erased erasedAccount) {
+	var settings AccountSettings
+	json.Unmarshal([]byte(erased.settingsStr), &settings)
+	am.dispatchWebhook(webhooks.EventUnregister, erased.accountName, settings.Email, nil)
+}
+
 func unmarshalRegisteredChannels(channelsStr string) (result []string) {
 	if channelsStr != "" {
 		result = strings.Split(channelsStr, ",")
@@ -1888,7 +2775,7 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 			err = errAccountUnverified
 			return
 		} else if clientAccount.Suspended != nil {
-			err = errAccountSuspended
+			err = &AccountSuspendedError{*clientAccount.Suspended}
 			return
 		}
 		// TODO(#1109) clean this check up?
@@ -1903,7 +2790,18 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 	}()
 
 	config := am.server.Config()
-	if config.Accounts.AuthScript.Enabled {
+	if config.Accounts.AuthExternal.Endpoint.Enabled {
+		var output AuthScriptOutput
+		output, err = am.server.authEndpoint.Check(AuthScriptInput{Certfp: certfp, IP: client.IP().String(), peerCerts: peerCerts})
+		if err != nil {
+			am.server.logger.Error("internal", "failed auth endpoint request", err.Error())
+			err = errAuthServiceUnavailable
+			return
+		} else if output.Success && output.AccountName != "" {
+			clientAccount, err = am.loadWithAutocreation(output.AccountName, config.Accounts.AuthExternal.Autocreate)
+			return
+		}
+	} else if config.Accounts.AuthScript.Enabled {
 		var output AuthScriptOutput
 		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
 			AuthScriptInput{Certfp: certfp, IP: client.IP().String(), peerCerts: peerCerts})
@@ -1926,7 +2824,10 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 		return nil
 	})
 
-	if err != nil {
+	if err == errAccountInvalidCredentials && config.Accounts.Registration.AutoRegisterCertfp {
+		clientAccount, err = am.autoRegisterCertfp(client, certfp, authzid)
+		return
+	} else if err != nil {
 		return err
 	}
 
@@ -1939,6 +2840,46 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 	return err
 }
 
+// autoRegisterCertfp creates a verified account for an unknown certfp presented
+// via SASL EXTERNAL, named after the authzid or else the client's nickname.
+// It fails (rather than binding the certfp) if the name is already registered.
+func (am *AccountManager) autoRegisterCertfp(client *Client, certfp, authzid string) (account ClientAccount, err error) {
+	config := am.server.Config()
+	name := authzid
+	if name == "" {
+		if client.registered {
+			name = client.Nick()
+		} else {
+			name = client.preregNick
+		}
+	}
+	if name == "" || name == "*" {
+		return account, errAccountInvalidCredentials
+	}
+	if err = config.Accounts.Registration.NamePolicy.Check(name); err != nil {
+		return
+	}
+
+	err = am.Register(client, name, "*", "", "", certfp)
+	switch err {
+	case nil:
+	case errAccountAlreadyRegistered, errAccountAlreadyUnregistered, errAccountMustHoldNick, errCertfpAlreadyExists:
+		// don't let a certificate take over an existing or reserved name
+		return account, errAccountInvalidCredentials
+	default:
+		return
+	}
+	if err = am.Verify(nil, name, ""); err != nil {
+		return
+	}
+	account, err = am.LoadAccount(name)
+	if err == nil {
+		am.server.logger.Info("accounts", "auto-registered account", name, "for certfp", certfp)
+		am.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] auto-registered account $c[grey][$r%s$c[grey]] with certificate fingerprint %s"), client.NickMaskString(), account.Name, certfp))
+	}
+	return
+}
+
 type settingsMunger func(input AccountSettings) (output AccountSettings, err error)
 
 func (am *AccountManager) ModifyAccountSettings(account string, munger settingsMunger) (newSettings AccountSettings, err error) {
@@ -1980,10 +2921,175 @@ func (am *AccountManager) ModifyAccountSettings(account string, munger settingsM
 	return
 }
 
+// AccessMasks returns the user@host masks from which connections are
+// recognized as the owner of the account (NS ACCESS).
+func (am *AccountManager) AccessMasks(account string) (masks []string, err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return nil, errAccountDoesNotExist
+	}
+	var rawMasks string
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		rawMasks, _ = tx.Get(fmt.Sprintf(keyAccountAccessMasks, cfAccount))
+		return nil
+	})
+	if rawMasks != "" {
+		err = json.Unmarshal([]byte(rawMasks), &masks)
+	}
+	return
+}
+
+func (am *AccountManager) ModifyAccessMasks(account, mask string, add bool) (err error) {
+	cfAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	if add {
+		mask, err = validateAccessMask(mask)
+		if err != nil {
+			return
+		}
+	}
+	maxMasks := am.server.Config().Accounts.NickReservation.Access.MaxMasks
+	key := fmt.Sprintf(keyAccountAccessMasks, cfAccount)
+
+	am.serialCacheUpdateMutex.Lock()
+	defer am.serialCacheUpdateMutex.Unlock()
+
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := am.loadRawAccount(tx, cfAccount); err != nil {
+			return errAccountDoesNotExist
+		}
+		var masks []string
+		if rawMasks, err := tx.Get(key); err == nil {
+			json.Unmarshal([]byte(rawMasks), &masks)
+		}
+		found := -1
+		for i, existing := range masks {
+			if strings.EqualFold(existing, mask) {
+				found = i
+				break
+			}
+		}
+		if add {
+			if found != -1 {
+				return errNoop
+			} else if maxMasks <= len(masks) {
+				return errLimitExceeded
+			}
+			masks = append(masks, mask)
+		} else {
+			if found == -1 {
+				return errNoop
+			}
+			masks = append(masks[:found], masks[found+1:]...)
+		}
+		if len(masks) == 0 {
+			tx.Delete(key)
+			return nil
+		}
+		serialized, _ := json.Marshal(masks)
+		_, _, err := tx.Set(key, string(serialized), nil)
+		return err
+	})
+}
+
+// minimum number of non-wildcard characters in the host part of an access mask
+const accessMaskMinHostChars = 6
+
+// validateAccessMask normalizes a user@host mask, rejecting ones that are
+// malformed or too broad (e.g., `*@*` or `*@*.com`)
+func validateAccessMask(mask string) (result string, err error) {
+	at := strings.IndexByte(mask, '@')
+	if at == -1 {
+		return "", errInvalidParams
+	}
+	user, host := mask[:at], mask[at+1:]
+	if user == "" || host == "" || strings.ContainsAny(mask, "! ") || strings.IndexByte(host, '@') != -1 {
+		return "", errInvalidParams
+	}
+	if len(strings.NewReplacer("*", "", "?", "").Replace(host)) < accessMaskMinHostChars {
+		return "", errAccessMaskTooBroad
+	}
+	if _, err = utils.CompileGlob(strings.ToLower(mask), false); err != nil {
+		return "", errInvalidParams
+	}
+	return strings.ToLower(mask), nil
+}
+
+// accessMaskMatches tests a mask against a username and the possible hostnames
+// of a connection. The username may be empty if it is not yet known, in which
+// case only masks with a user part of `*` can match.
+func accessMaskMatches(mask, username string, hosts ...string) bool {
+	at := strings.IndexByte(mask, '@')
+	if at == -1 {
+		return false
+	}
+	if mask[:at] != "*" {
+		if username == "" {
+			return false
+		}
+		userGlob, err := utils.CompileGlob(mask[:at], false)
+		if err != nil || !userGlob.MatchString(strings.ToLower(username)) {
+			return false
+		}
+	}
+	hostGlob, err := utils.CompileGlob(mask[at+1:], false)
+	if err != nil {
+		return false
+	}
+	for _, host := range hosts {
+		if host != "" && hostGlob.MatchString(strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRecognized returns whether an unauthenticated client is connecting from one
+// of the account's access masks, allowing it to use the account's nickname.
+func (am *AccountManager) IsRecognized(client *Client, account string) bool {
+	if !am.server.Config().Accounts.NickReservation.Access.Enabled {
+		return false
+	}
+	masks, err := am.AccessMasks(account)
+	if err != nil || len(masks) == 0 {
+		return false
+	}
+	ip, hostname := client.getWhoisActually()
+	username := client.Username()
+	if username == "*" {
+		username = ""
+	}
+	for _, mask := range masks {
+		if accessMaskMatches(mask, username, hostname, ip.String()) {
+			return true
+		}
+	}
+	return false
+}
+
 // represents someone's status in hostserv
 type VHostInfo struct {
-	ApprovedVHost string
-	Enabled       bool
+	ApprovedVHost   string
+	Enabled         bool
+	RequestedVHost  string `json:",omitempty"`
+	RejectedReason  string `json:",omitempty"`
+	LastRequestTime time.Time
+}
+
+// PendingVHostRequest is a vhost request awaiting operator approval
+type PendingVHostRequest struct {
+	Account string
+	VHostInfo
+}
+
+type vhostThrottleExceeded struct {
+	timeRemaining time.Duration
+}
+
+func (vhe *vhostThrottleExceeded) Error() string {
+	return fmt.Sprintf("Wait at least %v and try again", vhe.timeRemaining)
 }
 
 // callback type implementing the actual business logic of vhost operations
@@ -2000,6 +3106,96 @@ func (am *AccountManager) VHostSet(account string, vhost string) (result VHostIn
 	return am.performVHostChange(account, munger)
 }
 
+// VHostRequest records a user's request for a vhost, to be approved or
+// rejected by an operator.
+func (am *AccountManager) VHostRequest(account string, vhost string, cooldown time.Duration) (result VHostInfo, err error) {
+	munger := func(input VHostInfo) (output VHostInfo, err error) {
+		output = input
+		// you can update your existing request, but if you were approved or rejected,
+		// you can't spam a new request
+		if output.RequestedVHost == "" {
+			elapsed := time.Since(output.LastRequestTime)
+			if elapsed < cooldown {
+				return output, &vhostThrottleExceeded{timeRemaining: (cooldown - elapsed).Round(time.Second)}
+			}
+		}
+		output.RequestedVHost = vhost
+		output.RejectedReason = ""
+		output.LastRequestTime = time.Now().UTC()
+		return
+	}
+
+	return am.performVHostChange(account, munger)
+}
+
+// VHostApprove approves a pending vhost request; if vhost is nonempty,
+// it is granted instead of the requested vhost.
+func (am *AccountManager) VHostApprove(account string, vhost string) (result VHostInfo, err error) {
+	munger := func(input VHostInfo) (output VHostInfo, err error) {
+		if input.RequestedVHost == "" {
+			err = errNoVhostRequest
+			return
+		}
+		output = input
+		if vhost == "" {
+			vhost = input.RequestedVHost
+		}
+		output.ApprovedVHost = vhost
+		output.Enabled = true
+		output.RequestedVHost = ""
+		output.RejectedReason = ""
+		return
+	}
+
+	return am.performVHostChange(account, munger)
+}
+
+// VHostReject rejects a pending vhost request.
+func (am *AccountManager) VHostReject(account string, reason string) (result VHostInfo, err error) {
+	munger := func(input VHostInfo) (output VHostInfo, err error) {
+		if input.RequestedVHost == "" {
+			err = errNoVhostRequest
+			return
+		}
+		output = input
+		output.RequestedVHost = ""
+		output.RejectedReason = reason
+		return
+	}
+
+	return am.performVHostChange(account, munger)
+}
+
+// VHostListRequests returns up to `limit` pending vhost requests, oldest first,
+// and the total number of pending requests.
+func (am *AccountManager) VHostListRequests(limit int) (requests []PendingVHostRequest, total int) {
+	prefix := fmt.Sprintf(keyAccountVHost, "")
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			var info VHostInfo
+			if json.Unmarshal([]byte(value), &info) == nil && info.RequestedVHost != "" {
+				requests = append(requests, PendingVHostRequest{
+					Account:   strings.TrimPrefix(key, prefix),
+					VHostInfo: info,
+				})
+			}
+			return true
+		})
+	})
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].LastRequestTime.Before(requests[j].LastRequestTime)
+	})
+	total = len(requests)
+	if limit < total {
+		requests = requests[:limit]
+	}
+	return
+}
+
 func (am *AccountManager) VHostSetEnabled(client *Client, enabled bool) (result VHostInfo, err error) {
 	munger := func(input VHostInfo) (output VHostInfo, err error) {
 		if input.ApprovedVHost == "" {
@@ -2136,6 +3332,7 @@ var (
 		"PLAIN":         authPlainHandler,
 		"EXTERNAL":      authExternalHandler,
 		"SCRAM-SHA-256": authScramHandler,
+		"OAUTHBEARER":   authOauthBearerHandler,
 	}
 )
 
@@ -2231,6 +3428,14 @@ func (am *AccountManager) lookupSCRAMCreds(accountName string) (creds scram.Stor
 	if err != nil {
 		return
 	}
+	if err = am.checkAccountUsable(acct); err != nil {
+		return
+	}
+	// SCRAM has no way to carry a 2FA code
+	if am.totpEnabled(acct.NameCasefolded) {
+		err = errTOTPRequired
+		return
+	}
 	if acct.Credentials.SCRAMCreds.Iters == 0 {
 		err = errNoSCRAMCredentials
 		return
@@ -2242,6 +3447,23 @@ func (am *AccountManager) lookupSCRAMCreds(accountName string) (creds scram.Stor
 	return
 }
 
+// recordSCRAMResult updates the login lockout state for the account
+// after a SCRAM exchange that got as far as checking the client's proof.
+func (am *AccountManager) recordSCRAMResult(username string, success bool) {
+	if strudelIndex := strings.IndexByte(username, '@'); strudelIndex != -1 {
+		username = username[:strudelIndex]
+	}
+	cfAccount, err := CasefoldName(username)
+	if err != nil {
+		return
+	}
+	if success {
+		am.clearLoginFailures(cfAccount)
+	} else {
+		am.recordLoginFailure(cfAccount)
+	}
+}
+
 func (ac *AccountCredentials) AddCertfp(certfp string) (err error) {
 	// XXX we require that certfp is already normalized (rather than normalize here
 	// and pass back the normalized version as an additional return parameter);
@@ -2324,6 +3546,13 @@ type AccountSettings struct {
 	DMHistory        HistoryStatus
 	AutoAway         PersistentStatus
 	Email            string
+	ReadReceipts     bool
+	HideChannelInfo  bool
+	AwayMessage      string
+	Metadata         map[string]string
+	HideMetadata     bool
+	Ignores          []string // see NS SET IGNORE
+	Realname         string   // overrides the USER realname; see SETNAME and NS SET REALNAME
 }
 
 // ClientAccount represents a user account.
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/xdg-go/scram"
+
+	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/webhooks"
+)
+
+func newTestAccountServer(t *testing.T) *Server {
+	var config Config
+	config.languageManager, _ = languages.NewManager(false, "", "")
+	config.Accounts.AuthenticationEnabled = true
+	config.Accounts.Multiclient.Enabled = true
+	config.Accounts.NickReservation.guestRegexp, config.Accounts.NickReservation.guestRegexpFolded, _ = compileGuestRegexp("Guest-*", CasemappingASCII)
+	server := &Server{name: "ergo.test"}
+	server.SetConfig(&config)
+	var err error
+	if server.logger, err = logger.NewManager(nil); err != nil {
+		t.Fatal(err)
+	}
+	server.webhooks = webhooks.NewDispatcher(server.logger)
+	if server.store, err = buntdb.Open(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	server.accounts.Initialize(server)
+	return server
+}
+
+// testSCRAMLogin runs a complete SCRAM-SHA-256 exchange against the account manager
+func testSCRAMLogin(am *AccountManager, username, passphrase string) error {
+	client, _ := scram.SHA256.NewClientUnprepped(username, passphrase, "")
+	conv := client.NewConversation()
+	serverConv := am.NewScramConversation()
+	msg, _ := conv.Step("")
+	for !serverConv.Done() {
+		response, err := serverConv.Step(msg)
+		if err != nil {
+			return err
+		}
+		if msg, err = conv.Step(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSCRAMLoginLockout(t *testing.T) {
+	server := newTestAccountServer(t)
+	config := *server.Config()
+	config.Accounts.LoginLockout = LoginLockoutConfig{Enabled: true, MaxAttempts: 3, Window: time.Minute, Duration: time.Minute}
+	server.SetConfig(&config)
+	am := &server.accounts
+	if err := am.SARegister("alice", "hunter2hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if testSCRAMLogin(am, "alice", "wrongpassword") == nil {
+			t.Fatal("SCRAM should fail with the wrong password")
+		}
+		am.recordSCRAMResult("alice@phone", false)
+	}
+	// once the account is locked, even the right password is rejected:
+	if _, ok := testSCRAMLogin(am, "alice", "hunter2hunter2").(*AccountLockedError); !ok {
+		t.Error("SCRAM should fail while the account is locked")
+	}
+
+	am.clearLoginFailures("alice")
+	if err := testSCRAMLogin(am, "alice", "hunter2hunter2"); err != nil {
+		t.Errorf("SCRAM should succeed after the lockout is cleared: %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	server := newTestAccountServer(t)
+	config := *server.Config()
+	config.Accounts.NickReservation.AdditionalNickLimit = 1
+	server.SetConfig(&config)
+	am := &server.accounts
+	for _, account := range []string{"alice", "bob"} {
+		if err := am.SARegister(account, "hunter2hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certfp := "d7a0c2f67d8e9d2b12a3f2b1b5d06e0d0b6fa66c9c3f36cdbb5bf44d3d7a4c1e"
+	if err := am.addRemoveCertfp("alice", certfp, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := am.Merge("alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(items), 2, t)
+
+	if _, err := am.LoadAccount("alice"); err != errAccountDoesNotExist {
+		t.Errorf("source account should be erased, got %v", err)
+	}
+	bob, err := am.LoadAccount("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(bob.AdditionalNicks, []string{"alice"}, t)
+	assertEqual(bob.Credentials.Certfps, []string{certfp}, t)
+	assertEqual(am.NickToAccount("alice"), "bob", t)
+	server.store.View(func(tx *buntdb.Tx) error {
+		account, _ := tx.Get(fmt.Sprintf(keyCertToAccount, certfp))
+		assertEqual(account, "bob", t)
+		return nil
+	})
+}
+
+func TestAccessMasks(t *testing.T) {
+	for _, mask := range []string{"*@*", "*@*.com", "alice@1.2.*", "alice", "@example.com", "a!b@example.com"} {
+		if _, err := validateAccessMask(mask); err == nil {
+			t.Errorf("mask %s should have been rejected", mask)
+		}
+	}
+	mask, err := validateAccessMask("Alice@*.Example.com")
+	assertEqual(err, nil, t)
+	assertEqual(mask, "alice@*.example.com", t)
+
+	assertEqual(accessMaskMatches(mask, "~alice", "home.example.com"), false, t)
+	assertEqual(accessMaskMatches(mask, "alice", "192.0.2.1", "Home.Example.com"), true, t)
+	assertEqual(accessMaskMatches(mask, "alice", "example.org"), false, t)
+	// username not known yet:
+	assertEqual(accessMaskMatches(mask, "", "home.example.com"), false, t)
+	assertEqual(accessMaskMatches("*@*.example.com", "", "home.example.com"), true, t)
+}
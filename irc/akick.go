@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// AkickEntry is an entry on a registered channel's auto-kick list (CS AKICK).
+// Unlike +b, entries carry a reason and an optional expiration time, and can
+// match an account regardless of hostmask. Entries are keyed by their
+// (casefolded) nickmask or account name.
+type AkickEntry struct {
+	Account         bool // the key is an account name rather than a nickmask
+	Reason          string
+	TimeCreated     time.Time
+	Expires         time.Time // zero for no expiration
+	CreatorNickmask string
+	CreatorAccount  string
+}
+
+func (entry *AkickEntry) expired(now time.Time) bool {
+	return !entry.Expires.IsZero() && !now.Before(entry.Expires)
+}
+
+func (entry *AkickEntry) matches(key, nickMaskCasefolded, account, realname string) bool {
+	if entry.Account {
+		return account != "" && account == key
+	} else if isExtban(key) {
+		return matchExtban(key, account, realname)
+	}
+	re, err := utils.CompileGlob(key, false)
+	return err == nil && re.MatchString(nickMaskCasefolded)
+}
+
+// canonicalizeAkickTarget normalizes the argument of CS AKICK ADD/DEL: anything
+// that looks like a hostmask or extban ($a:, $r:) is a mask, anything else
+// is an account name.
+func canonicalizeAkickTarget(target string) (key string, isAccount bool, err error) {
+	if isExtban(target) {
+		key, err = canonicalizeExtban(target)
+		return
+	} else if strings.ContainsAny(target, "!@*?") {
+		key, err = CanonicalizeMaskWildcard(target)
+		return
+	}
+	key, err = CasefoldName(target)
+	return key, true, err
+}
+
+// akickTargetAccount returns the account named by a canonicalized AKICK key
+// (either an account name or an $a: extban), or "" if there is none.
+func akickTargetAccount(key string, isAccount bool) string {
+	if isAccount {
+		return key
+	} else if strings.HasPrefix(key, extbanAccount) {
+		return key[len(extbanAccount):]
+	}
+	return ""
+}
+
+// AddAkick adds an entry to the channel's auto-kick list, replacing any
+// existing entry for the same key.
+func (channel *Channel) AddAkick(key string, entry AkickEntry) (err error) {
+	defer func() {
+		if err == nil {
+			channel.MarkDirty(IncludeLists)
+		}
+	}()
+
+	limit := channel.server.Config().Limits.ChanListModes
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	if _, exists := channel.akicks[key]; !exists && len(channel.akicks) >= limit {
+		return errLimitExceeded
+	}
+	if channel.akicks == nil {
+		channel.akicks = make(map[string]AkickEntry)
+	}
+	channel.akicks[key] = entry
+	return nil
+}
+
+// RemoveAkick removes an entry from the channel's auto-kick list.
+func (channel *Channel) RemoveAkick(key string) (err error) {
+	defer func() {
+		if err == nil {
+			channel.MarkDirty(IncludeLists)
+		}
+	}()
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	if _, exists := channel.akicks[key]; !exists {
+		return errNoop
+	}
+	delete(channel.akicks, key)
+	return nil
+}
+
+// akickListing is a single entry of CS AKICK LIST output
+type akickListing struct {
+	Key string
+	AkickEntry
+}
+
+// Akicks returns the channel's unexpired auto-kick entries, sorted by creation time.
+func (channel *Channel) Akicks() (result []akickListing) {
+	channel.pruneAkicks()
+
+	channel.stateMutex.RLock()
+	for key, entry := range channel.akicks {
+		result = append(result, akickListing{Key: key, AkickEntry: entry})
+	}
+	channel.stateMutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TimeCreated.Before(result[j].TimeCreated)
+	})
+	return
+}
+
+// checkAkick returns the auto-kick entry matching the client, if any.
+func (channel *Channel) checkAkick(nickMaskCasefolded, account, realname string) (result AkickEntry, found bool) {
+	channel.pruneAkicks()
+
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	for key, entry := range channel.akicks {
+		if entry.matches(key, nickMaskCasefolded, account, realname) {
+			return entry, true
+		}
+	}
+	return
+}
+
+// pruneAkicks lazily removes expired auto-kick entries.
+func (channel *Channel) pruneAkicks() {
+	now := time.Now().UTC()
+
+	// this runs on every join, so only take the write lock if there's work to do
+	channel.stateMutex.RLock()
+	anyExpired := false
+	for _, entry := range channel.akicks {
+		if entry.expired(now) {
+			anyExpired = true
+			break
+		}
+	}
+	channel.stateMutex.RUnlock()
+	if !anyExpired {
+		return
+	}
+
+	channel.stateMutex.Lock()
+	pruned := false
+	for key, entry := range channel.akicks {
+		if entry.expired(now) {
+			delete(channel.akicks, key)
+			pruned = true
+		}
+	}
+	channel.stateMutex.Unlock()
+
+	if pruned {
+		channel.MarkDirty(IncludeLists)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAkickMatching(t *testing.T) {
+	key, isAccount, err := canonicalizeAkickTarget("*!*@Example.com")
+	if err != nil || isAccount || key != "*!*@example.com" {
+		t.Fatalf("unexpected canonicalization: %s %t %v", key, isAccount, err)
+	}
+	entry := AkickEntry{}
+	assertEqual(entry.matches(key, "alice!alice@example.com", "", ""), true, t)
+	assertEqual(entry.matches(key, "alice!alice@example.org", "alice", ""), false, t)
+
+	key, isAccount, err = canonicalizeAkickTarget("Alice")
+	if err != nil || !isAccount || key != "alice" {
+		t.Fatalf("unexpected canonicalization: %s %t %v", key, isAccount, err)
+	}
+	entry = AkickEntry{Account: true}
+	assertEqual(entry.matches(key, "bob!bob@example.org", "alice", ""), true, t)
+	assertEqual(entry.matches(key, "alice!alice@example.org", "", ""), false, t)
+
+	now := time.Now().UTC()
+	assertEqual(entry.expired(now), false, t)
+	entry.Expires = now.Add(-time.Minute)
+	assertEqual(entry.expired(now), true, t)
+}
+
+func TestAkickTargetAccount(t *testing.T) {
+	for _, target := range []string{"Alice", "$a:Alice"} {
+		key, isAccount, err := canonicalizeAkickTarget(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(akickTargetAccount(key, isAccount), "alice", t)
+	}
+	key, isAccount, _ := canonicalizeAkickTarget("alice!*@*")
+	assertEqual(akickTargetAccount(key, isAccount), "", t)
+}
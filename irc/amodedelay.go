@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/custime"
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// CS SET AMODE-DELAY: persistent modes are applied only after the member
+// has been in the channel for the configured delay, so that a compromised
+// account can't immediately use its access (e.g., to op a spambot).
+
+const maxAmodeDelay = 24 * time.Hour
+
+// amodeDelayFromString parses the argument of CS SET AMODE-DELAY
+func amodeDelayFromString(value string) (delay time.Duration, err error) {
+	if strings.ToLower(value) == "off" {
+		return 0, nil
+	}
+	delay, err = custime.ParseDuration(value)
+	if err != nil || delay < 0 || delay > maxAmodeDelay {
+		return 0, errInvalidParams
+	}
+	return
+}
+
+// applyDelayedAmodes applies the persistent modes of a member who joined at
+// joinTime, once the delay has elapsed; if they left in the meantime, it does nothing.
+func (channel *Channel) applyDelayedAmodes(client *Client, joinTime int64) {
+	defer channel.server.HandlePanic()
+
+	details := client.Details()
+
+	var applied modes.ModeChanges
+	channel.stateMutex.Lock()
+	memberData, ok := channel.members[client]
+	if ok && memberData.joinTime == joinTime {
+		memberData.amodeTimer = nil
+		channel.members[client] = memberData
+		// the AMODEs may have changed since the client joined
+		for _, mode := range channel.persistentModesNoMutex(details.account) {
+			// already applied (e.g., with CS OP) if SetMode returns false
+			if memberData.modes.SetMode(mode, true) {
+				applied = append(applied, modes.ModeChange{Op: modes.Add, Mode: mode, Arg: details.nick})
+			}
+		}
+	}
+	channel.stateMutex.Unlock()
+
+	announceCmodeChanges(channel, applied, chanservService.prefix, "*", "", false, nil)
+}
+
+// amodeDelayRemaining returns how much longer the client must be in the channel
+// before it can use its persistent modes there (e.g., with CS OP), or 0 if it can now.
+func (channel *Channel) amodeDelayRemaining(client *Client) time.Duration {
+	channel.stateMutex.RLock()
+	delay := channel.settings.AmodeDelay
+	memberData, present := channel.members[client]
+	channel.stateMutex.RUnlock()
+
+	if delay == 0 {
+		return 0
+	} else if !present {
+		return delay
+	}
+	remaining := time.Duration(memberData.joinTime + int64(delay) - time.Now().UnixNano())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
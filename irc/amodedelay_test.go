@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestAmodeDelay(t *testing.T) {
+	delay, err := amodeDelayFromString("30s")
+	assertEqual(err, nil, t)
+	assertEqual(delay, 30*time.Second, t)
+	delay, err = amodeDelayFromString("off")
+	assertEqual(err, nil, t)
+	assertEqual(delay, time.Duration(0), t)
+	delay, err = amodeDelayFromString("0")
+	assertEqual(err, nil, t)
+	assertEqual(delay, time.Duration(0), t)
+	_, err = amodeDelayFromString("2d")
+	assertEqual(err, errInvalidParams, t)
+	_, err = amodeDelayFromString("-5m")
+	assertEqual(err, errInvalidParams, t)
+	_, err = amodeDelayFromString("soon")
+	assertEqual(err, errInvalidParams, t)
+}
+
+func TestAmodeDelayJoinExemptions(t *testing.T) {
+	session, _ := newTestServiceSession(t)
+	client := session.client
+	client.account = "bob"
+	client.channels = make(ChannelSet)
+	client.sessions = []*Session{session}
+	client.server.Config().Channels.MaxChannelsPerClient = 10
+
+	channel := NewChannel(client.server, "#test", "#test", false)
+	channel.registeredFounder = "alice"
+	channel.key = "sesame"
+	channel.flags.SetMode(modes.InviteOnly, true)
+	channel.accountToUMode = map[string]modes.Mode{"bob": modes.ChannelOperator}
+	channel.settings.AmodeDelay = time.Minute
+
+	// the delay postpones the mode grant, but not the exemption from +k and +i:
+	rb := NewResponseBuffer(session)
+	err, _ := channel.Join(client, "", false, rb)
+	assertEqual(err, nil, t)
+	assertEqual(channel.ClientIsAtLeast(client, modes.ChannelOperator), false, t)
+
+	channel.stateMutex.RLock()
+	timer := channel.members[client].amodeTimer
+	channel.stateMutex.RUnlock()
+	if timer == nil {
+		t.Fatal("amode was not scheduled")
+	}
+	timer.Stop()
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+const (
+	authEndpointMinBackoff = time.Second
+	// requests waiting to be written to the endpoint; if the queue is full,
+	// the endpoint is too slow and authentication fails
+	authEndpointWriteQueueSize = 256
+)
+
+// AuthEndpointConfig configures a persistent connection to an external
+// authentication daemon, which receives one AuthScriptInput per line
+// (with an added `id` field) and answers with one AuthScriptOutput per line,
+// echoing the `id`. Responses may arrive in any order.
+type AuthEndpointConfig struct {
+	Enabled           bool
+	Network           string // "unix" or "tcp"
+	Address           string
+	Timeout           time.Duration
+	MaxConcurrency    uint          `yaml:"max-concurrency"`
+	MaxReconnectDelay time.Duration `yaml:"max-reconnect-delay"`
+}
+
+func (conf *AuthEndpointConfig) postprocess() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	switch conf.Network {
+	case "":
+		conf.Network = "unix"
+	case "unix", "tcp":
+	default:
+		return fmt.Errorf("invalid network for auth endpoint: %s", conf.Network)
+	}
+	if conf.Address == "" {
+		return errors.New("auth endpoint requires an address")
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 9 * time.Second
+	}
+	if conf.MaxReconnectDelay == 0 {
+		conf.MaxReconnectDelay = time.Minute
+	}
+	return nil
+}
+
+type authEndpointRequest struct {
+	ID uint64 `json:"id"`
+	AuthScriptInput
+}
+
+type authEndpointResponse struct {
+	ID uint64 `json:"id"`
+	AuthScriptOutput
+}
+
+type authEndpointResult struct {
+	output AuthScriptOutput
+	err    error
+}
+
+// AuthEndpoint manages the connection to the authentication daemon.
+// It fails closed: if the daemon is unreachable or slow, authentication fails.
+type AuthEndpoint struct {
+	server *Server
+
+	sync.Mutex // tier 1
+	config     AuthEndpointConfig
+	sem        utils.Semaphore
+	conn       net.Conn
+	// lines for the connection's writer goroutine, so that a slow socket
+	// is never written to while holding the mutex
+	writes  chan []byte
+	pending map[uint64]chan authEndpointResult
+	nextID  uint64
+	// incremented on every config change; stale reconnection loops exit
+	generation uint64
+	connecting bool
+	// delay before the next connection attempt; it keeps growing across
+	// reconnections until a request completes successfully
+	reconnectDelay time.Duration
+}
+
+func (ae *AuthEndpoint) Initialize(server *Server) {
+	ae.server = server
+	ae.pending = make(map[uint64]chan authEndpointResult)
+}
+
+// ApplyConfig (re)connects to the endpoint as necessary.
+func (ae *AuthEndpoint) ApplyConfig(config AuthEndpointConfig) {
+	ae.Lock()
+	defer ae.Unlock()
+
+	if ae.config == config {
+		return
+	}
+	oldConfig := ae.config
+	ae.config = config
+	if config.MaxConcurrency != 0 {
+		ae.sem = utils.NewSemaphore(int(config.MaxConcurrency))
+	} else {
+		ae.sem = nil
+	}
+
+	if oldConfig.Enabled == config.Enabled && oldConfig.Network == config.Network && oldConfig.Address == config.Address {
+		return
+	}
+	ae.generation++
+	ae.connecting = false
+	ae.reconnectDelay = 0
+	ae.disconnectInternal(errAuthServiceUnavailable)
+	ae.reconnectInternal()
+}
+
+func (ae *AuthEndpoint) Close() {
+	ae.ApplyConfig(AuthEndpointConfig{})
+}
+
+// Check sends an authentication request and waits for the response.
+func (ae *AuthEndpoint) Check(input AuthScriptInput) (output AuthScriptOutput, err error) {
+	ae.Lock()
+	sem, timeout := ae.sem, ae.config.Timeout
+	ae.Unlock()
+
+	if sem != nil {
+		if !sem.AcquireWithTimeout(timeout) {
+			return output, errTimedOut
+		}
+		defer sem.Release()
+	}
+
+	line, err := ae.send(input)
+	if err != nil {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-line.response:
+		output, err = result.output, result.err
+	case <-timer.C:
+		ae.Lock()
+		delete(ae.pending, line.id)
+		ae.Unlock()
+		err = errTimedOut
+	}
+
+	if err == nil && output.Error != "" {
+		err = fmt.Errorf("Authentication endpoint reported error: %s", output.Error)
+	}
+	return
+}
+
+type authEndpointPending struct {
+	id       uint64
+	response chan authEndpointResult
+}
+
+func (ae *AuthEndpoint) send(input AuthScriptInput) (result authEndpointPending, err error) {
+	ae.Lock()
+	defer ae.Unlock()
+
+	if ae.conn == nil {
+		return result, errAuthServiceUnavailable
+	}
+
+	ae.nextID++
+	result.id = ae.nextID
+	result.response = make(chan authEndpointResult, 1)
+	input.encodePeerCerts()
+	line, err := json.Marshal(authEndpointRequest{ID: result.id, AuthScriptInput: input})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	select {
+	case ae.writes <- line:
+	default:
+		ae.server.logger.Warning("internal", "auth endpoint write queue is full")
+		return result, errAuthServiceUnavailable
+	}
+	ae.pending[result.id] = result.response
+	return
+}
+
+func (ae *AuthEndpoint) writeLoop(conn net.Conn, writes chan []byte) {
+	defer ae.server.HandlePanic()
+
+	for line := range writes {
+		ae.Lock()
+		timeout := ae.config.Timeout
+		ae.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(line); err != nil {
+			ae.Lock()
+			if ae.conn == conn {
+				ae.server.logger.Error("internal", "failed write to auth endpoint", err.Error())
+				ae.disconnectInternal(errAuthServiceUnavailable)
+				ae.reconnectInternal()
+			}
+			ae.Unlock()
+			return
+		}
+	}
+}
+
+func (ae *AuthEndpoint) connectLoop(generation uint64, config AuthEndpointConfig) {
+	defer ae.server.HandlePanic()
+
+	for {
+		ae.Lock()
+		delay := ae.reconnectDelay
+		ae.Unlock()
+		if delay != 0 {
+			time.Sleep(delay)
+		}
+
+		conn, err := net.DialTimeout(config.Network, config.Address, config.Timeout)
+
+		ae.Lock()
+		if ae.generation != generation {
+			ae.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		// a connection that drops before answering anything counts as a failure too
+		if delay == 0 {
+			ae.reconnectDelay = authEndpointMinBackoff
+		} else if ae.reconnectDelay = 2 * delay; config.MaxReconnectDelay < ae.reconnectDelay {
+			ae.reconnectDelay = config.MaxReconnectDelay
+		}
+		if err == nil {
+			ae.conn = conn
+			ae.writes = make(chan []byte, authEndpointWriteQueueSize)
+			ae.connecting = false
+			go ae.writeLoop(conn, ae.writes)
+			ae.Unlock()
+			ae.server.logger.Info("internal", "connected to auth endpoint", config.Address)
+			ae.readLoop(generation, conn)
+			return
+		}
+		ae.Unlock()
+
+		ae.server.logger.Error("internal", "could not connect to auth endpoint", config.Address, err.Error())
+	}
+}
+
+func (ae *AuthEndpoint) readLoop(generation uint64, conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			ae.Lock()
+			if ae.generation == generation && ae.conn == conn {
+				ae.server.logger.Error("internal", "lost connection to auth endpoint", err.Error())
+				ae.disconnectInternal(errAuthServiceUnavailable)
+				ae.reconnectInternal()
+			}
+			ae.Unlock()
+			return
+		}
+
+		var response authEndpointResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			ae.server.logger.Error("internal", "invalid response from auth endpoint", err.Error())
+			continue
+		}
+		ae.Lock()
+		responseChan, ok := ae.pending[response.ID]
+		delete(ae.pending, response.ID)
+		if ok && response.Error == "" {
+			// the endpoint is healthy: reconnect immediately if the connection drops
+			ae.reconnectDelay = 0
+		}
+		ae.Unlock()
+		if ok {
+			responseChan <- authEndpointResult{output: response.AuthScriptOutput}
+		}
+	}
+}
+
+// fails all in-flight requests and closes the connection; requires the lock
+func (ae *AuthEndpoint) disconnectInternal(err error) {
+	if ae.conn != nil {
+		ae.conn.Close()
+		ae.conn = nil
+		close(ae.writes)
+		ae.writes = nil
+	}
+	for id, responseChan := range ae.pending {
+		responseChan <- authEndpointResult{err: err}
+		delete(ae.pending, id)
+	}
+}
+
+// starts a reconnection loop if one isn't already running; requires the lock
+func (ae *AuthEndpoint) reconnectInternal() {
+	if !ae.config.Enabled || ae.connecting {
+		return
+	}
+	ae.connecting = true
+	ae.generation++
+	go ae.connectLoop(ae.generation, ae.config)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+// a toy authentication daemon: accepts `secret` as the passphrase for every
+// account, answering requests in reverse order of arrival
+func runAuthDaemon(t *testing.T, listener net.Listener, batchSize int) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		var batch []authEndpointRequest
+		for len(batch) < batchSize {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var request authEndpointRequest
+			if err := json.Unmarshal(line, &request); err != nil {
+				t.Error(err)
+				return
+			}
+			batch = append(batch, request)
+		}
+		for i := len(batch) - 1; 0 <= i; i-- {
+			response := authEndpointResponse{ID: batch[i].ID}
+			response.Success = batch[i].Passphrase == "secret"
+			response.AccountName = batch[i].AccountName
+			line, _ := json.Marshal(response)
+			conn.Write(append(line, '\n'))
+		}
+	}
+}
+
+func newTestAuthEndpoint(t *testing.T, address string) *AuthEndpoint {
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ae AuthEndpoint
+	ae.Initialize(&Server{logger: logger})
+	config := AuthEndpointConfig{
+		Enabled: true,
+		Network: "tcp",
+		Address: address,
+		Timeout: time.Second,
+	}
+	if err := config.postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	ae.ApplyConfig(config)
+	return &ae
+}
+
+func waitForAuthEndpoint(t *testing.T, ae *AuthEndpoint) {
+	for i := 0; i < 100; i++ {
+		ae.Lock()
+		connected := ae.conn != nil
+		ae.Unlock()
+		if connected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("auth endpoint never connected")
+}
+
+func TestAuthEndpoint(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go runAuthDaemon(t, listener, 2)
+
+	ae := newTestAuthEndpoint(t, listener.Addr().String())
+	defer ae.Close()
+	waitForAuthEndpoint(t, ae)
+
+	// responses come back out of order and must be matched by id
+	results := make(chan AuthScriptOutput, 2)
+	for _, input := range []AuthScriptInput{{AccountName: "alice", Passphrase: "secret"}, {AccountName: "bob", Passphrase: "wrong"}} {
+		input := input
+		go func() {
+			output, err := ae.Check(input)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- output
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		output := <-results
+		if output.Success != (output.AccountName == "alice") {
+			t.Errorf("mismatched response: %#v", output)
+		}
+	}
+}
+
+func TestAuthEndpointFailsClosed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// the daemon never answers a lone request
+	go runAuthDaemon(t, listener, 2)
+
+	ae := newTestAuthEndpoint(t, listener.Addr().String())
+	defer ae.Close()
+	waitForAuthEndpoint(t, ae)
+
+	if _, err := ae.Check(AuthScriptInput{AccountName: "alice", Passphrase: "secret"}); err != errTimedOut {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	ae.Close()
+	if _, err := ae.Check(AuthScriptInput{AccountName: "alice", Passphrase: "secret"}); err != errAuthServiceUnavailable {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+}
+
+func TestAuthEndpointReconnectBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// the daemon hangs up on every connection without answering anything
+	var accepted uint32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddUint32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+
+	ae := newTestAuthEndpoint(t, listener.Addr().String())
+	defer ae.Close()
+	time.Sleep(authEndpointMinBackoff / 2)
+	// the connection dropped before a request round-tripped, so the next
+	// attempt must wait for the backoff instead of redialing immediately:
+	if count := atomic.LoadUint32(&accepted); count != 1 {
+		t.Errorf("expected a single connection attempt, got %d", count)
+	}
+}
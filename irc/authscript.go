@@ -29,20 +29,23 @@ type AuthScriptOutput struct {
 	Error       string `json:"error"`
 }
 
-func CheckAuthScript(sem utils.Semaphore, config ScriptConfig, input AuthScriptInput) (output AuthScriptOutput, err error) {
-	if sem != nil {
-		sem.Acquire()
-		defer sem.Release()
-	}
-
-	// PEM-encode the peer certificates before applying JSON
+// PEM-encode the peer certificates before applying JSON
+func (input *AuthScriptInput) encodePeerCerts() {
 	if len(input.peerCerts) != 0 {
 		input.PeerCerts = make([]string, len(input.peerCerts))
 		for i, cert := range input.peerCerts {
 			input.PeerCerts[i] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
 		}
 	}
+}
+
+func CheckAuthScript(sem utils.Semaphore, config ScriptConfig, input AuthScriptInput) (output AuthScriptOutput, err error) {
+	if sem != nil {
+		sem.Acquire()
+		defer sem.Release()
+	}
 
+	input.encodePeerCerts()
 	inputBytes, err := json.Marshal(input)
 	if err != nil {
 		return
@@ -7,7 +7,7 @@ package caps
 
 const (
 	// number of recognized capabilities:
-	numCapabs = 28
+	numCapabs = 31
 	// length of the uint64 array that represents the bitset:
 	bitsetLen = 1
 )
@@ -61,10 +61,18 @@ const (
 	// https://gist.github.com/DanielOaks/8126122f74b26012a3de37db80e4e0c6
 	Languages Capability = iota
 
+	// Metadata is the draft IRCv3 capability named "draft/metadata-2":
+	// https://github.com/ircv3/ircv3-specifications/pull/501
+	Metadata Capability = iota
+
 	// Multiline is the proposed IRCv3 capability named "draft/multiline":
 	// https://github.com/ircv3/ircv3-specifications/pull/398
 	Multiline Capability = iota
 
+	// PreAway is the proposed IRCv3 capability named "draft/pre-away":
+	// https://github.com/ircv3/ircv3-specifications/pull/514
+	PreAway Capability = iota
+
 	// Relaymsg is the proposed IRCv3 capability named "draft/relaymsg":
 	// https://github.com/ircv3/ircv3-specifications/pull/417
 	Relaymsg Capability = iota
@@ -109,6 +117,10 @@ const (
 	// https://ircv3.net/specs/extensions/setname.html
 	SetName Capability = iota
 
+	// StandardReplies is the IRCv3 capability named "standard-replies":
+	// https://ircv3.net/specs/extensions/standard-replies
+	StandardReplies Capability = iota
+
 	// STS is the IRCv3 capability named "sts":
 	// https://ircv3.net/specs/extensions/sts.html
 	STS Capability = iota
@@ -141,7 +153,9 @@ var (
 		"draft/event-playback",
 		"draft/extended-monitor",
 		"draft/languages",
+		"draft/metadata-2",
 		"draft/multiline",
+		"draft/pre-away",
 		"draft/relaymsg",
 		"echo-message",
 		"ergo.chat/nope",
@@ -153,6 +167,7 @@ var (
 		"sasl",
 		"server-time",
 		"setname",
+		"standard-replies",
 		"sts",
 		"userhost-in-names",
 		"znc.in/playback",
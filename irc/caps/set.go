@@ -84,6 +84,18 @@ func (s *Set) Subtract(other *Set) {
 	utils.BitsetSubtract(s[:], other[:])
 }
 
+// Diff returns the capabilities that are in this set but not in `old`,
+// and the capabilities that are in `old` but not in this set.
+func (s *Set) Diff(old *Set) (added, removed *Set) {
+	added = NewSet()
+	added.Union(s)
+	added.Subtract(old)
+	removed = NewSet()
+	removed.Union(old)
+	removed.Subtract(s)
+	return
+}
+
 // Empty returns whether the set is empty.
 func (s *Set) Empty() bool {
 	return utils.BitsetEmpty(s[:])
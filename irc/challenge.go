@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+)
+
+const (
+	// length in bytes of the random plaintext encrypted for CHALLENGE
+	operChallengeLen = 32
+	// base64 ciphertext is sent to the client in chunks of this length
+	operChallengeChunkLen = 60
+)
+
+var (
+	errInvalidPublicKey = errors.New("public key is not a PEM-encoded RSA key")
+)
+
+// operChallenge is the state of an in-progress CHALLENGE authentication.
+type operChallenge struct {
+	operName  string // casefolded name of the oper block
+	plaintext []byte
+}
+
+// parseOperPublicKey parses a PEM-encoded RSA public key, in either
+// PKIX ("BEGIN PUBLIC KEY") or PKCS#1 ("BEGIN RSA PUBLIC KEY") form.
+func parseOperPublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errInvalidPublicKey
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errInvalidPublicKey
+	}
+	return rsaKey, nil
+}
+
+// generateOperChallenge returns a random plaintext, together with
+// its base64-encoded RSA-OAEP (SHA-1) encryption under `key`.
+func generateOperChallenge(key *rsa.PublicKey) (plaintext []byte, challenge string, err error) {
+	plaintext = make([]byte, operChallengeLen)
+	if _, err = rand.Read(plaintext); err != nil {
+		return
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, key, plaintext, nil)
+	if err != nil {
+		return
+	}
+	return plaintext, base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// checkOperChallengeResponse checks a base64-encoded CHALLENGE response
+// against the expected plaintext.
+func checkOperChallengeResponse(plaintext []byte, response string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil || len(plaintext) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(plaintext, decoded) == 1
+}
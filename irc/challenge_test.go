@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+func TestOperChallenge(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*pem.Block{
+		{Type: "PUBLIC KEY", Bytes: pkix},
+		{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privKey.PublicKey)},
+	} {
+		pubKey, err := parseOperPublicKey(string(pem.EncodeToMemory(block)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext, challenge, err := generateOperChallenge(pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, privKey, ciphertext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEqual(checkOperChallengeResponse(plaintext, base64.StdEncoding.EncodeToString(decrypted)), true, t)
+		assertEqual(checkOperChallengeResponse(plaintext, base64.StdEncoding.EncodeToString(decrypted[1:])), false, t)
+		assertEqual(checkOperChallengeResponse(plaintext, "not base64!"), false, t)
+	}
+
+	if _, err := parseOperPublicKey("garbage"); err == nil {
+		t.Errorf("invalid PEM should fail to parse")
+	}
+}
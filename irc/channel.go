@@ -7,23 +7,35 @@ package irc
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"sync"
 
+	"github.com/ergochat/irc-go/ircfmt"
 	"github.com/ergochat/irc-go/ircutils"
 
 	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/connection_limits"
 	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
 type ChannelSettings struct {
 	History     HistoryStatus
 	QueryCutoff HistoryCutoff
+	EntryMsg    string `json:",omitempty"`
+	Flood       FloodSettings
+	Successor   string            `json:",omitempty"` // casefolded account; see CS SET SUCCESSOR
+	TopicLock   modes.Mode        `json:",omitempty"` // minimum AMODE required to change the topic
+	AmodeDelay  time.Duration     `json:",omitempty"` // delay before applying AMODEs on join
+	Language    string            `json:",omitempty"` // language for service notices about the channel
+	MuteNotify  bool              `json:",omitempty"` // notify operators when muted users try to speak
+	Metadata    map[string]string `json:",omitempty"` // draft/metadata-2; copy-on-write
 }
 
 // Channel represents a channel that clients can join.
@@ -39,13 +51,17 @@ type Channel struct {
 	server            *Server
 	createdTime       time.Time
 	registeredFounder string
+	founderHistory    []FounderChange
 	registeredTime    time.Time
-	transferPendingTo string
+	pendingTransfer   PendingTransfer
 	topic             string
 	topicSetBy        string
 	topicSetTime      time.Time
 	userLimit         int
 	accountToUMode    map[string]modes.Mode
+	akicks            map[string]AkickEntry
+	templates         map[string]modes.Modes // CS TEMPLATE: template name -> modes
+	roles             map[string]string      // CS ROLE: account -> template name
 	history           history.Buffer
 	stateMutex        sync.RWMutex    // tier 1
 	writerSemaphore   utils.Semaphore // tier 1.5
@@ -53,6 +69,12 @@ type Channel struct {
 	ensureLoaded      utils.Once      // manages loading stored registration info from the database
 	dirtyBits         uint
 	settings          ChannelSettings
+	lastClear         time.Time                         // last use of CS CLEAR, for rate limiting
+	joinThrottle      connection_limits.GenericThrottle // +j
+	joinProtection    modes.Mode                        // mode set automatically when +j is exceeded
+	joinDelay         int                               // +d, in seconds
+	permanentStored   bool                              // whether the db has a +P record for this channel
+	peakMembers       int                               // highest member count since startup, for CS STATS
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -128,6 +150,8 @@ func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	defer channel.stateMutex.Unlock()
 
 	channel.registeredFounder = chanReg.Founder
+	channel.founderHistory = chanReg.FounderHistory
+	channel.pendingTransfer = chanReg.PendingTransfer
 	channel.registeredTime = chanReg.RegisteredAt
 	channel.topic = chanReg.Topic
 	channel.topicSetBy = chanReg.TopicSetBy
@@ -136,8 +160,13 @@ func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	channel.createdTime = chanReg.RegisteredAt
 	channel.key = chanReg.Key
 	channel.userLimit = chanReg.UserLimit
+	channel.joinDelay = chanReg.JoinDelay
 	channel.settings = chanReg.Settings
 	channel.forward = chanReg.Forward
+	channel.joinThrottle = connection_limits.GenericThrottle{
+		Duration: chanReg.JoinThrottle.Period,
+		Limit:    chanReg.JoinThrottle.Joins,
+	}
 
 	for _, mode := range chanReg.Modes {
 		channel.flags.SetMode(mode, true)
@@ -148,6 +177,9 @@ func (channel *Channel) applyRegInfo(chanReg RegisteredChannel) {
 	channel.lists[modes.BanMask].SetMasks(chanReg.Bans)
 	channel.lists[modes.InviteMask].SetMasks(chanReg.Invites)
 	channel.lists[modes.ExceptMask].SetMasks(chanReg.Excepts)
+	channel.akicks = chanReg.Akicks
+	channel.templates = chanReg.Templates
+	channel.roles = chanReg.Roles
 }
 
 // obtain a consistent snapshot of the channel state that can be persisted to the DB
@@ -160,6 +192,12 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 	info.Founder = channel.registeredFounder
 	info.RegisteredAt = channel.registeredTime
 
+	if includeFlags&IncludeInitial != 0 {
+		info.FounderHistory = make([]FounderChange, len(channel.founderHistory))
+		copy(info.FounderHistory, channel.founderHistory)
+		info.PendingTransfer = channel.pendingTransfer
+	}
+
 	if includeFlags&IncludeTopic != 0 {
 		info.Topic = channel.topic
 		info.TopicSetBy = channel.topicSetBy
@@ -169,8 +207,15 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 	if includeFlags&IncludeModes != 0 {
 		info.Key = channel.key
 		info.Forward = channel.forward
-		info.Modes = channel.flags.AllModes()
+		for _, mode := range channel.flags.AllModes() {
+			// don't persist the temporary protection against join floods
+			if mode != channel.joinProtection {
+				info.Modes = append(info.Modes, mode)
+			}
+		}
 		info.UserLimit = channel.userLimit
+		info.JoinDelay = channel.joinDelay
+		info.JoinThrottle = channel.getJoinThrottleNoMutex()
 	}
 
 	if includeFlags&IncludeLists != 0 {
@@ -181,6 +226,18 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 		for account, mode := range channel.accountToUMode {
 			info.AccountToUMode[account] = mode
 		}
+		info.Akicks = make(map[string]AkickEntry, len(channel.akicks))
+		for key, entry := range channel.akicks {
+			info.Akicks[key] = entry
+		}
+		info.Templates = make(map[string]modes.Modes, len(channel.templates))
+		for name, tmodes := range channel.templates {
+			info.Templates[name] = tmodes
+		}
+		info.Roles = make(map[string]string, len(channel.roles))
+		for account, template := range channel.roles {
+			info.Roles[account] = template
+		}
 	}
 
 	if includeFlags&IncludeSettings != 0 {
@@ -197,10 +254,10 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 // This is the equivalent of Socket.Write().
 func (channel *Channel) MarkDirty(dirtyBits uint) {
 	channel.stateMutex.Lock()
-	isRegistered := channel.registeredFounder != ""
+	needsWrite := channel.registeredFounder != "" || channel.flags.HasMode(modes.Permanent) || channel.permanentStored
 	channel.dirtyBits = channel.dirtyBits | dirtyBits
 	channel.stateMutex.Unlock()
-	if !isRegistered {
+	if !needsWrite {
 		return
 	}
 
@@ -224,7 +281,11 @@ func (channel *Channel) IsClean() bool {
 		return false
 	}
 	// see #1507 and #704 among others; registered channels should never be removed
-	return channel.registeredFounder == ""
+	if channel.registeredFounder != "" {
+		return false
+	}
+	// likewise for +P channels, until the +P record has been deleted
+	return !(channel.flags.HasMode(modes.Permanent) || channel.permanentStored)
 }
 
 func (channel *Channel) wakeWriter() {
@@ -286,8 +347,17 @@ func (channel *Channel) performWrite(additionalDirtyBits uint) (err error) {
 	dirtyBits := channel.dirtyBits | additionalDirtyBits
 	channel.dirtyBits = 0
 	isRegistered := channel.registeredFounder != ""
+	isPermanent := !isRegistered && channel.flags.HasMode(modes.Permanent)
+	wasPermanent := channel.permanentStored
 	channel.stateMutex.Unlock()
 
+	if isPermanent || wasPermanent {
+		err = channel.performPermanentWrite(isPermanent, dirtyBits)
+		if err != nil || isPermanent {
+			return
+		}
+	}
+
 	if !isRegistered || dirtyBits == 0 {
 		return
 	}
@@ -313,6 +383,8 @@ func (channel *Channel) SetRegistered(founder string) error {
 	channel.registeredFounder = founder
 	channel.registeredTime = time.Now().UTC()
 	channel.accountToUMode[founder] = modes.ChannelFounder
+	// registration supersedes +P; the +P record is deleted on the next write
+	channel.flags.SetMode(modes.Permanent, false)
 	return nil
 }
 
@@ -342,6 +414,88 @@ func (channel *Channel) resetAccess() {
 	}
 }
 
+// checkClearCooldown rate-limits CS CLEAR: if the cooldown has elapsed since
+// the last use, it records a new use and returns true.
+func (channel *Channel) checkClearCooldown(cooldown time.Duration) (ok bool, remaining time.Duration) {
+	now := time.Now().UTC()
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	if elapsed := now.Sub(channel.lastClear); elapsed < cooldown {
+		return false, cooldown - elapsed
+	}
+	channel.lastClear = now
+	return true, 0
+}
+
+// clearListChanges returns the changes that empty a mask list (+b, +e, or +I).
+func (channel *Channel) clearListChanges(mode modes.Mode) (result modes.ModeChanges) {
+	masks := channel.lists[mode].Masks()
+	for mask := range masks {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: mode, Arg: mask})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Arg < result[j].Arg })
+	return
+}
+
+// resetModeChanges returns the changes that restore the default channel modes,
+// removing any key, user limit, or forward.
+func (channel *Channel) resetModeChanges(defaultModes modes.Modes) (result modes.ModeChanges) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+
+	for _, mode := range channel.flags.AllModes() {
+		if !modeListContains(defaultModes, mode) {
+			result = append(result, modes.ModeChange{Op: modes.Remove, Mode: mode})
+		}
+	}
+	if channel.key != "" {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: modes.Key, Arg: "*"})
+	}
+	if channel.userLimit != 0 {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: modes.UserLimit})
+	}
+	if channel.forward != "" {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: modes.Forward})
+	}
+	if channel.joinThrottle.Limit != 0 {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: modes.JoinThrottle})
+	}
+	if channel.joinDelay != 0 {
+		result = append(result, modes.ModeChange{Op: modes.Remove, Mode: modes.JoinDelay})
+	}
+	for _, mode := range defaultModes {
+		if !channel.flags.HasMode(mode) {
+			result = append(result, modes.ModeChange{Op: modes.Add, Mode: mode})
+		}
+	}
+	return
+}
+
+// deopChanges returns the changes that remove +o, +h, and +v from all members
+// except `exempt`.
+func (channel *Channel) deopChanges(exempt *Client) (result modes.ModeChanges) {
+	members := channel.Members()
+	nicks := make(map[*Client]string, len(members))
+	for _, member := range members {
+		nicks[member] = member.Nick()
+	}
+
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	for _, member := range members {
+		memberData, ok := channel.members[member]
+		if !ok || member == exempt {
+			continue
+		}
+		for _, mode := range []modes.Mode{modes.ChannelOperator, modes.Halfop, modes.Voice} {
+			if memberData.modes.HasMode(mode) {
+				result = append(result, modes.ModeChange{Op: modes.Remove, Mode: mode, Arg: nicks[member]})
+			}
+		}
+	}
+	return
+}
+
 // IsRegistered returns whether the channel is registered.
 func (channel *Channel) IsRegistered() bool {
 	channel.stateMutex.RLock()
@@ -358,12 +512,38 @@ const (
 	channelTransferFailed
 )
 
+// PendingTransfer is an offer of channel ownership (CS TRANSFER)
+// that has not yet been accepted by the recipient.
+type PendingTransfer struct {
+	To   string
+	Time time.Time
+}
+
+// checkAccept returns whether the transfer can be accepted at `now`: it must have
+// been pending for at least `cooldown`, and not for longer than `expiration`.
+func (pt PendingTransfer) checkAccept(now time.Time, cooldown, expiration time.Duration) (wait time.Duration, err error) {
+	elapsed := now.Sub(pt.Time)
+	if expiration != 0 && elapsed >= expiration {
+		return 0, errChannelTransferExpired
+	}
+	if elapsed < cooldown {
+		return cooldown - elapsed, errChannelTransferCooldown
+	}
+	return 0, nil
+}
+
 // Transfer transfers ownership of a registered channel to a different account
 func (channel *Channel) Transfer(client *Client, target string, hasPrivs bool) (status channelTransferStatus, err error) {
 	status = channelTransferFailed
 	defer func() {
-		if status == channelTransferComplete && err == nil {
+		if err != nil {
+			return
+		}
+		switch status {
+		case channelTransferComplete:
 			channel.Store(IncludeAllAttrs)
+		case channelTransferPending, channelTransferCancelled:
+			channel.Store(IncludeInitial)
 		}
 	}()
 
@@ -379,46 +559,157 @@ func (channel *Channel) Transfer(client *Client, target string, hasPrivs bool) (
 		return
 	}
 	if hasPrivs {
-		channel.transferOwnership(cftarget)
+		channel.transferOwnership(cftarget, founderChangeTransfer)
 		return channelTransferComplete, nil
 	} else {
 		if channel.registeredFounder == cftarget {
 			// transferring back to yourself cancels a pending transfer
-			channel.transferPendingTo = ""
+			channel.pendingTransfer = PendingTransfer{}
 			return channelTransferCancelled, nil
 		} else {
-			channel.transferPendingTo = cftarget
+			channel.pendingTransfer = PendingTransfer{
+				To:   cftarget,
+				Time: time.Now().UTC(),
+			}
 			return channelTransferPending, nil
 		}
 	}
 }
 
-func (channel *Channel) transferOwnership(newOwner string) {
+// PendingTransfer returns the channel's pending transfer, if any
+func (channel *Channel) PendingTransfer() (result PendingTransfer) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.pendingTransfer
+}
+
+// CancelTransfer cancels a pending transfer; this can be done by the founder,
+// the recipient, or an oper (if hasPrivs is set).
+func (channel *Channel) CancelTransfer(client *Client, hasPrivs bool) (cancelled PendingTransfer, founder string, err error) {
+	defer func() {
+		if err == nil {
+			channel.Store(IncludeInitial)
+		}
+	}()
+
+	account := client.Account()
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	cancelled, founder = channel.pendingTransfer, channel.registeredFounder
+	if cancelled.To == "" {
+		return cancelled, founder, errChannelTransferNotOffered
+	}
+	if !(hasPrivs || (account != "" && (account == founder || account == cancelled.To))) {
+		return cancelled, founder, errInsufficientPrivs
+	}
+	channel.pendingTransfer = PendingTransfer{}
+	return
+}
+
+func (channel *Channel) transferOwnership(newOwner, reason string) {
+	channel.recordFounderChange(channel.registeredFounder, newOwner, reason)
 	delete(channel.accountToUMode, channel.registeredFounder)
 	channel.registeredFounder = newOwner
 	channel.accountToUMode[channel.registeredFounder] = modes.ChannelFounder
-	channel.transferPendingTo = ""
+	channel.pendingTransfer = PendingTransfer{}
 }
 
-// AcceptTransfer implements `CS TRANSFER #chan ACCEPT`
-func (channel *Channel) AcceptTransfer(client *Client) (err error) {
+// channelMergeResult describes the changes to a channel from an account merge
+type channelMergeResult struct {
+	founder          bool
+	fromMode, toMode modes.Mode
+	fromRole, toRole string
+}
+
+func (result channelMergeResult) changed() bool {
+	return result.founder || result.fromMode != modes.Mode(0) || result.fromRole != ""
+}
+
+// mergeAccount moves the founder status, amode and role of the account `from`
+// to the account `to` (implements NS SAMERGE). If both accounts have an amode
+// or a role, the destination's is kept.
+func (channel *Channel) mergeAccount(from, to string) (result channelMergeResult) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	result.fromRole, result.toRole = channel.roles[from], channel.roles[to]
+	if result.fromRole != "" {
+		delete(channel.roles, from)
+		if result.toRole == "" {
+			channel.roles[to] = result.fromRole
+		}
+	}
+
+	result.fromMode = channel.accountToUMode[from]
+	result.toMode = channel.accountToUMode[to]
+	if channel.registeredFounder == from {
+		result.founder = true
+		channel.transferOwnership(to, founderChangeMerge)
+		return
+	}
+	delete(channel.accountToUMode, from)
+	if result.fromMode != modes.Mode(0) && result.toMode == modes.Mode(0) {
+		channel.accountToUMode[to] = result.fromMode
+	}
+	return
+}
+
+// AcceptTransfer implements `CS TRANSFER ACCEPT #chan`; unless override is set,
+// the transfer must have been pending for at least the configured cooldown.
+// It returns the previous founder, or how long to wait before accepting.
+func (channel *Channel) AcceptTransfer(client *Client, override bool) (from string, wait time.Duration, err error) {
+	var expired bool
 	defer func() {
 		if err == nil {
 			channel.Store(IncludeAllAttrs)
+		} else if expired {
+			channel.Store(IncludeInitial)
 		}
 	}()
 
 	account := client.Account()
 	if account == "" {
-		return errAccountNotLoggedIn
+		return "", 0, errAccountNotLoggedIn
 	}
+	config := channel.server.Config()
+	cooldown := time.Duration(config.Channels.Registration.TransferCooldown)
+	expiration := time.Duration(config.Channels.Registration.TransferExpiration)
+
 	channel.stateMutex.Lock()
 	defer channel.stateMutex.Unlock()
-	if account != channel.transferPendingTo {
-		return errChannelTransferNotOffered
+	if account != channel.pendingTransfer.To {
+		return "", 0, errChannelTransferNotOffered
+	}
+	if !override {
+		wait, err = channel.pendingTransfer.checkAccept(time.Now().UTC(), cooldown, expiration)
+		if err == errChannelTransferExpired {
+			expired = true
+			channel.pendingTransfer = PendingTransfer{}
+		}
+		if err != nil {
+			return
+		}
 	}
-	channel.transferOwnership(account)
-	return nil
+	from = channel.registeredFounder
+	channel.transferOwnership(account, founderChangeTransfer)
+	return
+}
+
+// sendEntryMessage sends the channel's entry message (CS SET ENTRYMSG),
+// if any, to a joining client
+func (channel *Channel) sendEntryMessage(client *Client, founder string, rb *ResponseBuffer) {
+	channel.stateMutex.RLock()
+	entryMsg := channel.settings.EntryMsg
+	chname := channel.name
+	channel.stateMutex.RUnlock()
+
+	if entryMsg == "" {
+		return
+	}
+	if founder != "" && founder == client.Account() && channel.server.Config().Channels.EntryMessage.ExemptFounder {
+		return
+	}
+	rb.Add(channelContextTags(rb.session, chname), chanservService.prefix, "NOTICE", client.Nick(), entryMsg)
 }
 
 func (channel *Channel) regenerateMembersCache() {
@@ -618,6 +909,7 @@ func (channel *Channel) modeStrings(client *Client) (result []string) {
 	showKey := isMember && (channel.key != "")
 	showUserLimit := channel.userLimit > 0
 	showForward := channel.forward != ""
+	joinThrottle := channel.getJoinThrottleNoMutex().String()
 
 	var mods strings.Builder
 	mods.WriteRune('+')
@@ -632,6 +924,12 @@ func (channel *Channel) modeStrings(client *Client) (result []string) {
 	if showForward {
 		mods.WriteRune(rune(modes.Forward))
 	}
+	if joinThrottle != "" {
+		mods.WriteRune(rune(modes.JoinThrottle))
+	}
+	if channel.joinDelay != 0 {
+		mods.WriteRune(rune(modes.JoinDelay))
+	}
 
 	for _, m := range channel.flags.AllModes() {
 		mods.WriteRune(rune(m))
@@ -650,6 +948,12 @@ func (channel *Channel) modeStrings(client *Client) (result []string) {
 	if showForward {
 		result = append(result, channel.forward)
 	}
+	if joinThrottle != "" {
+		result = append(result, joinThrottle)
+	}
+	if channel.joinDelay != 0 {
+		result = append(result, strconv.Itoa(channel.joinDelay))
+	}
 
 	return
 }
@@ -742,7 +1046,8 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	limit := channel.userLimit
 	chcount := len(channel.members)
 	_, alreadyJoined := channel.members[client]
-	persistentMode := channel.accountToUMode[details.account]
+	persistentModes := channel.persistentModesNoMutex(details.account)
+	persistentMode := highestChannelUserMode(persistentModes)
 	forward = channel.forward
 	channel.stateMutex.RUnlock()
 
@@ -769,20 +1074,23 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 
 		// #1901: +h and up exempt from all restrictions, but +v additionally exempts from +i:
 		if channel.flags.HasMode(modes.InviteOnly) && persistentMode == 0 &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+			!channel.lists[modes.InviteMask].MatchClient(details.nickMaskCasefolded, details.account, details.realname) {
 			return errInviteOnly, forward
 		}
 
-		if channel.lists[modes.BanMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.ExceptMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
-			// do not forward people who are banned:
-			return errBanned, ""
+		if channel.lists[modes.BanMask].MatchClient(details.nickMaskCasefolded, details.account, details.realname) &&
+			!channel.lists[modes.ExceptMask].MatchClient(details.nickMaskCasefolded, details.account, details.realname) &&
+			!channel.lists[modes.InviteMask].MatchClient(details.nickMaskCasefolded, details.account, details.realname) {
+			return errBanned, forward
+		}
+
+		if akick, found := channel.checkAkick(details.nickMaskCasefolded, details.account, details.realname); found {
+			return &akickError{reason: akick.Reason}, ""
 		}
 
 		if details.account == "" &&
 			(channel.flags.HasMode(modes.RegisteredOnly) || channel.server.Defcon() <= 2) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+			!channel.lists[modes.InviteMask].MatchClient(details.nickMaskCasefolded, details.account, details.realname) {
 			return errRegisteredOnly, forward
 		}
 	}
@@ -793,7 +1101,7 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 
 	client.server.logger.Debug("channels", fmt.Sprintf("%s joined channel %s", details.nick, chname))
 
-	givenMode := func() (givenMode modes.Mode) {
+	givenModes := func() (givenModes modes.Modes) {
 		channel.joinPartMutex.Lock()
 		defer channel.joinPartMutex.Unlock()
 
@@ -802,15 +1110,29 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 			defer channel.stateMutex.Unlock()
 
 			channel.members.Add(client)
+			if len(channel.members) > channel.peakMembers {
+				channel.peakMembers = len(channel.members)
+			}
 			firstJoin := len(channel.members) == 1
 			newChannel := firstJoin && channel.registeredFounder == ""
 			if newChannel {
-				givenMode = modes.ChannelOperator
+				givenModes = modes.Modes{modes.ChannelOperator}
 			} else {
-				givenMode = persistentMode
+				givenModes = persistentModes
+				// CS SET AMODE-DELAY: apply the modes later, if the client is still here
+				// (unless this is an always-on client rejoining at startup)
+				if delay := channel.settings.AmodeDelay; delay != 0 && len(givenModes) != 0 && rb != nil {
+					givenModes = nil
+					memberData := channel.members[client]
+					joinTime := memberData.joinTime
+					memberData.amodeTimer = time.AfterFunc(delay, func() {
+						channel.applyDelayedAmodes(client, joinTime)
+					})
+					channel.members[client] = memberData
+				}
 			}
-			if givenMode != 0 {
-				channel.members[client].modes.SetMode(givenMode, true)
+			for _, mode := range givenModes {
+				channel.members[client].modes.SetMode(mode, true)
 			}
 		}()
 
@@ -820,7 +1142,7 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	}()
 
 	var message utils.SplitMessage
-	respectAuditorium := givenMode == modes.Mode(0) && channel.flags.HasMode(modes.Auditorium)
+	respectAuditorium := len(givenModes) == 0 && channel.flags.HasMode(modes.Auditorium)
 	message = utils.MakeMessage("")
 	// no history item for fake persistent joins
 	if rb != nil && !respectAuditorium {
@@ -839,9 +1161,25 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		return nil, ""
 	}
 
-	var modestr string
-	if givenMode != 0 {
-		modestr = fmt.Sprintf("+%v", givenMode)
+	var throttleApplied modes.ModeChanges
+	if !hasPrivs {
+		throttleApplied = channel.touchJoinThrottle()
+		if len(throttleApplied) != 0 {
+			client.server.snomasks.Send(sno.LocalFlood, fmt.Sprintf(ircfmt.Unescape("Join throttle exceeded in channel $c[grey][$r%[1]s$c[grey]]; set +%[2]s"), chname, throttleApplied[0].Mode))
+		}
+	}
+
+	// kick off the autoreplay query now, so it runs while we send the JOIN burst
+	historyChan := channel.prefetchAutoreplayHistory(client, rb.session)
+
+	// MODE parameters announcing the persistent modes given on join, e.g.,
+	// `#chan +ov nick nick`
+	var modeParams []string
+	if len(givenModes) != 0 {
+		modeParams = append(modeParams, chname, "+"+givenModes.String())
+		for range givenModes {
+			modeParams = append(modeParams, details.nick)
+		}
 	}
 
 	// cache the most common case (JOIN without extended-join)
@@ -869,12 +1207,15 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 			} else {
 				cache.Send(session)
 			}
-			if givenMode != 0 {
-				session.Send(nil, client.server.name, "MODE", chname, modestr, details.nick)
+			if len(modeParams) != 0 {
+				session.Send(nil, client.server.name, "MODE", modeParams...)
 			}
 			if isAway && session.capabilities.Has(caps.AwayNotify) {
 				session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "AWAY", awayMessage)
 			}
+			if session.capabilities.Has(caps.Metadata) {
+				sendMemberMetadata(session, client)
+			}
 		}
 	}
 
@@ -888,61 +1229,89 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		// don't send topic and names for a SAJOIN of a different client
 		channel.SendTopic(client, rb, false)
 		channel.Names(client, rb)
+		channel.sendEntryMessage(client, founder, rb)
+		channel.sendMetadataBurst(client, rb)
 	} else {
 		// ensure that SAJOIN sends a MODE line to the originating client, if applicable
-		if givenMode != 0 {
-			rb.Add(nil, client.server.name, "MODE", chname, modestr, details.nick)
+		if len(modeParams) != 0 {
+			rb.Add(nil, client.server.name, "MODE", modeParams...)
 		}
 	}
 
+	if len(throttleApplied) != 0 {
+		announceCmodeChanges(channel, throttleApplied, client.server.name, "*", "", false, rb)
+		channel.noticeJoinThrottleExceeded(throttleApplied[0].Mode)
+	}
+
 	// TODO #259 can be implemented as Flush(false) (i.e., nonblocking) while holding joinPartMutex
 	rb.Flush(true)
 
-	channel.autoReplayHistory(client, rb, message.Msgid)
+	if historyChan != nil {
+		channel.replayPrefetchedHistory(rb, <-historyChan, message.Msgid)
+	}
 	return nil, ""
 }
 
 func (channel *Channel) autoReplayHistory(client *Client, rb *ResponseBuffer, skipMsgid string) {
-	// autoreplay any messages as necessary
-	var items []history.Item
+	if historyChan := channel.prefetchAutoreplayHistory(client, rb.session); historyChan != nil {
+		channel.replayPrefetchedHistory(rb, <-historyChan, skipMsgid)
+	}
+}
 
-	hasAutoreplayTimestamps := false
+// prefetchAutoreplayHistory starts the history query for autoreplay (if any is
+// needed) on a separate goroutine, so that it can run concurrently with sending
+// the JOIN burst; the result is delivered on the returned channel, which is nil
+// if no replay is necessary.
+func (channel *Channel) prefetchAutoreplayHistory(client *Client, session *Session) (result chan []history.Item) {
 	var start, end time.Time
-	if rb.session.zncPlaybackTimes.ValidFor(channel.NameCasefolded()) {
-		hasAutoreplayTimestamps = true
-		start, end = rb.session.zncPlaybackTimes.start, rb.session.zncPlaybackTimes.end
-	} else if !rb.session.autoreplayMissedSince.IsZero() {
+	var limit int
+
+	if session.zncPlaybackTimes.ValidFor(channel.NameCasefolded()) {
+		start, end = session.zncPlaybackTimes.start, session.zncPlaybackTimes.end
+		limit = channel.server.Config().History.ZNCMax
+	} else if !session.autoreplayMissedSince.IsZero() {
 		// we already checked for history caps in `playReattachMessages`
-		hasAutoreplayTimestamps = true
 		start = time.Now().UTC()
-		end = rb.session.autoreplayMissedSince
-	}
-
-	if hasAutoreplayTimestamps {
-		_, seq, _ := channel.server.GetHistorySequence(channel, client, "")
-		if seq != nil {
-			zncMax := channel.server.Config().History.ZNCMax
-			items, _ = seq.Between(history.Selector{Time: start}, history.Selector{Time: end}, zncMax)
-		}
-	} else if !rb.session.HasHistoryCaps() {
-		var replayLimit int
+		end = session.autoreplayMissedSince
+		limit = channel.server.Config().History.ZNCMax
+	} else if channel.flags.HasMode(modes.HiddenHistory) {
+		// +H: no autoreplay on join, but history can still be requested explicitly
+		return nil
+	} else if !session.HasHistoryCaps() {
 		customReplayLimit := client.AccountSettings().AutoreplayLines
 		if customReplayLimit != nil {
-			replayLimit = *customReplayLimit
+			limit = *customReplayLimit
 			maxLimit := channel.server.Config().History.ChathistoryMax
-			if maxLimit < replayLimit {
-				replayLimit = maxLimit
+			if maxLimit < limit {
+				limit = maxLimit
 			}
 		} else {
-			replayLimit = channel.server.Config().History.AutoreplayOnJoin
+			limit = channel.server.Config().History.AutoreplayOnJoin
 		}
-		if 0 < replayLimit {
-			_, seq, _ := channel.server.GetHistorySequence(channel, client, "")
-			if seq != nil {
-				items, _ = seq.Between(history.Selector{}, history.Selector{}, replayLimit)
-			}
+		if limit <= 0 {
+			return nil
 		}
+	} else {
+		return nil
 	}
+
+	result = make(chan []history.Item, 1)
+	go func() {
+		defer channel.server.HandlePanic()
+
+		var items []history.Item
+		defer func() {
+			result <- items
+		}()
+		_, seq, _ := channel.server.GetHistorySequence(channel, client, "")
+		if seq != nil {
+			items, _ = seq.Between(history.Selector{Time: start}, history.Selector{Time: end}, limit)
+		}
+	}()
+	return result
+}
+
+func (channel *Channel) replayPrefetchedHistory(rb *ResponseBuffer, items []history.Item, skipMsgid string) {
 	// remove the client's own JOIN line from the replay
 	numItems := len(items)
 	for i := len(items) - 1; 0 <= i; i-- {
@@ -1189,6 +1558,13 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 		return
 	}
 
+	// CS SET TOPICLOCK: unlike +t, this checks persistent access (AMODEs)
+	if lock := channel.Settings().TopicLock; lock != modes.Mode(0) && channel.IsRegistered() &&
+		!(csHasAccessLevel(channel, client, lock) || client.HasRoleCapabs("samode")) {
+		rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), channel.Name(), fmt.Sprintf(client.t("The topic is locked to users with access level: %s"), topicLockToString(lock)))
+		return
+	}
+
 	topic = ircutils.TruncateUTF8Safe(topic, client.server.Config().Limits.TopicLen)
 
 	channel.stateMutex.Lock()
@@ -1246,12 +1622,9 @@ func (channel *Channel) CanSpeak(client *Client) (bool, modes.Mode) {
 }
 
 func (channel *Channel) isMuted(client *Client) bool {
-	muteRe := channel.lists[modes.BanMask].MuteRegexp()
-	if muteRe == nil {
-		return false
-	}
-	nuh := client.NickMaskCasefolded()
-	return muteRe.MatchString(nuh) && !channel.lists[modes.ExceptMask].MatchMute(nuh)
+	details := client.Details()
+	return channel.lists[modes.BanMask].MatchMuteClient(details.nickMaskCasefolded, details.account, details.realname) &&
+		!channel.lists[modes.ExceptMask].MatchMuteClient(details.nickMaskCasefolded, details.account, details.realname)
 }
 
 func (channel *Channel) relayNickMuted(relayNick string) bool {
@@ -1280,20 +1653,41 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 	}
 
 	if canSpeak, mode := channel.CanSpeak(client); !canSpeak {
-		if histType != history.Notice {
+		if mode == modes.BanMask {
+			// muted (+b m:); let the sender know, and optionally the operators
+			if histType != history.Notice {
+				rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), client.t("Cannot send to channel (you are muted)"))
+				rb.Add(nil, client.server.name, "FAIL", command, "MUTED", channel.Name(), client.t("You are muted on this channel"))
+			}
+			if histType != history.Tagmsg {
+				channel.noticeMutedMessage(client, message)
+			}
+		} else if histType != history.Notice {
 			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), fmt.Sprintf(client.t("Cannot send to channel (+%s)"), mode))
 		}
 		return
 	}
 
+	if wait := channel.joinDelayRemaining(client); wait != 0 {
+		if histType != history.Notice {
+			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), fmt.Sprintf(client.t("You must wait %d seconds after joining before sending messages"), wait))
+		}
+		return
+	}
+
 	isCTCP := message.IsRestrictedCTCPMessage()
 	if isCTCP && channel.flags.HasMode(modes.NoCTCP) {
 		if histType != history.Notice {
-			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), fmt.Sprintf(client.t("Cannot send to channel (+%s)"), "C"))
+			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), client.t("CTCP blocked."))
 		}
 		return
 	}
 
+	if flooded, action := channel.checkFlood(client, floodMessageWeight(client.server.Config(), histType, isCTCP)); flooded {
+		channel.applyFloodAction(client, action)
+		return
+	}
+
 	details := client.Details()
 	isBot := client.HasMode(modes.Bot)
 	chname := channel.Name()
@@ -1322,16 +1716,23 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 		}
 	}
 
+	if histType != history.Tagmsg {
+		client.server.messageLog.Log(command, details.nickMask, details.accountName, chname, message)
+	}
+
 	// send echo-message
 	rb.addEchoMessage(clientOnlyTags, details.nickMask, details.accountName, command, chname, message)
 
 	var cache MessageCache
 	cache.InitializeSplitMessage(channel.server, details.nickMask, details.accountName, isBot, clientOnlyTags, command, chname, message)
+	operTags := impersonationTags(client, clientOnlyTags)
 	for _, member := range channel.Members() {
 		if minPrefixMode != modes.Mode(0) && !channel.ClientIsAtLeast(member, minPrefixMode) {
 			// STATUSMSG or OpModerated
 			continue
 		}
+		// operators are shown who is behind an impersonated account:
+		tagOperator := operTags != nil && member.Oper() != nil
 
 		for _, session := range member.Sessions() {
 			if session == rb.session {
@@ -1342,6 +1743,15 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 				continue // #753
 			}
 
+			if tagOperator && session.capabilities.Has(caps.MessageTags) {
+				if histType == history.Tagmsg {
+					session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, operTags, command, chname)
+				} else {
+					session.sendSplitMsgFromClientInternal(false, details.nickMask, details.accountName, isBot, operTags, command, chname, message)
+				}
+				continue
+			}
+
 			cache.Send(session)
 		}
 	}
@@ -1554,6 +1964,9 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 	}
 
 	rb.Add(nil, inviter.server.name, RPL_INVITING, details.nick, tnick, chname)
+	if invitee.isIgnoring(inviter, history.Invite) {
+		return
+	}
 	for _, iSession := range invitee.Sessions() {
 		iSession.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "INVITE", tnick, chname)
 	}
@@ -1603,6 +2016,26 @@ func (channel *Channel) auditoriumFriends(client *Client) (friends []*Client) {
 	return
 }
 
+// memberModeParams returns the MODE parameters that give a member its
+// current channel user modes (e.g., `#chan +ov nick nick`), or nil if it has none.
+func (channel *Channel) memberModeParams(client *Client, nick string) (params []string) {
+	channel.stateMutex.RLock()
+	memberData, ok := channel.members[client]
+	channel.stateMutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	memberModes := memberData.modes.AllModes()
+	if len(memberModes) == 0 {
+		return nil
+	}
+	params = append(params, channel.Name(), "+"+modes.Modes(memberModes).String())
+	for range memberModes {
+		params = append(params, nick)
+	}
+	return
+}
+
 // data for RPL_LIST
 func (channel *Channel) listData() (memberCount int, name, topic string) {
 	channel.stateMutex.RLock()
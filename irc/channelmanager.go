@@ -6,6 +6,7 @@ package irc
 import (
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ergochat/ergo/irc/utils"
 )
@@ -26,22 +27,32 @@ type ChannelManager struct {
 	sync.RWMutex // tier 2
 	// chans is the main data structure, mapping casefolded name -> *Channel
 	chans               map[string]*channelManagerEntry
-	chansSkeletons      utils.StringSet // skeletons of *unregistered* chans
-	registeredChannels  utils.StringSet // casefolds of registered chans
-	registeredSkeletons utils.StringSet // skeletons of registered chans
-	purgedChannels      utils.StringSet // casefolds of purged chans
+	chansSkeletons      utils.StringSet           // skeletons of *unregistered* chans
+	registeredChannels  utils.StringSet           // casefolds of registered chans
+	registeredSkeletons utils.StringSet           // skeletons of registered chans
+	purgedChannels      utils.StringSet           // casefolds of purged chans
+	renameRedirects     map[string]renameRedirect // casefolded old name -> new name, after RENAME
 	server              *Server
 }
 
+// renameRedirect forwards joins from a channel's old name after a RENAME
+type renameRedirect struct {
+	newName string
+	expires time.Time
+}
+
 // NewChannelManager returns a new ChannelManager.
 func (cm *ChannelManager) Initialize(server *Server) {
 	cm.chans = make(map[string]*channelManagerEntry)
 	cm.chansSkeletons = make(utils.StringSet)
+	cm.renameRedirects = make(map[string]renameRedirect)
 	cm.server = server
 
 	// purging should work even if registration is disabled
 	cm.purgedChannels = cm.server.channelRegistry.PurgedChannels()
 	cm.loadRegisteredChannels(server.Config())
+	// +P channels don't depend on registration being enabled
+	cm.loadPermanentChannels()
 }
 
 func (cm *ChannelManager) loadRegisteredChannels(config *Config) {
@@ -126,6 +137,15 @@ func (cm *ChannelManager) Join(client *Client, name string, key string, isSajoin
 			return nil, errChannelPurged, false
 		}
 		entry := cm.chans[casefoldedName]
+		if entry == nil && !isSajoin {
+			if redirect, ok := cm.renameRedirects[casefoldedName]; ok {
+				if time.Now().Before(redirect.expires) {
+					forward = redirect.newName
+					return nil, errChannelRenamed, false
+				}
+				delete(cm.renameRedirects, casefoldedName)
+			}
+		}
 		if entry == nil {
 			registered := cm.registeredChannels.Has(casefoldedName)
 			// enforce OpOnlyCreation
@@ -157,7 +177,7 @@ func (cm *ChannelManager) Join(client *Client, name string, key string, isSajoin
 	}()
 
 	if err != nil {
-		return err, ""
+		return err, forward
 	}
 
 	channel.EnsureLoaded()
@@ -374,6 +394,15 @@ func (cm *ChannelManager) Rename(name string, newName string) (err error) {
 		cm.chansSkeletons.Add(newSkeleton)
 	}
 	entry.channel.Rename(newName, newCfname)
+
+	// a real channel now exists under the new name:
+	delete(cm.renameRedirects, newCfname)
+	if redirectDuration := time.Duration(cm.server.Config().Channels.RenameRedirect); oldCfname != newCfname && redirectDuration > 0 {
+		cm.renameRedirects[oldCfname] = renameRedirect{
+			newName: newName,
+			expires: time.Now().Add(redirectDuration),
+		}
+	}
 	return nil
 }
 
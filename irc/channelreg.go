@@ -36,8 +36,19 @@ const (
 	keyChannelUserLimit      = "channel.userlimit %s"
 	keyChannelSettings       = "channel.settings %s"
 	keyChannelForward        = "channel.forward %s"
+	keyChannelAkicks         = "channel.akicks %s"
+	keyChannelTemplates      = "channel.templates %s" // map of template name to mode string
+	keyChannelRoles          = "channel.roles %s"     // map of account to template name
+	keyChannelJoinThrottle   = "channel.jointhrottle %s"
+	keyChannelJoinDelay      = "channel.joindelay %s"
+	keyChannelFounderHistory = "channel.founderhistory %s"
+	keyChannelTransfer       = "channel.transfer %s" // pending CS TRANSFER
 
 	keyChannelPurged = "channel.purged %s"
+
+	// unregistered channels made permanent by an oper with +P; the value is
+	// a JSON-serialized RegisteredChannel with no founder
+	keyChannelPermanent = "channel.permanent %s"
 )
 
 var (
@@ -58,6 +69,13 @@ var (
 		keyChannelUserLimit,
 		keyChannelSettings,
 		keyChannelForward,
+		keyChannelAkicks,
+		keyChannelTemplates,
+		keyChannelRoles,
+		keyChannelJoinThrottle,
+		keyChannelJoinDelay,
+		keyChannelFounderHistory,
+		keyChannelTransfer,
 	}
 )
 
@@ -86,6 +104,10 @@ type RegisteredChannel struct {
 	RegisteredAt time.Time
 	// Founder indicates the founder of the channel.
 	Founder string
+	// FounderHistory records past changes of founder.
+	FounderHistory []FounderChange
+	// PendingTransfer is an outstanding offer of ownership (CS TRANSFER).
+	PendingTransfer PendingTransfer
 	// Topic represents the channel topic.
 	Topic string
 	// TopicSetBy represents the host that set the topic.
@@ -100,6 +122,10 @@ type RegisteredChannel struct {
 	Forward string
 	// UserLimit is the user limit (0 for no limit)
 	UserLimit int
+	// JoinThrottle is the join throttle (+j), if any
+	JoinThrottle JoinThrottle
+	// JoinDelay is the join delay in seconds (+d), or 0 for none
+	JoinDelay int
 	// AccountToUMode maps user accounts to their persistent channel modes (e.g., +q, +h)
 	AccountToUMode map[string]modes.Mode
 	// Bans represents the bans set on the channel.
@@ -108,6 +134,12 @@ type RegisteredChannel struct {
 	Excepts map[string]MaskInfo
 	// Invites represents the invite exceptions set on the channel.
 	Invites map[string]MaskInfo
+	// Akicks is the auto-kick list managed with CS AKICK.
+	Akicks map[string]AkickEntry
+	// Templates maps CS TEMPLATE names to their modes.
+	Templates map[string]modes.Modes
+	// Roles maps accounts to their CS ROLE template names.
+	Roles map[string]string
 	// Settings are the chanserv-modifiable settings
 	Settings ChannelSettings
 }
@@ -116,6 +148,7 @@ type ChannelPurgeRecord struct {
 	Oper     string
 	PurgedAt time.Time
 	Reason   string
+	Public   bool `json:",omitempty"` // whether Reason is shown to users trying to join
 }
 
 // ChannelRegistry manages registered channels.
@@ -202,6 +235,8 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		regTime, _ := tx.Get(fmt.Sprintf(keyChannelRegTime, channelKey))
 		regTimeInt, _ := strconv.ParseInt(regTime, 10, 64)
 		founder, _ := tx.Get(fmt.Sprintf(keyChannelFounder, channelKey))
+		founderHistoryString, _ := tx.Get(fmt.Sprintf(keyChannelFounderHistory, channelKey))
+		transferString, _ := tx.Get(fmt.Sprintf(keyChannelTransfer, channelKey))
 		topic, _ := tx.Get(fmt.Sprintf(keyChannelTopic, channelKey))
 		topicSetBy, _ := tx.Get(fmt.Sprintf(keyChannelTopicSetBy, channelKey))
 		var topicSetTime time.Time
@@ -213,9 +248,14 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		modeString, _ := tx.Get(fmt.Sprintf(keyChannelModes, channelKey))
 		userLimitString, _ := tx.Get(fmt.Sprintf(keyChannelUserLimit, channelKey))
 		forward, _ := tx.Get(fmt.Sprintf(keyChannelForward, channelKey))
+		joinThrottleString, _ := tx.Get(fmt.Sprintf(keyChannelJoinThrottle, channelKey))
+		joinDelayString, _ := tx.Get(fmt.Sprintf(keyChannelJoinDelay, channelKey))
 		banlistString, _ := tx.Get(fmt.Sprintf(keyChannelBanlist, channelKey))
 		exceptlistString, _ := tx.Get(fmt.Sprintf(keyChannelExceptlist, channelKey))
 		invitelistString, _ := tx.Get(fmt.Sprintf(keyChannelInvitelist, channelKey))
+		akicksString, _ := tx.Get(fmt.Sprintf(keyChannelAkicks, channelKey))
+		templatesString, _ := tx.Get(fmt.Sprintf(keyChannelTemplates, channelKey))
+		rolesString, _ := tx.Get(fmt.Sprintf(keyChannelRoles, channelKey))
 		accountToUModeString, _ := tx.Get(fmt.Sprintf(keyChannelAccountToUMode, channelKey))
 		settingsString, _ := tx.Get(fmt.Sprintf(keyChannelSettings, channelKey))
 
@@ -225,6 +265,9 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		}
 
 		userLimit, _ := strconv.Atoi(userLimitString)
+		// an empty or invalid string means no join throttle
+		joinThrottle, _ := ParseJoinThrottle(joinThrottleString)
+		joinDelay, _ := strconv.Atoi(joinDelayString)
 
 		var banlist map[string]MaskInfo
 		_ = json.Unmarshal([]byte(banlistString), &banlist)
@@ -232,29 +275,50 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info RegisteredC
 		_ = json.Unmarshal([]byte(exceptlistString), &exceptlist)
 		var invitelist map[string]MaskInfo
 		_ = json.Unmarshal([]byte(invitelistString), &invitelist)
+		var akicks map[string]AkickEntry
+		_ = json.Unmarshal([]byte(akicksString), &akicks)
+		var templateStrings map[string]string
+		_ = json.Unmarshal([]byte(templatesString), &templateStrings)
+		templates := make(map[string]modes.Modes, len(templateStrings))
+		for name, modeString := range templateStrings {
+			templates[name] = modes.Modes(modeString)
+		}
+		var roles map[string]string
+		_ = json.Unmarshal([]byte(rolesString), &roles)
 		accountToUMode := make(map[string]modes.Mode)
 		_ = json.Unmarshal([]byte(accountToUModeString), &accountToUMode)
 
 		var settings ChannelSettings
 		_ = json.Unmarshal([]byte(settingsString), &settings)
+		var founderHistory []FounderChange
+		_ = json.Unmarshal([]byte(founderHistoryString), &founderHistory)
+		var pendingTransfer PendingTransfer
+		_ = json.Unmarshal([]byte(transferString), &pendingTransfer)
 
 		info = RegisteredChannel{
-			Name:           name,
-			NameCasefolded: nameCasefolded,
-			RegisteredAt:   time.Unix(0, regTimeInt).UTC(),
-			Founder:        founder,
-			Topic:          topic,
-			TopicSetBy:     topicSetBy,
-			TopicSetTime:   topicSetTime,
-			Key:            password,
-			Modes:          modeSlice,
-			Bans:           banlist,
-			Excepts:        exceptlist,
-			Invites:        invitelist,
-			AccountToUMode: accountToUMode,
-			UserLimit:      int(userLimit),
-			Settings:       settings,
-			Forward:        forward,
+			Name:            name,
+			NameCasefolded:  nameCasefolded,
+			RegisteredAt:    time.Unix(0, regTimeInt).UTC(),
+			Founder:         founder,
+			FounderHistory:  founderHistory,
+			PendingTransfer: pendingTransfer,
+			Topic:           topic,
+			TopicSetBy:      topicSetBy,
+			TopicSetTime:    topicSetTime,
+			Key:             password,
+			Modes:           modeSlice,
+			Bans:            banlist,
+			Excepts:         exceptlist,
+			Invites:         invitelist,
+			Akicks:          akicks,
+			Templates:       templates,
+			Roles:           roles,
+			AccountToUMode:  accountToUMode,
+			UserLimit:       int(userLimit),
+			Settings:        settings,
+			Forward:         forward,
+			JoinThrottle:    joinThrottle,
+			JoinDelay:       joinDelay,
 		}
 		return nil
 	})
@@ -287,6 +351,12 @@ func (reg *ChannelRegistry) deleteChannel(tx *buntdb.Tx, key string, info Regist
 
 		// to see if we're deleting the right channel, confirm the founder and the registration time
 		if founder == info.Founder && registeredAt.Equal(info.RegisteredAt) {
+			// remove this channel from the account-to-amodes index
+			accountToUModeString, _ := tx.Get(fmt.Sprintf(keyChannelAccountToUMode, key))
+			var accountToUMode map[string]modes.Mode
+			_ = json.Unmarshal([]byte(accountToUModeString), &accountToUMode)
+			reg.updateAccountToAmodeMapping(tx, key, accountToUMode, nil)
+
 			for _, keyFmt := range channelKeyStrings {
 				tx.Delete(fmt.Sprintf(keyFmt, key))
 			}
@@ -339,6 +409,61 @@ func (reg *ChannelRegistry) updateAccountToChannelMapping(tx *buntdb.Tx, channel
 	}
 }
 
+// maintain the reverse index of account -> channels where the account has amodes
+func (reg *ChannelRegistry) updateAccountToAmodeMapping(tx *buntdb.Tx, channelKey string, oldAmodes, newAmodes map[string]modes.Mode) {
+	for account, mode := range newAmodes {
+		if oldAmodes[account] != mode {
+			setAccountChannelAmode(tx, account, channelKey, mode)
+		}
+	}
+	for account := range oldAmodes {
+		if _, ok := newAmodes[account]; !ok {
+			setAccountChannelAmode(tx, account, channelKey, modes.Mode(0))
+		}
+	}
+}
+
+// sets (or with a zero mode, clears) one entry in an account's amode index
+func setAccountChannelAmode(tx *buntdb.Tx, account, channelKey string, mode modes.Mode) {
+	key := fmt.Sprintf(keyAccountChannelAmodes, account)
+	amodes := make(map[string]modes.Mode)
+	if amodesString, err := tx.Get(key); err == nil {
+		_ = json.Unmarshal([]byte(amodesString), &amodes)
+	}
+	if mode == modes.Mode(0) {
+		delete(amodes, channelKey)
+	} else {
+		amodes[channelKey] = mode
+	}
+	if len(amodes) == 0 {
+		tx.Delete(key)
+	} else {
+		amodesString, _ := json.Marshal(amodes)
+		tx.Set(key, string(amodesString), nil)
+	}
+}
+
+// AmodesForAccount returns the casefolded names of the channels where an account
+// has persistent modes, mapped to the mode in question.
+func (reg *ChannelRegistry) AmodesForAccount(account string) (result map[string]modes.Mode) {
+	cfaccount, err := CasefoldName(account)
+	if err != nil {
+		return
+	}
+	var amodesString string
+	reg.server.store.View(func(tx *buntdb.Tx) error {
+		amodesString, _ = tx.Get(fmt.Sprintf(keyAccountChannelAmodes, cfaccount))
+		return nil
+	})
+	if amodesString != "" {
+		err = json.Unmarshal([]byte(amodesString), &result)
+		if err != nil {
+			reg.server.logger.Error("internal", "corrupt amode index", cfaccount, err.Error())
+		}
+	}
+	return
+}
+
 // saveChannel saves a channel to the store.
 func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelInfo RegisteredChannel, includeFlags uint) {
 	channelKey := channelInfo.NameCasefolded
@@ -350,6 +475,14 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelInfo RegisteredCha
 		tx.Set(fmt.Sprintf(keyChannelName, channelKey), channelInfo.Name, nil)
 		tx.Set(fmt.Sprintf(keyChannelRegTime, channelKey), strconv.FormatInt(channelInfo.RegisteredAt.UnixNano(), 10), nil)
 		tx.Set(fmt.Sprintf(keyChannelFounder, channelKey), channelInfo.Founder, nil)
+		founderHistoryString, _ := json.Marshal(channelInfo.FounderHistory)
+		tx.Set(fmt.Sprintf(keyChannelFounderHistory, channelKey), string(founderHistoryString), nil)
+		if channelInfo.PendingTransfer.To != "" {
+			transferString, _ := json.Marshal(channelInfo.PendingTransfer)
+			tx.Set(fmt.Sprintf(keyChannelTransfer, channelKey), string(transferString), nil)
+		} else {
+			tx.Delete(fmt.Sprintf(keyChannelTransfer, channelKey))
+		}
 	}
 
 	if includeFlags&IncludeTopic != 0 {
@@ -368,6 +501,8 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelInfo RegisteredCha
 		tx.Set(fmt.Sprintf(keyChannelModes, channelKey), modeString, nil)
 		tx.Set(fmt.Sprintf(keyChannelUserLimit, channelKey), strconv.Itoa(channelInfo.UserLimit), nil)
 		tx.Set(fmt.Sprintf(keyChannelForward, channelKey), channelInfo.Forward, nil)
+		tx.Set(fmt.Sprintf(keyChannelJoinThrottle, channelKey), channelInfo.JoinThrottle.String(), nil)
+		tx.Set(fmt.Sprintf(keyChannelJoinDelay, channelKey), strconv.Itoa(channelInfo.JoinDelay), nil)
 	}
 
 	if includeFlags&IncludeLists != 0 {
@@ -377,8 +512,23 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelInfo RegisteredCha
 		tx.Set(fmt.Sprintf(keyChannelExceptlist, channelKey), string(exceptlistString), nil)
 		invitelistString, _ := json.Marshal(channelInfo.Invites)
 		tx.Set(fmt.Sprintf(keyChannelInvitelist, channelKey), string(invitelistString), nil)
+		akicksString, _ := json.Marshal(channelInfo.Akicks)
+		tx.Set(fmt.Sprintf(keyChannelAkicks, channelKey), string(akicksString), nil)
+		templateStrings := make(map[string]string, len(channelInfo.Templates))
+		for name, tmodes := range channelInfo.Templates {
+			templateStrings[name] = tmodes.String()
+		}
+		templatesString, _ := json.Marshal(templateStrings)
+		tx.Set(fmt.Sprintf(keyChannelTemplates, channelKey), string(templatesString), nil)
+		rolesString, _ := json.Marshal(channelInfo.Roles)
+		tx.Set(fmt.Sprintf(keyChannelRoles, channelKey), string(rolesString), nil)
+		accountToUModeKey := fmt.Sprintf(keyChannelAccountToUMode, channelKey)
+		oldAccountToUModeString, _ := tx.Get(accountToUModeKey)
+		var oldAccountToUMode map[string]modes.Mode
+		_ = json.Unmarshal([]byte(oldAccountToUModeString), &oldAccountToUMode)
+		reg.updateAccountToAmodeMapping(tx, channelKey, oldAccountToUMode, channelInfo.AccountToUMode)
 		accountToUModeString, _ := json.Marshal(channelInfo.AccountToUMode)
-		tx.Set(fmt.Sprintf(keyChannelAccountToUMode, channelKey), string(accountToUModeString), nil)
+		tx.Set(accountToUModeKey, string(accountToUModeString), nil)
 	}
 
 	if includeFlags&IncludeSettings != 0 {
@@ -431,3 +581,47 @@ func (reg *ChannelRegistry) UnpurgeChannel(chname string) (err error) {
 		return nil
 	})
 }
+
+// StorePermanentChannel persists the state of an unregistered channel with +P set.
+func (reg *ChannelRegistry) StorePermanentChannel(info RegisteredChannel) (err error) {
+	serialized, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	serializedStr := string(serialized)
+	key := fmt.Sprintf(keyChannelPermanent, info.NameCasefolded)
+
+	return reg.server.store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(key, serializedStr, nil)
+		return nil
+	})
+}
+
+// DeletePermanentChannel deletes the stored state of a +P channel.
+func (reg *ChannelRegistry) DeletePermanentChannel(chname string) (err error) {
+	key := fmt.Sprintf(keyChannelPermanent, chname)
+	return reg.server.store.Update(func(tx *buntdb.Tx) error {
+		tx.Delete(key)
+		return nil
+	})
+}
+
+// PermanentChannels returns the stored state of all +P channels.
+func (reg *ChannelRegistry) PermanentChannels() (result []RegisteredChannel) {
+	prefix := fmt.Sprintf(keyChannelPermanent, "")
+	reg.server.store.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			var info RegisteredChannel
+			if err := json.Unmarshal([]byte(value), &info); err == nil {
+				result = append(result, info)
+			} else {
+				reg.server.logger.Error("internal", "corrupt permanent channel record", key, err.Error())
+			}
+			return true
+		})
+	})
+	return
+}
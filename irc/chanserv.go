@@ -7,16 +7,23 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ergochat/ergo/irc/custime"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 	"github.com/ergochat/irc-go/ircfmt"
 )
 
-const chanservHelp = `ChanServ lets you register and manage channels.`
+const (
+	chanservHelp = `ChanServ lets you register and manage channels.`
+
+	// maximum number of mode changes per MODE line sent by CS CLEAR
+	csClearModesPerLine = 4
+)
 
 func chanregEnabled(config *Config) bool {
 	return config.Channels.Registration.Enabled
@@ -25,25 +32,55 @@ func chanregEnabled(config *Config) bool {
 var (
 	chanservCommands = map[string]*serviceCommand{
 		"op": {
-			handler: csOpHandler,
+			handler: csUserModeHandler,
 			help: `Syntax: $bOP #channel [nickname]$b
 
-OP makes the given nickname, or yourself, a channel admin. You can only use
-this command if you're a founder or in the AMODEs of the channel.`,
-			helpShort:    `$bOP$b makes the given user (or yourself) a channel admin.`,
+OP makes the given nickname a channel operator. If you don't give a nickname,
+it restores your own highest stored privilege (e.g., founder). You can only use
+this command if you're a founder or in the AMODEs of the channel, and you can
+only op others if your stored privilege is at least operator.`,
+			helpShort:    `$bOP$b makes the given user (or yourself) a channel operator.`,
 			authRequired: true,
 			enabled:      chanregEnabled,
 			minParams:    1,
+			maxParams:    2,
 		},
 		"deop": {
-			handler: csDeopHandler,
+			handler: csUserModeHandler,
 			help: `Syntax: $bDEOP #channel [nickname]$b
 
-DEOP removes the given nickname, or yourself, the channel admin. You can only use
-this command if you're the founder of the channel.`,
-			helpShort: `$bDEOP$b removes the given user (or yourself) from a channel admin.`,
-			enabled:   chanregEnabled,
-			minParams: 1,
+DEOP removes operator privileges (including admin and founder privileges that
+don't exceed your own) from the given nickname, or yourself. To deop others,
+your stored privilege on the channel must be at least operator.`,
+			helpShort:    `$bDEOP$b removes operator privileges from the given user (or yourself).`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    1,
+			maxParams:    2,
+		},
+		"voice": {
+			handler: csUserModeHandler,
+			help: `Syntax: $bVOICE #channel [nickname]$b
+
+VOICE gives voice to the given nickname, or yourself. To voice others, your
+stored privilege on the channel must be at least halfop.`,
+			helpShort:    `$bVOICE$b voices the given user (or yourself).`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    1,
+			maxParams:    2,
+		},
+		"devoice": {
+			handler: csUserModeHandler,
+			help: `Syntax: $bDEVOICE #channel [nickname]$b
+
+DEVOICE removes voice from the given nickname, or yourself. To devoice others,
+your stored privilege on the channel must be at least halfop.`,
+			helpShort:    `$bDEVOICE$b removes voice from the given user (or yourself).`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    1,
+			maxParams:    2,
 		},
 		"register": {
 			handler: csRegisterHandler,
@@ -90,18 +127,26 @@ the ordinary /MODE command.`,
 			handler: csClearHandler,
 			help: `Syntax: $bCLEAR #channel target$b
 
-CLEAR removes users or settings from a channel. Specifically:
+CLEAR removes users or settings from a channel, for example, to clean up after
+a flood of mode changes. Specifically:
 
-$bCLEAR #channel users$b kicks all users except for you.
+$bCLEAR #channel bans$b removes all bans (+b).
+$bCLEAR #channel invex$b removes all invite exceptions (+I).
+$bCLEAR #channel exempts$b removes all ban exceptions (+e).
+$bCLEAR #channel modes$b resets the channel modes to the server defaults.
+$bCLEAR #channel ops$b removes +o, +h, and +v from everyone except you.
+$bCLEAR #channel users$b kicks everyone who doesn't have an AMODE or role.
 $bCLEAR #channel access$b resets all stored bans, invites, ban exceptions,
-and persistent user-mode grants made with CS AMODE.`,
+and persistent user-mode grants made with CS AMODE (founder only).
+
+CLEAR requires founder or AMODE +a privileges on the channel.`,
 			helpShort: `$bCLEAR$b removes users or settings from a channel.`,
 			enabled:   chanregEnabled,
 			minParams: 2,
 		},
 		"transfer": {
 			handler: csTransferHandler,
-			help: `Syntax: $bTRANSFER [accept] #channel user [code]$b
+			help: `Syntax: $bTRANSFER [accept | cancel] #channel user [code]$b
 
 TRANSFER transfers ownership of a channel from one user to another.
 To prevent accidental transfers, a verification code is required. For
@@ -109,26 +154,45 @@ example, $bTRANSFER #channel alice$b displays the required confirmation
 code, then $bTRANSFER #channel alice 2930242125$b initiates the transfer.
 Unless you are an IRC operator with the correct permissions, alice must
 then accept the transfer, which she can do with $bTRANSFER accept #channel$b.
-To cancel a pending transfer, transfer the channel to yourself.`,
+The transfer can only be accepted after a waiting period (by default, 48
+hours), and expires if it is not accepted in time (by default, 7 days).
+Either party can cancel a pending transfer with $bTRANSFER cancel #channel$b.`,
 			helpShort: `$bTRANSFER$b transfers ownership of a channel to another user.`,
 			enabled:   chanregEnabled,
 			minParams: 2,
 		},
 		"purge": {
 			handler: csPurgeHandler,
-			help: `Syntax: $bPURGE <ADD | DEL | LIST> #channel [code] [reason]$b
+			help: `Syntax: $bPURGE <ADD | DEL | LIST> #channel [code] [PUBLIC] [reason]$b
 
 PURGE ADD blacklists a channel from the server, making it impossible to join
 or otherwise interact with the channel. If the channel currently has members,
 they will be kicked from it. PURGE may also be applied preemptively to
 channels that do not currently have members. A purge can be undone with
-PURGE DEL. To list purged channels, use PURGE LIST.`,
+PURGE DEL. To list purged channels, use PURGE LIST.
+
+Users trying to join a purged channel are shown a generic message set in the
+server configuration; if the reason is preceded by the word PUBLIC, they are
+shown the reason instead.`,
 			helpShort:         `$bPURGE$b blacklists a channel from the server.`,
 			capabs:            []string{"chanreg"},
 			minParams:         1,
-			maxParams:         3,
+			maxParams:         4,
 			unsplitFinalParam: true,
 		},
+		"saregister-limit": {
+			handler: csSaregisterLimitHandler,
+			help: `Syntax: $bSAREGISTER-LIMIT <account> <limit | default>$b
+
+SAREGISTER-LIMIT overrides the maximum number of channels the given account
+may register (set by the server configuration). Use 'default' to remove the
+override. An account that is already over its limit keeps its channels, but
+cannot register more.`,
+			helpShort: `$bSAREGISTER-LIMIT$b sets the channel registration limit of an account.`,
+			enabled:   chanregEnabled,
+			capabs:    []string{"chanreg"},
+			minParams: 2,
+		},
 		"list": {
 			handler: csListHandler,
 			help: `Syntax: $bLIST [regex]$b
@@ -141,12 +205,27 @@ If no regex is provided, all registered channels are returned.`,
 		},
 		"info": {
 			handler: csInfoHandler,
-			help: `Syntax: $INFO #channel$b
+			help: `Syntax: $bINFO #channel$b
 
-INFO displays info about a registered channel.`,
+INFO displays info about a registered channel: its founder, registration
+time, and settings. Users on the channel's access list can also see the
+number of AKICK entries; channel operators can see the entry message and the
+successor; only the founder can see the founder's email address.`,
 			helpShort: `$bINFO$b displays info about a registered channel.`,
 			enabled:   chanregEnabled,
 		},
+		"stats": {
+			handler: csStatsHandler,
+			help: `Syntax: $bSTATS #channel$b
+
+STATS displays activity statistics for a registered channel: the current and
+peak (since the server started) member counts and, if history is enabled for
+the channel, the number of messages and distinct speakers over the last day,
+week, and month. It is available to the channel founder.`,
+			helpShort: `$bSTATS$b displays activity statistics for a channel.`,
+			enabled:   chanregEnabled,
+			minParams: 1,
+		},
 		"get": {
 			handler: csGetHandler,
 			help: `Syntax: $bGET #channel <setting>$b
@@ -172,6 +251,10 @@ SET modifies a channel's settings. The following settings are available:`,
 2. 'ephemeral'  [a limited amount of temporary history, not stored on disk]
 3. 'on'         [history stored in a permanent database, if available]
 4. 'default'    [use the server default]`,
+				`$bENTRYMSG$b
+'entrymsg' sets a message that is sent to users (as a notice from ChanServ)
+when they join the channel, e.g., to point them to the channel rules.
+Use 'off' to remove it.`,
 				`$bQUERY-CUTOFF$b
 'query-cutoff' lets you restrict how much channel history can be retrieved
 by unprivileged users. Your options are:
@@ -182,10 +265,129 @@ by unprivileged users. Your options are:
                          channel; note that history will be effectively
                          unavailable to clients that are not always-on]
 4. 'default'            [use the server default]`,
+				`$bFLOOD$b
+'flood' enables flood protection, in the form <lines>:<seconds>:<action>:
+each member may send <lines> messages per <seconds> seconds. When a member
+exceeds the limit, ChanServ takes the action, which is one of 'mute' (a mute
+ban, +b m:*!*@host), 'kick', or 'kickban', and notifies the channel operators.
+Users with an AMODE are exempt. Use 'off' to disable it.`,
+				`$bSUCCESSOR$b
+'successor' designates an account that becomes the channel founder if the
+founder's account is unregistered. If there is no successor (or its account
+no longer exists), the channel passes to the holder of the highest AMODE.
+Use 'off' to remove the designation.`,
+				`$bAMODE-DELAY$b
+'amode-delay' delays the application of persistent modes (AMODEs) to users who
+join the channel, e.g., '30s' or '5m' (at most 24 hours). This prevents
+a compromised account from immediately using its channel privileges; the
+AMODE still exempts the user from join restrictions such as bans, +k, and
++i. An operator who has been in the channel for the delay can apply the
+modes early with OP or VOICE. Use 'off' (or '0') to disable it.`,
+				`$bTOPICLOCK$b
+'topiclock' restricts changing the topic to users with at least the given
+persistent access level (an AMODE), regardless of +t. Acceptable values are
+'op', 'admin', and 'founder', or 'off' to disable the lock.`,
+				`$bLANGUAGE$b
+'language' sets the language (e.g., 'es' or 'fr-FR') used for service
+notices about the channel, for users who have not chosen a language of
+their own. Use 'off' to revert to the server default.`,
+				`$bMUTE-NOTIFY$b
+'mute-notify' can be set to 'on' to notify channel operators when a muted
+user (+b m:) attempts to speak, including the beginning of the message.
+Notices are limited to one per user per minute. Use 'off' to disable it.`,
+				`$bKEY$b
+'key' sets the channel key (+k), or removes it with 'off'. Current members
+of the channel are sent the new key by ChanServ, unless the key is followed
+by the word NOQUIET. If the channel is not currently in use, the stored key is
+updated, and takes effect when the channel is next used.`,
 			},
 			enabled:   chanregEnabled,
 			minParams: 3,
 		},
+		"akick": {
+			handler: csAkickHandler,
+			help: `Syntax: $bAKICK #channel <ADD | DEL | LIST> [mask | account] [duration] [reason]$b
+
+AKICK manages the channel's auto-kick list, which prevents matching users
+from joining the channel. Unlike bans (+b), auto-kick entries are stored with
+the channel registration, carry a reason (shown to the user when they are
+refused), and can optionally expire. For example:
+
+$bAKICK #channel ADD *!*@example.com 7d spamming$b
+$bAKICK #channel ADD alice trolling$b
+
+An argument that contains any of $b!@*?$b is treated as a nickmask, and one
+that starts with $b$$b is an extended ban, e.g., $b$$r:*spambot*$b to match
+realnames (see /HELPOP CMODES). Anything else is treated as an account name,
+which matches that account regardless of hostmask. $bAKICK #channel DEL <mask | account>$b removes an entry, and
+$bAKICK #channel LIST$b lists the entries, with who added them and when.
+You must be the channel founder or have a persistent mode (AMODE) of +o or
+higher to use this command.`,
+			helpShort:    `$bAKICK$b manages a channel's auto-kick list.`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    2,
+		},
+		"cert": {
+			handler: csCertHandler,
+			help: `Syntax: $bCERT <LIST | ADD | DEL> [certfp]$b
+
+CERT manages the SHA-256 TLS certificate fingerprints that can be used to log
+into your account (e.g., with SASL EXTERNAL), the same as NickServ's CERT
+command. $bCERT LIST$b lists the authorized fingerprints, $bCERT ADD$b adds
+the given fingerprint (or that of your current certificate), and
+$bCERT DEL <fingerprint>$b removes one.`,
+			helpShort:    `$bCERT$b controls your account's certificate fingerprints.`,
+			authRequired: true,
+			enabled:      servCmdRequiresAuthEnabled,
+			minParams:    1,
+			maxParams:    2,
+		},
+		"invite": {
+			handler: csInviteHandler,
+			help: `Syntax: $bINVITE #channel <ADD | DEL | LIST> [account]$b
+
+INVITE manages the accounts that are permanently invited to the channel, i.e.,
+that can always join it, even if it is invite-only (+i). These are stored as
+account invite exceptions (+I $$a:account), which are saved with the channel
+registration. $bINVITE #channel ADD <account>$b invites an account,
+$bINVITE #channel DEL <account>$b removes it, and $bINVITE #channel LIST$b
+lists the invited accounts. You must be the channel founder or have a
+persistent mode (AMODE) of +o or higher to use this command.`,
+			helpShort:    `$bINVITE$b manages a channel's permanently invited accounts.`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    2,
+			maxParams:    3,
+		},
+		"template": {
+			handler: csTemplateHandler,
+			help: `Syntax: $bTEMPLATE #channel <SET | RENAME | DEL | LIST> [name] [modes | newname]$b
+
+TEMPLATE manages named access roles (templates) for the channel, each of which
+grants a set of channel modes. For example, $bTEMPLATE #channel SET moderator +ov$b
+creates (or changes) a template named "moderator", which you can then assign to
+accounts with $bROLE$b. Changing or renaming a template updates the modes of
+current channel members who hold it. $bTEMPLATE #channel DEL moderator$b deletes
+a template; if it is still assigned, you'll be asked for a confirmation code.
+$bTEMPLATE #channel LIST$b lists the templates and their assignments.`,
+			helpShort: `$bTEMPLATE$b manages named access roles for a channel.`,
+			enabled:   chanregEnabled,
+			minParams: 2,
+		},
+		"role": {
+			handler: csRoleHandler,
+			help: `Syntax: $bROLE #channel <ADD | DEL | LIST> [account] [template]$b
+
+ROLE assigns templates (see $bTEMPLATE$b) to accounts. For example,
+$bROLE #channel ADD alice moderator$b gives alice the modes of the "moderator"
+template whenever she joins the channel, in addition to any AMODE she has.
+$bROLE #channel DEL alice$b removes the assignment, and $bROLE #channel LIST$b
+lists all assignments.`,
+			helpShort: `$bROLE$b assigns access role templates to accounts.`,
+			enabled:   chanregEnabled,
+			minParams: 2,
+		},
 		"howtoban": {
 			handler:   csHowToBanHandler,
 			helpShort: `$bHOWTOBAN$b suggests the best available way of banning a user`,
@@ -205,17 +407,17 @@ func csAmodeHandler(service *ircService, server *Server, client *Client, command
 
 	channel := server.channels.Get(channelName)
 	if channel == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "Channel does not exist"))
 		return
 	} else if channel.Founder() == "" {
-		service.Notice(rb, client.t("Channel is not registered"))
+		service.Fail(rb, command, serviceErrChannelNotRegistered, client.tc(channel, "Channel is not registered"))
 		return
 	}
 
 	modeChanges, unknown := modes.ParseChannelModeChanges(params[1:]...)
 	var change modes.ModeChange
 	if len(modeChanges) > 1 || len(unknown) > 0 {
-		service.Notice(rb, client.t("Invalid mode change"))
+		service.ChannelNotice(rb, channel, client.tc(channel, "Invalid mode change"))
 		return
 	} else if len(modeChanges) == 1 {
 		change = modeChanges[0]
@@ -240,17 +442,17 @@ func csAmodeHandler(service *ircService, server *Server, client *Client, command
 		accountIsValid = (change.Arg != "")
 	}
 	if !accountIsValid {
-		service.Notice(rb, client.t("Account does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.tc(channel, "Account does not exist"))
 		return
 	}
 
 	affectedModes, err := channel.ProcessAccountToUmodeChange(client, change)
 
 	if err == errInsufficientPrivs {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
 		return
 	} else if err != nil {
-		service.Notice(rb, client.t("Internal error"))
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "Internal error"))
 		return
 	}
 
@@ -260,13 +462,13 @@ func csAmodeHandler(service *ircService, server *Server, client *Client, command
 		sort.Slice(affectedModes, func(i, j int) bool {
 			return umodeGreaterThan(affectedModes[i].Mode, affectedModes[j].Mode)
 		})
-		service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s has %[2]d persistent modes set"), channelName, len(affectedModes)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %[1]s has %[2]d persistent modes set"), channelName, len(affectedModes)))
 		for _, modeChange := range affectedModes {
-			service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s receives mode +%[2]s"), modeChange.Arg, string(modeChange.Mode)))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s receives mode +%[2]s"), modeChange.Arg, string(modeChange.Mode)))
 		}
 	case modes.Add, modes.Remove:
 		if len(affectedModes) > 0 {
-			service.Notice(rb, fmt.Sprintf(client.t("Successfully set persistent mode %[1]s on %[2]s"), strings.Join([]string{string(change.Op), string(change.Mode)}, ""), change.Arg))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Successfully set persistent mode %[1]s on %[2]s"), strings.Join([]string{string(change.Op), string(change.Mode)}, ""), change.Arg))
 			// #729: apply change to current membership
 			for _, member := range channel.Members() {
 				if member.Account() == change.Arg {
@@ -281,124 +483,127 @@ func csAmodeHandler(service *ircService, server *Server, client *Client, command
 				}
 			}
 		} else {
-			service.Notice(rb, client.t("No changes were made"))
+			service.Fail(rb, command, serviceErrNoChanges, client.tc(channel, "No changes were made"))
 		}
 	}
 }
 
-func csOpHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	channelInfo := server.channels.Get(params[0])
-	if channelInfo == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+// csUserModeCommands are the modes changed by CS OP, DEOP, VOICE, and DEVOICE
+var csUserModeCommands = map[string]modes.ModeChange{
+	"op":      {Op: modes.Add, Mode: modes.ChannelOperator},
+	"deop":    {Op: modes.Remove, Mode: modes.ChannelOperator},
+	"voice":   {Op: modes.Add, Mode: modes.Voice},
+	"devoice": {Op: modes.Remove, Mode: modes.Voice},
+}
+
+func csUserModeHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	change := csUserModeCommands[command]
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "Channel does not exist"))
 		return
 	}
-	channelName := channelInfo.Name()
-	founder := channelInfo.Founder()
-
-	clientAccount := client.Account()
-	if clientAccount == "" {
-		service.Notice(rb, client.t("You're not logged into an account"))
+	chname := channel.Name()
+	founder := channel.Founder()
+	if founder == "" {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %s is not registered"), chname))
 		return
 	}
 
-	var target *Client
+	target := client
 	if len(params) > 1 {
 		target = server.clients.Get(params[1])
 		if target == nil {
-			service.Notice(rb, client.t("Could not find given client"))
-			return
-		}
-	} else {
-		target = client
-	}
-
-	var givenMode modes.Mode
-	if target == client {
-		if clientAccount == founder {
-			givenMode = modes.ChannelFounder
-		} else {
-			givenMode = channelInfo.getAmode(clientAccount)
-			if givenMode == modes.Mode(0) {
-				service.Notice(rb, client.t("You don't have any stored privileges on that channel"))
-				return
-			}
-		}
-	} else {
-		if clientAccount == founder {
-			givenMode = modes.ChannelOperator
-		} else {
-			service.Notice(rb, client.t("Only the channel founder can do this"))
+			service.Fail(rb, command, serviceErrNoSuchNick, client.tc(channel, "No such nick"))
 			return
 		}
 	}
-
-	applied, change := channelInfo.applyModeToMember(client,
-		modes.ModeChange{Mode: givenMode,
-			Op:  modes.Add,
-			Arg: target.NickCasefolded(),
-		},
-		rb)
-	if applied {
-		announceCmodeChanges(channelInfo, modes.ModeChanges{change}, server.name, "*", "", false, rb)
-	}
-
-	service.Notice(rb, client.t("Successfully granted operator privileges"))
-
 	tnick := target.Nick()
-	server.logger.Info("services", fmt.Sprintf("Client %s op'd [%s] in channel %s", client.Nick(), tnick, channelName))
-	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] CS OP'd $c[grey][$r%s$c[grey]] in channel $c[grey][$r%s$c[grey]]"), client.NickMaskString(), tnick, channelName))
-}
-
-func csDeopHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	channel := server.channels.Get(params[0])
-	if channel == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+	present, _, targetModes := channel.ClientStatus(target)
+	if !present {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "%[1]s is not on channel %[2]s"), tnick, chname))
 		return
 	}
-	if !channel.hasClient(client) {
-		service.Notice(rb, client.t("You're not on that channel"))
-		return
+
+	account := client.Account()
+	level := channel.highestPersistentMode(account)
+	if account == founder {
+		level = modes.ChannelFounder
 	}
+	self := target == client
 
-	var target *Client
-	if len(params) > 1 {
-		target = server.clients.Get(params[1])
-		if target == nil {
-			service.Notice(rb, client.t("Could not find given client"))
+	// CS SET AMODE-DELAY: stored privileges can't be used until the delay has
+	// elapsed (giving up your own modes is always allowed)
+	if level != modes.Mode(0) && !(self && change.Op == modes.Remove) {
+		if remaining := channel.amodeDelayRemaining(client); remaining != 0 {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, fmt.Sprintf(client.tc(channel, "Your stored privileges on that channel will be usable in %v"), remaining.Round(time.Second)))
 			return
 		}
-	} else {
-		target = client
 	}
 
-	present, _, cumodes := channel.ClientStatus(target)
-	if !present || len(cumodes) == 0 {
-		service.Notice(rb, client.t("Target has no privileges to remove"))
-		return
+	// compute the changes, enforcing the mode hierarchy: you need a stored
+	// privilege to restore your own modes, halfop to (de)voice others,
+	// and op to (de)op others, who can't be above you
+	var changes modes.ModeChanges
+	if self && change.Op == modes.Add && change.Mode == modes.ChannelOperator {
+		// OP on yourself restores your highest stored privilege
+		if level == modes.Mode(0) {
+			service.ChannelNotice(rb, channel, client.tc(channel, "You don't have any stored privileges on that channel"))
+			return
+		}
+		change.Mode = level
+		changes = append(changes, change)
+	} else if self && change.Op == modes.Remove {
+		for _, mode := range targetModes {
+			if mode == change.Mode || (change.Mode == modes.ChannelOperator && umodeGreaterThan(mode, modes.ChannelOperator)) {
+				changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: mode})
+			}
+		}
+	} else {
+		required := modes.ChannelOperator
+		if change.Mode == modes.Voice {
+			required = modes.Halfop
+			if self {
+				required = modes.Voice
+			}
+		}
+		if level == modes.Mode(0) || umodeGreaterThan(required, level) {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
+			return
+		}
+		if !self && umodeGreaterThan(highestChannelUserMode(targetModes), level) {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "%s has higher privileges than you on that channel"), tnick))
+			return
+		}
+		changes = append(changes, change)
+		if change.Op == modes.Remove && change.Mode == modes.ChannelOperator {
+			// also remove any higher modes (e.g., admin) at or below your own level
+			for _, mode := range targetModes {
+				if umodeGreaterThan(mode, modes.ChannelOperator) && !umodeGreaterThan(mode, level) {
+					changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: mode})
+				}
+			}
+		}
 	}
 
-	tnick := target.Nick()
-	modeChanges := make(modes.ModeChanges, len(cumodes))
-	for i, mode := range cumodes {
-		modeChanges[i] = modes.ModeChange{
-			Mode: mode,
-			Op:   modes.Remove,
-			Arg:  tnick,
+	var applied modes.ModeChanges
+	for _, change := range changes {
+		change.Arg = target.NickCasefolded()
+		if ok, appliedChange := channel.applyModeToMember(client, change, rb); ok {
+			applied = append(applied, appliedChange)
 		}
 	}
-
-	// use the user's own permissions for the check, then announce
-	// the changes as coming from chanserv
-	applied := channel.ApplyChannelModeChanges(client, false, modeChanges, rb)
-	details := client.Details()
-	isBot := client.HasMode(modes.Bot)
-	announceCmodeChanges(channel, applied, details.nickMask, details.accountName, details.account, isBot, rb)
-
 	if len(applied) == 0 {
+		service.Fail(rb, command, serviceErrNoChanges, client.tc(channel, "No changes were made"))
 		return
 	}
+	announceCmodeChanges(channel, applied, service.prefix, "*", "", false, rb)
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Successfully set %[1]s on %[2]s"), applied.Strings()[0], tnick))
 
-	service.Notice(rb, client.t("Successfully removed operator privileges"))
+	server.logger.Info("services", fmt.Sprintf("Client %s used CS %s on [%s] in channel %s", client.Nick(), strings.ToUpper(command), tnick, chname))
+	if change.Op == modes.Add && change.Mode != modes.Voice {
+		server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] CS OP'd $c[grey][$r%s$c[grey]] in channel $c[grey][$r%s$c[grey]]"), client.NickMaskString(), tnick, chname))
+	}
 }
 
 func csRegisterHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
@@ -409,11 +614,11 @@ func csRegisterHandler(service *ircService, server *Server, client *Client, comm
 	channelName := params[0]
 	channelInfo := server.channels.Get(channelName)
 	if channelInfo == nil {
-		service.Notice(rb, client.t("No such channel"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.t("No such channel"))
 		return
 	}
 	if !channelInfo.ClientIsAtLeast(client, modes.ChannelOperator) {
-		service.Notice(rb, client.t("You must be an oper on the channel to register it"))
+		service.ChannelNotice(rb, channelInfo, client.t("You must be an oper on the channel to register it"))
 		return
 	}
 
@@ -429,7 +634,7 @@ func csRegisterHandler(service *ircService, server *Server, client *Client, comm
 		return
 	}
 
-	service.Notice(rb, fmt.Sprintf(client.t("Channel %s successfully registered"), channelName))
+	service.ChannelNotice(rb, channelInfo, fmt.Sprintf(client.t("Channel %s successfully registered"), channelName))
 
 	server.logger.Info("services", fmt.Sprintf("Client %s registered channel %s", client.Nick(), channelName))
 	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Channel registered $c[grey][$r%s$c[grey]] by $c[grey][$r%s$c[grey]]"), channelName, client.nickMaskString))
@@ -451,24 +656,56 @@ func csRegisterHandler(service *ircService, server *Server, client *Client, comm
 func checkChanLimit(service *ircService, client *Client, rb *ResponseBuffer) (ok bool) {
 	account := client.Account()
 	channelsAlreadyRegistered := client.server.accounts.ChannelsForAccount(account)
-	ok = len(channelsAlreadyRegistered) < client.server.Config().Channels.Registration.MaxChannelsPerAccount || client.HasRoleCapabs("chanreg")
+	limit, _ := client.server.accounts.ChannelLimit(account)
+	ok = len(channelsAlreadyRegistered) < limit || client.HasRoleCapabs("chanreg")
 	if !ok {
-		service.Notice(rb, client.t("You have already registered the maximum number of channels; try dropping some with /CS UNREGISTER"))
+		service.Fail(rb, "REGISTER", serviceErrLimitReached, fmt.Sprintf(client.t("You have already registered the maximum number of channels (%d); try dropping some with /CS UNREGISTER"), limit))
 	}
 	return
 }
 
-func csPrivsCheck(service *ircService, channel RegisteredChannel, client *Client, rb *ResponseBuffer) (success bool) {
+func csSaregisterLimitHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	accountName, limitStr := params[0], params[1]
+	limit := -1
+	if strings.ToLower(limitStr) != "default" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+			return
+		}
+	}
+
+	switch err := server.accounts.SetChannelLimit(accountName, limit); err {
+	case nil:
+	case errAccountDoesNotExist:
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
+		return
+	default:
+		server.logger.Error("internal", "CS SAREGISTER-LIMIT error:", err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		return
+	}
+
+	if limit < 0 {
+		service.Notice(rb, fmt.Sprintf(client.t("Account %s now has the default channel registration limit"), accountName))
+	} else {
+		service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s may now register up to %[2]d channels"), accountName, limit))
+	}
+	server.logger.Info("services", fmt.Sprintf("Operator %s set the channel registration limit of %s to %s", client.Nick(), accountName, limitStr))
+}
+
+func csPrivsCheck(service *ircService, command string, channel RegisteredChannel, client *Client, rb *ResponseBuffer) (success bool) {
 	founder := channel.Founder
 	if founder == "" {
-		service.Notice(rb, client.t("That channel is not registered"))
+		service.Fail(rb, command, serviceErrChannelNotRegistered, client.t("That channel is not registered"))
 		return false
 	}
 	if client.HasRoleCapabs("chanreg") {
 		return true
 	}
 	if founder != client.Account() {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return false
 	}
 	return true
@@ -483,62 +720,119 @@ func csUnregisterHandler(service *ircService, server *Server, client *Client, co
 
 	channel := server.channels.Get(channelName)
 	if channel == nil {
-		service.Notice(rb, client.t("No such channel"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "No such channel"))
 		return
 	}
 
 	info := channel.ExportRegistration(0)
 	channelKey := info.NameCasefolded
-	if !csPrivsCheck(service, info, client, rb) {
+	if !csPrivsCheck(service, command, info, client, rb) {
 		return
 	}
 
 	expectedCode := utils.ConfirmationCode(info.Name, info.RegisteredAt)
 	if expectedCode != verificationCode {
-		service.Notice(rb, ircfmt.Unescape(client.t("$bWarning: unregistering this channel will remove all stored channel attributes.$b")))
-		service.Notice(rb, fmt.Sprintf(client.t("To confirm, run this command: %s"), fmt.Sprintf("/CS UNREGISTER %s %s", channelKey, expectedCode)))
+		service.ChannelNotice(rb, channel, ircfmt.Unescape(client.tc(channel, "$bWarning: unregistering this channel will remove all stored channel attributes.$b")))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "To confirm, run this command: %s"), fmt.Sprintf("/CS UNREGISTER %s %s", channelKey, expectedCode)))
 		return
 	}
 
 	server.channels.SetUnregistered(channelKey, info.Founder)
-	service.Notice(rb, fmt.Sprintf(client.t("Channel %s is now unregistered"), channelKey))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %s is now unregistered"), channelKey))
 }
 
 func csClearHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	channel := server.channels.Get(params[0])
 	if channel == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "Channel does not exist"))
 		return
 	}
-	if !csPrivsCheck(service, channel.ExportRegistration(0), client, rb) {
+	target := strings.ToLower(params[1])
+	if target == "access" {
+		if !csPrivsCheck(service, command, channel.ExportRegistration(0), client, rb) {
+			return
+		}
+	} else if !channel.IsRegistered() {
+		service.Fail(rb, command, serviceErrChannelNotRegistered, client.tc(channel, "That channel is not registered"))
+		return
+	} else if !csHasAccessLevel(channel, client, modes.ChannelAdmin) {
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
 		return
 	}
 
-	switch strings.ToLower(params[1]) {
+	config := server.Config()
+	var changes modes.ModeChanges
+	switch target {
+	case "access", "bans", "invex", "exempts", "modes", "ops", "users":
+		if ok, remaining := channel.checkClearCooldown(config.Channels.Clear.Cooldown); !ok {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Please wait at least %v and try again"), remaining.Round(time.Second)))
+			return
+		}
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+
+	switch target {
 	case "access":
 		channel.resetAccess()
-		service.Notice(rb, client.t("Successfully reset channel access"))
+		service.ChannelNotice(rb, channel, client.tc(channel, "Successfully reset channel access"))
+		return
 	case "users":
-		for _, target := range channel.Members() {
-			if target != client {
-				channel.Kick(client, target, "Cleared by ChanServ", rb, true)
+		kicked := 0
+		for _, member := range channel.Members() {
+			if member == client {
+				continue
+			}
+			if account := member.Account(); account != "" && channel.highestPersistentMode(account) != modes.Mode(0) {
+				continue
 			}
+			channel.Kick(client, member, config.Channels.Clear.KickReason, rb, true)
+			kicked++
 		}
-	default:
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Kicked %[1]d users from %[2]s"), kicked, channel.Name()))
+		return
+	case "bans":
+		changes = channel.clearListChanges(modes.BanMask)
+	case "invex":
+		changes = channel.clearListChanges(modes.InviteMask)
+	case "exempts":
+		changes = channel.clearListChanges(modes.ExceptMask)
+	case "modes":
+		changes = channel.resetModeChanges(config.Channels.defaultModes)
+	case "ops":
+		changes = channel.deopChanges(client)
+	}
+
+	// apply and announce the changes in small batches, so that each MODE line
+	// stays well within the line length limit
+	total := 0
+	for len(changes) != 0 {
+		batchLen := len(changes)
+		if batchLen > csClearModesPerLine {
+			batchLen = csClearModesPerLine
+		}
+		applied := channel.ApplyChannelModeChanges(client, true, changes[:batchLen], rb)
+		announceCmodeChanges(channel, applied, server.name, "*", "", false, rb)
+		total += len(applied)
+		changes = changes[batchLen:]
 	}
-
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Cleared %[1]s on %[2]s (%[3]d changes)"), target, channel.Name(), total))
 }
 
 func csTransferHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	if strings.ToLower(params[0]) == "accept" {
+	switch strings.ToLower(params[0]) {
+	case "accept":
 		processTransferAccept(service, client, params[1], rb)
 		return
+	case "cancel":
+		processTransferCancel(service, client, params[1], rb)
+		return
 	}
 	chname := params[0]
 	channel := server.channels.Get(chname)
 	if channel == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.t("Channel does not exist"))
 		return
 	}
 	regInfo := channel.ExportRegistration(0)
@@ -548,21 +842,21 @@ func csTransferHandler(service *ircService, server *Server, client *Client, comm
 	oper := client.Oper()
 	hasPrivs := oper.HasRoleCapab("chanreg")
 	if !isFounder && !hasPrivs {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	}
 	target := params[1]
 	targetAccount, err := server.accounts.LoadAccount(params[1])
 	if err != nil {
-		service.Notice(rb, client.t("Account does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
 		return
 	}
 	if targetAccount.NameCasefolded != account {
 		expectedCode := utils.ConfirmationCode(regInfo.Name, regInfo.RegisteredAt)
 		codeValidated := 2 < len(params) && params[2] == expectedCode
 		if !codeValidated {
-			service.Notice(rb, ircfmt.Unescape(client.t("$bWarning: you are about to transfer control of your channel to another user.$b")))
-			service.Notice(rb, fmt.Sprintf(client.t("To confirm your channel transfer, type: /CS TRANSFER %[1]s %[2]s %[3]s"), chname, target, expectedCode))
+			service.ChannelNotice(rb, channel, ircfmt.Unescape(client.t("$bWarning: you are about to transfer control of your channel to another user.$b")))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("To confirm your channel transfer, type: /CS TRANSFER %[1]s %[2]s %[3]s"), chname, target, expectedCode))
 			return
 		}
 	}
@@ -571,21 +865,27 @@ func csTransferHandler(service *ircService, server *Server, client *Client, comm
 		server.snomasks.Send(sno.LocalOpers, message)
 		server.logger.Info("opers", message)
 	}
+	pending := channel.PendingTransfer()
 	status, err := channel.Transfer(client, target, hasPrivs)
 	if err == nil {
 		switch status {
 		case channelTransferComplete:
-			service.Notice(rb, fmt.Sprintf(client.t("Successfully transferred channel %[1]s to account %[2]s"), chname, target))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Successfully transferred channel %[1]s to account %[2]s"), chname, target))
 		case channelTransferPending:
 			sendTransferPendingNotice(service, server, target, chname)
-			service.Notice(rb, fmt.Sprintf(client.t("Transfer of channel %[1]s to account %[2]s succeeded, pending acceptance"), chname, target))
+			cooldown := time.Duration(server.Config().Channels.Registration.TransferCooldown)
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Transfer of channel %[1]s to account %[2]s succeeded, pending acceptance"), chname, target))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("The transfer can be accepted after %[1]v; you can cancel it before then with /CS TRANSFER CANCEL %[2]s"), cooldown, chname))
 		case channelTransferCancelled:
-			service.Notice(rb, fmt.Sprintf(client.t("Cancelled pending transfer of channel %s"), chname))
+			if pending.To != "" {
+				sendTransferNotice(service, server, pending.To, "Your offer of ownership of channel %s was cancelled", chname)
+			}
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Cancelled pending transfer of channel %s"), chname))
 		}
 	} else {
 		switch err {
 		case errChannelNotOwnedByAccount:
-			service.Notice(rb, client.t("You don't own that channel"))
+			service.Fail(rb, command, serviceErrNotOwner, client.t("You don't own that channel"))
 		default:
 			service.Notice(rb, client.t("Could not transfer channel"))
 		}
@@ -593,36 +893,71 @@ func csTransferHandler(service *ircService, server *Server, client *Client, comm
 }
 
 func sendTransferPendingNotice(service *ircService, server *Server, account, chname string) {
-	clients := server.accounts.AccountToClients(account)
-	if len(clients) == 0 {
-		return
+	config := server.Config()
+	cooldown := time.Duration(config.Channels.Registration.TransferCooldown)
+	expiration := time.Duration(config.Channels.Registration.TransferExpiration)
+	for _, client := range server.accounts.AccountToClients(account) {
+		client.Send(nil, service.prefix, "NOTICE", client.Nick(), fmt.Sprintf(client.t("You have been offered ownership of channel %[1]s. To accept, /CS TRANSFER ACCEPT %[1]s after %[2]v and within %[3]v; to decline, /CS TRANSFER CANCEL %[1]s"), chname, cooldown, expiration))
 	}
-	var client *Client
-	for _, candidate := range clients {
-		client = candidate
-		if candidate.NickCasefolded() == candidate.Account() {
-			break // prefer the login where the nick is the account
-		}
+}
+
+// sendTransferNotice notifies an account's clients about a change in the status of a transfer
+func sendTransferNotice(service *ircService, server *Server, account, format, chname string) {
+	for _, client := range server.accounts.AccountToClients(account) {
+		client.Send(nil, service.prefix, "NOTICE", client.Nick(), fmt.Sprintf(client.t(format), chname))
 	}
-	client.Send(nil, service.prefix, "NOTICE", client.Nick(), fmt.Sprintf(client.t("You have been offered ownership of channel %[1]s. To accept, /CS TRANSFER ACCEPT %[1]s"), chname))
 }
 
 func processTransferAccept(service *ircService, client *Client, chname string, rb *ResponseBuffer) {
 	channel := client.server.channels.Get(chname)
 	if channel == nil {
-		service.Notice(rb, client.t("Channel does not exist"))
+		service.Fail(rb, "TRANSFER", serviceErrNoSuchChannel, client.t("Channel does not exist"))
 		return
 	}
 	if !checkChanLimit(service, client, rb) {
 		return
 	}
-	switch channel.AcceptTransfer(client) {
+	// an oper with chanreg could have transferred the channel directly
+	override := client.HasRoleCapabs("chanreg")
+	from, wait, err := channel.AcceptTransfer(client, override)
+	switch err {
+	case nil:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Successfully accepted ownership of channel %s"), channel.Name()))
+		sendTransferNotice(service, client.server, from, "Ownership of channel %s was accepted by the recipient of your transfer", channel.Name())
+	case errChannelTransferNotOffered:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("You weren't offered ownership of channel %s"), channel.Name()))
+	case errChannelTransferCooldown:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("You can't accept ownership of channel %[1]s for another %[2]v"), channel.Name(), wait.Round(time.Minute)))
+	case errChannelTransferExpired:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("The offer of ownership of channel %s has expired"), channel.Name()))
+	default:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Could not accept ownership of channel %s"), channel.Name()))
+	}
+}
+
+func processTransferCancel(service *ircService, client *Client, chname string, rb *ResponseBuffer) {
+	channel := client.server.channels.Get(chname)
+	if channel == nil {
+		service.Fail(rb, "TRANSFER", serviceErrNoSuchChannel, client.t("Channel does not exist"))
+		return
+	}
+	cancelled, founder, err := channel.CancelTransfer(client, client.HasRoleCapabs("chanreg"))
+	switch err {
 	case nil:
-		service.Notice(rb, fmt.Sprintf(client.t("Successfully accepted ownership of channel %s"), channel.Name()))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Cancelled pending transfer of channel %s"), channel.Name()))
+		account := client.Account()
+		if account != founder {
+			sendTransferNotice(service, client.server, founder, "Your pending transfer of channel %s was cancelled", channel.Name())
+		}
+		if account != cancelled.To {
+			sendTransferNotice(service, client.server, cancelled.To, "Your offer of ownership of channel %s was cancelled", channel.Name())
+		}
 	case errChannelTransferNotOffered:
-		service.Notice(rb, fmt.Sprintf(client.t("You weren't offered ownership of channel %s"), channel.Name()))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Channel %s has no pending transfer"), channel.Name()))
+	case errInsufficientPrivs:
+		service.Fail(rb, "TRANSFER", serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 	default:
-		service.Notice(rb, fmt.Sprintf(client.t("Could not accept ownership of channel %s"), channel.Name()))
+		service.Fail(rb, "TRANSFER", serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
@@ -640,13 +975,13 @@ func csPurgeHandler(service *ircService, server *Server, client *Client, command
 	case "list":
 		csPurgeListHandler(service, client, rb)
 	default:
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 	}
 }
 
 func csPurgeAddHandler(service *ircService, client *Client, params []string, operName string, rb *ResponseBuffer) {
 	if len(params) == 0 {
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, "PURGE", serviceErrInvalidParams, client.t("Invalid parameters"))
 		return
 	}
 
@@ -668,14 +1003,23 @@ func csPurgeAddHandler(service *ircService, client *Client, params []string, ope
 	params = params[1:]
 
 	var reason string
-	if 1 < len(params) {
-		reason = params[1]
+	var public bool
+	if len(params) != 0 {
+		reason = params[0]
+		if fields := utils.FieldsN(reason, 2); len(fields) != 0 && strings.ToLower(fields[0]) == "public" {
+			public = true
+			reason = ""
+			if len(fields) > 1 {
+				reason = fields[1]
+			}
+		}
 	}
 
 	purgeRecord := ChannelPurgeRecord{
 		Oper:     operName,
 		PurgedAt: time.Now().UTC(),
 		Reason:   reason,
+		Public:   public,
 	}
 	switch client.server.channels.Purge(chname, purgeRecord) {
 	case nil:
@@ -689,13 +1033,13 @@ func csPurgeAddHandler(service *ircService, client *Client, params []string, ope
 	case errInvalidChannelName:
 		service.Notice(rb, fmt.Sprintf(client.t("Can't purge invalid channel %s"), chname))
 	default:
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, "PURGE", serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
 func csPurgeDelHandler(service *ircService, client *Client, params []string, operName string, rb *ResponseBuffer) {
 	if len(params) == 0 {
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, "PURGE", serviceErrInvalidParams, client.t("Invalid parameters"))
 		return
 	}
 
@@ -707,7 +1051,7 @@ func csPurgeDelHandler(service *ircService, client *Client, params []string, ope
 	case errNoSuchChannel:
 		service.Notice(rb, fmt.Sprintf(client.t("Channel %s wasn't previously purged from the server"), chname))
 	default:
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, "PURGE", serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
@@ -715,13 +1059,24 @@ func csPurgeListHandler(service *ircService, client *Client, rb *ResponseBuffer)
 	l := client.server.channels.ListPurged()
 	service.Notice(rb, fmt.Sprintf(client.t("There are %d purged channel(s)."), len(l)))
 	for i, c := range l {
-		service.Notice(rb, fmt.Sprintf("%d: %s", i+1, c))
+		record, err := client.server.channelRegistry.LoadPurgeRecord(c)
+		if err != nil {
+			service.Notice(rb, fmt.Sprintf("%d: %s", i+1, c))
+			continue
+		}
+		reason := record.Reason
+		if reason == "" {
+			reason = client.t("(none)")
+		} else if record.Public {
+			reason = fmt.Sprintf(client.t("%s (public)"), reason)
+		}
+		service.Notice(rb, fmt.Sprintf(client.t("%[1]d: %[2]s, purged by %[3]s at %[4]s; reason: %[5]s"), i+1, c, record.Oper, record.PurgedAt.Format(time.RFC1123), reason))
 	}
 }
 
 func csListHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	if !client.HasRoleCapabs("chanreg") {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	}
 
@@ -730,7 +1085,7 @@ func csListHandler(service *ircService, server *Server, client *Client, command
 		var err error
 		searchRegex, err = regexp.Compile(params[0])
 		if err != nil {
-			service.Notice(rb, client.t("Invalid regex"))
+			service.Fail(rb, command, serviceErrInvalidRegex, client.t("Invalid regex"))
 			return
 		}
 	}
@@ -769,6 +1124,9 @@ func csInfoHandler(service *ircService, server *Server, client *Client, command
 			service.Notice(rb, fmt.Sprintf(client.t("Purged at: %s"), purgeRecord.PurgedAt.Format(time.RFC1123)))
 			if purgeRecord.Reason != "" {
 				service.Notice(rb, fmt.Sprintf(client.t("Purge reason: %s"), purgeRecord.Reason))
+				if purgeRecord.Public {
+					service.Notice(rb, client.t("The purge reason is shown to users trying to join"))
+				}
 			}
 		}
 	} else {
@@ -780,108 +1138,464 @@ func csInfoHandler(service *ircService, server *Server, client *Client, command
 	var chinfo RegisteredChannel
 	channel := server.channels.Get(params[0])
 	if channel != nil {
-		chinfo = channel.ExportRegistration(0)
+		chinfo = channel.ExportRegistration(IncludeInitial | IncludeModes | IncludeLists | IncludeSettings)
 	} else {
 		chinfo, err = server.channelRegistry.LoadChannel(chname)
 		if err != nil && !(err == errNoSuchChannel || err == errFeatureDisabled) {
-			service.Notice(rb, client.t("An error occurred"))
+			service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
 			return
 		}
 	}
 
 	// channel exists but is unregistered, or doesn't exist:
 	if chinfo.Founder == "" {
-		service.Notice(rb, fmt.Sprintf(client.t("Channel %s is not registered"), chname))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %s is not registered"), chname))
 		return
 	}
-	service.Notice(rb, fmt.Sprintf(client.t("Channel %s is registered"), chinfo.Name))
-	service.Notice(rb, fmt.Sprintf(client.t("Founder: %s"), chinfo.Founder))
-	service.Notice(rb, fmt.Sprintf(client.t("Registered at: %s"), chinfo.RegisteredAt.Format(time.RFC1123)))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %s is registered"), chinfo.Name))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Founder: %s"), chinfo.Founder))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Registered at: %s"), chinfo.RegisteredAt.Format(time.RFC1123)))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Access list entries: %d"), len(chinfo.AccountToUMode)))
+	if chinfo.JoinThrottle.Joins != 0 {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Join throttle: %s"), chinfo.JoinThrottle.String()))
+	}
+	if chinfo.Settings.Flood.Enabled() {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Flood protection: %s"), chinfo.Settings.Flood.String()))
+	}
+	if chinfo.Settings.AmodeDelay != 0 {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "AMODE delay: %v"), chinfo.Settings.AmodeDelay))
+	}
+	if chinfo.Settings.TopicLock != modes.Mode(0) {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Topic lock level: %s"), topicLockToString(chinfo.Settings.TopicLock)))
+	}
+	if chinfo.Key != "" {
+		if client.HasRoleCapabs("chanreg") || (channel != nil && csHasAccessLevel(channel, client, modes.ChannelAdmin)) {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Key: %s"), chinfo.Key))
+		} else {
+			service.ChannelNotice(rb, channel, client.tc(channel, "Key: (set)"))
+		}
+	}
+	if chinfo.Settings.MuteNotify {
+		service.ChannelNotice(rb, channel, client.tc(channel, "Mute notifications: enabled"))
+	}
+	if chinfo.Settings.Language != "" {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Language: %s"), chinfo.Settings.Language))
+	}
+	if chinfo.Settings.History != HistoryDefault {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "History: %s"), historyStatusToString(chinfo.Settings.History)))
+	}
+	if chinfo.Settings.QueryCutoff != HistoryCutoffDefault {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "History query cutoff: %s"), historyCutoffToString(chinfo.Settings.QueryCutoff)))
+	}
+
+	// sensitive information is restricted to users on the access list (and chanreg opers)
+	if client.HasRoleCapabs("chanreg") || (channel != nil && csHasAccessLevel(channel, client, modes.Voice)) {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "AKICK entries: %d"), len(chinfo.Akicks)))
+	}
+	if client.HasRoleCapabs("chanreg") || client.Account() == chinfo.Founder {
+		if founder, err := server.accounts.LoadAccount(chinfo.Founder); err == nil && founder.Settings.Email != "" {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Founder's email: %s"), founder.Settings.Email))
+		}
+	}
+	if client.HasRoleCapabs("chanreg") {
+		limit, _ := server.accounts.ChannelLimit(chinfo.Founder)
+		count := len(server.accounts.ChannelsForAccount(chinfo.Founder))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Founder's registered channels: %[1]d (limit %[2]d)"), count, limit))
+	}
+	if pending := chinfo.PendingTransfer; pending.To != "" && (client.Account() == chinfo.Founder || client.HasRoleCapabs("chanreg")) {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Pending transfer to %[1]s, offered at %[2]s"), pending.To, pending.Time.Format(time.RFC1123)))
+	}
+	if channel != nil && csHasOperatorAccess(channel, client) {
+		if chinfo.Settings.EntryMsg != "" {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Entry message: %s"), chinfo.Settings.EntryMsg))
+		}
+		if chinfo.Settings.Successor != "" {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Successor: %s"), chinfo.Settings.Successor))
+		}
+		for _, change := range channel.FounderHistory() {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Founder changed from %[1]s to %[2]s (%[3]s) at %[4]s"), change.From, change.To, change.Reason, change.Time.Format(time.RFC1123)))
+		}
+	}
 }
 
-func displayChannelSetting(service *ircService, settingName string, settings ChannelSettings, client *Client, rb *ResponseBuffer) {
+func displayChannelSetting(service *ircService, settingName string, settings ChannelSettings, channel *Channel, client *Client, rb *ResponseBuffer) {
 	config := client.server.Config()
 
 	switch strings.ToLower(settingName) {
 	case "history":
 		effectiveValue := historyEnabled(config.History.Persistent.RegisteredChannels, settings.History)
-		service.Notice(rb, fmt.Sprintf(client.t("The stored channel history setting is: %s"), historyStatusToString(settings.History)))
-		service.Notice(rb, fmt.Sprintf(client.t("Given current server settings, the channel history setting is: %s"), historyStatusToString(effectiveValue)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The stored channel history setting is: %s"), historyStatusToString(settings.History)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Given current server settings, the channel history setting is: %s"), historyStatusToString(effectiveValue)))
 	case "query-cutoff":
 		effectiveValue := settings.QueryCutoff
 		if effectiveValue == HistoryCutoffDefault {
 			effectiveValue = config.History.Restrictions.queryCutoff
 		}
-		service.Notice(rb, fmt.Sprintf(client.t("The stored channel history query cutoff setting is: %s"), historyCutoffToString(settings.QueryCutoff)))
-		service.Notice(rb, fmt.Sprintf(client.t("Given current server settings, the channel history query cutoff setting is: %s"), historyCutoffToString(effectiveValue)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The stored channel history query cutoff setting is: %s"), historyCutoffToString(settings.QueryCutoff)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Given current server settings, the channel history query cutoff setting is: %s"), historyCutoffToString(effectiveValue)))
+	case "entrymsg":
+		if settings.EntryMsg == "" {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no entry message"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The channel entry message is: %s"), settings.EntryMsg))
+		}
+	case "successor":
+		if settings.Successor == "" {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no designated successor"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The channel's designated successor is: %s"), settings.Successor))
+		}
+	case "flood":
+		if settings.Flood.Enabled() {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The channel flood protection setting is: %s"), settings.Flood.String()))
+		} else {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no flood protection"))
+		}
+	case "amode-delay":
+		if settings.AmodeDelay == 0 {
+			service.ChannelNotice(rb, channel, client.tc(channel, "Persistent modes are applied immediately on join"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Persistent modes are applied %v after joining"), settings.AmodeDelay))
+		}
+	case "topiclock":
+		if settings.TopicLock == modes.Mode(0) {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no topic lock"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The topic can only be changed by users with access level: %s"), topicLockToString(settings.TopicLock)))
+		}
+	case "language":
+		if settings.Language == "" {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no language setting"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The channel language is: %s"), settings.Language))
+		}
+	case "key":
+		if key := channel.Key(); key == "" {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel has no key"))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The channel key is: %s"), key))
+		}
+	case "mute-notify":
+		if settings.MuteNotify {
+			service.ChannelNotice(rb, channel, client.tc(channel, "Channel operators are notified when muted users attempt to speak"))
+		} else {
+			service.ChannelNotice(rb, channel, client.tc(channel, "Channel operators are not notified when muted users attempt to speak"))
+		}
 	default:
-		service.Notice(rb, client.t("Invalid params"))
+		service.ChannelNotice(rb, channel, client.tc(channel, "Invalid params"))
 	}
 }
 
-func csGetHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	chname, setting := params[0], params[1]
-	channel := server.channels.Get(chname)
-	if channel == nil {
-		service.Notice(rb, client.t("No such channel"))
-		return
+// topicLockFromString parses the argument of CS SET TOPICLOCK
+func topicLockFromString(value string) (level modes.Mode, err error) {
+	switch strings.ToLower(value) {
+	case "off":
+		return modes.Mode(0), nil
+	case "op":
+		return modes.ChannelOperator, nil
+	case "admin":
+		return modes.ChannelAdmin, nil
+	case "founder":
+		return modes.ChannelFounder, nil
+	default:
+		return modes.Mode(0), errInvalidParams
 	}
-	info := channel.ExportRegistration(IncludeSettings)
-	if !csPrivsCheck(service, info, client, rb) {
-		return
+}
+
+func topicLockToString(level modes.Mode) string {
+	switch level {
+	case modes.ChannelOperator:
+		return "op"
+	case modes.ChannelAdmin:
+		return "admin"
+	case modes.ChannelFounder:
+		return "founder"
+	default:
+		return "off"
 	}
+}
 
-	displayChannelSetting(service, setting, info.Settings, client, rb)
+// csSetKey implements CS SET KEY: it changes the channel key (+k), announcing
+// the change to the channel and, if notify is set, sending the new key to
+// each member.
+func csSetKey(service *ircService, channel *Channel, client *Client, value string, notify bool) (err error) {
+	change := modes.ModeChange{Op: modes.Add, Mode: modes.Key, Arg: value}
+	if strings.ToLower(value) == "off" {
+		change = modes.ModeChange{Op: modes.Remove, Mode: modes.Key, Arg: "*"}
+		value = ""
+	} else if !validateChannelKey(value) {
+		return errInvalidParams
+	}
+
+	channel.setKey(value)
+	channel.MarkDirty(IncludeModes)
+	announceCmodeChanges(channel, modes.ModeChanges{change}, service.prefix, "*", "", false, nil)
+
+	if !notify || value == "" {
+		return nil
+	}
+	chname := channel.Name()
+	for _, member := range channel.Members() {
+		if member == client {
+			continue
+		}
+		member.Send(nil, service.prefix, "NOTICE", member.Nick(), fmt.Sprintf(member.tc(channel, "The key for %[1]s has been changed to: %[2]s"), chname, value))
+	}
+	return nil
 }
 
-func csSetHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	chname, setting, value := params[0], params[1], params[2]
-	channel := server.channels.Get(chname)
-	if channel == nil {
-		service.Notice(rb, client.t("No such channel"))
+// csSetKeyNotify returns whether CS SET KEY should send the new key to the
+// channel's members, i.e., whether the NOQUIET flag is absent
+func csSetKeyNotify(params []string) bool {
+	return !(len(params) > 3 && strings.ToLower(params[3]) == "noquiet")
+}
+
+// csSetStoredKey implements CS SET KEY for a registered channel that is not
+// currently in use: it updates the stored key, which is applied when the
+// channel is next loaded.
+func csSetStoredKey(service *ircService, server *Server, client *Client, command, chname, value string, rb *ResponseBuffer) {
+	cfname, err := CasefoldChannel(chname)
+	var info RegisteredChannel
+	if err == nil {
+		info, err = server.channelRegistry.LoadChannel(cfname)
+	}
+	if err == errFeatureDisabled || err == errNoSuchChannel || cfname == "" {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.t("No such channel"))
+		return
+	} else if err != nil {
+		server.logger.Error("internal", "couldn't load channel for CS SET KEY", chname, err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 		return
 	}
-	info := channel.ExportRegistration(IncludeSettings)
-	settings := info.Settings
-	if !csPrivsCheck(service, info, client, rb) {
+	if !csPrivsCheck(service, command, info, client, rb) {
 		return
 	}
 
-	var err error
-	switch strings.ToLower(setting) {
-	case "history":
-		settings.History, err = historyStatusFromString(value)
-		if err != nil {
-			err = errInvalidParams
-			break
-		}
-		channel.SetSettings(settings)
-		channel.resizeHistory(server.Config())
-	case "query-cutoff":
-		settings.QueryCutoff, err = historyCutoffFromString(value)
-		if err != nil {
-			err = errInvalidParams
-			break
-		}
-		channel.SetSettings(settings)
+	if strings.ToLower(value) == "off" {
+		value = ""
+	} else if !validateChannelKey(value) {
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+	info.Key = value
+	if err := server.channelRegistry.StoreChannel(info, IncludeModes); err != nil {
+		server.logger.Error("internal", "couldn't store channel key", chname, err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		return
 	}
+	service.Notice(rb, client.t("Successfully changed the channel settings"))
+}
 
-	switch err {
-	case nil:
-		service.Notice(rb, client.t("Successfully changed the channel settings"))
-		displayChannelSetting(service, setting, settings, client, rb)
-	case errInvalidParams:
-		service.Notice(rb, client.t("Invalid parameters"))
-	default:
-		server.logger.Error("internal", "CS SET error:", err.Error())
-		service.Notice(rb, client.t("An error occurred"))
+// channelLanguageFromString validates the argument of CS SET LANGUAGE
+func channelLanguageFromString(config *Config, value string) (language string, err error) {
+	switch strings.ToLower(value) {
+	case "off", "default":
+		return "", nil
+	}
+	language, ok := config.languageManager.Lookup(value)
+	if !ok {
+		return "", errInvalidParams
 	}
+	return language, nil
 }
 
-func csHowToBanHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	success := false
-	defer func() {
-		if success {
+// csSuccessorFromParam validates the argument of CS SET SUCCESSOR
+func csSuccessorFromParam(server *Server, founder, value string) (successor string, err error) {
+	if strings.ToLower(value) == "off" {
+		return "", nil
+	}
+	account, err := server.accounts.LoadAccount(value)
+	if err != nil {
+		return "", errAccountDoesNotExist
+	} else if account.NameCasefolded == founder {
+		return "", errInvalidParams
+	}
+	return account.NameCasefolded, nil
+}
+
+// entryMsgFromParams validates the argument of CS SET ENTRYMSG
+func entryMsgFromParams(config *Config, params []string) (entryMsg string, err error) {
+	entryMsg = strings.TrimSpace(strings.Join(params, " "))
+	if strings.ToLower(entryMsg) == "off" {
+		return "", nil
+	}
+	if config.Channels.EntryMessage.StripFormatting {
+		entryMsg = strings.TrimSpace(ircfmt.Strip(entryMsg))
+	}
+	if entryMsg == "" {
+		return "", errInvalidParams
+	} else if len(entryMsg) > config.Channels.EntryMessage.MaxLength {
+		return "", errEntryMsgTooLong
+	}
+	return
+}
+
+// time windows reported by CS STATS
+var csStatsWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+}
+
+func csStatsHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.t("No such channel"))
+		return
+	}
+	if !csPrivsCheck(service, command, channel.ExportRegistration(0), client, rb) {
+		return
+	}
+
+	chname := channel.Name()
+	current, peak := channel.MemberCounts()
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Statistics for %s:"), chname))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Current members: %d"), current))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Peak members since server start: %d"), peak))
+
+	now := time.Now().UTC()
+	cutoffs := make([]time.Time, len(csStatsWindows))
+	for i, window := range csStatsWindows {
+		cutoffs[i] = now.Add(-window.duration)
+	}
+	activity, speakersKnown, err := server.ChannelActivity(channel, cutoffs)
+	switch err {
+	case nil:
+	case errFeatureDisabled:
+		service.ChannelNotice(rb, channel, client.tc(channel, "Message statistics are unavailable because history is disabled for this channel"))
+		return
+	default:
+		server.logger.Error("internal", "CS STATS error:", err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
+		return
+	}
+	for i, window := range csStatsWindows {
+		if speakersKnown {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Last %[1]s: %[2]d messages from %[3]d distinct accounts"), client.tc(channel, window.name), activity[i].Messages, activity[i].Speakers))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Last %[1]s: %[2]d messages"), client.tc(channel, window.name), activity[i].Messages))
+		}
+	}
+}
+
+func csGetHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	chname, setting := params[0], params[1]
+	channel := server.channels.Get(chname)
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "No such channel"))
+		return
+	}
+	info := channel.ExportRegistration(IncludeSettings)
+	if !csPrivsCheck(service, command, info, client, rb) {
+		return
+	}
+
+	displayChannelSetting(service, setting, info.Settings, channel, client, rb)
+}
+
+func csSetHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	chname, setting, value := params[0], params[1], params[2]
+	channel := server.channels.Get(chname)
+	if channel == nil && strings.ToLower(setting) == "key" {
+		csSetStoredKey(service, server, client, command, chname, value, rb)
+		return
+	} else if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "No such channel"))
+		return
+	}
+	info := channel.ExportRegistration(IncludeSettings)
+	settings := info.Settings
+	if !csPrivsCheck(service, command, info, client, rb) {
+		return
+	}
+
+	var err error
+	switch strings.ToLower(setting) {
+	case "history":
+		settings.History, err = historyStatusFromString(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		channel.SetSettings(settings)
+		channel.resizeHistory(server.Config())
+	case "query-cutoff":
+		settings.QueryCutoff, err = historyCutoffFromString(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		channel.SetSettings(settings)
+	case "entrymsg":
+		settings.EntryMsg, err = entryMsgFromParams(server.Config(), params[2:])
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "flood":
+		settings.Flood, err = ParseFloodSettings(value)
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "successor":
+		settings.Successor, err = csSuccessorFromParam(server, info.Founder, value)
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "amode-delay":
+		settings.AmodeDelay, err = amodeDelayFromString(value)
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "topiclock":
+		settings.TopicLock, err = topicLockFromString(value)
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "language":
+		settings.Language, err = channelLanguageFromString(server.Config(), value)
+		if err != nil {
+			break
+		}
+		channel.SetSettings(settings)
+	case "mute-notify":
+		settings.MuteNotify, err = utils.StringToBool(value)
+		if err != nil {
+			err = errInvalidParams
+			break
+		}
+		channel.SetSettings(settings)
+	case "key":
+		err = csSetKey(service, channel, client, value, csSetKeyNotify(params))
+	}
+
+	switch err {
+	case nil:
+		service.ChannelNotice(rb, channel, client.tc(channel, "Successfully changed the channel settings"))
+		displayChannelSetting(service, setting, settings, channel, client, rb)
+	case errInvalidParams:
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+	case errAccountDoesNotExist:
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.tc(channel, "Account does not exist"))
+	case errEntryMsgTooLong:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "The entry message can be at most %d bytes long"), server.Config().Channels.EntryMessage.MaxLength))
+	default:
+		server.logger.Error("internal", "CS SET error:", err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
+	}
+}
+
+func csHowToBanHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	success := false
+	defer func() {
+		if success {
 			service.Notice(rb, client.t("Note that if the user is currently in the channel, you must /KICK them after you ban them"))
 		}
 	}()
@@ -889,12 +1603,12 @@ func csHowToBanHandler(service *ircService, server *Server, client *Client, comm
 	chname, nick := params[0], params[1]
 	channel := server.channels.Get(chname)
 	if channel == nil {
-		service.Notice(rb, client.t("No such channel"))
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.t("No such channel"))
 		return
 	}
 
 	if !(channel.ClientIsAtLeast(client, modes.ChannelOperator) || client.HasRoleCapabs("samode")) {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	}
 
@@ -903,10 +1617,10 @@ func csHowToBanHandler(service *ircService, server *Server, client *Client, comm
 	if target == nil {
 		whowasList := server.whoWas.Find(nick, 1)
 		if len(whowasList) == 0 {
-			service.Notice(rb, client.t("No such nick"))
+			service.Fail(rb, command, serviceErrNoSuchNick, client.t("No such nick"))
 			return
 		}
-		service.Notice(rb, fmt.Sprintf(client.t("Warning: %s is not currently connected to the server. Using WHOWAS data, which may be inaccurate:"), nick))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Warning: %s is not currently connected to the server. Using WHOWAS data, which may be inaccurate:"), nick))
 		details = whowasList[0]
 	} else {
 		details = target.Details().WhoWas
@@ -914,22 +1628,22 @@ func csHowToBanHandler(service *ircService, server *Server, client *Client, comm
 
 	if details.account != "" {
 		if channel.getAmode(details.account) != modes.Mode(0) {
-			service.Notice(rb, fmt.Sprintf(client.t("Warning: account %s currently has a persistent channel privilege granted with CS AMODE. If this mode is not removed, bans will not be respected"), details.accountName))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Warning: account %s currently has a persistent channel privilege granted with CS AMODE. If this mode is not removed, bans will not be respected"), details.accountName))
 			return
 		} else if details.account == channel.Founder() {
-			service.Notice(rb, fmt.Sprintf(client.t("Warning: account %s is the channel founder and cannot be banned"), details.accountName))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Warning: account %s is the channel founder and cannot be banned"), details.accountName))
 			return
 		}
 	}
 
 	config := server.Config()
 	if !config.Server.Cloaks.EnabledForAlwaysOn {
-		service.Notice(rb, client.t("Warning: server.ip-cloaking.enabled-for-always-on is disabled. This reduces the precision of channel bans."))
+		service.ChannelNotice(rb, channel, client.t("Warning: server.ip-cloaking.enabled-for-always-on is disabled. This reduces the precision of channel bans."))
 	}
 
 	if details.account != "" {
 		if config.Accounts.NickReservation.ForceNickEqualsAccount || target.AlwaysOn() {
-			service.Notice(rb, fmt.Sprintf(client.t("User %[1]s is authenticated and can be banned by nickname: /MODE %[2]s +b %[3]s!*@*"), details.nick, channel.Name(), details.nick))
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("User %[1]s is authenticated and can be banned by nickname: /MODE %[2]s +b %[3]s!*@*"), details.nick, channel.Name(), details.nick))
 			success = true
 			return
 		}
@@ -948,12 +1662,526 @@ func csHowToBanHandler(service *ircService, server *Server, client *Client, comm
 			collateralDamage = append(collateralDamage, mcl.Nick())
 		}
 	}
-	service.Notice(rb, fmt.Sprintf(client.t("User %[1]s can be banned by hostname: /MODE %[2]s +b %[3]s"), details.nick, channel.Name(), ban))
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("User %[1]s can be banned by hostname: /MODE %[2]s +b %[3]s"), details.nick, channel.Name(), ban))
 	success = true
 	if len(collateralDamage) != 0 {
-		service.Notice(rb, fmt.Sprintf(client.t("Warning: this ban will affect %d other users:"), len(collateralDamage)))
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Warning: this ban will affect %d other users:"), len(collateralDamage)))
 		for _, line := range utils.BuildTokenLines(400, collateralDamage, " ") {
-			service.Notice(rb, line)
+			service.ChannelNotice(rb, channel, line)
+		}
+	}
+}
+
+func csHasOperatorAccess(channel *Channel, client *Client) bool {
+	return csHasAccessLevel(channel, client, modes.ChannelOperator)
+}
+
+// csHasAccessLevel checks whether the client is the founder of the channel,
+// has persistent modes of at least `level`, or is a chanreg oper.
+func csHasAccessLevel(channel *Channel, client *Client, level modes.Mode) bool {
+	if client.HasRoleCapabs("chanreg") {
+		return true
+	}
+	account := client.Account()
+	if account == "" {
+		return false
+	} else if account == channel.Founder() {
+		return true
+	}
+	amode := channel.highestPersistentMode(account)
+	return amode == level || umodeGreaterThan(amode, level)
+}
+
+// csHasPrivsOverAccount checks whether the client's persistent modes outrank
+// those of the given account (founders and chanreg opers outrank everyone).
+func csHasPrivsOverAccount(channel *Channel, client *Client, account string) bool {
+	if client.HasRoleCapabs("chanreg") {
+		return true
+	}
+	clientAccount := client.Account()
+	if clientAccount == "" {
+		return false
+	} else if clientAccount == channel.Founder() {
+		return true
+	}
+	return channelUserModeHasPrivsOver(channel.highestPersistentMode(clientAccount), channel.highestPersistentMode(account))
+}
+
+func csCertHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	verb := strings.ToLower(params[0])
+	var certfp string
+	switch verb {
+	case "list":
+	case "add", "del":
+		if len(params) == 2 {
+			certfp = params[1]
+		} else if verb == "add" && rb.session.certfp != "" {
+			certfp = rb.session.certfp
+		} else {
+			service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+			return
+		}
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+
+	// same as NS CERT, but only for your own account
+	certfpCommand(service, server, client, command, verb, client.Account(), certfp, client.HasRoleCapabs("accreg"), rb)
+}
+
+func csAkickHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "Channel does not exist"))
+		return
+	} else if channel.Founder() == "" {
+		service.Fail(rb, command, serviceErrChannelNotRegistered, client.tc(channel, "Channel is not registered"))
+		return
+	}
+	if !csHasOperatorAccess(channel, client) {
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
+		return
+	}
+
+	switch strings.ToLower(params[1]) {
+	case "add":
+		csAkickAddHandler(service, channel, client, params[2:], rb)
+	case "del", "remove":
+		csAkickDelHandler(service, channel, client, params[2:], rb)
+	case "list":
+		csAkickListHandler(service, channel, client, rb)
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+	}
+}
+
+func csAkickAddHandler(service *ircService, channel *Channel, client *Client, params []string, rb *ResponseBuffer) {
+	if len(params) == 0 {
+		service.Fail(rb, "AKICK", serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+	key, isAccount, err := canonicalizeAkickTarget(params[0])
+	if err != nil {
+		service.Fail(rb, "AKICK", serviceErrInvalidMask, client.tc(channel, "Invalid mask or account name"))
+		return
+	}
+	if account := akickTargetAccount(key, isAccount); account != "" {
+		if account == channel.Founder() {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel founder can't be auto-kicked"))
+			return
 		}
+		// as with AMODE, you can only auto-kick accounts you have privileges over
+		if !csHasPrivsOverAccount(channel, client, account) {
+			service.Fail(rb, "AKICK", serviceErrInsufficientPrivs, fmt.Sprintf(client.tc(channel, "%s has higher privileges than you on that channel"), account))
+			return
+		}
+	}
+	params = params[1:]
+
+	now := time.Now().UTC()
+	entry := AkickEntry{
+		Account:         isAccount,
+		TimeCreated:     now,
+		CreatorNickmask: client.NickMaskString(),
+		CreatorAccount:  client.AccountName(),
+	}
+	if len(params) != 0 {
+		if duration, err := custime.ParseDuration(params[0]); err == nil {
+			entry.Expires = now.Add(duration)
+			params = params[1:]
+		}
+	}
+	entry.Reason = strings.Join(params, " ")
+
+	switch channel.AddAkick(key, entry) {
+	case nil:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Added %[1]s to the auto-kick list of %[2]s"), key, channel.Name()))
+		client.server.logger.Info("services", fmt.Sprintf("Client %s added %s to the auto-kick list of %s", client.Nick(), key, channel.Name()))
+	case errLimitExceeded:
+		service.ChannelNotice(rb, channel, client.tc(channel, "The auto-kick list is full"))
+	default:
+		service.Fail(rb, "AKICK", serviceErrUnknown, client.tc(channel, "An error occurred"))
+	}
+}
+
+func csAkickDelHandler(service *ircService, channel *Channel, client *Client, params []string, rb *ResponseBuffer) {
+	if len(params) == 0 {
+		service.Fail(rb, "AKICK", serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+	key, _, err := canonicalizeAkickTarget(params[0])
+	if err != nil {
+		service.Fail(rb, "AKICK", serviceErrInvalidMask, client.tc(channel, "Invalid mask or account name"))
+		return
+	}
+
+	switch channel.RemoveAkick(key) {
+	case nil:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Removed %[1]s from the auto-kick list of %[2]s"), key, channel.Name()))
+		client.server.logger.Info("services", fmt.Sprintf("Client %s removed %s from the auto-kick list of %s", client.Nick(), key, channel.Name()))
+	case errNoop:
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "%s is not on the auto-kick list"), key))
+	default:
+		service.Fail(rb, "AKICK", serviceErrUnknown, client.tc(channel, "An error occurred"))
+	}
+}
+
+func csAkickListHandler(service *ircService, channel *Channel, client *Client, rb *ResponseBuffer) {
+	akicks := channel.Akicks()
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %[1]s has %[2]d auto-kick entries"), channel.Name(), len(akicks)))
+	for _, akick := range akicks {
+		key := akick.Key
+		if akick.Account {
+			key = fmt.Sprintf(client.tc(channel, "account %s"), key)
+		}
+		creator := akick.CreatorNickmask
+		if akick.CreatorAccount != "" {
+			creator = fmt.Sprintf("%s (%s)", creator, akick.CreatorAccount)
+		}
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "%[1]s: added by %[2]s at %[3]s"), key, creator, akick.TimeCreated.Format(time.RFC1123)))
+		if akick.Reason != "" {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "  Reason: %s"), akick.Reason))
+		}
+		if !akick.Expires.IsZero() {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "  Expires: %s"), akick.Expires.Format(time.RFC1123)))
+		}
+	}
+}
+
+func csInviteHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "Channel does not exist"))
+		return
+	} else if channel.Founder() == "" {
+		service.Fail(rb, command, serviceErrChannelNotRegistered, client.tc(channel, "Channel is not registered"))
+		return
+	}
+	if !csHasOperatorAccess(channel, client) {
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
+		return
+	}
+
+	subCmd := strings.ToLower(params[1])
+	if subCmd == "list" {
+		csInviteListHandler(service, channel, client, rb)
+		return
+	}
+	if len(params) < 3 || !(subCmd == "add" || subCmd == "del" || subCmd == "remove") {
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+	account, err := server.accounts.LoadAccount(params[2])
+	if err != nil {
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.tc(channel, "Account does not exist"))
+		return
+	}
+	mask := extbanAccount + account.NameCasefolded
+
+	var change modes.ModeChange
+	if subCmd == "add" {
+		if channel.lists[modes.InviteMask].Length() >= server.Config().Limits.ChanListModes {
+			service.ChannelNotice(rb, channel, client.tc(channel, "The channel's invite exception list is full"))
+			return
+		}
+		change = modes.ModeChange{Op: modes.Add, Mode: modes.InviteMask}
+		change.Arg, err = channel.lists[modes.InviteMask].Add(mask, client.NickMaskString(), client.AccountName())
+	} else {
+		change = modes.ModeChange{Op: modes.Remove, Mode: modes.InviteMask}
+		change.Arg, err = channel.lists[modes.InviteMask].Remove(mask)
+	}
+	if err != nil {
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
+		return
+	}
+	if change.Arg == "" {
+		if subCmd == "add" {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s is already invited to %[2]s"), account.Name, channel.Name()))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s is not invited to %[2]s"), account.Name, channel.Name()))
+		}
+		return
+	}
+
+	channel.MarkDirty(IncludeLists)
+	announceCmodeChanges(channel, modes.ModeChanges{change}, service.prefix, "*", "", false, nil)
+	if subCmd == "add" {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s is now invited to %[2]s"), account.Name, channel.Name()))
+	} else {
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s is no longer invited to %[2]s"), account.Name, channel.Name()))
+	}
+}
+
+// csInviteListHandler lists the account invite exceptions, pruning any
+// whose accounts no longer exist.
+func csInviteListHandler(service *ircService, channel *Channel, client *Client, rb *ResponseBuffer) {
+	var accounts []string
+	var pruned modes.ModeChanges
+	for mask, info := range channel.lists[modes.InviteMask].Masks() {
+		account := strings.TrimPrefix(mask, extbanAccount)
+		if account == mask || account == "" {
+			continue // not an account invite exception
+		}
+		if _, err := client.server.accounts.LoadAccount(account); err == errAccountDoesNotExist {
+			if removed, _ := channel.lists[modes.InviteMask].Remove(mask); removed != "" {
+				pruned = append(pruned, modes.ModeChange{Op: modes.Remove, Mode: modes.InviteMask, Arg: removed})
+			}
+			continue
+		}
+		accounts = append(accounts, fmt.Sprintf(client.tc(channel, "%[1]s: added by %[2]s at %[3]s"), account, info.CreatorNickmask, info.TimeCreated.Format(time.RFC1123)))
+	}
+	if len(pruned) != 0 {
+		channel.MarkDirty(IncludeLists)
+		announceCmodeChanges(channel, pruned, service.prefix, "*", "", false, nil)
+	}
+
+	sort.Strings(accounts)
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %[1]s has %[2]d invited accounts"), channel.Name(), len(accounts)))
+	for _, line := range accounts {
+		service.ChannelNotice(rb, channel, line)
+	}
+}
+
+func csTemplateHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "No such channel"))
+		return
+	}
+	info := channel.ExportRegistration(0)
+
+	subCmd := strings.ToLower(params[1])
+	if subCmd == "list" {
+		if !csHasOperatorAccess(channel, client) {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
+			return
+		}
+		csTemplateListHandler(service, channel, client, rb)
+		return
+	}
+	if !csPrivsCheck(service, command, info, client, rb) {
+		return
+	}
+	if len(params) < 3 {
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+	name, err := casefoldTemplateName(params[2])
+	if err != nil {
+		service.Fail(rb, command, serviceErrInvalidTemplate, client.tc(channel, "Invalid template name"))
+		return
+	}
+
+	var modify func(templates map[string]modes.Modes, roles map[string]string) error
+	var successMsg string
+	switch subCmd {
+	case "set":
+		if len(params) < 4 {
+			service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+			return
+		}
+		tmodes, err := parseTemplateModes(params[3])
+		if err != nil {
+			service.ChannelNotice(rb, channel, client.tc(channel, "Invalid modes; templates can only contain channel privilege modes, e.g., +ov"))
+			return
+		}
+		modify = func(templates map[string]modes.Modes, roles map[string]string) error {
+			if _, exists := templates[name]; !exists && len(templates) >= server.Config().Limits.ChanListModes {
+				return errLimitExceeded
+			}
+			templates[name] = tmodes
+			return nil
+		}
+		successMsg = fmt.Sprintf(client.tc(channel, "Template %[1]s now grants +%[2]s"), name, tmodes.String())
+	case "rename":
+		if len(params) < 4 {
+			service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+			return
+		}
+		newName, err := casefoldTemplateName(params[3])
+		if err != nil {
+			service.Fail(rb, command, serviceErrInvalidTemplate, client.tc(channel, "Invalid template name"))
+			return
+		}
+		modify = func(templates map[string]modes.Modes, roles map[string]string) error {
+			tmodes, exists := templates[name]
+			if !exists {
+				return errNoSuchTemplate
+			} else if _, exists := templates[newName]; exists {
+				return errTemplateExists
+			}
+			delete(templates, name)
+			templates[newName] = tmodes
+			for account, template := range roles {
+				if template == name {
+					roles[account] = newName
+				}
+			}
+			return nil
+		}
+		successMsg = fmt.Sprintf(client.tc(channel, "Renamed template %[1]s to %[2]s"), name, newName)
+	case "del", "delete", "remove":
+		_, roles := channel.Templates()
+		assignees := 0
+		for _, template := range roles {
+			if template == name {
+				assignees++
+			}
+		}
+		if assignees != 0 {
+			expectedCode := utils.ConfirmationCode(info.Name+" "+name, info.RegisteredAt)
+			if len(params) < 4 || params[3] != expectedCode {
+				service.ChannelNotice(rb, channel, ircfmt.Unescape(fmt.Sprintf(client.tc(channel, "$bWarning: template %[1]s is assigned to %[2]d account(s), whose roles will be removed.$b"), name, assignees)))
+				service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "To confirm, run this command: %s"), fmt.Sprintf("/CS TEMPLATE %s DEL %s %s", info.Name, name, expectedCode)))
+				return
+			}
+		}
+		modify = func(templates map[string]modes.Modes, roles map[string]string) error {
+			if _, exists := templates[name]; !exists {
+				return errNoSuchTemplate
+			}
+			delete(templates, name)
+			for account, template := range roles {
+				if template == name {
+					delete(roles, account)
+				}
+			}
+			return nil
+		}
+		successMsg = fmt.Sprintf(client.tc(channel, "Deleted template %s"), name)
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+
+	applied, err := channel.ModifyTemplates(modify)
+	switch err {
+	case nil:
+		service.ChannelNotice(rb, channel, successMsg)
+		announceCmodeChanges(channel, applied, server.name, "*", "", false, rb)
+	case errNoSuchTemplate, errTemplateExists:
+		service.ChannelNotice(rb, channel, client.tc(channel, err.Error()))
+	case errLimitExceeded:
+		service.ChannelNotice(rb, channel, client.tc(channel, "The channel has too many templates"))
+	default:
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
+	}
+}
+
+func csTemplateListHandler(service *ircService, channel *Channel, client *Client, rb *ResponseBuffer) {
+	templates, roles := channel.Templates()
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignees := make(map[string][]string)
+	for account, template := range roles {
+		assignees[template] = append(assignees[template], account)
+	}
+
+	service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %[1]s has %[2]d templates"), channel.Name(), len(names)))
+	for _, name := range names {
+		accounts := assignees[name]
+		sort.Strings(accounts)
+		if len(accounts) == 0 {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Template %[1]s grants +%[2]s (not assigned)"), name, templates[name].String()))
+		} else {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Template %[1]s grants +%[2]s, assigned to: %[3]s"), name, templates[name].String(), strings.Join(accounts, ", ")))
+		}
+	}
+}
+
+func csRoleHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		service.Fail(rb, command, serviceErrNoSuchChannel, client.tc(channel, "No such channel"))
+		return
+	}
+
+	subCmd := strings.ToLower(params[1])
+	if subCmd == "list" {
+		if !csHasOperatorAccess(channel, client) {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, client.tc(channel, "Insufficient privileges"))
+			return
+		}
+		templates, roles := channel.Templates()
+		accounts := make([]string, 0, len(roles))
+		for account := range roles {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+		service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Channel %[1]s has %[2]d role assignments"), channel.Name(), len(accounts)))
+		for _, account := range accounts {
+			template := roles[account]
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.tc(channel, "Account %[1]s has role %[2]s (+%[3]s)"), account, template, templates[template].String()))
+		}
+		return
+	}
+	if !csPrivsCheck(service, command, channel.ExportRegistration(0), client, rb) {
+		return
+	}
+	if len(params) < 3 {
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+	account, err := CasefoldName(params[2])
+	if err != nil {
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.tc(channel, "Account does not exist"))
+		return
+	}
+
+	var modify func(templates map[string]modes.Modes, roles map[string]string) error
+	var successMsg string
+	switch subCmd {
+	case "add", "set":
+		if len(params) < 4 {
+			service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+			return
+		}
+		if _, err := server.accounts.LoadAccount(account); err != nil {
+			service.Fail(rb, command, serviceErrNoSuchAccount, client.tc(channel, "Account does not exist"))
+			return
+		}
+		name, err := casefoldTemplateName(params[3])
+		if err != nil {
+			service.Fail(rb, command, serviceErrInvalidTemplate, client.tc(channel, "Invalid template name"))
+			return
+		}
+		modify = func(templates map[string]modes.Modes, roles map[string]string) error {
+			if _, exists := templates[name]; !exists {
+				return errNoSuchTemplate
+			}
+			roles[account] = name
+			return nil
+		}
+		successMsg = fmt.Sprintf(client.tc(channel, "Account %[1]s now has role %[2]s"), account, name)
+	case "del", "delete", "remove":
+		// allow removal of accounts that may have been deleted
+		modify = func(templates map[string]modes.Modes, roles map[string]string) error {
+			if _, exists := roles[account]; !exists {
+				return errNoop
+			}
+			delete(roles, account)
+			return nil
+		}
+		successMsg = fmt.Sprintf(client.tc(channel, "Removed the role of account %s"), account)
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.tc(channel, "Invalid parameters"))
+		return
+	}
+
+	applied, err := channel.ModifyTemplates(modify)
+	switch err {
+	case nil:
+		service.ChannelNotice(rb, channel, successMsg)
+		announceCmodeChanges(channel, applied, server.name, "*", "", false, rb)
+	case errNoSuchTemplate:
+		service.ChannelNotice(rb, channel, client.tc(channel, err.Error()))
+	case errNoop:
+		service.Fail(rb, command, serviceErrNoChanges, client.tc(channel, "No changes were made"))
+	default:
+		service.Fail(rb, command, serviceErrUnknown, client.tc(channel, "An error occurred"))
 	}
 }
@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestTopicLock(t *testing.T) {
+	for _, value := range []string{"off", "op", "admin", "founder"} {
+		level, err := topicLockFromString(value)
+		assertEqual(err, nil, t)
+		assertEqual(topicLockToString(level), value, t)
+	}
+	level, err := topicLockFromString("Founder")
+	assertEqual(err, nil, t)
+	assertEqual(level, modes.ChannelFounder, t)
+	_, err = topicLockFromString("halfop")
+	assertEqual(err, errInvalidParams, t)
+}
+
+func TestPendingTransferAccept(t *testing.T) {
+	offered := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pt := PendingTransfer{To: "alice", Time: offered}
+	cooldown, expiration := 48*time.Hour, 7*24*time.Hour
+
+	wait, err := pt.checkAccept(offered.Add(time.Hour), cooldown, expiration)
+	assertEqual(err, errChannelTransferCooldown, t)
+	assertEqual(wait, 47*time.Hour, t)
+
+	_, err = pt.checkAccept(offered.Add(cooldown), cooldown, expiration)
+	assertEqual(err, nil, t)
+
+	_, err = pt.checkAccept(offered.Add(expiration), cooldown, expiration)
+	assertEqual(err, errChannelTransferExpired, t)
+
+	// no cooldown or expiration
+	_, err = pt.checkAccept(offered.Add(365*24*time.Hour), 0, 0)
+	assertEqual(err, nil, t)
+}
+
+func TestCsSetKeyNotify(t *testing.T) {
+	assertEqual(csSetKeyNotify([]string{"#chan", "key", "hunter2"}), true, t)
+	assertEqual(csSetKeyNotify([]string{"#chan", "key", "hunter2", "NoQuiet"}), false, t)
+	assertEqual(csSetKeyNotify([]string{"#chan", "key", "hunter2", "quiet"}), true, t)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// channel templates (CS TEMPLATE) are named sets of channel-user modes;
+// roles (CS ROLE) assign a template to an account. together with the
+// account's amode (CS AMODE), they determine the modes the account
+// receives on join.
+
+var (
+	validTemplateNameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+)
+
+func casefoldTemplateName(name string) (result string, err error) {
+	result = strings.ToLower(name)
+	if !validTemplateNameRegexp.MatchString(result) {
+		return "", errInvalidParams
+	}
+	return
+}
+
+// parseTemplateModes parses the mode argument of CS TEMPLATE SET, e.g., `+ov`
+func parseTemplateModes(modeStr string) (result modes.Modes, err error) {
+	modeStr = strings.TrimPrefix(modeStr, "+")
+	if modeStr == "" {
+		return nil, errInvalidParams
+	}
+	for _, char := range modeStr {
+		mode := modes.Mode(char)
+		isUserMode := false
+		for _, userMode := range modes.ChannelUserModes {
+			if mode == userMode {
+				isUserMode = true
+				break
+			}
+		}
+		if !isUserMode {
+			return nil, errInvalidParams
+		}
+		if !modeListContains(result, mode) {
+			result = append(result, mode)
+		}
+	}
+	return
+}
+
+func modeListContains(list modes.Modes, mode modes.Mode) bool {
+	for _, m := range list {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// persistentModesNoMutex returns the modes an account receives on join:
+// its amode, plus the modes of its role's template, if any.
+// the caller must hold channel.stateMutex.
+func (channel *Channel) persistentModesNoMutex(account string) (result modes.Modes) {
+	if account == "" {
+		return
+	}
+	if amode := channel.accountToUMode[account]; amode != modes.Mode(0) {
+		result = append(result, amode)
+	}
+	if template, ok := channel.roles[account]; ok {
+		for _, mode := range channel.templates[template] {
+			if !modeListContains(result, mode) {
+				result = append(result, mode)
+			}
+		}
+	}
+	return
+}
+
+// highestPersistentMode returns the most privileged mode an account
+// receives on join (from either its amode or its role).
+func (channel *Channel) highestPersistentMode(account string) (result modes.Mode) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return highestChannelUserMode(channel.persistentModesNoMutex(account))
+}
+
+func highestChannelUserMode(list modes.Modes) (result modes.Mode) {
+	for _, mode := range modes.ChannelUserModes {
+		if modeListContains(list, mode) {
+			return mode
+		}
+	}
+	return
+}
+
+// Templates returns a copy of the channel's templates and role assignments.
+func (channel *Channel) Templates() (templates map[string]modes.Modes, roles map[string]string) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	templates = make(map[string]modes.Modes, len(channel.templates))
+	for name, tmodes := range channel.templates {
+		templates[name] = tmodes
+	}
+	roles = make(map[string]string, len(channel.roles))
+	for account, template := range channel.roles {
+		roles[account] = template
+	}
+	return
+}
+
+// ModifyTemplates applies a change to the channel's templates and role
+// assignments, then brings the modes of current members in line with their
+// new persistent modes. It returns the resulting mode changes, which the caller
+// should announce.
+func (channel *Channel) ModifyTemplates(modify func(templates map[string]modes.Modes, roles map[string]string) error) (applied modes.ModeChanges, err error) {
+	members := channel.Members()
+	accounts := make(map[*Client]string, len(members))
+	nicks := make(map[*Client]string, len(members))
+	for _, member := range members {
+		if account := member.Account(); account != "" {
+			accounts[member] = account
+			nicks[member] = member.Nick()
+		}
+	}
+
+	var changed []*Client
+	defer func() {
+		if err == nil {
+			channel.MarkDirty(IncludeLists)
+		}
+		for _, member := range changed {
+			member.markDirty(IncludeChannels)
+		}
+	}()
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	before := make(map[*Client]modes.Modes, len(accounts))
+	for member, account := range accounts {
+		before[member] = channel.persistentModesNoMutex(account)
+	}
+
+	if channel.templates == nil {
+		channel.templates = make(map[string]modes.Modes)
+	}
+	if channel.roles == nil {
+		channel.roles = make(map[string]string)
+	}
+	if err = modify(channel.templates, channel.roles); err != nil {
+		return
+	}
+
+	for member, account := range accounts {
+		memberData, ok := channel.members[member]
+		if !ok {
+			continue
+		}
+		after := channel.persistentModesNoMutex(account)
+		memberChanged := false
+		for _, mode := range before[member] {
+			if !modeListContains(after, mode) && memberData.modes.SetMode(mode, false) {
+				applied = append(applied, modes.ModeChange{Op: modes.Remove, Mode: mode, Arg: nicks[member]})
+				memberChanged = true
+			}
+		}
+		for _, mode := range after {
+			if memberData.modes.SetMode(mode, true) {
+				applied = append(applied, modes.ModeChange{Op: modes.Add, Mode: mode, Arg: nicks[member]})
+				memberChanged = true
+			}
+		}
+		if memberChanged {
+			changed = append(changed, member)
+		}
+	}
+	return
+}
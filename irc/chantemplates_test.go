@@ -0,0 +1,28 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestParseTemplateModes(t *testing.T) {
+	result, err := parseTemplateModes("+ovo")
+	assertEqual(err, nil, t)
+	assertEqual(result, modes.Modes{modes.ChannelOperator, modes.Voice}, t)
+	assertEqual(highestChannelUserMode(result), modes.ChannelOperator, t)
+
+	_, err = parseTemplateModes("+m")
+	assertEqual(err, errInvalidParams, t)
+	_, err = parseTemplateModes("+")
+	assertEqual(err, errInvalidParams, t)
+
+	name, err := casefoldTemplateName("Moderator")
+	assertEqual(err, nil, t)
+	assertEqual(name, "moderator", t)
+	_, err = casefoldTemplateName("two words")
+	assertEqual(err, errInvalidParams, t)
+}
@@ -43,6 +43,9 @@ const (
 	// controls how often often we write an autoreplay-missed client's
 	// deviceid->lastseentime mapping to the database
 	lastSeenWriteInterval = time.Hour
+	// draft/pre-away: `AWAY *` marks a session as away without a message,
+	// e.g., a bouncer with no attached clients
+	preAwayMessage = "*"
 )
 
 const (
@@ -72,6 +75,8 @@ type Client struct {
 	accountName        string // display name of the account: uncasefolded, '*' if not logged in
 	accountRegDate     time.Time
 	accountSettings    AccountSettings
+	ignores            *ignoreMatcher // compiled from accountSettings.Ignores
+	recognizedAccount  string         // NS ACCESS: using the account's nick, but not logged in
 	awayMessage        string
 	channels           ChannelSet
 	ctime              time.Time
@@ -85,6 +90,8 @@ type Client struct {
 	lastSeen           map[string]time.Time // maps device ID (including "") to time of last received command
 	lastSeenLastWrite  time.Time            // last time `lastSeen` was written to the datastore
 	loginThrottle      connection_limits.GenericThrottle
+	metadata           map[string]string // draft/metadata-2; copy-on-write
+	metadataThrottle   connection_limits.GenericThrottle
 	nextSessionID      int64 // Incremented when a new session is established
 	nick               string
 	nickCasefolded     string
@@ -93,6 +100,7 @@ type Client struct {
 	oper               *Oper
 	preregNick         string
 	proxiedIP          net.IP // actual remote IP if using the PROXY protocol
+	webircGateway      string // name of the WEBIRC gateway, if any (realIP is the gateway's IP)
 	rawHostname        string
 	cloakedHostname    string
 	realname           string
@@ -110,6 +118,7 @@ type Client struct {
 	username           string
 	vhost              string
 	history            history.Buffer
+	impersonation      *impersonation // NS IMPERSONATE session, if any
 	dirtyBits          uint
 	writerSemaphore    utils.Semaphore // tier 1.5
 }
@@ -118,6 +127,10 @@ type saslStatus struct {
 	mechanism string
 	value     string
 	scramConv *scram.ServerConversation
+	// OAUTHBEARER: we sent the error report and are awaiting the client's dummy response
+	oauthFailed bool
+	// OAUTHBEARER: the token is being validated with the IdP
+	oauthPending bool
 }
 
 func (s *saslStatus) Clear() {
@@ -180,6 +193,14 @@ type Session struct {
 	autoreplayMissedSince time.Time
 
 	batch MultilineBatch
+
+	metadataSubs metadataSubscriptions
+
+	operChallenge operChallenge
+
+	// held while executing a command from the session, or the completion
+	// of asynchronous work started by one (see runAsync)
+	commandMutex sync.Mutex
 }
 
 // MultilineBatch tracks the state of a client-to-server multiline batch.
@@ -604,6 +625,20 @@ func (client *Client) t(originalString string) string {
 	return languageManager.Translate(client.Languages(), originalString)
 }
 
+// tc is like t, but for messages concerning a channel: if the client hasn't chosen
+// a language of its own, the channel's language (CS SET LANGUAGE) is used.
+func (client *Client) tc(channel *Channel, originalString string) string {
+	languageManager := client.server.Config().languageManager
+	if !languageManager.Enabled() {
+		return originalString
+	}
+	languages := client.Languages()
+	if channel != nil {
+		languages = languageManager.ForChannel(languages, channel.Settings().Language)
+	}
+	return languageManager.Translate(languages, originalString)
+}
+
 // main client goroutine: read lines and execute the corresponding commands
 // `proxyLine` is the PROXY-before-TLS line, if there was one
 func (client *Client) run(session *Session) {
@@ -687,10 +722,16 @@ func (client *Client) run(session *Session) {
 			session.Send(nil, client.server.name, ERR_INPUTTOOLONG, client.Nick(), client.t("Input line contained excess tag data"))
 			continue
 		} else if err == ircmsg.ErrorBodyTooLong {
-			if !client.server.Config().Server.Compatibility.allowTruncation {
+			config := client.server.Config()
+			if !config.Server.Compatibility.allowTruncation {
 				session.Send(nil, client.server.name, ERR_INPUTTOOLONG, client.Nick(), client.t("Input line too long"))
 				continue
-			} // else: proceed with the truncated line
+			}
+			// else: proceed with the truncated line; if we're enforcing UTF-8,
+			// don't leave a partial codepoint at the end
+			if config.Server.EnforceUtf8 && len(msg.Params) != 0 {
+				msg.Params[len(msg.Params)-1] = utils.TrimIncompleteUTF8(msg.Params[len(msg.Params)-1])
+			}
 		} else if err != nil {
 			client.Quit(client.t("Received malformed line"), session)
 			break
@@ -703,7 +744,11 @@ func (client *Client) run(session *Session) {
 			cmd = invalidUtf8Command
 		}
 
-		isExiting := cmd.Run(client.server, client, session, msg)
+		isExiting := func() bool {
+			session.commandMutex.Lock()
+			defer session.commandMutex.Unlock()
+			return cmd.Run(client.server, client, session, msg)
+		}()
 		if isExiting {
 			break
 		} else if session.client != client {
@@ -714,6 +759,28 @@ func (client *Client) run(session *Session) {
 	}
 }
 
+// runAsync performs slow work (e.g., a request to an external service) on a
+// separate goroutine, so that it can't stall the session's main goroutine.
+// `complete` then runs serialized with the session's commands, as though
+// it were executed by the main goroutine.
+func (session *Session) runAsync(work func(), complete func(rb *ResponseBuffer)) {
+	server := session.client.server
+	go func() {
+		defer server.HandlePanic()
+
+		work()
+
+		session.commandMutex.Lock()
+		defer session.commandMutex.Unlock()
+		if session.socket.IsClosed() {
+			return
+		}
+		rb := NewResponseBuffer(session)
+		complete(rb)
+		rb.Send(true)
+	}()
+}
+
 func (client *Client) playReattachMessages(session *Session) {
 	client.server.playRegistrationBurst(session)
 	hasHistoryCaps := session.HasHistoryCaps()
@@ -1045,6 +1112,48 @@ func (client *Client) sendChghost(oldNickMask string, vhost string) {
 	}
 }
 
+// sendSetnameFallback shows a realname change to channel members without the
+// setname capability, by having the client quit and then rejoin each channel
+// they share (with its channel modes restored).
+func (client *Client) sendSetnameFallback() {
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+	isAway, awayMessage := client.Away()
+	now := time.Now().UTC()
+
+	rejoins := make(map[*Session][]*Channel)
+	for _, channel := range client.Channels() {
+		for _, member := range channel.auditoriumFriends(client) {
+			if member == client {
+				continue
+			}
+			for _, session := range member.Sessions() {
+				if !session.capabilities.Has(caps.SetName) {
+					rejoins[session] = append(rejoins[session], channel)
+				}
+			}
+		}
+	}
+
+	for session, channels := range rejoins {
+		session.sendFromClientInternal(false, now, "", details.nickMask, details.accountName, isBot, nil, "QUIT", "Changing realname")
+		for _, channel := range channels {
+			chname := channel.Name()
+			if session.capabilities.Has(caps.ExtendedJoin) {
+				session.sendFromClientInternal(false, now, "", details.nickMask, details.accountName, isBot, nil, "JOIN", chname, details.accountName, details.realname)
+			} else {
+				session.sendFromClientInternal(false, now, "", details.nickMask, details.accountName, isBot, nil, "JOIN", chname)
+			}
+			if modeParams := channel.memberModeParams(client, details.nick); len(modeParams) != 0 {
+				session.Send(nil, client.server.name, "MODE", modeParams...)
+			}
+		}
+		if isAway && session.capabilities.Has(caps.AwayNotify) {
+			session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "AWAY", awayMessage)
+		}
+	}
+}
+
 // choose the correct vhost to display
 func (client *Client) getVHostNoMutex() string {
 	// hostserv vhost OR operclass vhost OR nothing (i.e., normal rdns hostmask)
@@ -1057,6 +1166,16 @@ func (client *Client) getVHostNoMutex() string {
 	}
 }
 
+// setUserHost overrides the client's username and vhost (oper CHGHOST);
+// the vhost lasts until the next HostServ vhost change.
+func (client *Client) setUserHost(username, vhost string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.username = username
+	client.vhost = vhost
+	client.updateNickMaskNoMutex()
+}
+
 // SetVHost updates the client's hostserv-based vhost
 func (client *Client) SetVHost(vhost string) (updated bool) {
 	client.stateMutex.Lock()
@@ -1347,7 +1466,7 @@ func (client *Client) destroy(session *Session) {
 
 	// alert monitors
 	if registered {
-		client.server.monitorManager.AlertAbout(details.nick, details.nickCasefolded, false)
+		client.server.monitorManager.AlertAbout(details.nick, details.nickCasefolded, details.username, details.hostname, false)
 	}
 
 	// clean up channels
@@ -1366,6 +1485,9 @@ func (client *Client) destroy(session *Session) {
 	client.server.clients.Remove(client)
 
 	// clean up self
+	if record := client.Impersonation(); record != nil {
+		client.server.logger.Warning("opers", fmt.Sprintf("Oper %s (client %s) stopped impersonating account %s by disconnecting", record.oper, details.nickMask, record.account))
+	}
 	client.server.accounts.Logout(client)
 
 	if quitMessage == "" {
@@ -1666,6 +1788,14 @@ func (client *Client) checkLoginThrottle() (throttled bool, remainingTime time.D
 	return client.loginThrottle.Touch()
 }
 
+// refundLoginThrottle undoes a checkLoginThrottle, for failures that
+// weren't the client's fault
+func (client *Client) refundLoginThrottle() {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.loginThrottle.Refund()
+}
+
 func (client *Client) historyStatus(config *Config) (status HistoryStatus, target string) {
 	if !config.History.Enabled {
 		return HistoryDisabled, ""
@@ -87,7 +87,7 @@ func (clients *ClientManager) Remove(client *Client) error {
 func (clients *ClientManager) SetNick(client *Client, session *Session, newNick string, dryRun bool) (setNick string, err error, returnedFromAway bool) {
 	config := client.server.Config()
 
-	var newCfNick, newSkeleton string
+	var newCfNick, newSkeleton, recognizedAccount string
 
 	client.stateMutex.RLock()
 	account := client.account
@@ -167,7 +167,11 @@ func (clients *ClientManager) SetNick(client *Client, session *Session, newNick
 
 		reservedAccount, method := client.server.accounts.EnforcementStatus(newCfNick, newSkeleton)
 		if method == NickEnforcementStrict && reservedAccount != "" && reservedAccount != account {
-			return "", errNicknameReserved, false
+			if account == "" && client.server.accounts.IsRecognized(client, reservedAccount) {
+				recognizedAccount = reservedAccount
+			} else {
+				return "", errNicknameReserved, false
+			}
 		}
 	}
 
@@ -240,6 +244,7 @@ func (clients *ClientManager) SetNick(client *Client, session *Session, newNick
 	clients.removeInternal(client, formercfnick, formerskeleton)
 	clients.byNick[newCfNick] = client
 	clients.bySkeleton[newSkeleton] = client
+	client.setRecognizedAccount(recognizedAccount)
 	return newNick, nil, false
 }
 
@@ -6,6 +6,7 @@ package irc
 import (
 	"testing"
 
+	"github.com/ergochat/ergo/irc/languages"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -88,3 +89,45 @@ func TestWhoFields(t *testing.T) {
 		t.Error("failed to set and get")
 	}
 }
+
+func TestPreAway(t *testing.T) {
+	var config Config
+	config.Accounts.Multiclient.AutoAway = PersistentMandatory
+	config.languageManager, _ = languages.NewManager(false, "", "")
+	server := &Server{}
+	server.SetConfig(&config)
+
+	// an always-on client with no attached sessions is auto-away
+	client := &Client{server: server, registered: true, alwaysOn: true}
+	client.setAutoAwayNoMutex(&config)
+	disconnected := client.AwayMessage()
+	if disconnected == "" {
+		t.Fatal("client with no sessions should be away")
+	}
+
+	// a session that sent `AWAY *` before registration does not bring us back
+	bouncer := &Session{awayMessage: preAwayMessage}
+	_, _, _, back := client.AddSession(bouncer)
+	assertEqual(back, false, t)
+	assertEqual(client.AwayMessage(), disconnected, t)
+
+	// an ordinary session does
+	phone := &Session{}
+	_, _, _, back = client.AddSession(phone)
+	assertEqual(back, true, t)
+	assertEqual(client.AwayMessage(), "", t)
+
+	// the pre-away session doesn't contribute an away message
+	wasAway, nowAway := phone.SetAway("gone fishing")
+	assertEqual(wasAway, "", t)
+	assertEqual(nowAway, "gone fishing", t)
+
+	// until it explicitly sets one
+	wasAway, nowAway = bouncer.SetAway("brb")
+	assertEqual(wasAway, "gone fishing", t)
+	assertEqual(nowAway, "brb", t)
+
+	wasAway, nowAway = bouncer.SetAway("")
+	assertEqual(wasAway, "brb", t)
+	assertEqual(nowAway, "", t)
+}
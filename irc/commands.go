@@ -75,6 +75,10 @@ var Commands map[string]Command
 
 func init() {
 	Commands = map[string]Command{
+		"ADMIN": {
+			handler:   adminHandler,
+			minParams: 0,
+		},
 		"AMBIANCE": {
 			handler:   sceneHandler,
 			minParams: 2,
@@ -85,8 +89,9 @@ func init() {
 			minParams:    1,
 		},
 		"AWAY": {
-			handler:   awayHandler,
-			minParams: 0,
+			handler:      awayHandler,
+			usablePreReg: true,
+			minParams:    0,
 		},
 		"BATCH": {
 			handler:        batchHandler,
@@ -98,10 +103,29 @@ func init() {
 			usablePreReg: true,
 			minParams:    1,
 		},
+		"CHALLENGE": {
+			handler:   challengeHandler,
+			minParams: 1,
+		},
 		"CHATHISTORY": {
 			handler:   chathistoryHandler,
 			minParams: 4,
 		},
+		"CHGHOST": {
+			handler:   chghostHandler,
+			minParams: 3,
+			capabs:    []string{"vhosts"},
+		},
+		"CLONES": {
+			handler:   clonesHandler,
+			minParams: 0,
+			capabs:    []string{"ban"},
+		},
+		"CONNLIMIT": {
+			handler:   connlimitHandler,
+			minParams: 1,
+			capabs:    []string{"ban"},
+		},
 		"DEBUG": {
 			handler:   debugHandler,
 			minParams: 1,
@@ -124,6 +148,11 @@ func init() {
 			handler:   extjwtHandler,
 			minParams: 1,
 		},
+		"GLINE": {
+			handler:   klineHandler,
+			minParams: 1,
+			capabs:    []string{"ban"},
+		},
 		"HELP": {
 			handler:   helpHandler,
 			minParams: 0,
@@ -170,6 +199,10 @@ func init() {
 			usablePreReg: true,
 			minParams:    1,
 		},
+		"LINKS": {
+			handler:   linksHandler,
+			minParams: 0,
+		},
 		"LIST": {
 			handler:   listHandler,
 			minParams: 0,
@@ -178,6 +211,10 @@ func init() {
 			handler:   lusersHandler,
 			minParams: 0,
 		},
+		"METADATA": {
+			handler:   metadataHandler,
+			minParams: 2,
+		},
 		"MODE": {
 			handler:   modeHandler,
 			minParams: 1,
@@ -276,6 +313,9 @@ func init() {
 			handler:   setnameHandler,
 			minParams: 1,
 		},
+		"SILENCE": {
+			handler: silenceHandler,
+		},
 		"SUMMON": {
 			handler: summonHandler,
 		},
@@ -293,6 +333,16 @@ func init() {
 			minParams: 0,
 			capabs:    []string{"rehash"},
 		},
+		"TEMPBAN": {
+			handler:   tempbanHandler,
+			minParams: 2,
+			capabs:    []string{"ban"},
+		},
+		"TESTLINE": {
+			handler:   testlineHandler,
+			minParams: 1,
+			capabs:    []string{"ban"},
+		},
 		"TIME": {
 			handler:   timeHandler,
 			minParams: 0,
@@ -311,6 +361,11 @@ func init() {
 			minParams: 1,
 			capabs:    []string{"ban"},
 		},
+		"UNGLINE": {
+			handler:   unKLineHandler,
+			minParams: 1,
+			capabs:    []string{"ban"},
+		},
 		"UNINVITE": {
 			handler:   inviteHandler,
 			minParams: 2,
@@ -329,6 +384,10 @@ func init() {
 			handler:   userhostHandler,
 			minParams: 1,
 		},
+		"USERIP": {
+			handler:   useripHandler,
+			minParams: 1,
+		},
 		"USERS": {
 			handler: usersHandler,
 		},
@@ -341,6 +400,10 @@ func init() {
 			handler:   versionHandler,
 			minParams: 0,
 		},
+		"WATCH": {
+			handler:   watchHandler,
+			minParams: 0,
+		},
 		"WEBIRC": {
 			handler:      webircHandler,
 			usablePreReg: true,
@@ -7,6 +7,7 @@ package irc
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -22,6 +23,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"code.cloudfoundry.org/bytefmt"
 	"github.com/ergochat/irc-go/ircfmt"
@@ -35,11 +38,14 @@ import (
 	"github.com/ergochat/ergo/irc/isupport"
 	"github.com/ergochat/ergo/irc/jwt"
 	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/ldap"
 	"github.com/ergochat/ergo/irc/logger"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/mysql"
+	"github.com/ergochat/ergo/irc/oauth2"
 	"github.com/ergochat/ergo/irc/passwd"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webhooks"
 )
 
 // here's how this works: exported (capitalized) members of the config structs
@@ -283,10 +289,55 @@ type throttleConfig struct {
 	MaxAttempts int `yaml:"max-attempts"`
 }
 
+// LoginLockoutConfig controls per-account lockout after repeated login failures.
+type LoginLockoutConfig struct {
+	Enabled     bool
+	MaxAttempts int `yaml:"max-attempts"`
+	Window      time.Duration
+	Duration    time.Duration
+}
+
+// JoinThrottleConfig controls the protection a channel receives when its
+// join throttle (channel mode +j) is exceeded.
+type JoinThrottleConfig struct {
+	ProtectionMode string `yaml:"protection-mode"`
+	protectionMode modes.Mode
+	Cooldown       time.Duration
+}
+
+type FloodProtectionConfig struct {
+	// how much CTCPs (other than ACTION) and TAGMSGs count against
+	// the flood limit, relative to a regular message:
+	CTCPWeight   *float64 `yaml:"ctcp-weight"`
+	ctcpWeight   float64
+	TagmsgWeight *float64 `yaml:"tagmsg-weight"`
+	tagmsgWeight float64
+	ExemptOpers  *bool `yaml:"exempt-opers"`
+	exemptOpers  bool
+	ExemptAmodes *bool `yaml:"exempt-amodes"`
+	exemptAmodes bool
+	KickReason   string `yaml:"kick-reason"`
+}
+
 type ThrottleConfig struct {
 	throttleConfig
 }
 
+// LoggingConfig is the `logging` section: the log outputs, and the message log.
+// For compatibility, the section can also be just the list of outputs.
+type LoggingConfig struct {
+	Outputs    []logger.LoggingConfig
+	MessageLog MessageLogConfig `yaml:"message-log"`
+}
+
+func (lc *LoggingConfig) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	if err = unmarshal(&lc.Outputs); err == nil {
+		return
+	}
+	type plainLoggingConfig LoggingConfig
+	return unmarshal((*plainLoggingConfig)(lc))
+}
+
 func (t *ThrottleConfig) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
 	// note that this technique only works if the zero value of the struct
 	// doesn't need any postprocessing (because if the field is omitted entirely
@@ -311,9 +362,13 @@ type AccountConfig struct {
 	} `yaml:"require-sasl"`
 	DefaultUserModes    *string `yaml:"default-user-modes"`
 	defaultUserModes    modes.Modes
-	LoginThrottling     ThrottleConfig `yaml:"login-throttling"`
-	SkipServerPassword  bool           `yaml:"skip-server-password"`
-	LoginViaPassCommand bool           `yaml:"login-via-pass-command"`
+	LoginThrottling     ThrottleConfig     `yaml:"login-throttling"`
+	MaxIgnores          int                `yaml:"max-ignores"`
+	LoginLockout        LoginLockoutConfig `yaml:"login-lockout"`
+	SkipServerPassword  bool               `yaml:"skip-server-password"`
+	LoginViaPassCommand bool               `yaml:"login-via-pass-command"`
+	RehashOnLogin       *bool              `yaml:"rehash-on-login"`
+	rehashOnLogin       bool
 	NickReservation     struct {
 		Enabled                bool
 		AdditionalNickLimit    int `yaml:"additional-nick-limit"`
@@ -327,11 +382,19 @@ type AccountConfig struct {
 		ForceGuestFormat       bool `yaml:"force-guest-format"`
 		ForceNickEqualsAccount bool `yaml:"force-nick-equals-account"`
 		ForbidAnonNickChanges  bool `yaml:"forbid-anonymous-nick-changes"`
+		// NS ACCESS: recognition of unauthenticated users by user@host
+		Access struct {
+			Enabled  bool
+			MaxMasks int `yaml:"max-masks"`
+		}
 	} `yaml:"nick-reservation"`
-	Multiclient MulticlientConfig
-	Bouncer     *MulticlientConfig // # handle old name for 'multiclient'
-	VHosts      VHostConfig
-	AuthScript  AuthScriptConfig `yaml:"auth-script"`
+	Multiclient  MulticlientConfig
+	Bouncer      *MulticlientConfig // # handle old name for 'multiclient'
+	VHosts       VHostConfig
+	AuthScript   AuthScriptConfig   `yaml:"auth-script"`
+	AuthExternal AuthExternalConfig `yaml:"auth-external"`
+	LDAP         ldap.ServerConfig
+	Webhooks     []webhooks.Config
 }
 
 type ScriptConfig struct {
@@ -348,6 +411,14 @@ type AuthScriptConfig struct {
 	Autocreate   bool
 }
 
+// AuthExternalConfig controls authentication against external identity providers
+type AuthExternalConfig struct {
+	// should we automatically create (verified) accounts for new users?
+	Autocreate bool                      `yaml:"auto-create"`
+	OAuth2     oauth2.OAuth2BearerConfig `yaml:"oauth2"`
+	Endpoint   AuthEndpointConfig
+}
+
 type IPCheckScriptConfig struct {
 	ScriptConfig `yaml:",inline"`
 	ExemptSASL   bool `yaml:"exempt-sasl"`
@@ -365,8 +436,140 @@ type AccountRegistrationConfig struct {
 	LegacyCallbacks        struct {
 		Mailto email.MailtoConfig
 	} `yaml:"callbacks"`
-	VerifyTimeout custime.Duration `yaml:"verify-timeout"`
-	BcryptCost    uint             `yaml:"bcrypt-cost"`
+	VerifyTimeout custime.Duration  `yaml:"verify-timeout"`
+	BcryptCost    uint              `yaml:"bcrypt-cost"`
+	NamePolicy    AccountNamePolicy `yaml:"name-policy"`
+	// create accounts for unknown certfps presented via SASL EXTERNAL
+	AutoRegisterCertfp bool           `yaml:"auto-register-certfp"`
+	PasswordPolicy     PasswordPolicy `yaml:"password-policy"`
+}
+
+// PasswordPolicy sets minimum requirements for account passwords chosen by users.
+type PasswordPolicy struct {
+	MinLength        int  `yaml:"min-length"`
+	RequireUppercase bool `yaml:"require-uppercase"`
+	RequireDigit     bool `yaml:"require-digit"`
+	RequireSymbol    bool `yaml:"require-symbol"`
+}
+
+type passwordRequirement uint
+
+const (
+	passwordMinLength passwordRequirement = iota
+	passwordUppercase
+	passwordDigit
+	passwordSymbol
+)
+
+// passwordPolicyError lists the requirements that a password failed
+type passwordPolicyError struct {
+	minLength int
+	failed    []passwordRequirement
+}
+
+func (err *passwordPolicyError) describe(translate func(string) string) string {
+	descriptions := make([]string, len(err.failed))
+	for i, requirement := range err.failed {
+		switch requirement {
+		case passwordMinLength:
+			descriptions[i] = fmt.Sprintf(translate("at least %d characters"), err.minLength)
+		case passwordUppercase:
+			descriptions[i] = translate("an uppercase letter")
+		case passwordDigit:
+			descriptions[i] = translate("a digit")
+		case passwordSymbol:
+			descriptions[i] = translate("a symbol or punctuation character")
+		}
+	}
+	return fmt.Sprintf(translate("Password does not meet the requirements; it must contain: %s"), strings.Join(descriptions, ", "))
+}
+
+func (err *passwordPolicyError) Error() string {
+	return err.describe(func(s string) string { return s })
+}
+
+// Check returns a *passwordPolicyError listing every requirement the
+// passphrase fails, or nil.
+func (policy *PasswordPolicy) Check(passphrase string) error {
+	var hasUpper, hasDigit, hasSymbol bool
+	for _, r := range passphrase {
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+		hasSymbol = hasSymbol || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+	var failed []passwordRequirement
+	if utf8.RuneCountInString(passphrase) < policy.MinLength {
+		failed = append(failed, passwordMinLength)
+	}
+	if policy.RequireUppercase && !hasUpper {
+		failed = append(failed, passwordUppercase)
+	}
+	if policy.RequireDigit && !hasDigit {
+		failed = append(failed, passwordDigit)
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		failed = append(failed, passwordSymbol)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &passwordPolicyError{minLength: policy.MinLength, failed: failed}
+}
+
+// AccountNamePolicy restricts the names of newly registered accounts,
+// independently of the rules for nicknames.
+type AccountNamePolicy struct {
+	Enabled bool
+	// contents of a regexp character class, e.g., `a-z0-9_-`
+	AllowedCharacters string   `yaml:"allowed-characters"`
+	MinLength         int      `yaml:"min-length"`
+	MaxLength         int      `yaml:"max-length"`
+	ReservedPrefixes  []string `yaml:"reserved-prefixes"`
+	allowedRegexp     *regexp.Regexp
+}
+
+func (policy *AccountNamePolicy) postprocess() (err error) {
+	if !policy.Enabled {
+		return nil
+	}
+	if policy.AllowedCharacters != "" {
+		policy.allowedRegexp, err = regexp.Compile(fmt.Sprintf("^[%s]*$", policy.AllowedCharacters))
+		if err != nil {
+			return fmt.Errorf("invalid allowed-characters: %w", err)
+		}
+	}
+	if policy.MaxLength != 0 && policy.MaxLength < policy.MinLength {
+		return fmt.Errorf("max-length must not be less than min-length")
+	}
+	for i, prefix := range policy.ReservedPrefixes {
+		policy.ReservedPrefixes[i] = strings.ToLower(prefix)
+	}
+	return nil
+}
+
+// Check validates a proposed account name (as given, not casefolded)
+// and returns an error naming the violated rule, if any.
+func (policy *AccountNamePolicy) Check(name string) error {
+	if !policy.Enabled {
+		return nil
+	}
+	length := utf8.RuneCountInString(name)
+	if length < policy.MinLength {
+		return errAccountNameTooShort
+	}
+	if policy.MaxLength != 0 && policy.MaxLength < length {
+		return errAccountNameTooLong
+	}
+	if policy.allowedRegexp != nil && !policy.allowedRegexp.MatchString(name) {
+		return errAccountNameForbiddenCharacters
+	}
+	lowered := strings.ToLower(name)
+	for _, prefix := range policy.ReservedPrefixes {
+		if strings.HasPrefix(lowered, prefix) {
+			return errAccountNameReservedPrefix
+		}
+	}
+	return nil
 }
 
 type VHostConfig struct {
@@ -374,6 +577,10 @@ type VHostConfig struct {
 	MaxLength      int    `yaml:"max-length"`
 	ValidRegexpRaw string `yaml:"valid-regexp"`
 	validRegexp    *regexp.Regexp
+	UserRequests   struct {
+		Enabled  bool
+		Cooldown custime.Duration
+	} `yaml:"user-requests"`
 }
 
 type NickEnforcementMethod int
@@ -473,9 +680,11 @@ type OperConfig struct {
 	Password    string
 	Fingerprint *string // legacy name for certfp, #1050
 	Certfp      string
+	PublicKey   string `yaml:"public-key"`
 	Auto        bool
 	Hidden      bool
 	Modes       string
+	Account     string
 }
 
 // Various server-enforced limits on data size.
@@ -486,6 +695,7 @@ type Limits struct {
 	IdentLen             int `yaml:"identlen"`
 	KickLen              int `yaml:"kicklen"`
 	MonitorEntries       int `yaml:"monitor-entries"`
+	WatchEntries         int `yaml:"watch-entries"`
 	NickLen              int `yaml:"nicklen"`
 	TopicLen             int `yaml:"topiclen"`
 	WhowasEntries        int `yaml:"whowas-entries"`
@@ -496,6 +706,17 @@ type Limits struct {
 	}
 }
 
+// MetadataConfig controls the draft/metadata-2 capability.
+type MetadataConfig struct {
+	Enabled       bool
+	MaxSubs       int      `yaml:"max-subs"`
+	MaxKeys       int      `yaml:"max-keys"`
+	MaxValueBytes int      `yaml:"max-value-bytes"`
+	AllowedKeys   []string `yaml:"allowed-keys"`
+	allowedKeys   utils.StringSet
+	SetThrottle   ThrottleConfig `yaml:"set-throttle"`
+}
+
 // STSConfig controls the STS configuration/
 type STSConfig struct {
 	Enabled       bool
@@ -548,10 +769,16 @@ type Config struct {
 		passwordBytes  []byte
 		Name           string
 		nameCasefolded string
-		Listeners      map[string]listenerConfigBlock
-		UnixBindMode   os.FileMode        `yaml:"unix-bind-mode"`
-		TorListeners   TorListenersConfig `yaml:"tor-listeners"`
-		WebSockets     struct {
+		Contact        string
+		Admin          struct {
+			Name  string
+			Nick  string
+			Email string
+		}
+		Listeners    map[string]listenerConfigBlock
+		UnixBindMode os.FileMode        `yaml:"unix-bind-mode"`
+		TorListeners TorListenersConfig `yaml:"tor-listeners"`
+		WebSockets   struct {
 			AllowedOrigins       []string `yaml:"allowed-origins"`
 			allowedOriginRegexps []*regexp.Regexp
 		}
@@ -585,6 +812,7 @@ type Config struct {
 		}
 		isupport                 isupport.List
 		IPLimits                 connection_limits.LimiterConfig `yaml:"ip-limits"`
+		ConnectionThrottle       connection_limits.BackoffConfig `yaml:"connection-throttle"`
 		Cloaks                   cloaks.CloakConfig              `yaml:"ip-cloaking"`
 		SecureNetDefs            []string                        `yaml:"secure-nets"`
 		secureNets               []net.IPNet
@@ -638,22 +866,46 @@ type Config struct {
 		OpOnlyCreation       bool `yaml:"operator-only-creation"`
 		Registration         struct {
 			Enabled               bool
-			OperatorOnly          bool `yaml:"operator-only"`
-			MaxChannelsPerAccount int  `yaml:"max-channels-per-account"`
+			OperatorOnly          bool             `yaml:"operator-only"`
+			MaxChannelsPerAccount int              `yaml:"max-channels-per-account"`
+			TransferCooldown      custime.Duration `yaml:"transfer-cooldown"`
+			TransferExpiration    custime.Duration `yaml:"transfer-expiration"`
 		}
 		ListDelay        time.Duration    `yaml:"list-delay"`
+		PurgeMessage     string           `yaml:"purge-message"`
+		RenameRedirect   custime.Duration `yaml:"rename-redirect"`
 		InviteExpiration custime.Duration `yaml:"invite-expiration"`
+		EntryMessage     struct {
+			MaxLength       int  `yaml:"max-length"`
+			StripFormatting bool `yaml:"strip-formatting"`
+			ExemptFounder   bool `yaml:"exempt-founder"`
+		} `yaml:"entry-message"`
+		Clear struct {
+			KickReason string        `yaml:"kick-reason"`
+			Cooldown   time.Duration `yaml:"cooldown"`
+		}
+		JoinThrottle    JoinThrottleConfig    `yaml:"join-throttle"`
+		FloodProtection FloodProtectionConfig `yaml:"flood-protection"`
 	}
 
 	OperClasses map[string]*OperClassConfig `yaml:"oper-classes"`
 
 	Opers map[string]*OperConfig
 
+	// policy for operator /KILL messages
+	Kill struct {
+		Template      string
+		MaxReasonLen  int  `yaml:"max-reason-length"`
+		RequireReason bool `yaml:"require-reason"`
+	}
+
+	Metadata MetadataConfig
+
 	// parsed operator definitions, unexported so they can't be defined
 	// directly in YAML:
 	operators map[string]*Oper
 
-	Logging []logger.LoggingConfig
+	Logging LoggingConfig
 
 	Debug struct {
 		RecoverFromErrors *bool `yaml:"recover-from-errors"`
@@ -686,6 +938,7 @@ type Config struct {
 			UnregisteredChannels bool             `yaml:"unregistered-channels"`
 			RegisteredChannels   PersistentStatus `yaml:"registered-channels"`
 			DirectMessages       PersistentStatus `yaml:"direct-messages"`
+			MigrateOnReregister  bool             `yaml:"migrate-on-reregister"`
 		}
 		Retention struct {
 			AllowIndividualDelete bool `yaml:"allow-individual-delete"`
@@ -696,6 +949,9 @@ type Config struct {
 			Whitelist []string
 			Blacklist []string
 		} `yaml:"tagmsg-storage"`
+		Export struct {
+			CompressionLevel int `yaml:"compression-level"`
+		}
 	}
 
 	Filename string
@@ -793,9 +1049,11 @@ type Oper struct {
 	Vhost     string
 	Pass      []byte
 	Certfp    string
+	PublicKey *rsa.PublicKey // for CHALLENGE
 	Auto      bool
 	Hidden    bool
 	Modes     []modes.ModeChange
+	Account   string // casefolded account linked to this oper block, if any
 }
 
 func (oper *Oper) HasRoleCapab(capab string) bool {
@@ -831,11 +1089,23 @@ func (conf *Config) Operators(oc map[string]*OperClass) (map[string]*Oper, error
 				return nil, fmt.Errorf("Oper %s has an invalid fingerprint: %s", oper.Name, err.Error())
 			}
 		}
+		if opConf.PublicKey != "" {
+			oper.PublicKey, err = parseOperPublicKey(opConf.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("Oper %s has an invalid public key: %s", oper.Name, err.Error())
+			}
+		}
 		oper.Auto = opConf.Auto
 		oper.Hidden = opConf.Hidden
+		if opConf.Account != "" {
+			oper.Account, err = CasefoldName(opConf.Account)
+			if err != nil {
+				return nil, fmt.Errorf("Oper %s has an invalid account name: %s", oper.Name, err.Error())
+			}
+		}
 
-		if oper.Pass == nil && oper.Certfp == "" {
-			return nil, fmt.Errorf("Oper %s has neither a password nor a fingerprint", name)
+		if oper.Pass == nil && oper.Certfp == "" && oper.PublicKey == nil {
+			return nil, fmt.Errorf("Oper %s has neither a password, a fingerprint, nor a public key", name)
 		}
 
 		oper.Vhost = opConf.Vhost
@@ -1154,6 +1424,15 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Server.MaxLineLen < DefaultMaxLineLen {
 		config.Server.MaxLineLen = DefaultMaxLineLen
 	}
+	if config.Server.ConnectionThrottle.BaseDelay <= 0 {
+		config.Server.ConnectionThrottle.BaseDelay = time.Second
+	}
+	if config.Server.ConnectionThrottle.MaxDelay < config.Server.ConnectionThrottle.BaseDelay {
+		config.Server.ConnectionThrottle.MaxDelay = time.Minute
+	}
+	if config.Server.ConnectionThrottle.TTL <= 0 {
+		config.Server.ConnectionThrottle.TTL = 10 * time.Minute
+	}
 	if config.Datastore.MySQL.Enabled {
 		if config.Limits.NickLen > mysql.MaxTargetLength || config.Limits.ChannelLen > mysql.MaxTargetLength {
 			return nil, fmt.Errorf("to use MySQL, nick and channel length limits must be %d or lower", mysql.MaxTargetLength)
@@ -1207,6 +1486,53 @@ func LoadConfig(filename string) (config *Config, err error) {
 	config.Server.capValues[caps.STS] = config.Server.STS.Value()
 
 	config.Server.lookupHostnames = utils.BoolDefaultTrue(config.Server.LookupHostnames)
+	config.Accounts.rehashOnLogin = utils.BoolDefaultTrue(config.Accounts.RehashOnLogin)
+	if config.Channels.EntryMessage.MaxLength <= 0 {
+		config.Channels.EntryMessage.MaxLength = 300
+	}
+	if config.Kill.Template == "" {
+		config.Kill.Template = "Killed ({oper} ({reason}))"
+	}
+	if config.Channels.PurgeMessage == "" {
+		config.Channels.PurgeMessage = errChannelPurged.Error()
+	}
+	if config.Channels.Clear.KickReason == "" {
+		config.Channels.Clear.KickReason = "Cleared by ChanServ"
+	}
+	if config.Channels.JoinThrottle.ProtectionMode == "" {
+		config.Channels.JoinThrottle.ProtectionMode = "i"
+	}
+	config.Channels.JoinThrottle.protectionMode, err = parseJoinThrottleProtection(config.Channels.JoinThrottle.ProtectionMode)
+	if err != nil {
+		return nil, err
+	}
+	if config.Channels.JoinThrottle.Cooldown <= 0 {
+		config.Channels.JoinThrottle.Cooldown = time.Minute
+	}
+	config.Channels.FloodProtection.ctcpWeight = 1
+	if config.Channels.FloodProtection.CTCPWeight != nil {
+		config.Channels.FloodProtection.ctcpWeight = *config.Channels.FloodProtection.CTCPWeight
+	}
+	config.Channels.FloodProtection.tagmsgWeight = 1
+	if config.Channels.FloodProtection.TagmsgWeight != nil {
+		config.Channels.FloodProtection.tagmsgWeight = *config.Channels.FloodProtection.TagmsgWeight
+	}
+	config.Channels.FloodProtection.exemptOpers = utils.BoolDefaultTrue(config.Channels.FloodProtection.ExemptOpers)
+	config.Channels.FloodProtection.exemptAmodes = utils.BoolDefaultTrue(config.Channels.FloodProtection.ExemptAmodes)
+	if config.Channels.FloodProtection.KickReason == "" {
+		config.Channels.FloodProtection.KickReason = "Flooding"
+	}
+	if config.Accounts.LoginLockout.Enabled {
+		if config.Accounts.LoginLockout.MaxAttempts <= 0 {
+			config.Accounts.LoginLockout.MaxAttempts = 10
+		}
+		if config.Accounts.LoginLockout.Window <= 0 {
+			config.Accounts.LoginLockout.Window = 10 * time.Minute
+		}
+		if config.Accounts.LoginLockout.Duration <= 0 {
+			config.Accounts.LoginLockout.Duration = 15 * time.Minute
+		}
+	}
 
 	// process webirc blocks
 	var newWebIRC []webircConfig
@@ -1224,6 +1550,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 	}
 	config.Server.WebIRC = newWebIRC
 
+	if config.Limits.WatchEntries == 0 {
+		config.Limits.WatchEntries = 128
+	}
+
 	if config.Limits.Multiline.MaxBytes <= 0 {
 		config.Server.supportedCaps.Disable(caps.Multiline)
 	} else {
@@ -1236,6 +1566,32 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Server.capValues[caps.Multiline] = multilineCapValue
 	}
 
+	if !config.Metadata.Enabled {
+		config.Server.supportedCaps.Disable(caps.Metadata)
+	} else {
+		if config.Metadata.MaxSubs <= 0 {
+			config.Metadata.MaxSubs = 100
+		}
+		if config.Metadata.MaxKeys <= 0 {
+			config.Metadata.MaxKeys = 100
+		}
+		if config.Metadata.MaxValueBytes <= 0 {
+			config.Metadata.MaxValueBytes = 300
+		}
+		if len(config.Metadata.AllowedKeys) != 0 {
+			config.Metadata.allowedKeys = make(utils.StringSet)
+			for _, key := range config.Metadata.AllowedKeys {
+				key = strings.ToLower(key)
+				if !metadataKeyValid(key) {
+					return nil, fmt.Errorf("invalid metadata key: %s", key)
+				}
+				config.Metadata.allowedKeys.Add(key)
+			}
+		}
+		config.Server.capValues[caps.Metadata] = fmt.Sprintf("max-subs=%d,max-keys=%d,max-value-bytes=%d",
+			config.Metadata.MaxSubs, config.Metadata.MaxKeys, config.Metadata.MaxValueBytes)
+	}
+
 	// handle legacy name 'bouncer' for 'multiclient' section:
 	if config.Accounts.Bouncer != nil {
 		config.Accounts.Multiclient = *config.Accounts.Bouncer
@@ -1249,6 +1605,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 
 	if !config.Accounts.NickReservation.Enabled {
 		config.Accounts.NickReservation.ForceNickEqualsAccount = false
+		config.Accounts.NickReservation.Access.Enabled = false
+	}
+	if config.Accounts.NickReservation.Access.MaxMasks <= 0 {
+		config.Accounts.NickReservation.Access.MaxMasks = 5
 	}
 
 	if config.Accounts.NickReservation.ForceNickEqualsAccount && !config.Accounts.Multiclient.Enabled {
@@ -1269,7 +1629,7 @@ func LoadConfig(filename string) (config *Config, err error) {
 	}
 
 	var newLogConfigs []logger.LoggingConfig
-	for _, logConfig := range config.Logging {
+	for _, logConfig := range config.Logging.Outputs {
 		// methods
 		methods := make(map[string]bool)
 		for _, method := range strings.Split(logConfig.Method, " ") {
@@ -1312,7 +1672,11 @@ func LoadConfig(filename string) (config *Config, err error) {
 
 		newLogConfigs = append(newLogConfigs, logConfig)
 	}
-	config.Logging = newLogConfigs
+	config.Logging.Outputs = newLogConfigs
+
+	if config.Logging.MessageLog.Enabled && config.Logging.MessageLog.Filename == "" {
+		return nil, errors.New("logging.message-log is enabled but no filename is specified")
+	}
 
 	if config.Accounts.Registration.EmailVerification.Enabled {
 		err := config.Accounts.Registration.EmailVerification.Postprocess(config.Server.Name)
@@ -1393,6 +1757,25 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if !config.Accounts.AdvertiseSCRAM {
 		saslCapValue = "PLAIN,EXTERNAL"
 	}
+	err = webhooks.ValidateConfig(config.Accounts.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Accounts.LDAP.Postprocess()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ldap configuration: %w", err)
+	}
+	err = config.Accounts.AuthExternal.Endpoint.postprocess()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Accounts.AuthExternal.OAuth2.Postprocess()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid oauth2 configuration: %w", err)
+	}
+	if config.Accounts.AuthExternal.OAuth2.Enabled {
+		saslCapValue += ",OAUTHBEARER"
+	}
 	config.Server.capValues[caps.SASL] = saslCapValue
 	if !config.Accounts.AuthenticationEnabled {
 		config.Server.supportedCaps.Disable(caps.SASL)
@@ -1455,16 +1838,30 @@ func LoadConfig(filename string) (config *Config, err error) {
 	// parse default channel modes
 	config.Channels.defaultModes = ParseDefaultChannelModes(config.Channels.DefaultModes)
 
+	if err := config.Accounts.Registration.NamePolicy.postprocess(); err != nil {
+		return nil, fmt.Errorf("invalid accounts.registration.name-policy: %w", err)
+	}
+
 	if config.Accounts.Registration.BcryptCost == 0 {
 		config.Accounts.Registration.BcryptCost = passwd.DefaultCost
 	}
 
+	if config.Accounts.MaxIgnores == 0 {
+		config.Accounts.MaxIgnores = 64
+	}
+
 	if config.Channels.MaxChannelsPerClient == 0 {
 		config.Channels.MaxChannelsPerClient = 100
 	}
 	if config.Channels.Registration.MaxChannelsPerAccount == 0 {
 		config.Channels.Registration.MaxChannelsPerAccount = 15
 	}
+	if config.Channels.Registration.TransferCooldown == 0 {
+		config.Channels.Registration.TransferCooldown = custime.Duration(48 * time.Hour)
+	}
+	if config.Channels.Registration.TransferExpiration == 0 {
+		config.Channels.Registration.TransferExpiration = custime.Duration(7 * 24 * time.Hour)
+	}
 
 	config.Server.Compatibility.forceTrailing = utils.BoolDefaultTrue(config.Server.Compatibility.ForceTrailing)
 	config.Server.Compatibility.allowTruncation = utils.BoolDefaultTrue(config.Server.Compatibility.AllowTruncation)
@@ -1493,6 +1890,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, fmt.Errorf("You must configure a MySQL server in order to enable persistent history")
 	}
 
+	if !(0 <= config.History.Export.CompressionLevel && config.History.Export.CompressionLevel <= 9) {
+		return nil, fmt.Errorf("history.export.compression-level must be between 1 and 9 (or 0 for the default)")
+	}
+
 	if config.History.ZNCMax == 0 {
 		config.History.ZNCMax = config.History.ChathistoryMax
 	}
@@ -1581,12 +1982,12 @@ func (config *Config) generateISupport() (err error) {
 	}
 	isupport.Add("CHANNELLEN", strconv.Itoa(config.Limits.ChannelLen))
 	isupport.Add("CHANTYPES", chanTypes)
-	isupport.Add("ELIST", "U")
+	isupport.Add("ELIST", "CMNTU")
 	isupport.Add("EXCEPTS", "")
 	if config.Extjwt.Default.Enabled() || len(config.Extjwt.Services) != 0 {
 		isupport.Add("EXTJWT", "1")
 	}
-	isupport.Add("EXTBAN", ",m")
+	isupport.Add("EXTBAN", extbanPrefix+","+extbanTypes)
 	isupport.Add("FORWARD", "f")
 	isupport.Add("INVEX", "")
 	isupport.Add("KICKLEN", strconv.Itoa(config.Limits.KickLen))
@@ -1594,6 +1995,7 @@ func (config *Config) generateISupport() (err error) {
 	isupport.Add("MAXTARGETS", maxTargetsString)
 	isupport.Add("MODES", "")
 	isupport.Add("MONITOR", strconv.Itoa(config.Limits.MonitorEntries))
+	isupport.Add("WATCH", strconv.Itoa(config.Limits.WatchEntries))
 	isupport.Add("NETWORK", config.Network.Name)
 	isupport.Add("NICKLEN", strconv.Itoa(config.Limits.NickLen))
 	isupport.Add("PREFIX", "(qaohv)~&@%+")
@@ -1601,6 +2003,7 @@ func (config *Config) generateISupport() (err error) {
 		isupport.Add("RPCHAN", "E")
 		isupport.Add("RPUSER", "E")
 	}
+	isupport.Add("SILENCE", strconv.Itoa(config.Accounts.MaxIgnores))
 	isupport.Add("STATUSMSG", "~&@%+")
 	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:%d", maxTargetsString, maxTargetsString, maxTargetsString, config.Limits.MonitorEntries))
 	isupport.Add("TOPICLEN", strconv.Itoa(config.Limits.TopicLen))
@@ -1618,31 +2021,37 @@ func (config *Config) generateISupport() (err error) {
 
 // Diff returns changes in supported caps across a rehash.
 func (config *Config) Diff(oldConfig *Config) (addedCaps, removedCaps *caps.Set) {
-	addedCaps = caps.NewSet()
-	removedCaps = caps.NewSet()
 	if oldConfig == nil {
-		return
+		return caps.NewSet(), caps.NewSet()
 	}
 
-	if oldConfig.Server.capValues[caps.Languages] != config.Server.capValues[caps.Languages] {
-		// XXX updated caps get a DEL line and then a NEW line with the new value
-		addedCaps.Add(caps.Languages)
-		removedCaps.Add(caps.Languages)
-	}
+	// STS is handled separately, below
+	oldCaps, newCaps := oldConfig.Server.supportedCapsWithoutSTS, config.Server.supportedCapsWithoutSTS
+	addedCaps, removedCaps = newCaps.Diff(oldCaps)
 
-	if !oldConfig.Accounts.AuthenticationEnabled && config.Accounts.AuthenticationEnabled {
-		addedCaps.Add(caps.SASL)
-	} else if oldConfig.Accounts.AuthenticationEnabled && !config.Accounts.AuthenticationEnabled {
-		removedCaps.Add(caps.SASL)
+	// caps that remain supported, but whose values changed:
+	valueChanged := func(capab caps.Capability) {
+		if !(oldCaps.Has(capab) && newCaps.Has(capab)) {
+			return
+		}
+		if oldConfig.Server.capValues[capab] == config.Server.capValues[capab] {
+			return
+		}
+		if capab == caps.SASL {
+			// per the SASL 3.2 spec, a changed mechanism list is advertised
+			// with CAP NEW alone, without deleting the cap
+			addedCaps.Add(capab)
+		} else {
+			// XXX updated caps get a DEL line and then a NEW line with the new value
+			addedCaps.Add(capab)
+			removedCaps.Add(capab)
+		}
 	}
-
-	if oldConfig.Limits.Multiline.MaxBytes != 0 && config.Limits.Multiline.MaxBytes == 0 {
-		removedCaps.Add(caps.Multiline)
-	} else if oldConfig.Limits.Multiline.MaxBytes == 0 && config.Limits.Multiline.MaxBytes != 0 {
-		addedCaps.Add(caps.Multiline)
-	} else if oldConfig.Limits.Multiline != config.Limits.Multiline {
-		removedCaps.Add(caps.Multiline)
-		addedCaps.Add(caps.Multiline)
+	for capab := range config.Server.capValues {
+		valueChanged(capab)
+	}
+	for capab := range oldConfig.Server.capValues {
+		valueChanged(capab)
 	}
 
 	if oldConfig.Server.STS.Enabled != config.Server.STS.Enabled || oldConfig.Server.capValues[caps.STS] != config.Server.capValues[caps.STS] {
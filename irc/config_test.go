@@ -6,6 +6,10 @@ package irc
 import (
 	"reflect"
 	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ergochat/ergo/irc/caps"
 )
 
 func TestEnvironmentOverrides(t *testing.T) {
@@ -99,3 +103,138 @@ func TestEnvironmentOverrideErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestAccountNamePolicy(t *testing.T) {
+	policy := AccountNamePolicy{
+		Enabled:           true,
+		AllowedCharacters: "a-z0-9_-",
+		MinLength:         3,
+		MaxLength:         10,
+		ReservedPrefixes:  []string{"Guest"},
+	}
+	if err := policy.postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]error{
+		"alice":        nil,
+		"a_b-9":        nil,
+		"al":           errAccountNameTooShort,
+		"alicealice11": errAccountNameTooLong,
+		"Alice":        errAccountNameForbiddenCharacters,
+		"ålice":        errAccountNameForbiddenCharacters,
+		"guest123":     errAccountNameReservedPrefix,
+	}
+	for name, expected := range cases {
+		if err := policy.Check(name); err != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, err)
+		}
+	}
+
+	policy.Enabled = false
+	if err := policy.Check("Ålice"); err != nil {
+		t.Errorf("disabled policy should accept everything, got %v", err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	failures := func(policy PasswordPolicy, passphrase string) (result []passwordRequirement) {
+		if err := policy.Check(passphrase); err != nil {
+			result = err.(*passwordPolicyError).failed
+		}
+		return
+	}
+
+	var empty PasswordPolicy
+	assertEqual(failures(empty, "a"), []passwordRequirement(nil), t)
+
+	minLength := PasswordPolicy{MinLength: 8}
+	assertEqual(failures(minLength, "1234567"), []passwordRequirement{passwordMinLength}, t)
+	assertEqual(failures(minLength, "12345678"), []passwordRequirement(nil), t)
+	// length is in characters, not bytes:
+	assertEqual(failures(minLength, "ééééééé"), []passwordRequirement{passwordMinLength}, t)
+
+	uppercase := PasswordPolicy{RequireUppercase: true}
+	assertEqual(failures(uppercase, "hunter2"), []passwordRequirement{passwordUppercase}, t)
+	assertEqual(failures(uppercase, "Hunter2"), []passwordRequirement(nil), t)
+	assertEqual(failures(uppercase, "écarté É"), []passwordRequirement(nil), t)
+
+	digit := PasswordPolicy{RequireDigit: true}
+	assertEqual(failures(digit, "hunter"), []passwordRequirement{passwordDigit}, t)
+	assertEqual(failures(digit, "0hunter"), []passwordRequirement(nil), t)
+
+	symbol := PasswordPolicy{RequireSymbol: true}
+	assertEqual(failures(symbol, "hunter2 hunter3"), []passwordRequirement{passwordSymbol}, t)
+	assertEqual(failures(symbol, "hunter2!"), []passwordRequirement(nil), t)
+	assertEqual(failures(symbol, "hunter2+"), []passwordRequirement(nil), t)
+
+	all := PasswordPolicy{MinLength: 10, RequireUppercase: true, RequireDigit: true, RequireSymbol: true}
+	assertEqual(failures(all, "hunter"), []passwordRequirement{passwordMinLength, passwordUppercase, passwordDigit, passwordSymbol}, t)
+	assertEqual(failures(all, "Hunter2!xyz"), []passwordRequirement(nil), t)
+	assertEqual(all.Check("hunter2").Error(), "Password does not meet the requirements; it must contain: at least 10 characters, an uppercase letter, a symbol or punctuation character", t)
+}
+
+func TestCapDiff(t *testing.T) {
+	makeConfig := func(disabled ...caps.Capability) *Config {
+		var config Config
+		config.Server.supportedCapsWithoutSTS = caps.NewCompleteSet()
+		config.Server.supportedCapsWithoutSTS.Disable(caps.STS)
+		config.Server.supportedCapsWithoutSTS.Disable(disabled...)
+		config.Server.capValues = make(caps.Values)
+		config.Server.capValues[caps.SASL] = "PLAIN,EXTERNAL"
+		config.Server.capValues[caps.Languages] = "1,en"
+		return &config
+	}
+
+	oldConfig := makeConfig(caps.Chathistory, caps.EventPlayback)
+	newConfig := makeConfig(caps.Relaymsg)
+	added, removed := newConfig.Diff(oldConfig)
+	assertEqual(*added, *caps.NewSet(caps.Chathistory, caps.EventPlayback), t)
+	assertEqual(*removed, *caps.NewSet(caps.Relaymsg), t)
+
+	// a changed value is sent as DEL followed by NEW, except for SASL,
+	// which only gets NEW
+	newConfig = makeConfig(caps.Chathistory, caps.EventPlayback)
+	newConfig.Server.capValues[caps.SASL] = "PLAIN,EXTERNAL,SCRAM-SHA-256"
+	newConfig.Server.capValues[caps.Languages] = "2,en,~es"
+	added, removed = newConfig.Diff(oldConfig)
+	assertEqual(*added, *caps.NewSet(caps.SASL, caps.Languages), t)
+	assertEqual(*removed, *caps.NewSet(caps.Languages), t)
+
+	// values of unsupported caps don't matter
+	newConfig = makeConfig(caps.Chathistory, caps.EventPlayback, caps.SASL)
+	newConfig.Server.capValues[caps.SASL] = "EXTERNAL"
+	added, removed = newConfig.Diff(oldConfig)
+	assertEqual(added.Empty(), true, t)
+	assertEqual(*removed, *caps.NewSet(caps.SASL), t)
+
+	added, removed = newConfig.Diff(nil)
+	assertEqual(added.Empty() && removed.Empty(), true, t)
+}
+
+func TestLoggingConfig(t *testing.T) {
+	var config LoggingConfig
+	err := yaml.Unmarshal([]byte(`
+outputs:
+    - method: stderr
+      type: "*"
+      level: debug
+message-log:
+    enabled: true
+    filename: messages.log
+`), &config)
+	assertEqual(err, nil, t)
+	assertEqual(len(config.Outputs), 1, t)
+	assertEqual(config.Outputs[0].Method, "stderr", t)
+	assertEqual(config.MessageLog, MessageLogConfig{Enabled: true, Filename: "messages.log"}, t)
+
+	// the traditional format is just the list of outputs
+	config = LoggingConfig{}
+	err = yaml.Unmarshal([]byte(`
+- method: stderr
+  type: "*"
+  level: debug
+`), &config)
+	assertEqual(err, nil, t)
+	assertEqual(len(config.Outputs), 1, t)
+	assertEqual(config.MessageLog.Enabled, false, t)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package connection_limits
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ergochat/ergo/irc/flatip"
+)
+
+// BackoffConfig controls exponential backoff for IPs that reconnect
+// too frequently: once an IP exceeds MaxPerMinute connections in a minute,
+// it is blocked for BaseDelay * 2^n (for the nth excess connection), up to
+// MaxDelay. Connections made while it is blocked are rejected.
+type BackoffConfig struct {
+	Enabled      bool
+	MaxPerMinute int           `yaml:"max-per-minute"`
+	BaseDelay    time.Duration `yaml:"base-delay"`
+	MaxDelay     time.Duration `yaml:"max-delay"`
+	// state for an IP is discarded after it has been idle for this long
+	TTL time.Duration `yaml:"ttl"`
+}
+
+const (
+	backoffWindow = time.Minute
+)
+
+type backoffState struct {
+	sync.Mutex
+	windowStart  time.Time
+	count        int // connections in the current window
+	excess       int // connections over the limit, across consecutive windows
+	blockedUntil time.Time
+	lastSeen     time.Time
+}
+
+// Backoff tracks recent connections per IP, for delaying reconnect floods.
+// It is owned by the server, so its state is preserved across listener
+// restarts and rehashes.
+type Backoff struct {
+	states      sync.Map // flatip.IP -> *backoffState
+	lastCleanup int64    // UnixNano, accessed atomically
+}
+
+// Delay records a connection from addr and returns how long the IP must
+// wait before connecting again; if this is nonzero, the connection
+// should be rejected.
+func (b *Backoff) Delay(config *BackoffConfig, addr flatip.IP, now time.Time) (delay time.Duration) {
+	if !config.Enabled || config.MaxPerMinute <= 0 {
+		return 0
+	}
+	b.maybeCleanup(config, now)
+
+	value, _ := b.states.LoadOrStore(addr, new(backoffState))
+	state := value.(*backoffState)
+	state.Lock()
+	defer state.Unlock()
+
+	if now.Sub(state.windowStart) >= backoffWindow {
+		// the backoff resets only after a full window under the limit
+		if state.count <= config.MaxPerMinute {
+			state.excess = 0
+		}
+		state.windowStart = now
+		state.count = 0
+	}
+	state.count++
+	state.lastSeen = now
+	// retrying while blocked doesn't extend the block
+	if now.Before(state.blockedUntil) {
+		return state.blockedUntil.Sub(now)
+	}
+	if state.count <= config.MaxPerMinute {
+		return 0
+	}
+	delay = backoffDelay(config.BaseDelay, config.MaxDelay, state.excess)
+	state.excess++
+	state.blockedUntil = now.Add(delay)
+	return delay
+}
+
+// backoffDelay computes base * 2^attempts, capped at max
+func backoffDelay(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if max <= delay || delay <= 0 {
+			return max
+		}
+	}
+	if max < delay {
+		return max
+	}
+	return delay
+}
+
+// maybeCleanup discards idle state, at most once per TTL
+func (b *Backoff) maybeCleanup(config *BackoffConfig, now time.Time) {
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = backoffWindow
+	}
+	last := atomic.LoadInt64(&b.lastCleanup)
+	if now.UnixNano()-last < int64(ttl) || !atomic.CompareAndSwapInt64(&b.lastCleanup, last, now.UnixNano()) {
+		return
+	}
+	b.states.Range(func(key, value interface{}) bool {
+		state := value.(*backoffState)
+		state.Lock()
+		expired := ttl <= now.Sub(state.lastSeen)
+		state.Unlock()
+		if expired {
+			b.states.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package connection_limits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	assertEqual(backoffDelay(time.Second, time.Minute, 0), time.Second, t)
+	assertEqual(backoffDelay(time.Second, time.Minute, 3), 8*time.Second, t)
+	assertEqual(backoffDelay(time.Second, time.Minute, 6), time.Minute, t)
+	assertEqual(backoffDelay(time.Second, time.Minute, 1000), time.Minute, t)
+}
+
+func TestBackoff(t *testing.T) {
+	config := BackoffConfig{
+		Enabled:      true,
+		MaxPerMinute: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Second,
+		TTL:          10 * time.Minute,
+	}
+	var b Backoff
+	ip := easyParseIP("8.8.8.8")
+	now := time.Now()
+
+	assertEqual(b.Delay(&config, ip, now), time.Duration(0), t)
+	assertEqual(b.Delay(&config, ip, now), time.Duration(0), t)
+	assertEqual(b.Delay(&config, ip, now), time.Second, t)
+	// still blocked:
+	assertEqual(b.Delay(&config, ip, now.Add(time.Second/2)), time.Second/2, t)
+	assertEqual(b.Delay(&config, ip, now.Add(time.Second)), 2*time.Second, t)
+	assertEqual(b.Delay(&config, easyParseIP("8.8.4.4"), now), time.Duration(0), t)
+
+	// still over the limit in the previous window, so the backoff continues
+	now = now.Add(time.Minute)
+	assertEqual(b.Delay(&config, ip, now), time.Duration(0), t)
+	assertEqual(b.Delay(&config, ip, now), time.Duration(0), t)
+	assertEqual(b.Delay(&config, ip, now), 4*time.Second, t)
+
+	// idle state expires
+	now = now.Add(time.Hour)
+	b.Delay(&config, easyParseIP("8.8.4.4"), now)
+	_, found := b.states.Load(ip)
+	assertEqual(found, false, t)
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -34,6 +35,12 @@ type customLimit struct {
 	nets          []flatip.IPNet
 }
 
+// customNet is a single net from a custom limit block
+type customNet struct {
+	net   flatip.IPNet
+	limit int // index into customLimits
+}
+
 type limiterKey struct {
 	maskedIP  flatip.IP
 	prefixLen uint8 // 0 for the fake nets we generate for custom limits
@@ -63,6 +70,8 @@ type LimiterConfig struct {
 
 	exemptedNets []flatip.IPNet
 	customLimits []customLimit
+	// all the nets from customLimits, most specific first
+	customNets []customNet
 }
 
 func (config *LimiterConfig) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
@@ -82,7 +91,13 @@ func (config *LimiterConfig) postprocess() (err error) {
 		config.exemptedNets[i] = flatip.FromNetIPNet(exempted)
 	}
 
-	for identifier, customLimitConf := range config.CustomLimits {
+	identifiers := make([]string, 0, len(config.CustomLimits))
+	for identifier := range config.CustomLimits {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	for _, identifier := range identifiers {
+		customLimitConf := config.CustomLimits[identifier]
 		nets := make([]flatip.IPNet, len(customLimitConf.Nets))
 		for i, netStr := range customLimitConf.Nets {
 			normalizedNet, err := flatip.ParseToNormalizedNet(netStr)
@@ -107,11 +122,23 @@ func (config *LimiterConfig) postprocess() (err error) {
 			customID:      identifier,
 			nets:          nets,
 		})
+		for _, net := range nets {
+			config.customNets = append(config.customNets, customNet{net: net, limit: len(config.customLimits) - 1})
+		}
 	}
+	// if nets from different blocks overlap, the most specific one applies
+	sort.SliceStable(config.customNets, func(i, j int) bool {
+		return config.customNets[i].net.PrefixLen > config.customNets[j].net.PrefixLen
+	})
 
 	return nil
 }
 
+// IsExempt returns whether addr is exempt from connection limits
+func (config *LimiterConfig) IsExempt(addr flatip.IP) bool {
+	return flatip.IPInNets(addr, config.exemptedNets)
+}
+
 // Limiter manages the automated client connection limits.
 type Limiter struct {
 	sync.Mutex
@@ -127,11 +154,10 @@ type Limiter struct {
 // addrToKey canonicalizes `addr` to a string key, and returns
 // the relevant connection limit and throttle max-per-window values
 func (cl *Limiter) addrToKey(addr flatip.IP) (key limiterKey, customID string, limit int, throttle int) {
-	for _, custom := range cl.config.customLimits {
-		for _, net := range custom.nets {
-			if net.Contains(addr) {
-				return limiterKey{maskedIP: custom.name, prefixLen: 0}, custom.customID, custom.maxConcurrent, custom.maxPerWindow
-			}
+	for _, customNet := range cl.config.customNets {
+		if customNet.net.Contains(addr) {
+			custom := &cl.config.customLimits[customNet.limit]
+			return limiterKey{maskedIP: custom.name, prefixLen: 0}, custom.customID, custom.maxConcurrent, custom.maxPerWindow
 		}
 	}
 
@@ -251,6 +277,41 @@ func (cl *Limiter) Status(addr flatip.IP) (netName string, status LimiterStatus)
 	return
 }
 
+// CustomLimitStatus is the current state of a custom-limits block
+type CustomLimitStatus struct {
+	Name         string
+	Nets         []string
+	Count        int
+	MaxCount     int
+	Throttle     int
+	MaxPerWindow int
+}
+
+// CustomLimitStatuses returns the current connection counts for each
+// custom-limits block, sorted by name
+func (cl *Limiter) CustomLimitStatuses() (result []CustomLimitStatus) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	result = make([]CustomLimitStatus, len(cl.config.customLimits))
+	for i, custom := range cl.config.customLimits {
+		key := limiterKey{maskedIP: custom.name, prefixLen: 0}
+		nets := make([]string, len(custom.nets))
+		for j, net := range custom.nets {
+			nets[j] = net.String()
+		}
+		result[i] = CustomLimitStatus{
+			Name:         custom.customID,
+			Nets:         nets,
+			Count:        cl.limiter[key],
+			MaxCount:     custom.maxConcurrent,
+			Throttle:     cl.throttler[key].Count,
+			MaxPerWindow: custom.maxPerWindow,
+		}
+	}
+	return
+}
+
 // ResetThrottle resets the throttle count for an IP
 func (cl *Limiter) ResetThrottle(addr flatip.IP) {
 	cl.Lock()
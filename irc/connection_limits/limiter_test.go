@@ -93,3 +93,34 @@ func TestLimits(t *testing.T) {
 		t.Errorf("ip should not be blocked, but %v", err)
 	}
 }
+
+func TestMostSpecificCustomLimit(t *testing.T) {
+	config := baseConfig
+	config.CustomLimits = map[string]CustomLimitConfig{
+		"a-broad": {
+			Nets:          []string{"10.0.0.0/8"},
+			MaxConcurrent: 16,
+		},
+		"z-narrow": {
+			Nets:          []string{"10.1.2.0/24"},
+			MaxConcurrent: 2,
+		},
+	}
+	config.postprocess()
+	var limiter Limiter
+	limiter.ApplyConfig(&config)
+
+	_, customID, maxConc, _ := limiter.addrToKey(easyParseIP("10.1.2.3"))
+	assertEqual(customID, "z-narrow", t)
+	assertEqual(maxConc, 2, t)
+	_, customID, maxConc, _ = limiter.addrToKey(easyParseIP("10.1.3.3"))
+	assertEqual(customID, "a-broad", t)
+	assertEqual(maxConc, 16, t)
+
+	limiter.AddClient(easyParseIP("10.1.2.3"))
+	statuses := limiter.CustomLimitStatuses()
+	assertEqual(len(statuses), 2, t)
+	assertEqual(statuses[1].Name, "z-narrow", t)
+	assertEqual(statuses[1].Count, 1, t)
+	assertEqual(statuses[0].Count, 0, t)
+}
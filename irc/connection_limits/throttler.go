@@ -29,6 +29,13 @@ func (g *GenericThrottle) Touch() (throttled bool, remainingTime time.Duration)
 	return g.touch(time.Now().UTC())
 }
 
+// Refund removes the record of the most recent event, if any
+func (g *GenericThrottle) Refund() {
+	if 0 < g.Count {
+		g.Count -= 1
+	}
+}
+
 func (g *GenericThrottle) touch(now time.Time) (throttled bool, remainingTime time.Duration) {
 	if g.Limit == 0 {
 		return // limit of 0 disables throttling
@@ -24,7 +24,7 @@ const (
 	// 'version' of the database schema
 	keySchemaVersion = "db.version"
 	// latest schema of the db
-	latestDbSchema = 22
+	latestDbSchema = 23
 
 	keyCloakSecret = "crypto.cloak_secret"
 )
@@ -1112,6 +1112,41 @@ func schemaChangeV21To22(config *Config, tx *buntdb.Tx) error {
 	return nil
 }
 
+// build a reverse index from accounts to the channels where they have amodes
+func schemaChangeV22To23(config *Config, tx *buntdb.Tx) error {
+	umodePrefix := "channel.accounttoumode "
+	accountToAmodes := make(map[string]map[string]modes.Mode)
+	tx.AscendGreaterOrEqual("", umodePrefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, umodePrefix) {
+			return false
+		}
+		channel := strings.TrimPrefix(key, umodePrefix)
+		var accountToUMode map[string]modes.Mode
+		err := json.Unmarshal([]byte(value), &accountToUMode)
+		if err != nil {
+			log.Printf("error (v22-23) processing amodes for %s: %v\n", channel, err)
+			return true
+		}
+		for account, mode := range accountToUMode {
+			if accountToAmodes[account] == nil {
+				accountToAmodes[account] = make(map[string]modes.Mode)
+			}
+			accountToAmodes[account][channel] = mode
+		}
+		return true
+	})
+
+	for account, amodes := range accountToAmodes {
+		b, err := json.Marshal(amodes)
+		if err != nil {
+			log.Printf("error (v22-23) serializing amode index for %s: %v\n", account, err)
+			continue
+		}
+		tx.Set("account.channelamodes "+account, string(b), nil)
+	}
+	return nil
+}
+
 func getSchemaChange(initialVersion int) (result SchemaChange, ok bool) {
 	for _, change := range allChanges {
 		if initialVersion == change.InitialVersion {
@@ -1227,4 +1262,9 @@ var allChanges = []SchemaChange{
 		TargetVersion:  22,
 		Changer:        schemaChangeV21To22,
 	},
+	{
+		InitialVersion: 22,
+		TargetVersion:  23,
+		Changer:        schemaChangeV22To23,
+	},
 }
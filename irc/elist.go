@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+var (
+	errInvalidElist = errors.New("invalid LIST filter")
+)
+
+// elistMatcher takes and matches ELIST conditions
+type elistMatcher struct {
+	MinClientsActive bool
+	MinClients       int
+	MaxClientsActive bool
+	MaxClients       int
+
+	// channel age (C) and topic age (T), in minutes
+	MinAgeActive      bool
+	MinAge            int
+	MaxAgeActive      bool
+	MaxAge            int
+	MinTopicAgeActive bool
+	MinTopicAge       int
+	MaxTopicAgeActive bool
+	MaxTopicAge       int
+
+	Masks        []*regexp.Regexp
+	NegatedMasks []*regexp.Regexp
+}
+
+// parseListParams parses the parameters of LIST into a list of channel names
+// and ELIST conditions; each parameter is a comma-separated list of channels
+// and conditions. It returns the first invalid condition in case of error.
+func parseListParams(params []string) (channels []string, matcher elistMatcher, invalid string, err error) {
+	for _, param := range params {
+		for _, token := range strings.Split(param, ",") {
+			if token == "" {
+				continue
+			}
+			if err = matcher.addCondition(token, &channels); err != nil {
+				return nil, matcher, token, err
+			}
+		}
+	}
+	return
+}
+
+func (matcher *elistMatcher) addCondition(token string, channels *[]string) (err error) {
+	// C<n, C>n, T<n, T>n: bounds on channel and topic age
+	if len(token) > 1 && strings.IndexByte("CcTt", token[0]) != -1 && (token[1] == '<' || token[1] == '>') {
+		minActive, min, maxActive, max := &matcher.MinAgeActive, &matcher.MinAge, &matcher.MaxAgeActive, &matcher.MaxAge
+		if token[0] == 'T' || token[0] == 't' {
+			minActive, min, maxActive, max = &matcher.MinTopicAgeActive, &matcher.MinTopicAge, &matcher.MaxTopicAgeActive, &matcher.MaxTopicAge
+		}
+		val, err := strconv.Atoi(token[2:])
+		if err != nil || val < 0 {
+			return errInvalidElist
+		}
+		if token[1] == '<' {
+			*maxActive, *max = true, val-1 // -1 because < means less than the given number
+		} else {
+			*minActive, *min = true, val+1 // +1 because > means more than the given number
+		}
+		return nil
+	}
+
+	switch token[0] {
+	case '<', '>':
+		val, err := strconv.Atoi(token[1:])
+		if err != nil || val < 0 {
+			return errInvalidElist
+		}
+		if token[0] == '<' {
+			matcher.MaxClientsActive = true
+			matcher.MaxClients = val - 1
+		} else {
+			matcher.MinClientsActive = true
+			matcher.MinClients = val + 1
+		}
+	case '!':
+		mask, err := compileElistMask(token[1:])
+		if err != nil {
+			return err
+		}
+		matcher.NegatedMasks = append(matcher.NegatedMasks, mask)
+	default:
+		if strings.ContainsAny(token, "*?") {
+			mask, err := compileElistMask(token)
+			if err != nil {
+				return err
+			}
+			matcher.Masks = append(matcher.Masks, mask)
+		} else if token[0] == '#' {
+			*channels = append(*channels, token)
+		} else {
+			return errInvalidElist
+		}
+	}
+	return nil
+}
+
+func compileElistMask(mask string) (result *regexp.Regexp, err error) {
+	if mask == "" {
+		return nil, errInvalidElist
+	}
+	folded, err := foldPermissive(mask)
+	if err != nil {
+		return nil, errInvalidElist
+	}
+	result, err = utils.CompileGlob(folded, false)
+	if err != nil {
+		return nil, errInvalidElist
+	}
+	return
+}
+
+// Matches checks whether the given channel matches our matches.
+func (matcher *elistMatcher) Matches(channel *Channel) bool {
+	channel.stateMutex.RLock()
+	members := len(channel.members)
+	cfname := channel.nameCasefolded
+	ctime := channel.createdTime
+	topicSetTime := channel.topicSetTime
+	channel.stateMutex.RUnlock()
+
+	return matcher.matches(members, cfname, ctime, topicSetTime, time.Now())
+}
+
+func (matcher *elistMatcher) matches(members int, cfname string, ctime, topicSetTime, now time.Time) bool {
+	if matcher.MinClientsActive && members < matcher.MinClients {
+		return false
+	}
+	if matcher.MaxClientsActive && members > matcher.MaxClients {
+		return false
+	}
+
+	age := int(now.Sub(ctime) / time.Minute)
+	if matcher.MinAgeActive && age < matcher.MinAge {
+		return false
+	}
+	if matcher.MaxAgeActive && age > matcher.MaxAge {
+		return false
+	}
+
+	if matcher.MinTopicAgeActive || matcher.MaxTopicAgeActive {
+		// channels without a topic have no topic age
+		if topicSetTime.IsZero() {
+			return false
+		}
+		topicAge := int(now.Sub(topicSetTime) / time.Minute)
+		if matcher.MinTopicAgeActive && topicAge < matcher.MinTopicAge {
+			return false
+		}
+		if matcher.MaxTopicAgeActive && topicAge > matcher.MaxTopicAge {
+			return false
+		}
+	}
+
+	if len(matcher.Masks) != 0 {
+		matched := false
+		for _, mask := range matcher.Masks {
+			if mask.MatchString(cfname) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, mask := range matcher.NegatedMasks {
+		if mask.MatchString(cfname) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseListParams(t *testing.T) {
+	channels, matcher, _, err := parseListParams([]string{"#a,>2,<10", "C>5,T<60,!*spam*"})
+	assertEqual(err, nil, t)
+	assertEqual(channels, []string{"#a"}, t)
+	assertEqual(matcher.MinClientsActive && matcher.MinClients == 3, true, t)
+	assertEqual(matcher.MaxClientsActive && matcher.MaxClients == 9, true, t)
+	assertEqual(matcher.MinAgeActive && matcher.MinAge == 6, true, t)
+	assertEqual(matcher.MaxTopicAgeActive && matcher.MaxTopicAge == 59, true, t)
+	assertEqual(len(matcher.NegatedMasks), 1, t)
+
+	for _, bad := range []string{">x", "C<", "T>-1", "foo", "!"} {
+		_, _, invalid, err := parseListParams([]string{bad})
+		assertEqual(err, errInvalidElist, t)
+		assertEqual(invalid, bad, t)
+	}
+}
+
+func TestElistMatches(t *testing.T) {
+	now := time.Now()
+	ctime := now.Add(-10 * time.Minute)
+	topicTime := now.Add(-2 * time.Minute)
+	match := func(params string) bool {
+		_, matcher, _, err := parseListParams([]string{params})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matcher.matches(5, "#ergo", ctime, topicTime, now)
+	}
+
+	assertEqual(match(">4"), true, t)
+	assertEqual(match(">5"), false, t)
+	assertEqual(match("<6"), true, t)
+	assertEqual(match("<5"), false, t)
+	assertEqual(match("C>5"), true, t)
+	assertEqual(match("C<5"), false, t)
+	assertEqual(match("T<5"), true, t)
+	assertEqual(match("T>5"), false, t)
+	assertEqual(match("#ERG*"), true, t)
+	assertEqual(match("#foo*,*go"), true, t)
+	assertEqual(match("#foo*"), false, t)
+	assertEqual(match("!*erg*"), false, t)
+
+	_, matcher, _, _ := parseListParams([]string{"T>0"})
+	assertEqual(matcher.matches(5, "#ergo", ctime, time.Time{}, now), false, t)
+}
@@ -23,6 +23,11 @@ var (
 	errAccountDoesNotExist            = errors.New("Account does not exist")
 	errAccountInvalidCredentials      = errors.New("Invalid account credentials")
 	errAccountBadPassphrase           = errors.New(`Passphrase contains forbidden characters or is otherwise invalid`)
+	errAccountNameTooShort            = errors.New(`Account name is shorter than the minimum length`)
+	errAccountNameTooLong             = errors.New(`Account name is longer than the maximum length`)
+	errAccountNameForbiddenCharacters = errors.New(`Account name contains characters that are not allowed`)
+	errAccountNameReservedPrefix      = errors.New(`Account name begins with a reserved prefix`)
+	errAccessMaskTooBroad             = errors.New(`Access mask is too broad`)
 	errAccountNickReservationFailed   = errors.New("Could not (un)reserve nick")
 	errAccountNotLoggedIn             = errors.New("You're not logged into an account")
 	errAccountAlreadyLoggedIn         = errors.New("You're already logged into an account")
@@ -33,10 +38,15 @@ var (
 	errAccountVerificationInvalidCode = errors.New("Invalid account verification code")
 	errAccountUpdateFailed            = errors.New(`Error while updating your account information`)
 	errAccountMustHoldNick            = errors.New(`You must hold that nickname in order to register it`)
+	errAccountMergeAlwaysOn           = errors.New(`Accounts with always-on clients can't be merged`)
+	errAccountMergeSelf               = errors.New(`Can't merge an account into itself`)
 	errAuthzidAuthcidMismatch         = errors.New(`authcid and authzid must be the same`)
+	errAuthServiceUnavailable         = errors.New(`Authentication service is temporarily unavailable`)
 	errCertfpAlreadyExists            = errors.New(`An account already exists for your certificate fingerprint`)
 	errChannelNotOwnedByAccount       = errors.New("Channel not owned by the specified account")
 	errChannelTransferNotOffered      = errors.New(`You weren't offered ownership of that channel`)
+	errChannelTransferCooldown        = errors.New(`The channel transfer can't be accepted yet`)
+	errChannelTransferExpired         = errors.New(`The channel transfer offer has expired`)
 	errChannelAlreadyRegistered       = errors.New("Channel is already registered")
 	errChannelNotRegistered           = errors.New("Channel is not registered")
 	errChannelNameInUse               = errors.New(`Channel name in use`)
@@ -51,13 +61,25 @@ var (
 	errNoExistingBan                  = errors.New("Ban does not exist")
 	errNoSuchChannel                  = errors.New(`No such channel`)
 	errChannelPurged                  = errors.New(`This channel was purged by the server operators and cannot be used`)
+	errChannelRenamed                 = errors.New(`This channel was renamed`)
 	errConfusableIdentifier           = errors.New("This identifier is confusable with one already in use")
 	errInsufficientPrivs              = errors.New("Insufficient privileges")
 	errInvalidUsername                = errors.New("Invalid username")
 	errFeatureDisabled                = errors.New(`That feature is disabled`)
 	errBanned                         = errors.New("IP or nickmask banned")
 	errInvalidParams                  = utils.ErrInvalidParams
+	errEntryMsgTooLong                = errors.New(`Entry message is too long`)
+	errNoSuchTemplate                 = errors.New(`No such template`)
+	errImpersonateAlwaysOn            = errors.New(`You can't impersonate from an always-on client`)
+	errAlreadyImpersonating           = errors.New(`You're already impersonating an account; use /NS IMPERSONATE END first`)
+	errNotImpersonating               = errors.New(`You're not impersonating an account`)
+	errTOTPRequired                   = errors.New(`Two-factor authentication code required; use /NS IDENTIFY <account> <password> --2fa <code>`)
+	errInvalidTOTP                    = errors.New(`Invalid two-factor authentication code`)
+	errTOTPAlreadyEnabled             = errors.New(`Two-factor authentication is already enabled`)
+	errTOTPNotEnabled                 = errors.New(`Two-factor authentication is not enabled`)
+	errTemplateExists                 = errors.New(`A template with that name already exists`)
 	errNoVhost                        = errors.New(`You do not have an approved vhost`)
+	errNoVhostRequest                 = errors.New(`No pending vhost request`)
 	errLimitExceeded                  = errors.New("Limit exceeded")
 	errNoop                           = errors.New("Action was a no-op")
 	errCASFailed                      = errors.New("Compare-and-swap update of database value failed")
@@ -98,3 +120,44 @@ type ThrottleError struct {
 func (te *ThrottleError) Error() string {
 	return fmt.Sprintf(`Please wait at least %v and try again`, te.Duration)
 }
+
+// AccountSuspendedError is returned for logins to a suspended account;
+// unlike errAccountSuspended, it carries the details of the suspension
+type AccountSuspendedError struct {
+	AccountSuspension
+}
+
+func (se *AccountSuspendedError) Error() string {
+	return errAccountSuspended.Error()
+}
+
+// Details describes the suspension to the affected user
+func (se *AccountSuspendedError) Details() string {
+	reason := se.Reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+	if se.Duration == 0 {
+		return fmt.Sprintf(`Account has been suspended indefinitely (reason: %s)`, reason)
+	}
+	return fmt.Sprintf(`Account has been suspended until %s (reason: %s)`, se.TimeCreated.Add(se.Duration).Format(time.RFC1123), reason)
+}
+
+// AccountLockedError is returned for logins to an account that has been
+// temporarily locked due to repeated authentication failures
+type AccountLockedError struct {
+	time.Duration
+}
+
+func (le *AccountLockedError) Error() string {
+	return fmt.Sprintf(`Account is locked due to too many failed login attempts; try again in %v`, le.Duration)
+}
+
+// akickError is returned when a join is refused due to a CS AKICK entry
+type akickError struct {
+	reason string
+}
+
+func (ae *akickError) Error() string {
+	return fmt.Sprintf("Auto-kicked from channel: %s", ae.reason)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// extended bans, which match on something other than the client's n!u@h:
+// $a:account matches clients logged into the account, $a: (or $a) matches
+// all logged-in clients, and $r:glob matches the client's realname.
+// they can be combined with the mute extban, e.g., m:$a:account.
+
+const (
+	extbanPrefix   = "$"
+	extbanAccount  = "$a:"
+	extbanRealname = "$r:"
+	// extban types, as advertised in ISUPPORT EXTBAN
+	extbanTypes = "amr"
+)
+
+func isExtban(mask string) bool {
+	return strings.HasPrefix(mask, extbanPrefix)
+}
+
+// CanonicalizeBanMask canonicalizes the argument of +b, +e, or +I,
+// which can be an extban or a n!u@h mask.
+func CanonicalizeBanMask(mask string) (result string, err error) {
+	mask = strings.TrimSpace(mask)
+	// accept the $-prefixed form of the mute extban, as advertised in EXTBAN
+	if strings.HasPrefix(mask, "$m:") {
+		mask = mask[1:]
+	}
+	if strings.HasPrefix(mask, "m:") && isExtban(mask[2:]) {
+		result, err = canonicalizeExtban(mask[2:])
+		return "m:" + result, err
+	} else if isExtban(mask) {
+		return canonicalizeExtban(mask)
+	}
+	return CanonicalizeMaskWildcard(mask)
+}
+
+func canonicalizeExtban(mask string) (result string, err error) {
+	if mask == "$a" || mask == extbanAccount {
+		return extbanAccount, nil
+	} else if strings.HasPrefix(mask, extbanAccount) {
+		account, err := CasefoldName(mask[len(extbanAccount):])
+		if err != nil {
+			return "", errInvalidParams
+		}
+		return extbanAccount + account, nil
+	} else if strings.HasPrefix(mask, extbanRealname) {
+		glob := strings.ToLower(mask[len(extbanRealname):])
+		if glob == "" || utils.SafeErrorParam(glob) != glob {
+			return "", errInvalidParams
+		}
+		return extbanRealname + glob, nil
+	}
+	return "", errInvalidParams
+}
+
+// extbanMatcher matches clients against a set of canonicalized extbans
+type extbanMatcher struct {
+	anyAccount bool
+	accounts   map[string]bool
+	realnames  *regexp.Regexp
+}
+
+func compileExtbans(masks []string) (result *extbanMatcher) {
+	if len(masks) == 0 {
+		return nil
+	}
+	result = new(extbanMatcher)
+	var realnameGlobs []string
+	for _, mask := range masks {
+		if mask == extbanAccount {
+			result.anyAccount = true
+		} else if strings.HasPrefix(mask, extbanAccount) {
+			if result.accounts == nil {
+				result.accounts = make(map[string]bool)
+			}
+			result.accounts[mask[len(extbanAccount):]] = true
+		} else if strings.HasPrefix(mask, extbanRealname) {
+			realnameGlobs = append(realnameGlobs, mask[len(extbanRealname):])
+		}
+	}
+	if len(realnameGlobs) != 0 {
+		result.realnames, _ = utils.CompileMasks(realnameGlobs)
+	}
+	return
+}
+
+// match takes the client's casefolded account name (or "") and realname
+func (m *extbanMatcher) match(account, realname string) bool {
+	if m == nil {
+		return false
+	}
+	if account != "" && (m.anyAccount || m.accounts[account]) {
+		return true
+	}
+	return m.realnames != nil && m.realnames.MatchString(strings.ToLower(realname))
+}
+
+// matchExtban matches a single canonicalized extban
+func matchExtban(mask, account, realname string) bool {
+	return compileExtbans([]string{mask}).match(account, realname)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestCanonicalizeBanMask(t *testing.T) {
+	for input, expected := range map[string]string{
+		"$a:Alice":          "$a:alice",
+		"$a":                "$a:",
+		"$a:":               "$a:",
+		"$r:*Spam?Bot*":     "$r:*spam?bot*",
+		"m:$a:Alice":        "m:$a:alice",
+		"$m:$r:*spam*":      "m:$r:*spam*",
+		"Bob!*@Example.com": "bob!*@example.com",
+	} {
+		result, err := CanonicalizeBanMask(input)
+		assertEqual(err, nil, t)
+		assertEqual(result, expected, t)
+	}
+	for _, invalid := range []string{"$r:", "$x:foo", "$a:a b"} {
+		_, err := CanonicalizeBanMask(invalid)
+		assertEqual(err, errInvalidParams, t)
+	}
+}
+
+func TestUserMaskSetExtbans(t *testing.T) {
+	set := NewUserMaskSet()
+	set.Add("$a:alice", "", "")
+	set.Add("$r:*spam*", "", "")
+	set.Add("m:$a:", "", "")
+
+	assertEqual(set.MatchClient("alice!u@h", "alice", "Alice"), true, t)
+	assertEqual(set.MatchClient("bob!u@h", "bob", "Bob"), false, t)
+	assertEqual(set.MatchClient("bob!u@h", "", "I post SPAM"), true, t)
+	assertEqual(set.Match("alice!u@h"), false, t)
+
+	assertEqual(set.MatchMuteClient("bob!u@h", "bob", "Bob"), true, t)
+	assertEqual(set.MatchMuteClient("bob!u@h", "", "Bob"), false, t)
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+	"github.com/ergochat/irc-go/ircutils"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// per-channel message flood protection, configured with CS SET FLOOD
+
+// FloodAction is what happens to a member who floods the channel
+type FloodAction uint
+
+const (
+	FloodActionMute FloodAction = iota
+	FloodActionKick
+	FloodActionKickban
+)
+
+func floodActionFromString(str string) (action FloodAction, err error) {
+	switch strings.ToLower(str) {
+	case "mute":
+		return FloodActionMute, nil
+	case "kick":
+		return FloodActionKick, nil
+	case "kickban":
+		return FloodActionKickban, nil
+	default:
+		return action, errInvalidParams
+	}
+}
+
+func (action FloodAction) String() string {
+	switch action {
+	case FloodActionKick:
+		return "kick"
+	case FloodActionKickban:
+		return "kickban"
+	default:
+		return "mute"
+	}
+}
+
+// FloodSettings allows each member to send Lines messages per Seconds
+// (with bursts of up to Lines messages); Lines == 0 disables flood protection.
+type FloodSettings struct {
+	Lines   int
+	Seconds int
+	Action  FloodAction
+}
+
+// ParseFloodSettings parses the argument of CS SET FLOOD, <lines>:<seconds>:<action>
+func ParseFloodSettings(str string) (result FloodSettings, err error) {
+	if strings.ToLower(str) == "off" {
+		return
+	}
+	fields := strings.Split(str, ":")
+	if len(fields) != 3 {
+		return result, errInvalidParams
+	}
+	result.Lines, err = strconv.Atoi(fields[0])
+	if err != nil || result.Lines <= 0 {
+		return FloodSettings{}, errInvalidParams
+	}
+	result.Seconds, err = strconv.Atoi(fields[1])
+	if err != nil || result.Seconds <= 0 {
+		return FloodSettings{}, errInvalidParams
+	}
+	result.Action, err = floodActionFromString(fields[2])
+	if err != nil {
+		return FloodSettings{}, err
+	}
+	return
+}
+
+func (fs FloodSettings) Enabled() bool {
+	return fs.Lines != 0
+}
+
+func (fs FloodSettings) String() string {
+	if !fs.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d:%d:%s", fs.Lines, fs.Seconds, fs.Action.String())
+}
+
+// floodBucket is a per-member token bucket; it is stored in the member's
+// memberData, so it goes away when they leave the channel.
+type floodBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes weight tokens from the bucket, returning false if it was
+// exhausted (in which case it is refilled, so the action is applied once).
+func (bucket *floodBucket) take(settings FloodSettings, weight float64, now time.Time) (ok bool) {
+	capacity := float64(settings.Lines)
+	if bucket.last.IsZero() {
+		bucket.tokens = capacity
+	} else {
+		rate := capacity / float64(settings.Seconds)
+		bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+		if bucket.tokens > capacity {
+			bucket.tokens = capacity
+		}
+	}
+	bucket.last = now
+
+	if bucket.tokens < weight {
+		bucket.tokens = capacity
+		return false
+	}
+	bucket.tokens -= weight
+	return true
+}
+
+// floodMessageWeight returns how much a message counts against the flood limit
+func floodMessageWeight(config *Config, histType history.ItemType, isCTCP bool) float64 {
+	if histType == history.Tagmsg {
+		return config.Channels.FloodProtection.tagmsgWeight
+	} else if isCTCP {
+		return config.Channels.FloodProtection.ctcpWeight
+	}
+	return 1
+}
+
+// checkFlood records a message from client, returning whether it exceeded
+// the channel's flood limit (and if so, what to do about it).
+func (channel *Channel) checkFlood(client *Client, weight float64) (flooded bool, action FloodAction) {
+	if weight <= 0 {
+		return
+	}
+	config := channel.server.Config().Channels.FloodProtection
+	if config.exemptOpers && client.Oper() != nil {
+		return
+	}
+	account := client.Account()
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	settings := channel.settings.Flood
+	if !settings.Enabled() {
+		return
+	}
+	if config.exemptAmodes && account != "" && channel.accountToUMode[account] != modes.Mode(0) {
+		return
+	}
+	memberData, ok := channel.members[client]
+	if !ok || memberData.flood == nil {
+		return
+	}
+	if memberData.flood.take(settings, weight, time.Now()) {
+		return
+	}
+	return true, settings.Action
+}
+
+// applyFloodAction mutes, kicks, or kickbans a member who flooded the channel,
+// on behalf of ChanServ.
+func (channel *Channel) applyFloodAction(client *Client, action FloodAction) {
+	config := channel.server.Config()
+	details := client.Details()
+
+	if action == FloodActionMute || action == FloodActionKickban {
+		mask := fmt.Sprintf("*!*@%s", strings.ToLower(details.hostname))
+		if action == FloodActionMute {
+			mask = "m:" + mask
+		}
+		mask, err := channel.lists[modes.BanMask].Add(mask, chanservService.prefix, "*")
+		if err == nil {
+			channel.MarkDirty(IncludeLists)
+			change := modes.ModeChange{Op: modes.Add, Mode: modes.BanMask, Arg: mask}
+			announceCmodeChanges(channel, modes.ModeChanges{change}, chanservService.prefix, "*", "", false, nil)
+		}
+	}
+	if action == FloodActionKick || action == FloodActionKickban {
+		channel.kickFromService(chanservService.prefix, client, config.Channels.FloodProtection.KickReason)
+	}
+
+	channel.noticeFloodExceeded(details.nick, action)
+	channel.server.snomasks.Send(sno.LocalFlood, fmt.Sprintf(ircfmt.Unescape("%[1]s$r exceeded the flood limit in channel $c[grey][$r%[2]s$c[grey]]; action taken: %[3]s"), details.nick, channel.Name(), action.String()))
+}
+
+// kickFromService removes a member from the channel with a KICK
+// from a service or the server, rather than from another client.
+func (channel *Channel) kickFromService(source string, target *Client, comment string) {
+	comment = ircutils.TruncateUTF8Safe(comment, channel.server.Config().Limits.KickLen)
+	message := utils.MakeMessage(comment)
+	targetNick := target.Nick()
+	chname := channel.Name()
+	for _, member := range channel.Members() {
+		for _, session := range member.Sessions() {
+			session.sendFromClientInternal(false, message.Time, message.Msgid, source, "*", false, nil, "KICK", chname, targetNick, comment)
+		}
+	}
+
+	histItem := history.Item{
+		Type:        history.Kick,
+		Nick:        source,
+		AccountName: "*",
+		Message:     message,
+	}
+	histItem.Params[0] = targetNick
+	channel.AddHistoryItem(histItem, "")
+
+	channel.Quit(target)
+}
+
+// noticeFloodExceeded informs channel operators about a flood protection action
+func (channel *Channel) noticeFloodExceeded(nick string, action FloodAction) {
+	settings := channel.Settings().Flood
+	chname := channel.Name()
+	for _, member := range channel.Members() {
+		if !channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			continue
+		}
+		message := fmt.Sprintf(member.t("%[1]s exceeded the flood limit (%[2]s); action taken: %[3]s"), nick, settings.String(), action.String())
+		for _, session := range member.Sessions() {
+			session.Send(nil, channel.server.name, "NOTICE", "@"+chname, message)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFloodSettings(t *testing.T) {
+	settings, err := ParseFloodSettings("5:10:KickBan")
+	assertEqual(err, nil, t)
+	assertEqual(settings, FloodSettings{Lines: 5, Seconds: 10, Action: FloodActionKickban}, t)
+	assertEqual(settings.String(), "5:10:kickban", t)
+
+	settings, err = ParseFloodSettings("off")
+	assertEqual(err, nil, t)
+	assertEqual(settings.Enabled(), false, t)
+
+	for _, bad := range []string{"", "5:10", "0:10:kick", "5:-1:mute", "5:10:ban", "a:10:kick"} {
+		if _, err := ParseFloodSettings(bad); err == nil {
+			t.Errorf("%s should be rejected", bad)
+		}
+	}
+}
+
+func TestFloodBucket(t *testing.T) {
+	settings := FloodSettings{Lines: 3, Seconds: 6, Action: FloodActionMute}
+	var bucket floodBucket
+	now := time.Now()
+	assertEqual(bucket.take(settings, 1, now), true, t)
+	assertEqual(bucket.take(settings, 1, now), true, t)
+	assertEqual(bucket.take(settings, 1, now), true, t)
+	assertEqual(bucket.take(settings, 1, now), false, t)
+	// the bucket is refilled after the action
+	assertEqual(bucket.take(settings, 2, now), true, t)
+	assertEqual(bucket.take(settings, 2, now), false, t)
+	// refills at 0.5 tokens per second
+	bucket.tokens = 0
+	now = now.Add(2 * time.Second)
+	assertEqual(bucket.take(settings, 1, now), true, t)
+	assertEqual(bucket.take(settings, 1, now), false, t)
+}
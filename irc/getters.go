@@ -35,6 +35,20 @@ func (server *Server) GetOperator(name string) (oper *Oper) {
 	return server.Config().operators[name]
 }
 
+// GetOperatorForAccount returns the oper block linked to the given
+// (casefolded) account via its `account` field, if any.
+func (server *Server) GetOperatorForAccount(cfAccount string) (oper *Oper) {
+	if cfAccount == "" {
+		return
+	}
+	for _, candidate := range server.Config().operators {
+		if candidate.Account == cfAccount {
+			return candidate
+		}
+	}
+	return
+}
+
 func (server *Server) Languages() (lm *languages.Manager) {
 	return server.Config().languageManager
 }
@@ -120,10 +134,10 @@ func (client *Client) AddSession(session *Session) (success bool, numSessions in
 	client.sessions = newSessions
 	// TODO(#1551) there should be a cap to opt out of this behavior on a session
 	if persistenceEnabled(config.Accounts.Multiclient.AutoAway, client.accountSettings.AutoAway) {
-		client.awayMessage = ""
-		if len(client.sessions) == 1 {
-			back = true
-		}
+		// the new session is not away, so this will bring us back:
+		wasAway := client.awayMessage != ""
+		client.setAutoAwayNoMutex(config)
+		back = wasAway && client.awayMessage == ""
 	}
 	return true, len(client.sessions), lastSeen, back
 }
@@ -200,7 +214,9 @@ func (client *Client) Away() (result bool, message string) {
 	return
 }
 
-func (session *Session) SetAway(awayMessage string) {
+// SetAway sets the away state of the session, returning the away message
+// of the client as a whole before and after the change.
+func (session *Session) SetAway(awayMessage string) (wasAway, nowAway string) {
 	client := session.client
 	config := client.server.Config()
 
@@ -210,12 +226,16 @@ func (session *Session) SetAway(awayMessage string) {
 	session.awayMessage = awayMessage
 	session.awayAt = time.Now().UTC()
 
+	wasAway = client.awayMessage
 	autoAway := client.registered && client.alwaysOn && persistenceEnabled(config.Accounts.Multiclient.AutoAway, client.accountSettings.AutoAway)
 	if autoAway {
 		client.setAutoAwayNoMutex(config)
+	} else if awayMessage == preAwayMessage {
+		client.awayMessage = config.languageManager.Translate(client.languages, `User is currently away`)
 	} else {
 		client.awayMessage = awayMessage
 	}
+	nowAway = client.awayMessage
 	return
 }
 
@@ -228,6 +248,9 @@ func (client *Client) setAutoAwayNoMutex(config *Config) {
 			// a session is active, we are not auto-away
 			client.awayMessage = ""
 			return
+		} else if cSession.awayMessage == preAwayMessage {
+			// draft/pre-away: the session is away, but has no message of its
+			// own, so it neither brings us back nor sets the away message
 		} else if cSession.awayAt.After(awaySetAt) {
 			// choose the latest available away message from any session
 			globalAwayState = cSession.awayMessage
@@ -236,7 +259,11 @@ func (client *Client) setAutoAwayNoMutex(config *Config) {
 	}
 	if awaySetAt.IsZero() {
 		// no sessions, enable auto-away
-		client.awayMessage = config.languageManager.Translate(client.languages, `User is currently disconnected`)
+		if client.accountSettings.AwayMessage != "" {
+			client.awayMessage = client.accountSettings.AwayMessage
+		} else {
+			client.awayMessage = config.languageManager.Translate(client.languages, `User is currently disconnected`)
+		}
 	} else {
 		client.awayMessage = globalAwayState
 	}
@@ -258,6 +285,25 @@ func (client *Client) uniqueIdentifiers() (nickCasefolded string, skeleton strin
 	return client.nickCasefolded, client.skeleton
 }
 
+func (client *Client) Impersonation() *impersonation {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.impersonation
+}
+
+func (client *Client) setImpersonation(record *impersonation) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.impersonation = record
+}
+
+// VHost returns the client's hostserv-based vhost, if any
+func (client *Client) VHost() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.vhost
+}
+
 func (client *Client) Oper() *Oper {
 	client.stateMutex.RLock()
 	defer client.stateMutex.RUnlock()
@@ -308,12 +354,48 @@ func (client *Client) Login(account ClientAccount) {
 	client.account = account.NameCasefolded
 	client.accountName = account.Name
 	client.accountSettings = account.Settings
+	client.ignores = compileIgnores(account.Settings.Ignores)
+	if len(account.Settings.Metadata) != 0 {
+		client.metadata = account.Settings.Metadata
+	}
+	if account.Settings.Realname != "" && !client.registered {
+		// a stored realname takes precedence over the one sent with USER
+		client.realname = account.Settings.Realname
+	}
+	client.recognizedAccount = ""
 	// mark always-on here: it will not be respected until the client is registered
 	client.alwaysOn = alwaysOn
 	client.accountRegDate = account.RegisteredAt
 	return
 }
 
+// WebIRCGateway returns the name of the WEBIRC gateway the client connected through, if any
+func (client *Client) WebIRCGateway() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.webircGateway
+}
+
+func (client *Client) setWebIRCGateway(gateway string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.webircGateway = gateway
+}
+
+// RecognizedAccount returns the account whose nickname the client is using
+// by virtue of matching one of its access masks (NS ACCESS), if any.
+func (client *Client) RecognizedAccount() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.recognizedAccount
+}
+
+func (client *Client) setRecognizedAccount(account string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.recognizedAccount = account
+}
+
 func (client *Client) setAccountName(name string) {
 	// XXX this assumes validation elsewhere
 	client.stateMutex.Lock()
@@ -352,6 +434,7 @@ func (client *Client) Logout() {
 	client.alwaysOn = false
 	client.accountRegDate = time.Time{}
 	client.accountSettings = AccountSettings{}
+	client.ignores = nil
 	client.stateMutex.Unlock()
 }
 
@@ -365,7 +448,8 @@ func (client *Client) AccountSettings() (result AccountSettings) {
 func (client *Client) SetAccountSettings(settings AccountSettings) {
 	// we mark dirty if the client is transitioning to always-on
 	var becameAlwaysOn bool
-	alwaysOn := persistenceEnabled(client.server.Config().Accounts.Multiclient.AlwaysOn, settings.AlwaysOn)
+	config := client.server.Config()
+	alwaysOn := persistenceEnabled(config.Accounts.Multiclient.AlwaysOn, settings.AlwaysOn)
 	client.stateMutex.Lock()
 	if client.registered {
 		// only allow the client to become always-on if their nick equals their account name
@@ -374,10 +458,23 @@ func (client *Client) SetAccountSettings(settings AccountSettings) {
 		client.alwaysOn = alwaysOn
 	}
 	client.accountSettings = settings
+	client.ignores = compileIgnores(settings.Ignores)
+	// the custom away message may have changed; apply it immediately
+	var awayChanged bool
+	var awayMessage string
+	if client.registered && client.alwaysOn && persistenceEnabled(config.Accounts.Multiclient.AutoAway, settings.AutoAway) {
+		oldAwayMessage := client.awayMessage
+		client.setAutoAwayNoMutex(config)
+		awayMessage = client.awayMessage
+		awayChanged = awayMessage != oldAwayMessage
+	}
 	client.stateMutex.Unlock()
 	if becameAlwaysOn {
 		client.markDirty(IncludeAllAttrs)
 	}
+	if awayChanged {
+		dispatchAwayNotify(client, awayMessage != "", awayMessage)
+	}
 }
 
 func (client *Client) Languages() (languages []string) {
@@ -529,6 +626,12 @@ func (channel *Channel) setUserLimit(limit int) {
 	channel.stateMutex.Unlock()
 }
 
+func (channel *Channel) Key() string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.key
+}
+
 func (channel *Channel) setKey(key string) {
 	channel.stateMutex.Lock()
 	defer channel.stateMutex.Unlock()
@@ -541,6 +644,14 @@ func (channel *Channel) Founder() string {
 	return channel.registeredFounder
 }
 
+// MemberCounts returns the current number of members and the highest
+// number since the server started.
+func (channel *Channel) MemberCounts() (current, peak int) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return len(channel.members), channel.peakMembers
+}
+
 func (channel *Channel) HighestUserMode(client *Client) (result modes.Mode) {
 	channel.stateMutex.RLock()
 	clientModes := channel.members[client].modes
@@ -31,6 +31,7 @@ import (
 	"github.com/ergochat/ergo/irc/history"
 	"github.com/ergochat/ergo/irc/jwt"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/oauth2"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 )
@@ -69,7 +70,9 @@ func registrationErrorToMessage(config *Config, client *Client, err error) (mess
 	}
 
 	switch err {
-	case errAccountAlreadyRegistered, errAccountAlreadyVerified, errAccountAlreadyUnregistered, errAccountAlreadyLoggedIn, errAccountCreation, errAccountMustHoldNick, errAccountBadPassphrase, errCertfpAlreadyExists, errFeatureDisabled, errAccountBadPassphrase:
+	case errAccountAlreadyRegistered, errAccountAlreadyVerified, errAccountAlreadyUnregistered, errAccountAlreadyLoggedIn, errAccountCreation, errAccountMustHoldNick, errAccountBadPassphrase, errCertfpAlreadyExists, errFeatureDisabled, errAccountBadPassphrase,
+		errAccountNameTooShort, errAccountNameTooLong, errAccountNameForbiddenCharacters, errAccountNameReservedPrefix,
+		errTOTPRequired, errInvalidTOTP:
 		message = err.Error()
 	case errLimitExceeded:
 		message = `There have been too many registration attempts recently; try again later`
@@ -97,6 +100,20 @@ func sendSuccessfulRegResponse(service *ircService, client *Client, rb *Response
 	sendSuccessfulAccountAuth(service, client, rb, false)
 }
 
+// dispatchAccountNotify sends ACCOUNT to friends (and extended-monitor
+// subscribers) after the client logs in or out.
+func dispatchAccountNotify(client *Client, rb *ResponseBuffer) {
+	details := client.Details()
+	for friend := range client.FriendsMonitors(caps.AccountNotify) {
+		if friend != rb.session {
+			friend.Send(nil, details.nickMask, "ACCOUNT", details.accountName)
+		}
+	}
+	if rb.session.capabilities.Has(caps.AccountNotify) {
+		rb.Add(nil, details.nickMask, "ACCOUNT", details.accountName)
+	}
+}
+
 // sendSuccessfulAccountAuth means that an account auth attempt completed successfully, and is used to dispatch messages.
 func sendSuccessfulAccountAuth(service *ircService, client *Client, rb *ResponseBuffer, forSASL bool) {
 	details := client.Details()
@@ -112,16 +129,9 @@ func sendSuccessfulAccountAuth(service *ircService, client *Client, rb *Response
 	}
 
 	if client.Registered() {
-		// dispatch account-notify
-		for friend := range client.FriendsMonitors(caps.AccountNotify) {
-			if friend != rb.session {
-				friend.Send(nil, details.nickMask, "ACCOUNT", details.accountName)
-			}
-		}
-		if rb.session.capabilities.Has(caps.AccountNotify) {
-			rb.Add(nil, details.nickMask, "ACCOUNT", details.accountName)
-		}
+		dispatchAccountNotify(client, rb)
 		client.server.sendLoginSnomask(details.nickMask, details.accountName)
+		client.server.sendSuccessionNotices(client, rb.session)
 	}
 
 	// #1479: for Tor clients, replace the hostname with the always-on cloak here
@@ -145,6 +155,21 @@ func (server *Server) sendLoginSnomask(nickMask, accountName string) {
 	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] logged into account $c[grey][$r%s$c[grey]]"), nickMask, accountName))
 }
 
+// ADMIN [server]
+func adminHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	admin := server.Config().Server.Admin
+	if admin.Name == "" && admin.Nick == "" && admin.Email == "" {
+		rb.Add(nil, server.name, ERR_NOADMININFO, nick, server.name, client.t("No administrative info available"))
+		return false
+	}
+	rb.Add(nil, server.name, RPL_ADMINME, nick, server.name, client.t("Administrative info"))
+	rb.Add(nil, server.name, RPL_ADMINLOC1, nick, admin.Name)
+	rb.Add(nil, server.name, RPL_ADMINLOC2, nick, admin.Nick)
+	rb.Add(nil, server.name, RPL_ADMINEMAIL, nick, admin.Email)
+	return false
+}
+
 // AUTHENTICATE [<mechanism>|<data>|*]
 func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	session := rb.session
@@ -178,6 +203,9 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb
 
 		mechanism := strings.ToUpper(msg.Params[0])
 		_, mechanismIsEnabled := EnabledSaslMechanisms[mechanism]
+		if mechanism == "OAUTHBEARER" && !config.Accounts.AuthExternal.OAuth2.Enabled {
+			mechanismIsEnabled = false
+		}
 
 		if mechanismIsEnabled {
 			session.sasl.mechanism = mechanism
@@ -247,11 +275,6 @@ func authPlainHandler(server *Server, client *Client, session *Session, value []
 
 	if len(splitValue) == 3 {
 		authzid, authcid = string(splitValue[0]), string(splitValue[1])
-
-		if authzid != "" && authcid != authzid {
-			rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), client.t("SASL authentication failed: authcid and authzid should be the same"))
-			return false
-		}
 	} else {
 		rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), client.t("SASL authentication failed: Invalid auth blob"))
 		return false
@@ -267,7 +290,13 @@ func authPlainHandler(server *Server, client *Client, session *Session, value []
 		}
 	}
 	password := string(splitValue[2])
-	err := server.accounts.AuthenticateByPassphrase(client, authcid, password)
+	var err error
+	if authzid != "" && !plainIdentitiesMatch(authcid, authzid) {
+		// RFC 4616 authzid: privileged accounts may authenticate as another account
+		err = server.accounts.AuthenticateByPassphraseAs(client, authcid, authzid, password, rb.session.certfp)
+	} else {
+		err = server.accounts.AuthenticateByPassphrase(client, authcid, password, rb.session.certfp)
+	}
 	if err != nil {
 		sendAuthErrorResponse(client, rb, err)
 		return false
@@ -279,21 +308,43 @@ func authPlainHandler(server *Server, client *Client, session *Session, value []
 	return false
 }
 
+// plainIdentitiesMatch reports whether a SASL PLAIN authzid names the same
+// account as the authcid.
+func plainIdentitiesMatch(authcid, authzid string) bool {
+	if authcid == authzid {
+		return true
+	}
+	cfAuthcid, err := CasefoldName(authcid)
+	if err != nil {
+		return false
+	}
+	cfAuthzid, err := CasefoldName(authzid)
+	return err == nil && cfAuthcid == cfAuthzid
+}
+
 func sendAuthErrorResponse(client *Client, rb *ResponseBuffer, err error) {
 	msg := authErrorToMessage(client.server, err)
 	rb.Add(nil, client.server.name, ERR_SASLFAIL, client.nick, fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
 	if err == errAccountUnverified {
 		rb.Add(nil, client.server.name, "NOTE", "AUTHENTICATE", "VERIFICATION_REQUIRED", "*", client.t(err.Error()))
+	} else if _, ok := err.(*AccountLockedError); ok {
+		rb.Add(nil, client.server.name, "FAIL", "AUTHENTICATE", "ACCOUNT_LOCKED", "*", msg)
 	}
 }
 
 func authErrorToMessage(server *Server, err error) (msg string) {
 	if throttled, ok := err.(*ThrottleError); ok {
 		return throttled.Error()
+	} else if locked, ok := err.(*AccountLockedError); ok {
+		return locked.Error()
+	} else if suspended, ok := err.(*AccountSuspendedError); ok {
+		return suspended.Details()
 	}
 
 	switch err {
-	case errAccountDoesNotExist, errAccountUnverified, errAccountInvalidCredentials, errAuthzidAuthcidMismatch, errNickAccountMismatch, errAccountSuspended:
+	case errAccountDoesNotExist, errAccountUnverified, errAccountInvalidCredentials, errAuthzidAuthcidMismatch, errNickAccountMismatch, errAccountSuspended, errFeatureDisabled, errAuthServiceUnavailable,
+		errAccountNameTooShort, errAccountNameTooLong, errAccountNameForbiddenCharacters, errAccountNameReservedPrefix,
+		errTOTPRequired, errInvalidTOTP:
 		return err.Error()
 	default:
 		// don't expose arbitrary error messages to the user
@@ -377,6 +428,7 @@ func authScramHandler(server *Server, client *Client, session *Session, value []
 				rb.Add(nil, server.name, ERR_SASLFAIL, client.nick, client.t("SASL authentication failed: authcid and authzid should be the same"))
 				return false
 			}
+			server.accounts.recordSCRAMResult(authcid, true)
 			account, err := server.accounts.LoadAccount(authcid)
 			if err == nil {
 				server.accounts.Login(client, account)
@@ -398,6 +450,10 @@ func authScramHandler(server *Server, client *Client, session *Session, value []
 		rb.Add(nil, server.name, "AUTHENTICATE", base64.StdEncoding.EncodeToString([]byte(response)))
 	} else {
 		continueAuth = false
+		if response == "e=invalid-proof" {
+			// a wrong password counts towards the login lockout, as with PLAIN
+			server.accounts.recordSCRAMResult(session.sasl.scramConv.Username(), false)
+		}
 		rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), err.Error())
 		return false
 	}
@@ -405,8 +461,74 @@ func authScramHandler(server *Server, client *Client, session *Session, value []
 	return false
 }
 
+// AUTHENTICATE OAUTHBEARER
+func authOauthBearerHandler(server *Server, client *Client, session *Session, value []byte, rb *ResponseBuffer) bool {
+	// after a failure, RFC 7628 has the client send a dummy \x01 response
+	// to the server's error report; only then do we report the failure.
+	// any input while the token is being validated is likewise a failure
+	if session.sasl.oauthFailed || session.sasl.oauthPending {
+		session.sasl.Clear()
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), client.t("SASL authentication failed"))
+		return false
+	}
+
+	authzid, token, err := oauth2.ParseSASLMessage(value)
+	if err != nil {
+		session.sasl.Clear()
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.Nick(), client.t("SASL authentication failed: Invalid auth blob"))
+		return false
+	}
+
+	if strudelIndex := strings.IndexByte(authzid, '@'); strudelIndex != -1 {
+		var deviceID string
+		authzid, deviceID = authzid[:strudelIndex], authzid[strudelIndex+1:]
+		if !client.registered {
+			rb.session.deviceID = deviceID
+		}
+	}
+
+	session.sasl.oauthPending = true
+	err = server.accounts.AuthenticateByBearerToken(session, authzid, token, func(rb *ResponseBuffer, account ClientAccount, err error) {
+		// the client may have aborted in the meantime
+		if !session.sasl.oauthPending {
+			return
+		}
+		session.sasl.oauthPending = false
+		if err == errAccountInvalidCredentials {
+			session.sasl.oauthFailed = true
+			failure := server.Config().Accounts.AuthExternal.OAuth2.SASLFailure()
+			rb.Add(nil, server.name, "AUTHENTICATE", base64.StdEncoding.EncodeToString(failure))
+			return
+		}
+
+		session.sasl.Clear()
+		if err == nil && client.LoggedIntoAccount() {
+			err = errAccountAlreadyLoggedIn
+		}
+		if err != nil {
+			sendAuthErrorResponse(client, rb, err)
+			return
+		}
+		server.accounts.Login(client, account)
+		if fixupNickEqualsAccount(client, rb, server.Config(), "") {
+			sendSuccessfulAccountAuth(nil, client, rb, true)
+		}
+	})
+	if err != nil {
+		session.sasl.Clear()
+		sendAuthErrorResponse(client, rb, err)
+	}
+	return false
+}
+
 // AWAY [<message>]
 func awayHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	// AWAY is only usable before connection registration with draft/pre-away
+	if !client.registered && !rb.session.capabilities.Has(caps.PreAway) {
+		rb.Add(nil, server.name, ERR_NOTREGISTERED, "*", client.t("You need to register before you can use that command"))
+		return false
+	}
+
 	var isAway bool
 	var awayMessage string
 	if len(msg.Params) > 0 {
@@ -415,7 +537,7 @@ func awayHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		awayMessage = ircutils.TruncateUTF8Safe(awayMessage, server.Config().Limits.AwayLen)
 	}
 
-	rb.session.SetAway(awayMessage)
+	wasAway, nowAway := rb.session.SetAway(awayMessage)
 
 	if isAway {
 		rb.Add(nil, server.name, RPL_NOWAWAY, client.nick, client.t("You have been marked as being away"))
@@ -423,7 +545,11 @@ func awayHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		rb.Add(nil, server.name, RPL_UNAWAY, client.nick, client.t("You are no longer marked as being away"))
 	}
 
-	dispatchAwayNotify(client, isAway, awayMessage)
+	// other sessions of an always-on client may keep it away (or not away),
+	// so only notify friends when the away state of the client changed
+	if client.registered && wasAway != nowAway {
+		dispatchAwayNotify(client, nowAway != "", nowAway)
+	}
 	return false
 }
 
@@ -440,6 +566,23 @@ func dispatchAwayNotify(client *Client, isAway bool, awayMessage string) {
 	}
 }
 
+// dispatchMonitorAway follows the online notification for a nick with its
+// away state, for extended-monitor subscribers (e.g., if the client
+// connected with draft/pre-away, or an away client changed nicks)
+func dispatchMonitorAway(client *Client, cfnick string) {
+	isAway, awayMessage := client.Away()
+	if !isAway {
+		return
+	}
+	monitors := make(map[*Session]empty)
+	client.server.monitorManager.AddMonitors(monitors, cfnick, caps.AwayNotify)
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+	for session := range monitors {
+		session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "AWAY", awayMessage)
+	}
+}
+
 // BATCH {+,-}reference-tag type [params...]
 func batchHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	tag := msg.Params[0]
@@ -590,6 +733,62 @@ func capHandler(server *Server, client *Client, msg ircmsg.Message, rb *Response
 	return false
 }
 
+// CHALLENGE <name>
+// CHALLENGE +<response>
+func challengeHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	if client.HasMode(modes.Operator) {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "CHALLENGE", client.t("You're already opered-up!"))
+		return false
+	}
+
+	// the certfp check is repeated when verifying the response, in case of a rehash
+	usable := func(oper *Oper) bool {
+		return oper != nil && oper.PublicKey != nil && (oper.Certfp == "" || oper.Certfp == rb.session.certfp)
+	}
+
+	param := msg.Params[0]
+	pending := rb.session.operChallenge
+	rb.session.operChallenge = operChallenge{}
+
+	if strings.HasPrefix(param, "+") {
+		if pending.operName == "" {
+			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "CHALLENGE", client.t("No challenge is in progress"))
+			return false
+		}
+		oper := server.GetOperator(pending.operName)
+		if !usable(oper) || !checkOperChallengeResponse(pending.plaintext, param[1:]) {
+			rb.Add(nil, server.name, ERR_PASSWDMISMATCH, client.Nick(), client.t("Password incorrect"))
+			client.Quit(client.t("Password incorrect"), rb.session)
+			return true
+		}
+		applyOper(client, oper, rb)
+		return false
+	}
+
+	oper := server.GetOperator(param)
+	if !usable(oper) {
+		rb.Add(nil, server.name, ERR_NOOPERHOST, client.Nick(), client.t("No appropriate operator blocks were found for your host"))
+		return false
+	}
+	plaintext, challenge, err := generateOperChallenge(oper.PublicKey)
+	if err != nil {
+		server.logger.Error("opers", "couldn't generate challenge", err.Error())
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "CHALLENGE", client.t("Could not generate challenge"))
+		return false
+	}
+	rb.session.operChallenge = operChallenge{operName: oper.Name, plaintext: plaintext}
+	for len(challenge) > 0 {
+		chunkLen := operChallengeChunkLen
+		if len(challenge) < chunkLen {
+			chunkLen = len(challenge)
+		}
+		rb.Add(nil, server.name, RPL_RSACHALLENGE2, client.Nick(), challenge[:chunkLen])
+		challenge = challenge[chunkLen:]
+	}
+	rb.Add(nil, server.name, RPL_ENDOFRSACHALLENGE2, client.Nick(), client.t("End of CHALLENGE"))
+	return false
+}
+
 // CHATHISTORY <target> <preposition> <query> [<limit>]
 // e.g., CHATHISTORY #ircv3 AFTER id=ytNBbt565yt4r3err3 10
 // CHATHISTORY <target> BETWEEN <query> <query> <direction> [<limit>]
@@ -748,6 +947,37 @@ func chathistoryHandler(server *Server, client *Client, msg ircmsg.Message, rb *
 	return
 }
 
+// CHGHOST <nick> <username> <hostname>
+func chghostHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	targetNick, username, hostname := msg.Params[0], msg.Params[1], msg.Params[2]
+	target := server.clients.Get(targetNick)
+	if target == nil {
+		rb.Add(nil, server.name, "FAIL", "CHGHOST", "NO_SUCH_NICKNAME", utils.SafeErrorParam(targetNick), client.t("No such nick"))
+		return false
+	}
+	if server.Config().Limits.IdentLen < len(username) || !isIdent(username) {
+		rb.Add(nil, server.name, "FAIL", "CHGHOST", "INVALID_USERNAME", utils.SafeErrorParam(username), client.t("Invalid username"))
+		return false
+	}
+	if validateVhost(server, hostname, true) != nil {
+		rb.Add(nil, server.name, "FAIL", "CHGHOST", "INVALID_HOSTNAME", utils.SafeErrorParam(hostname), client.t("Invalid hostname"))
+		return false
+	}
+
+	oldNickMask := target.NickMaskString()
+	target.setUserHost(username, hostname)
+	newNickMask := target.NickMaskString()
+	if oldNickMask != newNickMask {
+		target.sendChghost(oldNickMask, hostname)
+	}
+
+	operName := client.Oper().Name
+	server.logger.Info("opers", fmt.Sprintf("Operator %s (client %s) changed %s to %s", operName, client.Nick(), oldNickMask, newNickMask))
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] changed $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), operName, oldNickMask, newNickMask))
+	rb.Notice(fmt.Sprintf(client.t("Changed %[1]s to %[2]s"), oldNickMask, newNickMask))
+	return false
+}
+
 // DEBUG <subcmd>
 func debugHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	param := strings.ToUpper(msg.Params[0])
@@ -837,6 +1067,78 @@ func defconHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	return false
 }
 
+// CLONES [min]
+func clonesHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	minCount := 2
+	if len(msg.Params) != 0 {
+		count, err := strconv.Atoi(msg.Params[0])
+		if err != nil || count < 1 {
+			rb.Add(nil, server.name, "FAIL", "CLONES", "INVALID_PARAMS", utils.SafeErrorParam(msg.Params[0]), client.t("Invalid minimum count"))
+			return false
+		}
+		minCount = count
+	}
+
+	// group connections (i.e., sessions, to account for multiclient) by IP
+	connections := make(map[string][]string)
+	for _, target := range server.clients.AllClients() {
+		details := target.Details()
+		description := details.nick
+		if details.accountName != "*" {
+			description = fmt.Sprintf("%s (%s)", details.nick, details.accountName)
+		}
+		sessionData, _ := target.AllSessionData(nil, false)
+		for _, session := range sessionData {
+			ip := session.ip.String()
+			connections[ip] = append(connections[ip], description)
+		}
+	}
+
+	var ips []string
+	for ip, descriptions := range connections {
+		if minCount <= len(descriptions) {
+			ips = append(ips, ip)
+		}
+	}
+	// most connections first
+	sort.Slice(ips, func(i, j int) bool {
+		if len(connections[ips[i]]) != len(connections[ips[j]]) {
+			return len(connections[ips[i]]) > len(connections[ips[j]])
+		}
+		return ips[i] < ips[j]
+	})
+
+	if len(ips) == 0 {
+		rb.Notice(fmt.Sprintf(client.t("No IPs have at least %d connections"), minCount))
+		return false
+	}
+	for _, ip := range ips {
+		descriptions := connections[ip]
+		sort.Strings(descriptions)
+		rb.Notice(fmt.Sprintf(client.t("%[1]s: %[2]d connections: %[3]s"), ip, len(descriptions), strings.Join(descriptions, ", ")))
+	}
+	rb.Notice(fmt.Sprintf(client.t("End of CLONES list (%d IPs)"), len(ips)))
+	return false
+}
+
+// CONNLIMIT LIST
+func connlimitHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	if strings.ToLower(msg.Params[0]) != "list" {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Invalid CONNLIMIT parameter"))
+		return false
+	}
+
+	statuses := server.connectionLimiter.CustomLimitStatuses()
+	if len(statuses) == 0 {
+		rb.Notice(client.t("No custom connection limits are configured"))
+		return false
+	}
+	for _, status := range statuses {
+		rb.Notice(fmt.Sprintf(client.t("%[1]s (%[2]s): %[3]d/%[4]d concurrent connections, %[5]d/%[6]d connection attempts in the current window"), status.Name, strings.Join(status.Nets, ", "), status.Count, status.MaxCount, status.Throttle, status.MaxPerWindow))
+	}
+	return false
+}
+
 // helper for parsing the reason args to DLINE and KLINE
 func getReasonsFromParams(params []string, currentArg int) (reason, operReason string) {
 	reason = "No reason given"
@@ -1151,8 +1453,23 @@ func infoHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	if Commit != "" {
 		rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("It was built from git hash %s."), Commit))
 	}
-	rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("It was compiled using %s."), runtime.Version()))
-	rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("This server has been running since %s."), server.ctime.Format(time.RFC1123)))
+	if BuildDate != "" {
+		rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("It was built on %s."), BuildDate))
+	}
+	rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("It was compiled using %[1]s for %[2]s/%[3]s."), runtime.Version(), runtime.GOOS, runtime.GOARCH))
+	rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("This server has been running since %[1]s (uptime %[2]s)."), server.ctime.Format(time.RFC1123), time.Since(server.ctime).Round(time.Second)))
+	if contact := server.Config().Server.Contact; contact != "" {
+		rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf(client.t("Server operators can be contacted at: %s"), contact))
+	}
+	// library versions are only shown to operators
+	if client.Oper() != nil {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok && len(buildInfo.Deps) != 0 {
+			rb.Add(nil, server.name, RPL_INFO, nick, client.t("Linked libraries:"))
+			for _, dep := range buildInfo.Deps {
+				rb.Add(nil, server.name, RPL_INFO, nick, fmt.Sprintf("    %s %s", dep.Path, dep.Version))
+			}
+		}
+	}
 	rb.Add(nil, server.name, RPL_INFO, nick, "")
 	rb.Add(nil, server.name, RPL_INFO, nick, client.t("Ergo is released under the MIT license."))
 	rb.Add(nil, server.name, RPL_INFO, nick, "")
@@ -1224,6 +1541,11 @@ func isonHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	return false
 }
 
+const (
+	// maximum length of a chain of +f forwards followed by a single JOIN
+	maxForwardHops = 8
+)
+
 // JOIN <channel>{,<channel>} [<key>{,<key>}]
 func joinHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	// #1417: allow `JOIN 0` with a confirmation code
@@ -1255,28 +1577,63 @@ func joinHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 			key = keys[i]
 		}
 		err, forward := server.channels.Join(client, name, key, false, rb)
-		if err != nil {
-			if forward != "" {
-				rb.Add(nil, server.name, ERR_LINKCHANNEL, client.Nick(), utils.SafeErrorParam(name), forward, client.t("Forwarding to another channel"))
-				name = forward
-				err, _ = server.channels.Join(client, name, key, false, rb)
+		// follow +f forwards, breaking any loops
+		var visited map[string]bool
+		for hops := 0; err != nil && forward != "" && hops < maxForwardHops; hops++ {
+			cfForward, cfErr := CasefoldChannel(forward)
+			if visited == nil {
+				visited = make(map[string]bool)
+				if cfName, cfErr := CasefoldChannel(name); cfErr == nil {
+					visited[cfName] = true
+				}
 			}
-			if err != nil {
-				sendJoinError(client, name, rb, err)
+			if cfErr != nil || visited[cfForward] {
+				break
 			}
+			visited[cfForward] = true
+			rb.Add(nil, server.name, ERR_LINKCHANNEL, client.Nick(), utils.SafeErrorParam(name), forward, client.t("Forwarding to another channel"))
+			name = forward
+			err, forward = server.channels.Join(client, name, key, false, rb)
+		}
+		if err != nil {
+			sendJoinError(client, name, rb, err)
 		}
 	}
 	return false
 }
 
+// sendPurgedJoinError explains to a user that a channel they tried to join
+// is purged, including the purge reason only if it was marked public
+func sendPurgedJoinError(client *Client, name string, rb *ResponseBuffer) {
+	message := client.server.Config().Channels.PurgeMessage
+	if cfname, err := CasefoldChannel(name); err == nil {
+		if record, err := client.server.channelRegistry.LoadPurgeRecord(cfname); err == nil && record.Public && record.Reason != "" {
+			message = record.Reason
+		}
+	}
+	rb.Add(nil, client.server.name, ERR_BANNEDFROMCHAN, client.Nick(), utils.SafeErrorParam(name), client.t("Cannot join channel (purged)"))
+	rb.Add(nil, client.server.name, "NOTE", "JOIN", "CHANNEL_PURGED", utils.SafeErrorParam(name), message)
+}
+
 func sendJoinError(client *Client, name string, rb *ResponseBuffer, err error) {
 	var code, errMsg, forbiddingMode string
+	if akick, ok := err.(*akickError); ok {
+		errMsg = client.t("Cannot join channel (auto-kick)")
+		if akick.reason != "" {
+			errMsg = fmt.Sprintf(client.t("Cannot join channel (auto-kick): %s"), akick.reason)
+		}
+		rb.Add(nil, client.server.name, ERR_BANNEDFROMCHAN, client.Nick(), utils.SafeErrorParam(name), errMsg)
+		return
+	}
 	switch err {
 	case errInsufficientPrivs:
 		code, errMsg = ERR_NOSUCHCHANNEL, `Only server operators can create new channels`
 	case errConfusableIdentifier:
 		code, errMsg = ERR_NOSUCHCHANNEL, `That channel name is too close to the name of another channel`
 	case errChannelPurged:
+		sendPurgedJoinError(client, name, rb)
+		return
+	case errChannelRenamed:
 		code, errMsg = ERR_NOSUCHCHANNEL, err.Error()
 	case errTooManyChannels:
 		code, errMsg = ERR_TOOMANYCHANNELS, `You have joined too many channels`
@@ -1406,9 +1763,16 @@ func kickHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 // KILL <nickname> <comment>
 func killHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	nickname := msg.Params[0]
+	killConfig := server.Config().Kill
 	comment := "<no reason supplied>"
-	if len(msg.Params) > 1 {
+	if len(msg.Params) > 1 && msg.Params[1] != "" {
 		comment = msg.Params[1]
+		if killConfig.MaxReasonLen > 0 {
+			comment = ircutils.TruncateUTF8Safe(comment, killConfig.MaxReasonLen)
+		}
+	} else if killConfig.RequireReason {
+		rb.Add(nil, server.name, "FAIL", "KILL", "NEED_REASON", utils.SafeErrorParam(nickname), client.t("A reason is required for KILL"))
+		return false
 	}
 
 	target := server.clients.Get(nickname)
@@ -1419,8 +1783,9 @@ func killHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		rb.Add(nil, client.server.name, ERR_UNKNOWNERROR, client.Nick(), "KILL", fmt.Sprintf(client.t("Client %s is always-on and cannot be fully removed by /KILL; consider /NS SUSPEND instead"), target.Nick()))
 	}
 
-	quitMsg := fmt.Sprintf("Killed (%s (%s))", client.nick, comment)
+	quitMsg := formatKillMessage(killConfig.Template, client.Nick(), comment)
 
+	server.logger.Info("opers", fmt.Sprintf("Operator %s (client %s) killed %s: %s", client.Oper().Name, client.Nick(), target.NickMaskString(), comment))
 	server.snomasks.Send(sno.LocalKills, fmt.Sprintf(ircfmt.Unescape("%s$r was killed by %s $c[grey][$r%s$c[grey]]"), target.nick, client.nick, comment))
 
 	target.Quit(quitMsg, nil)
@@ -1428,6 +1793,11 @@ func killHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	return false
 }
 
+// formatKillMessage fills in the {oper} and {reason} placeholders of kill.template
+func formatKillMessage(template, oper, reason string) string {
+	return strings.NewReplacer("{oper}", oper, "{reason}", reason).Replace(template)
+}
+
 // KLINE [ANDKILL] [MYSELF] [duration] <mask> [ON <server>] [reason [| oper reason]]
 // KLINE LIST
 func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
@@ -1494,14 +1864,15 @@ func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		return false
 	}
 
-	matcher, err := utils.CompileGlob(mask, false)
+	matcher, hostNet, err := compileKLineMask(mask)
 	if err != nil {
 		rb.Add(nil, server.name, ERR_UNKNOWNERROR, details.nick, msg.Command, client.t("Erroneous nickname"))
 		return false
 	}
+	kln := KLineInfo{Mask: mask, Matcher: matcher, HostNet: hostNet}
 
 	for _, clientMask := range client.AllNickmasks() {
-		if !klineMyself && matcher.MatchString(clientMask) {
+		if !klineMyself && kln.matches(clientMask) {
 			rb.Add(nil, server.name, ERR_UNKNOWNERROR, details.nick, msg.Command, client.t("This ban matches you. To KLINE yourself, you must use the command:  /KLINE MYSELF <arguments>"))
 			return false
 		}
@@ -1545,7 +1916,7 @@ func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 
 		for _, mcl := range server.clients.AllClients() {
 			for _, clientMask := range mcl.AllNickmasks() {
-				if matcher.MatchString(clientMask) {
+				if kln.matches(clientMask) {
 					clientsToKill = append(clientsToKill, mcl)
 					killedClientNicks = append(killedClientNicks, mcl.nick)
 					break
@@ -1626,6 +1997,22 @@ func languageHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 }
 
 // LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
+// LINKS [[<remote server>] <server mask>]
+func linksHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	// ergo does not link to other servers, so the only server we know about
+	// is ourselves: hop count 0, no uplink
+	mask := "*"
+	if len(msg.Params) != 0 {
+		mask = msg.Params[len(msg.Params)-1]
+	}
+	nick := client.Nick()
+	if matcher, err := utils.CompileGlob(strings.ToLower(mask), false); err == nil && matcher.MatchString(strings.ToLower(server.name)) {
+		rb.Add(nil, server.name, RPL_LINKS, nick, server.name, "*", fmt.Sprintf("0 %s", server.Config().Network.Name))
+	}
+	rb.Add(nil, server.name, RPL_ENDOFLINKS, nick, mask, client.t("End of LINKS list"))
+	return false
+}
+
 func listHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	config := server.Config()
 	if time.Since(client.ctime) < config.Channels.ListDelay && client.Account() == "" && !client.HasMode(modes.Operator) {
@@ -1635,43 +2022,12 @@ func listHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		return false
 	}
 
-	// get channels
-	var channels []string
-	for _, param := range msg.Params {
-		if 0 < len(param) && param[0] == '#' {
-			for _, channame := range strings.Split(param, ",") {
-				if 0 < len(channame) && channame[0] == '#' {
-					channels = append(channels, channame)
-				}
-			}
-		}
-	}
-
-	// get elist conditions
-	var matcher elistMatcher
-	for _, param := range msg.Params {
-		if len(param) < 1 {
-			continue
-		}
-
-		if param[0] == '<' {
-			param = param[1:]
-			val, err := strconv.Atoi(param)
-			if err != nil {
-				continue
-			}
-			matcher.MaxClientsActive = true
-			matcher.MaxClients = val - 1 // -1 because < means less than the given number
-		}
-		if param[0] == '>' {
-			param = param[1:]
-			val, err := strconv.Atoi(param)
-			if err != nil {
-				continue
-			}
-			matcher.MinClientsActive = true
-			matcher.MinClients = val + 1 // +1 because > means more than the given number
-		}
+	// get channels and elist conditions
+	channels, matcher, invalid, err := parseListParams(msg.Params)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "LIST", "INVALID_PARAMS", utils.SafeErrorParam(invalid), client.t("Invalid LIST filter"))
+		rb.Add(nil, server.name, RPL_LISTEND, client.Nick(), client.t("End of LIST"))
+		return false
 	}
 
 	nick := client.Nick()
@@ -1777,10 +2133,12 @@ func announceCmodeChanges(channel *Channel, applied modes.ModeChanges, source, a
 			message.Split = append(message.Split, utils.MessagePair{Message: changeString})
 		}
 		args := append([]string{channel.name}, changeStrings...)
-		rb.AddFromClient(message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
+		if rb != nil {
+			rb.AddFromClient(message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
+		}
 		for _, member := range channel.Members() {
 			for _, session := range member.Sessions() {
-				if session != rb.session {
+				if rb == nil || session != rb.session {
 					session.sendFromClientInternal(false, message.Time, message.Msgid, source, accountName, isBot, nil, "MODE", args...)
 				}
 			}
@@ -1888,7 +2246,7 @@ func monitorRemoveHandler(server *Server, client *Client, msg ircmsg.Message, rb
 
 	targets := strings.Split(msg.Params[1], ",")
 	for _, target := range targets {
-		server.monitorManager.Remove(rb.session, target)
+		server.monitorManager.Remove(rb.session, monitorListMonitor, target)
 	}
 
 	return false
@@ -1903,6 +2261,9 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 
 	var online []string
 	var offline []string
+	// extended-monitor: online targets whose away state should follow RPL_MONONLINE
+	var away []*Client
+	sendAway := rb.session.capabilities.HasAll(caps.ExtendedMonitor, caps.AwayNotify)
 
 	limits := server.Config().Limits
 
@@ -1914,7 +2275,7 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 		}
 
 		// add target
-		err := server.monitorManager.Add(rb.session, target, limits.MonitorEntries)
+		err := server.monitorManager.Add(rb.session, monitorListMonitor, target, limits.MonitorEntries)
 		if err == errMonitorLimitExceeded {
 			rb.Add(nil, server.name, ERR_MONLISTFULL, client.Nick(), strconv.Itoa(limits.MonitorEntries), strings.Join(targets, ","))
 			break
@@ -1926,6 +2287,9 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 		// add to online / offline lists
 		if currentNick != "" {
 			online = append(online, currentNick)
+			if tClient := server.clients.Get(currentNick); sendAway && tClient != nil && tClient.AwayMessage() != "" {
+				away = append(away, tClient)
+			}
 		} else {
 			offline = append(offline, target)
 		}
@@ -1934,6 +2298,12 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 	if len(online) > 0 {
 		rb.Add(nil, server.name, RPL_MONONLINE, client.Nick(), strings.Join(online, ","))
 	}
+	for _, tClient := range away {
+		if isAway, awayMessage := tClient.Away(); isAway {
+			details := tClient.Details()
+			rb.AddFromClient(time.Time{}, "", details.nickMask, details.accountName, tClient.HasMode(modes.Bot), nil, "AWAY", awayMessage)
+		}
+	}
 	if len(offline) > 0 {
 		rb.Add(nil, server.name, RPL_MONOFFLINE, client.Nick(), strings.Join(offline, ","))
 	}
@@ -1943,14 +2313,14 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 
 // MONITOR C
 func monitorClearHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
-	server.monitorManager.RemoveAll(rb.session)
+	server.monitorManager.Clear(rb.session, monitorListMonitor)
 	return false
 }
 
 // MONITOR L
 func monitorListHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	nick := client.Nick()
-	monitorList := server.monitorManager.List(rb.session)
+	monitorList := server.monitorManager.List(rb.session, monitorListMonitor)
 
 	var nickList []string
 	for _, cfnick := range monitorList {
@@ -1977,7 +2347,7 @@ func monitorStatusHandler(server *Server, client *Client, msg ircmsg.Message, rb
 	var online []string
 	var offline []string
 
-	monitorList := server.monitorManager.List(rb.session)
+	monitorList := server.monitorManager.List(rb.session, monitorListMonitor)
 
 	for _, name := range monitorList {
 		currentNick := server.getCurrentNick(name)
@@ -2224,6 +2594,9 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		nickMaskString := details.nickMask
 		accountName := details.accountName
 		isBot := client.HasMode(modes.Bot)
+		if histType != history.Tagmsg {
+			server.messageLog.Log(command, nickMaskString, accountName, target, message)
+		}
 		for _, tClient := range server.clients.AllClients() {
 			if (target[1] == '$' && matcher.MatchString(tClient.server.name)) || // $$servername
 				(target[1] == '#' && matcher.MatchString(tClient.Hostname())) { // $#hostname
@@ -2287,8 +2660,15 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		}
 		nickMaskString := details.nickMask
 		accountName := details.accountName
+		if histType != history.Tagmsg {
+			server.messageLog.Log(command, nickMaskString, accountName, tnick, message)
+		}
+		// NS SET IGNORE: drop the message silently, as though it had been delivered
+		ignored := user.isIgnoring(client, histType)
 		var deliverySessions []*Session
-		deliverySessions = append(deliverySessions, user.Sessions()...)
+		if !ignored {
+			deliverySessions = append(deliverySessions, user.Sessions()...)
+		}
 		// all sessions of the sender, except the originating session, get a copy as well:
 		if client != user {
 			for _, session := range client.Sessions() {
@@ -2299,13 +2679,21 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		}
 
 		isBot := client.HasMode(modes.Bot)
+		sessionTags := tags
+		// operators are shown who is behind an impersonated account:
+		if operTags := impersonationTags(client, tags); operTags != nil && user.Oper() != nil {
+			sessionTags = operTags
+		}
 		for _, session := range deliverySessions {
 			hasTagsCap := session.capabilities.Has(caps.MessageTags)
+			tagsToSend := tags
+			if session.client == user {
+				tagsToSend = sessionTags
+			}
 			// don't send TAGMSG at all if they don't have the tags cap
 			if histType == history.Tagmsg && hasTagsCap {
-				session.sendFromClientInternal(false, message.Time, message.Msgid, nickMaskString, accountName, isBot, tags, command, tnick)
+				session.sendFromClientInternal(false, message.Time, message.Msgid, nickMaskString, accountName, isBot, tagsToSend, command, tnick)
 			} else if histType != history.Tagmsg && !(session.isTor && message.IsRestrictedCTCPMessage()) {
-				tagsToSend := tags
 				if !hasTagsCap {
 					tagsToSend = nil
 				}
@@ -2323,7 +2711,7 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		}
 
 		config := server.Config()
-		if !config.History.Enabled {
+		if !config.History.Enabled || ignored {
 			return
 		}
 		item := history.Item{
@@ -2521,7 +2909,7 @@ func passHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 			if strudelIndex := strings.IndexByte(account, '@'); strudelIndex != -1 {
 				account, rb.session.deviceID = account[:strudelIndex], account[strudelIndex+1:]
 			}
-			err := server.accounts.AuthenticateByPassphrase(client, account, accountPass)
+			err := server.accounts.AuthenticateByPassphrase(client, account, accountPass, rb.session.certfp)
 			if err == nil {
 				sendSuccessfulAccountAuth(nil, client, rb, true)
 				// login-via-pass-command entails that we do not need to check
@@ -2587,15 +2975,18 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	case "*", accountName:
 		// ok
 	default:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "ACCOUNTNAME_MUST_BE_NICK", utils.SafeErrorParam(msg.Params[0]), client.t("You may only register your nickname as your account name"))
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "ACCOUNT_NAME_MUST_BE_NICK", utils.SafeErrorParam(msg.Params[0]), client.t("You may only register your nickname as your account name"))
 		return
 	}
 
 	// check that accountName is valid as a non-final parameter;
 	// this is necessary for us to be valid and it will prevent us from emitting invalid error lines
 	nickErrorParam := utils.SafeErrorParam(accountName)
-	if accountName == "*" || accountName != nickErrorParam {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_USERNAME", nickErrorParam, client.t("Username invalid or not given"))
+	if accountName == "*" {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "NEED_NICK", nickErrorParam, client.t("You must set a nickname before registering"))
+		return
+	} else if accountName != nickErrorParam {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "BAD_ACCOUNT_NAME", nickErrorParam, client.t("Username invalid or not given"))
 		return
 	}
 
@@ -2608,11 +2999,24 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 		rb.Add(nil, server.name, "FAIL", "REGISTER", "COMPLETE_CONNECTION_REQUIRED", accountName, client.t("You must complete the connection before registering your account"))
 		return
 	}
-	if client.registerCmdSent || client.Account() != "" {
+	if client.Account() != "" {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "ALREADY_AUTHENTICATED", accountName, client.t("You're already logged into an account"))
+		return
+	}
+	if client.registerCmdSent {
 		rb.Add(nil, server.name, "FAIL", "REGISTER", "ALREADY_REGISTERED", accountName, client.t("You have already registered or attempted to register"))
 		return
 	}
 
+	if err := config.Accounts.Registration.NamePolicy.Check(accountName); err != nil {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "BAD_ACCOUNT_NAME", accountName, client.t(err.Error()))
+		return
+	}
+	if err := config.Accounts.Registration.PasswordPolicy.Check(msg.Params[2]); err != nil {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "WEAK_PASSWORD", accountName, err.(*passwordPolicyError).describe(client.t))
+		return
+	}
+
 	callbackNamespace, callbackValue, err := parseCallback(msg.Params[1], config)
 	if err != nil {
 		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_EMAIL", accountName, client.t("A valid e-mail address is required"))
@@ -2645,9 +3049,9 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 			announcePendingReg(client, rb, accountName)
 		}
 	case errAccountAlreadyRegistered, errAccountAlreadyUnregistered, errAccountMustHoldNick:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "USERNAME_EXISTS", accountName, client.t("Username is already registered or otherwise unavailable"))
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "ACCOUNT_EXISTS", accountName, client.t("Username is already registered or otherwise unavailable"))
 	case errAccountBadPassphrase:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_PASSWORD", accountName, client.t("Password was invalid"))
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "UNACCEPTABLE_PASSWORD", accountName, client.t("Password was invalid"))
 	default:
 		if emailError := registrationCallbackErrorText(config, client, err); emailError != "" {
 			rb.Add(nil, server.name, "FAIL", "REGISTER", "UNACCEPTABLE_EMAIL", accountName, emailError)
@@ -2670,7 +3074,7 @@ func verifyHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		return
 	}
 	if client.Account() != "" {
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "ALREADY_REGISTERED", client.t("You have already registered or attempted to register"))
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "ALREADY_AUTHENTICATED", client.t("You're already logged into an account"))
 		return
 	}
 
@@ -2703,15 +3107,34 @@ func verifyHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 // REHASH
 func rehashHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	nick := client.Nick()
-	server.logger.Info("server", "REHASH command used by", nick)
-	err := server.rehash()
+
+	var scope string
+	if len(msg.Params) != 0 {
+		scope = strings.ToUpper(msg.Params[0])
+	}
+	var err error
+	switch scope {
+	case "":
+		server.logger.Info("server", "REHASH command used by", nick)
+		err = server.rehash()
+	case "MOTD":
+		server.logger.Info("server", "REHASH MOTD command used by", nick)
+		err = server.rehashMOTD()
+	default:
+		rb.Add(nil, server.name, "FAIL", "REHASH", "INVALID_PARAMS", utils.SafeErrorParam(msg.Params[0]), client.t("Unknown rehash scope"))
+		return false
+	}
 
 	if err == nil {
-		// we used to send RPL_REHASHING here but i don't think it really makes sense
-		// in the labeled-response world, since the intent is "rehash in progress" but
-		// it won't display until the rehash is actually complete
-		// TODO all operators should get a notice of some kind here
-		rb.Notice(client.t("Rehash complete"))
+		// RPL_REHASHING's intent is "rehash in progress", but in the labeled-response
+		// world it won't display until the rehash is actually complete, so we send it
+		// afterwards, as a confirmation:
+		rb.Add(nil, server.name, RPL_REHASHING, nick, server.configFilename, client.t("Rehash complete"))
+		description := "the server configuration"
+		if scope == "MOTD" {
+			description = "the MOTD"
+		}
+		server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%[1]s$c[grey]] rehashed %[2]s"), nick, description))
 	} else {
 		rb.Add(nil, server.name, ERR_UNKNOWNERROR, nick, "REHASH", err.Error())
 	}
@@ -2846,11 +3269,7 @@ func renameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	}
 
 	config := server.Config()
-	status, _, _ := channel.historyStatus(config)
-	if status == HistoryPersistent {
-		rb.Add(nil, server.name, "FAIL", "RENAME", "CANNOT_RENAME", oldName, utils.SafeErrorParam(newName), client.t("Channels with persistent history cannot be renamed"))
-		return false
-	}
+	status, oldTarget, _ := channel.historyStatus(config)
 
 	// perform the channel rename
 	err := server.channels.Rename(oldName, newName)
@@ -2865,6 +3284,15 @@ func renameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		return false
 	}
 
+	// move persistent history to the new name, for continuity
+	if status == HistoryPersistent {
+		if _, newTarget, _ := channel.historyStatus(config); newTarget != oldTarget {
+			if err := server.historyDB.RenameTarget(oldTarget, newTarget); err != nil {
+				server.logger.Error("internal", "couldn't migrate history for renamed channel", oldTarget, newTarget, err.Error())
+			}
+		}
+	}
+
 	// send RENAME messages
 	clientPrefix := client.NickMaskString()
 	for _, mcl := range channel.Members() {
@@ -2940,19 +3368,96 @@ func setnameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 		return false
 	}
 
+	dispatchSetname(client, realname, rb)
+
+	// persist the new realname for future connections to the account
+	if account := client.Account(); account != "" {
+		server.accounts.ModifyAccountSettings(account, func(in AccountSettings) (out AccountSettings, err error) {
+			out = in
+			out.Realname = realname
+			return
+		})
+	}
+	return false
+}
+
+// dispatchSetname changes the client's realname and notifies all of its sessions
+// and friends; rb may be nil if the change didn't originate from the client.
+func dispatchSetname(client *Client, realname string, rb *ResponseBuffer) {
 	client.SetRealname(realname)
 	details := client.Details()
 
 	// alert friends
 	now := time.Now().UTC()
 	friends := client.FriendsMonitors(caps.SetName)
-	delete(friends, rb.session)
+	var originSession *Session
+	if rb != nil && rb.session.client == client {
+		originSession = rb.session
+		delete(friends, originSession)
+	}
 	isBot := client.HasMode(modes.Bot)
 	for session := range friends {
 		session.sendFromClientInternal(false, now, "", details.nickMask, details.accountName, isBot, nil, "SETNAME", details.realname)
 	}
+	// without the cap, channel members see a quit and rejoin instead
+	client.sendSetnameFallback()
 	// respond to the user unconditionally, even if they don't have the cap
-	rb.AddFromClient(now, "", details.nickMask, details.accountName, isBot, nil, "SETNAME", details.realname)
+	if originSession != nil {
+		rb.AddFromClient(now, "", details.nickMask, details.accountName, isBot, nil, "SETNAME", details.realname)
+	}
+}
+
+// SILENCE [{+|-}<mask>]
+func silenceHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	account := client.Account()
+	if account == "" {
+		rb.Add(nil, server.name, "FAIL", "SILENCE", "ACCOUNT_REQUIRED", client.t("You must be logged in to use SILENCE"))
+		return false
+	}
+
+	if len(msg.Params) == 0 {
+		for _, entry := range client.AccountSettings().Ignores {
+			rb.Add(nil, server.name, RPL_SILELIST, nick, nick, entry)
+		}
+		rb.Add(nil, server.name, RPL_ENDOFSILELIST, nick, client.t("End of Silence List"))
+		return false
+	}
+
+	// a mask without a prefix is added, as in ircu
+	mask := msg.Params[0]
+	add := true
+	if strings.HasPrefix(mask, "+") {
+		mask = mask[1:]
+	} else if strings.HasPrefix(mask, "-") {
+		add = false
+		mask = mask[1:]
+	}
+	var entry string
+	var err error
+	if mask != "" {
+		entry, err = canonicalizeIgnoreEntry(mask)
+	}
+	if mask == "" || err != nil {
+		rb.Add(nil, server.name, "FAIL", "SILENCE", "INVALID_MASK", utils.SafeErrorParam(mask), client.t("Invalid mask"))
+		return false
+	}
+
+	_, err = server.accounts.ModifyAccountSettings(account, ignoreListMunger(add, entry, server.Config().Accounts.MaxIgnores))
+	switch err {
+	case nil:
+		op := "+"
+		if !add {
+			op = "-"
+		}
+		rb.Add(nil, client.NickMaskString(), "SILENCE", op+entry)
+	case errNoop:
+		// removing an entry that isn't on the list; nothing to do
+	case errLimitExceeded:
+		rb.Add(nil, server.name, ERR_SILELISTFULL, nick, entry, client.t("Your silence list is full"))
+	default:
+		rb.Add(nil, server.name, "FAIL", "SILENCE", "UNKNOWN_ERROR", client.t("An error occurred"))
+	}
 	return false
 }
 
@@ -2962,6 +3467,84 @@ func summonHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	return false
 }
 
+// TEMPBAN [ANDKILL] <nick|mask> <duration> [reason [| oper reason]]
+func tempbanHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	params := msg.Params
+	var andKill bool
+	if strings.ToLower(params[0]) == "andkill" {
+		andKill = true
+		params = params[1:]
+	}
+	if len(params) < 2 {
+		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.Nick(), msg.Command, client.t("Not enough parameters"))
+		return false
+	}
+
+	target, durationStr := params[0], params[1]
+	if duration, err := custime.ParseDuration(durationStr); err != nil || duration <= 0 {
+		rb.Add(nil, server.name, "FAIL", "TEMPBAN", "INVALID_DURATION", utils.SafeErrorParam(durationStr), client.t("Invalid duration"))
+		return false
+	}
+	mask := target
+	if !strings.ContainsAny(target, "!@*?") {
+		if targetClient := server.clients.Get(target); targetClient != nil {
+			mask = "*!*@" + targetClient.IPString()
+		}
+	}
+
+	// the rest is a K-Line with a mandatory duration
+	klineParams := []string{durationStr, mask}
+	if andKill {
+		klineParams = append([]string{"ANDKILL"}, klineParams...)
+	}
+	klineParams = append(klineParams, params[2:]...)
+	return klineHandler(server, client, ircmsg.Message{Command: "KLINE", Params: klineParams}, rb)
+}
+
+// TESTLINE <nick!user@host>
+func testlineHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick, username, hostname, err := parseTestlineMask(msg.Params[0])
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "TESTLINE", "INVALID_PARAMS", utils.SafeErrorParam(msg.Params[0]), client.t("Invalid nickmask; it must be of the form nick!user@host"))
+		return false
+	}
+
+	// same order as connection time: D-Lines against the IP, then K-Lines
+	if ip, err := flatip.ParseIP(hostname); err == nil {
+		if isBanned, info := server.dlines.CheckIP(ip); isBanned {
+			rb.Notice(fmt.Sprintf(client.t("%[1]s matches a D-Line: %[2]s"), msg.Params[0], formatBanForListing(client, ip.String(), info)))
+			return false
+		}
+	}
+	nickmask := fmt.Sprintf("%s!%s@%s", nick, username, hostname)
+	if isBanned, klineMask, info := server.klines.MatchMasks(nickmask); isBanned {
+		rb.Notice(fmt.Sprintf(client.t("%[1]s matches a K-Line: %[2]s"), msg.Params[0], formatBanForListing(client, klineMask, info)))
+		return false
+	}
+	rb.Notice(fmt.Sprintf(client.t("%s does not match any D-Lines or K-Lines"), msg.Params[0]))
+	return false
+}
+
+// parseTestlineMask splits a nick!user@host into its components,
+// casefolded the same way as the nickmasks of connecting clients.
+func parseTestlineMask(mask string) (nick, username, hostname string, err error) {
+	bang := strings.IndexByte(mask, '!')
+	at := strings.LastIndexByte(mask, '@')
+	if bang == -1 || at == -1 || at < bang {
+		return "", "", "", errInvalidParams
+	}
+	nick, err = CasefoldName(mask[:bang])
+	if err != nil {
+		return
+	}
+	username = strings.ToLower(mask[bang+1 : at])
+	hostname = strings.ToLower(mask[at+1:])
+	if username == "" || hostname == "" {
+		return "", "", "", errInvalidParams
+	}
+	return
+}
+
 // TIME
 func timeHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	rb.Add(nil, server.name, RPL_TIME, client.nick, server.name, time.Now().UTC().Format(time.RFC1123))
@@ -3066,7 +3649,7 @@ func userHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		if colonIndex := strings.IndexByte(username, ':'); colonIndex != -1 {
 			var password string
 			username, password = username[:colonIndex], username[colonIndex+1:]
-			err := server.accounts.AuthenticateByPassphrase(client, username, password)
+			err := server.accounts.AuthenticateByPassphrase(client, username, password, rb.session.certfp)
 			if err == nil {
 				sendSuccessfulAccountAuth(nil, client, rb, true)
 			} else {
@@ -3150,6 +3733,64 @@ func userhostHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	return false
 }
 
+// USERIP <nickname>{ <nickname>}
+func useripHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	hasPrivs := client.HasMode(modes.Operator)
+	// same privilege as RPL_WHOISACTUALLY
+	canSeeIPs := client.HasRoleCapabs("ban")
+	returnedClients := make(ClientSet)
+
+	var tl utils.TokenLineBuilder
+	tl.Initialize(400, " ")
+	for i, nickname := range msg.Params {
+		if i >= 10 {
+			break
+		}
+
+		target := server.clients.Get(nickname)
+		if target == nil {
+			continue
+		}
+		if target != client && !canSeeIPs {
+			rb.Add(nil, server.name, ERR_NOPRIVILEGES, client.Nick(), client.t("Permission Denied"))
+			return false
+		}
+		if returnedClients.Has(target) {
+			continue
+		}
+		returnedClients.Add(target)
+
+		var isOper, isAway string
+		if operStatusVisible(client, target, hasPrivs) {
+			isOper = "*"
+		}
+		if away, _ := target.Away(); away {
+			isAway = "-"
+		} else {
+			isAway = "+"
+		}
+		var ip net.IP
+		if target == client {
+			ip = rb.session.IP()
+		} else {
+			ip, _ = target.getWhoisActually()
+		}
+		details := target.Details()
+		tl.Add(fmt.Sprintf("%s%s=%s%s@%s", details.nick, isOper, isAway, details.username, utils.IPStringToHostname(ip.String())))
+	}
+
+	lines := tl.Lines()
+	if lines == nil {
+		lines = []string{""}
+	}
+	nick := client.Nick()
+	for _, line := range lines {
+		rb.Add(nil, client.server.name, RPL_USERIP, nick, line)
+	}
+
+	return false
+}
+
 // USERS [parameters]
 func usersHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	rb.Add(nil, server.name, ERR_USERSDISABLED, client.Nick(), client.t("USERS has been disabled"))
@@ -3163,6 +3804,78 @@ func versionHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	return false
 }
 
+// WATCH [+nick|-nick|C|S|l|L ...]
+// legacy alternative to MONITOR; see the documentation for UnrealIRCd's WATCH
+func watchHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	limit := server.Config().Limits.WatchEntries
+
+	// sends RPL_NOWON or RPL_NOWOFF (or the supplied variants) for a nick
+	sendStatus := func(target, onNumeric, onMessage, offNumeric, offMessage string) {
+		if iclient := server.clients.Get(target); iclient != nil {
+			details := iclient.Details()
+			rb.Add(nil, server.name, onNumeric, nick, details.nick, details.username, details.hostname, strconv.FormatInt(iclient.ctime.Unix(), 10), client.t(onMessage))
+		} else {
+			rb.Add(nil, server.name, offNumeric, nick, target, "*", "*", "0", client.t(offMessage))
+		}
+	}
+
+	var params []string
+	for _, param := range msg.Params {
+		params = append(params, strings.FieldsFunc(param, func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+	if len(params) == 0 {
+		params = []string{"l"}
+	}
+
+	for _, param := range params {
+		switch param[0] {
+		case '+':
+			target := param[1:]
+			if target == "" || len(target) > server.Config().Limits.NickLen {
+				continue
+			}
+			err := server.monitorManager.Add(rb.session, monitorListWatch, target, limit)
+			if err == errMonitorLimitExceeded {
+				rb.Add(nil, server.name, ERR_TOOMANYWATCH, nick, target, fmt.Sprintf(client.t("Maximum size for WATCH-list is %d entries"), limit))
+			} else if err == nil {
+				sendStatus(target, RPL_NOWON, "is online", RPL_NOWOFF, "is offline")
+			}
+		case '-':
+			target := param[1:]
+			if target == "" {
+				continue
+			}
+			server.monitorManager.Remove(rb.session, monitorListWatch, target)
+			sendStatus(target, RPL_WATCHOFF, "stopped watching", RPL_WATCHOFF, "stopped watching")
+		case 'C', 'c':
+			server.monitorManager.Clear(rb.session, monitorListWatch)
+			rb.Add(nil, server.name, RPL_CLEARWATCH, nick, client.t("Your WATCH list is now empty"))
+		case 'S', 's':
+			watchList := server.monitorManager.List(rb.session, monitorListWatch)
+			watchers := server.monitorManager.CountWatchers(client.NickCasefolded(), monitorListWatch)
+			rb.Add(nil, server.name, RPL_WATCHSTAT, nick, fmt.Sprintf(client.t("You have %[1]d and are on %[2]d WATCH entries"), len(watchList), watchers))
+			sort.Strings(watchList)
+			for _, line := range utils.BuildTokenLines(maxLastArgLength, watchList, " ") {
+				rb.Add(nil, server.name, RPL_WATCHLIST, nick, line)
+			}
+			rb.Add(nil, server.name, RPL_ENDOFWATCHLIST, nick, "S", client.t("End of WATCH S"))
+		case 'l', 'L':
+			// l lists the online entries, L lists the offline entries as well
+			watchList := server.monitorManager.List(rb.session, monitorListWatch)
+			sort.Strings(watchList)
+			for _, target := range watchList {
+				if param[0] == 'L' || server.clients.Get(target) != nil {
+					sendStatus(target, RPL_NOWON, "is online", RPL_NOWOFF, "is offline")
+				}
+			}
+			rb.Add(nil, server.name, RPL_ENDOFWATCHLIST, nick, param[:1], fmt.Sprintf(client.t("End of WATCH %s"), param[:1]))
+		}
+	}
+
+	return false
+}
+
 // WEBIRC <password> <gateway> <hostname> <ip> [:flag1 flag2=x flag3]
 func webircHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	// only allow unregistered clients to use this command
@@ -3209,6 +3922,10 @@ func webircHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 				client.Quit(quitMsg, rb.session)
 				return true
 			} else {
+				// retain the gateway details (the real IP is kept as well) for auditing
+				gateway := utils.SafeErrorParam(strings.ToValidUTF8(msg.Params[1], "\uFFFD"))
+				client.setWebIRCGateway(gateway)
+				server.logger.Info("connect-ip", "Accepted WEBIRC from gateway", gateway, client.realIP.String(), "for client IP", msg.Params[3])
 				return false
 			}
 		}
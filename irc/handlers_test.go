@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestFormatKillMessage(t *testing.T) {
+	assertEqual(formatKillMessage("Killed ({oper} ({reason}))", "alice", "spam"), "Killed (alice (spam))", t)
+	assertEqual(formatKillMessage("{reason} [{oper}]", "alice", "{oper}"), "{oper} [alice]", t)
+}
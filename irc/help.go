@@ -40,13 +40,23 @@ var (
 Ergo supports the following channel modes:
 
   +b  |  Client masks that are banned from the channel (e.g. *!*@127.0.0.1)
+         Extended bans: $a:account matches users logged into the account ($a:
+         matches all logged-in users), $r:*text* matches realnames, and an
+         m: prefix (e.g. m:$a:account) mutes instead of banning. These also
+         work with +e and +I.
   +e  |  Client masks that are exempted from bans.
   +I  |  Client masks that are exempted from the invite-only flag.
   +i  |  Invite-only mode, only invited clients can join the channel.
   +k  |  Key required when joining the channel.
   +l  |  Client join limit for the channel.
-  +f  |  Users who are unable to join this channel (due to another mode) are forwarded
-         to the provided channel instead.
+  +f  |  Users who are unable to join this channel (due to +i, +l, +k, or +b) are
+         forwarded to the provided channel instead. Setting +f requires operator
+         privileges in the target channel, unless the target channel is +F.
+  +F  |  Anyone can set +f to forward users to this channel.
+  +j  |  Join throttle, in the format n:t (at most n joins every t seconds). When
+         exceeded, the channel is temporarily protected (e.g. with +i).
+  +d  |  Join delay, in seconds: users can't send messages to the channel until
+         they have been in it for this long (channel operators are exempt).
   +m  |  Moderated mode, only privileged clients can talk on the channel.
   +n  |  No-outside-messages mode, only users that are on the channel can send
       |  messages to it.
@@ -60,6 +70,11 @@ Ergo supports the following channel modes:
          from unvoiced clients.
   +U  |  Op-moderated mode: messages from unprivileged clients are sent
          only to channel operators.
+  +H  |  History is not automatically replayed to joining clients; it can
+         still be requested explicitly (e.g. with CHATHISTORY).
+  +P  |  Permanent mode (opers only): the channel's topic, modes, and lists are
+         saved, and it persists while empty and across restarts, without being
+         registered. Registering the channel removes +P.
 
 = Prefixes =
 
@@ -88,6 +103,8 @@ Ergo supports the following server notice masks for operators:
   a  |  Local announcements.
   c  |  Local client connections.
   d  |  Local client disconnects.
+  f  |  Local flood protection events (channel floods, join throttles,
+         and rejected connections).
   j  |  Local channel actions.
   k  |  Local kills.
   n  |  Local nick changes.
@@ -104,12 +121,21 @@ To set a snomask, do this with your nickname:
 
 For instance, this would set the kill, oper, account and xline snomasks on dan:
 
-  /MODE dan +s koux`
+  /MODE dan +s koux
+
+Subscribing to snomasks requires the "snomasks" or "ban" operator capability;
+alternatively, "accreg" allows the account snomask, and "vhosts" the vhost
+snomask. Masks you are not privileged for are ignored.`
 )
 
 // Help contains the help strings distributed with the IRCd.
 var Help = map[string]HelpEntry{
 	// Commands
+	"admin": {
+		text: `ADMIN [server]
+
+Shows contact information for the administrator of the server.`,
+	},
 	"ambiance": {
 		text: `AMBIANCE <target> <text to be sent>
 
@@ -125,7 +151,9 @@ http://ircv3.net/specs/extensions/sasl-3.1.html`,
 		text: `AWAY [message]
 
 If [message] is sent, marks you away. If [message] is not sent, marks you no
-longer away.`,
+longer away. With the draft/pre-away capability, AWAY may be sent before
+connection registration; a [message] of * marks you away without a message,
+so that reattaching to an always-on client does not mark it as back.`,
 	},
 	"batch": {
 		text: `BATCH {+,-}reference-tag type [params...]
@@ -139,6 +167,16 @@ issue this command manually.`,
 Used in capability negotiation. See the IRCv3 specs for more info:
 http://ircv3.net/specs/core/capability-negotiation-3.1.html
 http://ircv3.net/specs/core/capability-negotiation-3.2.html`,
+	},
+	"challenge": {
+		text: `CHALLENGE <name>
+CHALLENGE +<response>
+
+CHALLENGE gives you IRCop privs without sending a password, if the oper
+block has a public key configured. The server replies with a random value,
+encrypted with the public key (RSA-OAEP with SHA-1) and base64-encoded.
+Decrypt it with your private key and send the base64-encoded result back
+with CHALLENGE +<response>.`,
 	},
 	"chathistory": {
 		text: `CHATHISTORY [params]
@@ -146,6 +184,31 @@ http://ircv3.net/specs/core/capability-negotiation-3.2.html`,
 CHATHISTORY is a history replay command associated with the IRCv3
 chathistory extension. See this document:
 https://ircv3.net/specs/extensions/chathistory`,
+	},
+	"chghost": {
+		oper: true,
+		text: `CHGHOST <nick> <username> <hostname>
+
+Changes the username and hostname displayed for the given user, without
+disconnecting them. The hostname lasts until the user's vhost changes (e.g.,
+when they log into an account with a HostServ vhost).`,
+	},
+	"clones": {
+		oper: true,
+		text: `CLONES [min]
+
+Lists the IPs with at least [min] (default 2) simultaneous connections, with
+the nickname and account of each connection. This is useful for identifying
+botnets and clone floods.`,
+	},
+	"connlimit": {
+		oper: true,
+		text: `CONNLIMIT LIST
+
+Lists the custom connection limit blocks from the server config (the
+server.ip-limits.custom-limits section), with the current number of
+connections and recent connection attempts from each. When an IP is contained
+in nets from more than one block, the most specific net applies.`,
 	},
 	"debug": {
 		oper: true,
@@ -217,6 +280,17 @@ To remove a DLINE, use the "UNDLINE" command.`,
 		text: `EXTJWT <target> [service_name]
 
 Get a JSON Web Token for target (either * or a channel name).`,
+	},
+	"gline": {
+		oper: true,
+		text: `GLINE [ANDKILL] [MYSELF] [duration] <mask> [ON <server>] [reason [| oper reason]]
+GLINE LIST
+
+GLINE is an alias for KLINE: since Ergo is not linked to other servers, a
+K-Line already applies to the whole network. "GLINE LIST" lists the current
+bans, with their reasons and the time left before they expire.
+
+To remove a GLINE, use the "UNGLINE" command.`,
 	},
 	"help": {
 		text: `HELP <argument>
@@ -290,9 +364,11 @@ from. If "MYSELF" is not given, trying to KLINE yourself will result in an error
 [duration] can be of the following forms:
 	1y 12mo 31d 10h 8m 13s
 
-<mask> is specified in typical IRC format. For example:
+<mask> is specified in typical IRC format. The host can also be a CIDR
+network, which matches clients by IP address. For example:
 	dan
 	dan!5*@127.*
+	*!*@2001:db8::/32
 
 ON <server> specifies that the ban is to be set on that specific server.
 
@@ -306,6 +382,13 @@ To remove a KLINE, use the "UNKLINE" command.`,
 		text: `LANGUAGE <code>{ <code>}
 
 Sets your preferred languages to the given ones.`,
+	},
+	"links": {
+		text: `LINKS [[<remote server>] <server mask>]
+
+Lists the servers linked to this one, along with their hop counts and
+descriptions. If <server mask> is given, only servers matching it are shown.
+Ergo does not link to other servers, so only this server is listed.`,
 	},
 	"list": {
 		text: `LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
@@ -347,6 +430,35 @@ Lists all the nicknames you are currently monitoring.
 
     MONITOR S
 Lists whether each nick in your MONITOR list is online or offline.`,
+	},
+	"metadata": {
+		text: `METADATA <target> <subcommand> [<params>]
+
+Views or modifies key-value metadata attached to users and channels (the
+draft/metadata-2 capability). <target> is a nickname, a channel, or * for
+yourself. The subcommands are:
+
+    METADATA <target> GET <key> [<key>...]
+Displays the values of the given keys.
+
+    METADATA <target> LIST
+Displays all keys and values set on the target.
+
+    METADATA <target> SET <key> [<value>]
+Sets the key to the given value, or unsets it if no value is given. You can
+modify your own metadata, and channel operators can modify the channel's.
+
+    METADATA <target> CLEAR
+Unsets all keys on the target.
+
+    METADATA * SUB <key> [<key>...]
+    METADATA * UNSUB <key> [<key>...]
+    METADATA * SUBS
+Manages the keys you are subscribed to; you will be notified of changes to
+subscribed keys for users and channels you share.
+
+    METADATA <target> SYNC
+Sends all subscribed keys for the target.`,
 	},
 	"motd": {
 		text: `MOTD [server]
@@ -462,7 +574,18 @@ The SCENE command is used to send a scene notification to the given target.`,
 	"setname": {
 		text: `SETNAME <realname>
 
-The SETNAME command updates the realname to be the newly-given one.`,
+The SETNAME command updates the realname to be the newly-given one. Channel members
+whose clients don't support the setname capability see you quit and rejoin
+instead.`,
+	},
+	"silence": {
+		text: `SILENCE [{+|-}<mask>]
+
+SILENCE adds (+) or removes (-) a mask from your server-side ignore list;
+direct messages, notices, and invites from matching users are dropped.
+Masks are either nick!user@host masks or $a:account. With no arguments,
+SILENCE shows the list. The list is stored with your account (it's the same
+list as /NS SET IGNORE), so you must be logged in to use it.`,
 	},
 	"summon": {
 		text: `SUMMON [parameters]
@@ -487,9 +610,33 @@ Registers an account in accordance with the draft/account-registration capabilit
 	},
 	"rehash": {
 		oper: true,
-		text: `REHASH
+		text: `REHASH [MOTD]
+
+Reloads the config file and updates TLS certificates on listeners. With MOTD,
+reloads only the MOTD file.
+
+Some settings cannot be changed without a restart; if they have changed, the
+rehash is aborted. These include the server name, the datastore path, the
+casemapping, UTF-8 enforcement, and max-line-len.`,
+	},
+	"tempban": {
+		oper: true,
+		text: `TEMPBAN [ANDKILL] <nick | mask> <duration> [reason [| oper reason]]
 
-Reloads the config file and updates TLS certificates on listeners`,
+Adds a temporary K-Line, which is removed automatically after the given
+duration (e.g., 30m, 12h, or 7d). If a nickname of a connected client is
+given, the client's IP address is banned; otherwise the argument is a mask
+as for KLINE. "ANDKILL" means that all matching clients are also removed from
+the server. See /HELPOP KLINE for more information.`,
+	},
+	"testline": {
+		oper: true,
+		text: `TESTLINE <nick!user@host>
+
+Shows which ban, if any, would prevent a client with the given nickmask from
+connecting, without applying it. If the host is an IP address, D-Lines are
+checked first, followed by K-Lines (as at connection time). Ergo has no
+separate X-Lines.`,
 	},
 	"time": {
 		text: `TIME [server]
@@ -540,11 +687,23 @@ For example:
 
 Used in connection registration, sets your username and realname to the given
 values (though your username may also be looked up with Ident).`,
+	},
+	"ungline": {
+		oper: true,
+		text: `UNGLINE <mask>
+
+UNGLINE is an alias for UNKLINE: it removes an existing ban on a mask.`,
 	},
 	"uninvite": {
 		text: `UNINVITE <nickname> <channel>
 
 UNINVITE rescinds a channel invitation sent for an invite-only channel.`,
+	},
+	"userip": {
+		text: `USERIP <nickname>{ <nickname>}
+
+Shows the real IP addresses of the given users. Takes up to 10 nicknames.
+Without operator privileges, you can only look up your own IP address.`,
 	},
 	"users": {
 		text: `USERS [parameters]
@@ -565,6 +724,21 @@ Verifies an account in accordance with the draft/account-registration capability
 		text: `VERSION [server]
 
 Views the version of software and the RPL_ISUPPORT tokens for the given server.`,
+	},
+	"watch": {
+		text: `WATCH [+nick|-nick|C|S|l|L ...]
+
+WATCH is a legacy alternative to MONITOR, for clients that don't support it.
+The parameters are:
+
+    +nick   adds the nickname to your WATCH list
+    -nick   removes the nickname from your WATCH list
+    C       clears your WATCH list
+    S       shows the status of your WATCH list
+    l       lists the online nicknames on your WATCH list
+    L       lists all nicknames on your WATCH list
+
+Your WATCH list is separate from your MONITOR list.`,
 	},
 	"webirc": {
 		oper: true, // not really, but it's restricted anyways
@@ -0,0 +1,255 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package history
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidExportFormat = errors.New("Invalid export format")
+)
+
+// ExportFormat is the file format for an account data export:
+// one of json, json.gz, csv, or csv.gz.
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json"
+)
+
+func ParseExportFormat(format string) (result ExportFormat, err error) {
+	switch strings.ToLower(format) {
+	case "json", "json.gz", "csv", "csv.gz":
+		return ExportFormat(strings.ToLower(format)), nil
+	default:
+		return "", ErrInvalidExportFormat
+	}
+}
+
+// Extension is the file extension for the format, e.g. `.csv.gz`
+func (format ExportFormat) Extension() string {
+	return "." + string(format)
+}
+
+// ExportFormatFromFilename infers the format of an export file from its extension
+func ExportFormatFromFilename(filename string) (result ExportFormat, err error) {
+	for _, format := range []string{"json.gz", "csv.gz", "json", "csv"} {
+		if strings.HasSuffix(strings.ToLower(filename), "."+format) {
+			return ExportFormat(format), nil
+		}
+	}
+	return "", ErrInvalidExportFormat
+}
+
+func (format ExportFormat) compressed() bool {
+	return strings.HasSuffix(string(format), ".gz")
+}
+
+func (format ExportFormat) csv() bool {
+	return strings.HasPrefix(string(format), "csv")
+}
+
+var csvHeader = []string{"target", "time", "msgid", "type", "nick", "account", "message"}
+
+var itemTypeNames = map[ItemType]string{
+	Privmsg: "PRIVMSG",
+	Notice:  "NOTICE",
+	Join:    "JOIN",
+	Part:    "PART",
+	Kick:    "KICK",
+	Quit:    "QUIT",
+	Mode:    "MODE",
+	Tagmsg:  "TAGMSG",
+	Nick:    "NICK",
+	Topic:   "TOPIC",
+	Invite:  "INVITE",
+}
+
+// Exporter serializes history items in an ExportFormat.
+type Exporter struct {
+	out       *bufio.Writer
+	gzipper   *gzip.Writer
+	sink      io.Writer // out, or gzipper if compressing
+	csvWriter *csv.Writer
+}
+
+// NewExporter wraps a writer; compressionLevel is used for the .gz formats
+// (0 for the gzip default). Close must be called to finish the output.
+func NewExporter(writer io.Writer, format ExportFormat, compressionLevel int) (exporter *Exporter, err error) {
+	if compressionLevel == 0 {
+		compressionLevel = gzip.DefaultCompression
+	}
+	exporter = new(Exporter)
+	exporter.out = bufio.NewWriter(writer)
+	exporter.sink = exporter.out
+	if format.compressed() {
+		exporter.gzipper, err = gzip.NewWriterLevel(exporter.out, compressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		exporter.sink = exporter.gzipper
+	}
+	if format.csv() {
+		exporter.csvWriter = csv.NewWriter(exporter.sink)
+		err = exporter.csvWriter.Write(csvHeader)
+	}
+	return
+}
+
+func (exporter *Exporter) Write(item Item) (err error) {
+	if exporter.csvWriter != nil {
+		return exporter.csvWriter.Write([]string{
+			item.CfCorrespondent,
+			item.Message.Time.UTC().Format(time.RFC3339Nano),
+			item.Message.Msgid,
+			itemTypeNames[item.Type],
+			item.Nick,
+			item.AccountName,
+			messageText(item),
+		})
+	}
+
+	line, err := json.Marshal(item)
+	if err != nil {
+		return
+	}
+	_, err = exporter.sink.Write(append(line, '\n'))
+	return
+}
+
+func messageText(item Item) string {
+	if len(item.Message.Split) == 0 {
+		return item.Message.Message
+	}
+	var buf strings.Builder
+	for i, line := range item.Message.Split {
+		if i != 0 && !line.Concat {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line.Message)
+	}
+	return buf.String()
+}
+
+// Close flushes all output; it doesn't close the underlying writer.
+func (exporter *Exporter) Close() (err error) {
+	if exporter.csvWriter != nil {
+		exporter.csvWriter.Flush()
+		err = exporter.csvWriter.Error()
+	}
+	if exporter.gzipper != nil {
+		if gzErr := exporter.gzipper.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if flushErr := exporter.out.Flush(); err == nil {
+		err = flushErr
+	}
+	return
+}
+
+// ReadExportMsgids reads back the msgids from an export, in order.
+// For JSON, this accepts either one item per line (as written by Exporter)
+// or a single JSON array of items.
+func ReadExportMsgids(reader io.Reader, format ExportFormat) (msgids []string, err error) {
+	if format.compressed() {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	if format.csv() {
+		csvReader := csv.NewReader(reader)
+		csvReader.FieldsPerRecord = len(csvHeader)
+		records, err := csvReader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			// skip the header
+			if i != 0 {
+				msgids = append(msgids, record[2])
+			}
+		}
+		return msgids, nil
+	}
+
+	type exportedItem struct {
+		Message struct {
+			Msgid string
+		}
+	}
+	buffered := bufio.NewReader(reader)
+	// peek at the first non-whitespace byte to distinguish a JSON array
+	for {
+		var b byte
+		b, err = buffered.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		buffered.UnreadByte()
+		if b == '[' {
+			var items []exportedItem
+			if err = json.NewDecoder(buffered).Decode(&items); err != nil {
+				return
+			}
+			for _, item := range items {
+				msgids = append(msgids, item.Message.Msgid)
+			}
+			return
+		}
+		break
+	}
+	decoder := json.NewDecoder(buffered)
+	for {
+		var item exportedItem
+		err = decoder.Decode(&item)
+		if err == io.EOF {
+			return msgids, nil
+		} else if err != nil {
+			return
+		}
+		msgids = append(msgids, item.Message.Msgid)
+	}
+}
+
+// DiffMsgids returns the msgids present in `before` but not `after` (deleted),
+// and those present in `after` but not `before` (inserted), in order.
+func DiffMsgids(before, after []string) (deleted, inserted []string) {
+	beforeSet := make(map[string]bool, len(before))
+	for _, msgid := range before {
+		beforeSet[msgid] = true
+	}
+	afterSet := make(map[string]bool, len(after))
+	for _, msgid := range after {
+		afterSet[msgid] = true
+	}
+	for _, msgid := range before {
+		if !afterSet[msgid] {
+			deleted = append(deleted, msgid)
+		}
+	}
+	for _, msgid := range after {
+		if !beforeSet[msgid] {
+			inserted = append(inserted, msgid)
+		}
+	}
+	return
+}
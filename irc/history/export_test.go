@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"testing"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func exportItems(t *testing.T, format ExportFormat, level int) []byte {
+	first := easyItem("alice", "2006-01-01 15:04:05Z")
+	first.Type = Privmsg
+	first.AccountName = "alice"
+	first.CfCorrespondent = "#ergo"
+	first.Message.Message = "hello, world"
+	first.Message.Msgid = "a"
+	second := easyItem("alice", "2006-01-01 15:04:06Z")
+	second.Type = Notice
+	second.AccountName = "alice"
+	second.CfCorrespondent = "bob"
+	second.Message.Msgid = "b"
+	second.Message.Split = []utils.MessagePair{{Message: "line one"}, {Message: "line \"two\""}, {Message: ", continued", Concat: true}}
+
+	var buf bytes.Buffer
+	exporter, err := NewExporter(&buf, format, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []Item{first, second} {
+		if err := exporter.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	return result
+}
+
+func TestCompressedExport(t *testing.T) {
+	for _, format := range []ExportFormat{"json", "csv"} {
+		plain := exportItems(t, format, 0)
+		for _, level := range []int{0, 1, 9} {
+			compressed := exportItems(t, format+".gz", level)
+			if !bytes.Equal(gunzip(t, compressed), plain) {
+				t.Errorf("decompressed %s export (level %d) doesn't match uncompressed export", format, level)
+			}
+		}
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(exportItems(t, "csv", 0))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(records), 3, t)
+	assertEqual(records[0], csvHeader, t)
+	assertEqual(records[1], []string{"#ergo", "2006-01-01T15:04:05Z", "a", "PRIVMSG", "alice", "alice", "hello, world"}, t)
+	assertEqual(records[2][6], "line one\nline \"two\", continued", t)
+}
+
+func TestParseExportFormat(t *testing.T) {
+	format, err := ParseExportFormat("CSV.GZ")
+	assertEqual(err, nil, t)
+	assertEqual(format.Extension(), ".csv.gz", t)
+	_, err = ParseExportFormat("xml")
+	assertEqual(err, ErrInvalidExportFormat, t)
+}
+
+func TestReadExportMsgids(t *testing.T) {
+	for _, format := range []ExportFormat{"json", "json.gz", "csv", "csv.gz"} {
+		msgids, err := ReadExportMsgids(bytes.NewReader(exportItems(t, format, 0)), format)
+		assertEqual(err, nil, t)
+		assertEqual(msgids, []string{"a", "b"}, t)
+	}
+
+	msgids, err := ReadExportMsgids(bytes.NewReader([]byte(` [{"Message": {"Msgid": "x"}}, {"Message": {"Msgid": "y"}}]`)), "json")
+	assertEqual(err, nil, t)
+	assertEqual(msgids, []string{"x", "y"}, t)
+
+	format, err := ExportFormatFromFilename("abc-2006-01-01T15:04:05.000Z.json.gz")
+	assertEqual(err, nil, t)
+	assertEqual(format, ExportFormat("json.gz"), t)
+}
+
+func TestDiffMsgids(t *testing.T) {
+	deleted, inserted := DiffMsgids([]string{"a", "b", "c", "d"}, []string{"a", "c", "e"})
+	assertEqual(deleted, []string{"b", "d"}, t)
+	assertEqual(inserted, []string{"e"}, t)
+}
@@ -374,6 +374,59 @@ func (list *Buffer) Delete(predicate Predicate) (count int) {
 	return
 }
 
+// CountByAccount returns the number of messages (PRIVMSG and NOTICE) in the
+// buffer sent by each account, sorted in descending order of count.
+func (list *Buffer) CountByAccount() (results []Count) {
+	list.RLock()
+	defer list.RUnlock()
+
+	items := list.matchInternal(func(item *Item) bool {
+		return (item.Type == Privmsg || item.Type == Notice) && item.AccountName != "*" && item.AccountName != ""
+	}, true, 0)
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.AccountName]++
+	}
+	results = make([]Count, 0, len(counts))
+	for name, count := range counts {
+		results = append(results, Count{Name: name, Count: count})
+	}
+	SortCounts(results)
+	return
+}
+
+// ActivitySince returns the number of messages (PRIVMSG and NOTICE) and the
+// number of distinct accounts that sent them, since each of the given cutoffs.
+func (list *Buffer) ActivitySince(cutoffs []time.Time) (results []Activity) {
+	list.RLock()
+	defer list.RUnlock()
+
+	results = make([]Activity, len(cutoffs))
+	speakers := make([]map[string]bool, len(cutoffs))
+	for i := range speakers {
+		speakers[i] = make(map[string]bool)
+	}
+	list.matchInternal(func(item *Item) bool {
+		if item.Type != Privmsg && item.Type != Notice {
+			return false
+		}
+		for i, cutoff := range cutoffs {
+			if item.Message.Time.Before(cutoff) {
+				continue
+			}
+			results[i].Messages++
+			if item.AccountName != "*" && item.AccountName != "" {
+				speakers[i][item.AccountName] = true
+			}
+		}
+		return false
+	}, true, 0)
+	for i := range results {
+		results[i].Speakers = len(speakers[i])
+	}
+	return
+}
+
 // latest returns the items most recently added, up to `limit`. If `limit` is 0,
 // it returns all items.
 func (list *Buffer) latest(limit int) (results []Item) {
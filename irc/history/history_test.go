@@ -241,6 +241,25 @@ func TestDisabledByResize(t *testing.T) {
 	assertEqual(len(items), 0, t)
 }
 
+func TestCountByAccount(t *testing.T) {
+	buf := NewHistoryBuffer(16, 0)
+	addItem := func(itemType ItemType, account string) {
+		item := easyItem("testnick", "2006-01-01 15:04:05Z")
+		item.Type = itemType
+		item.AccountName = account
+		buf.Add(item)
+	}
+	addItem(Privmsg, "alice")
+	addItem(Privmsg, "bob")
+	addItem(Notice, "bob")
+	addItem(Join, "alice")
+	addItem(Privmsg, "*")
+	addItem(Privmsg, "carol")
+
+	assertEqual(buf.CountByAccount(), []Count{{"bob", 2}, {"alice", 1}, {"carol", 1}}, t)
+	assertEqual(NewHistoryBuffer(0, 0).CountByAccount(), []Count{}, t)
+}
+
 func BenchmarkInsert(b *testing.B) {
 	buf := NewHistoryBuffer(1024, 0)
 	b.ResetTimer()
@@ -262,3 +281,51 @@ func BenchmarkMatch(b *testing.B) {
 		buf.lookup("512")
 	}
 }
+
+func TestActivitySince(t *testing.T) {
+	buf := NewHistoryBuffer(16, 0)
+	addItem := func(itemType ItemType, account, timestamp string) {
+		item := easyItem("testnick", timestamp)
+		item.Type = itemType
+		item.AccountName = account
+		buf.Add(item)
+	}
+	addItem(Privmsg, "alice", "2006-01-01 15:04:05Z")
+	addItem(Privmsg, "bob", "2006-01-05 15:04:05Z")
+	addItem(Join, "carol", "2006-01-06 15:04:05Z")
+	addItem(Notice, "bob", "2006-01-07 15:04:05Z")
+	addItem(Privmsg, "*", "2006-01-07 16:04:05Z")
+
+	cutoffs := []time.Time{
+		easyParse("2006-01-07 00:00:00Z"),
+		easyParse("2006-01-02 00:00:00Z"),
+		easyParse("2005-12-01 00:00:00Z"),
+	}
+	assertEqual(buf.ActivitySince(cutoffs), []Activity{{2, 1}, {3, 1}, {4, 2}}, t)
+	assertEqual(NewHistoryBuffer(0, 0).ActivitySince(cutoffs[:1]), []Activity{{0, 0}}, t)
+}
+
+func TestSequenceContains(t *testing.T) {
+	buf := NewHistoryBuffer(16, 0)
+	add := func(correspondent, msgid, timestamp string) {
+		item := easyItem("testnick", timestamp)
+		item.CfCorrespondent = correspondent
+		item.Message.Msgid = msgid
+		buf.Add(item)
+	}
+	add("alice", "a0", "2006-01-01 00:00:00Z")
+	add("bob", "b0", "2006-01-01 00:01:00Z")
+	add("alice", "a1", "2006-01-01 00:02:00Z")
+	add("bob", "b1", "2006-01-01 00:03:00Z")
+
+	alice := buf.MakeSequence("alice", time.Time{})
+	for msgid, expected := range map[string]bool{"a0": true, "a1": true, "b0": false, "b1": false, "c0": false} {
+		found, err := SequenceContains(alice, msgid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != expected {
+			t.Errorf("msgid %s: expected %t, got %t", msgid, expected, found)
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package history
 
 import (
+	"sort"
 	"strings"
 	"time"
 )
@@ -48,6 +49,31 @@ func GenericAround(seq Sequence, start Selector, limit int) (results []Item, err
 	return
 }
 
+// SequenceContains returns whether the message with the given msgid belongs to
+// the sequence (e.g., to a particular DM conversation). Looking up a msgid in
+// a Selector doesn't check this by itself, so find the item of the sequence
+// immediately preceding the msgid, then check the items that follow it.
+func SequenceContains(seq Sequence, msgid string) (found bool, err error) {
+	after := time.Unix(0, 0)
+	previous, err := seq.Between(Selector{}, Selector{Msgid: msgid}, 1)
+	if err != nil {
+		return
+	} else if len(previous) != 0 {
+		after = previous[0].Message.Time
+	}
+	// allow for a few items with the same timestamp
+	items, err := seq.Between(Selector{Time: after}, Selector{}, 16)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		if item.HasMsgid(msgid) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // MinMaxAsc converts CHATHISTORY arguments into time intervals, handling the most
 // general case (BETWEEN going forwards or backwards) natively and the other ordering
 // queries (AFTER, BEFORE, LATEST) as special cases.
@@ -91,3 +117,26 @@ func HistservMungeMsgid(msgid string) string {
 func NormalizeMsgid(msgid string) string {
 	return strings.TrimPrefix(msgid, "_")
 }
+
+// Count is the number of history items attributed to some name
+// (e.g., the items sent by an account, or the items stored for a target)
+type Count struct {
+	Name  string
+	Count int
+}
+
+// Activity summarizes the traffic in a target's history since some cutoff time
+type Activity struct {
+	Messages int // PRIVMSG and NOTICE
+	Speakers int // distinct accounts
+}
+
+// SortCounts sorts counts in descending order, breaking ties by name.
+func SortCounts(counts []Count) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+}
@@ -4,10 +4,11 @@
 package irc
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ergochat/ergo/irc/history"
@@ -54,15 +55,30 @@ be necessary to locate the message.`,
 		},
 		"export": {
 			handler: histservExportHandler,
-			help: `Syntax: $bEXPORT <account>$b
+			help: `Syntax: $bEXPORT <account> [format]$b
 
 EXPORT exports all messages sent by an account as JSON. This can be used at
-the request of the account holder.`,
+the request of the account holder. The optional format argument is one of
+json (the default), json.gz, csv, or csv.gz; the .gz formats are compressed
+with gzip.`,
 			helpShort: `$bEXPORT$b exports all messages sent by an account as JSON.`,
 			enabled:   historyComplianceEnabled,
 			capabs:    []string{"history"},
 			minParams: 1,
-			maxParams: 1,
+			maxParams: 2,
+		},
+		"diff": {
+			handler: histservDiffHandler,
+			help: `Syntax: $bDIFF <file1> <file2>$b
+
+DIFF compares two files produced by EXPORT (in the server's output directory),
+listing the messages present in the first but not the second (deleted), and
+those present in the second but not the first (inserted).`,
+			helpShort: `$bDIFF$b compares two history exports.`,
+			enabled:   historyComplianceEnabled,
+			capabs:    []string{"history"},
+			minParams: 2,
+			maxParams: 2,
 		},
 		"play": {
 			handler: histservPlayHandler,
@@ -78,6 +94,55 @@ CHATHISTORY.`,
 			minParams: 1,
 			maxParams: 2,
 		},
+		"stats": {
+			handler: histservStatsHandler,
+			help: `Syntax: $bSTATS CHANNEL <channel>$b
+        $bSTATS ACCOUNT <account>$b
+
+STATS CHANNEL lists the accounts that have sent messages to a channel, in
+descending order of message count. Channel operators can view the statistics
+for their own channels. STATS ACCOUNT (for server administrators only) lists
+the channels an account has sent messages to, with a count for each.`,
+			helpShort: `$bSTATS$b shows message counts from history.`,
+			enabled:   histservEnabled,
+			minParams: 2,
+			maxParams: 2,
+		},
+		"quota": {
+			handler: histservQuotaHandler,
+			help: `Syntax: $bQUOTA$b
+
+QUOTA shows how many of the messages you have sent are stored in history.`,
+			helpShort:    `$bQUOTA$b shows how many of your messages are stored.`,
+			enabled:      histservEnabled,
+			authRequired: true,
+		},
+		"readreceipt": {
+			handler: histservReadReceiptHandler,
+			help: `Syntax: $bREADRECEIPT <nickname> <msgid>$b
+
+READRECEIPT records that you have read your direct messages with the given
+user, up to and including the message with the given msgid, which must be
+in your stored history of that conversation. If the other user has enabled
+the read-receipts setting in NickServ, they will be notified.`,
+			helpShort:    `$bREADRECEIPT$b acknowledges reading your direct messages.`,
+			enabled:      histservEnabled,
+			authRequired: true,
+			minParams:    2,
+			maxParams:    2,
+		},
+		"readreceipts": {
+			handler: histservReadReceiptsHandler,
+			help: `Syntax: $bREADRECEIPTS <nickname>$b
+
+READRECEIPTS shows the latest read receipt recorded between you and the given
+user, in both directions.`,
+			helpShort:    `$bREADRECEIPTS$b shows read receipts for a direct message conversation.`,
+			enabled:      histservEnabled,
+			authRequired: true,
+			minParams:    1,
+			maxParams:    1,
+		},
 	}
 )
 
@@ -117,7 +182,7 @@ func histservDeleteHandler(service *ircService, server *Server, client *Client,
 		}
 	}
 	if !isOper && !isChanop && accountName == "*" {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	}
 
@@ -126,9 +191,9 @@ func histservDeleteHandler(service *ircService, server *Server, client *Client,
 		service.Notice(rb, client.t("Successfully deleted message"))
 	} else {
 		if isOper {
-			service.Notice(rb, fmt.Sprintf(client.t("Error deleting message: %v"), err))
+			service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Error deleting message: %v"), err))
 		} else {
-			service.Notice(rb, client.t("Could not delete message"))
+			service.Fail(rb, command, serviceErrUnknown, client.t("Could not delete message"))
 		}
 	}
 }
@@ -136,48 +201,254 @@ func histservDeleteHandler(service *ircService, server *Server, client *Client,
 func histservExportHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	cfAccount, err := CasefoldName(params[0])
 	if err != nil {
-		service.Notice(rb, client.t("Invalid account name"))
+		service.Fail(rb, command, serviceErrInvalidAccountName, client.t("Invalid account name"))
 		return
 	}
 
+	format := history.ExportJSON
+	if len(params) > 1 {
+		format, err = history.ParseExportFormat(params[1])
+		if err != nil {
+			service.Fail(rb, command, serviceErrInvalidFormat, client.t("Invalid export format"))
+			return
+		}
+	}
+
 	config := server.Config()
 	// don't include the account name in the filename because of escaping concerns
-	filename := fmt.Sprintf("%s-%s.json", utils.GenerateSecretToken(), time.Now().UTC().Format(IRCv3TimestampFormat))
+	filename := fmt.Sprintf("%s-%s%s", utils.GenerateSecretToken(), time.Now().UTC().Format(IRCv3TimestampFormat), format.Extension())
 	pathname := config.getOutputPath(filename)
 	outfile, err := os.Create(pathname)
 	if err != nil {
-		service.Notice(rb, fmt.Sprintf(client.t("Error opening export file: %v"), err))
-	} else {
-		service.Notice(rb, fmt.Sprintf(client.t("Started exporting data for account %[1]s to file %[2]s"), cfAccount, filename))
+		service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Error opening export file: %v"), err))
+		return
 	}
+	exporter, err := history.NewExporter(outfile, format, config.History.Export.CompressionLevel)
+	if err != nil {
+		outfile.Close()
+		service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Error opening export file: %v"), err))
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Started exporting data for account %[1]s to file %[2]s"), cfAccount, filename))
 
-	go histservExportAndNotify(service, server, cfAccount, outfile, filename, client.Nick())
+	go histservExportAndNotify(service, server, cfAccount, outfile, exporter, filename, client.Nick())
 }
 
-func histservExportAndNotify(service *ircService, server *Server, cfAccount string, outfile *os.File, filename, alertNick string) {
+func histservExportAndNotify(service *ircService, server *Server, cfAccount string, outfile *os.File, exporter *history.Exporter, filename, alertNick string) {
 	defer server.HandlePanic()
 
 	defer outfile.Close()
-	writer := bufio.NewWriter(outfile)
-	defer writer.Flush()
 
-	server.historyDB.Export(cfAccount, writer)
+	server.historyDB.Export(cfAccount, exporter.Write)
+	err := exporter.Close()
+	if err != nil {
+		server.logger.Error("internal", "could not write history export", filename, err.Error())
+	}
 
+	// this runs asynchronously, after the response to the EXPORT command was sent,
+	// so the notice can't be labeled (it still gets server-time from Send)
 	client := server.clients.Get(alertNick)
 	if client != nil && client.HasRoleCapabs("history") {
 		client.Send(nil, service.prefix, "NOTICE", client.Nick(), fmt.Sprintf(client.t("Data export for %[1]s completed and written to %[2]s"), cfAccount, filename))
 	}
 }
 
+const histservDiffLimit = 100
+
+func histservDiffHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	config := server.Config()
+	var exports [2][]string
+	for i, filename := range params {
+		// only allow reading files from the output directory
+		if filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+			service.Fail(rb, command, serviceErrInvalidFilename, fmt.Sprintf(client.t("Invalid filename: %s"), filename))
+			return
+		}
+		format, err := history.ExportFormatFromFilename(filename)
+		if err != nil {
+			service.Fail(rb, command, serviceErrInvalidFilename, fmt.Sprintf(client.t("Invalid filename: %s"), filename))
+			return
+		}
+		exports[i], err = histservReadExport(config.getOutputPath(filename), format)
+		if err != nil {
+			service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Error reading export file %[1]s: %[2]v"), filename, err))
+			return
+		}
+	}
+
+	deleted, inserted := history.DiffMsgids(exports[0], exports[1])
+	service.Notice(rb, fmt.Sprintf(client.t("%[1]d message(s) deleted, %[2]d message(s) inserted"), len(deleted), len(inserted)))
+	count := 0
+	for _, diff := range []struct {
+		msgids []string
+		prefix string
+	}{{deleted, "-"}, {inserted, "+"}} {
+		for _, msgid := range diff.msgids {
+			if count == histservDiffLimit {
+				service.Notice(rb, fmt.Sprintf(client.t("Output truncated after %d differences"), histservDiffLimit))
+				return
+			}
+			service.Notice(rb, diff.prefix+msgid)
+			count++
+		}
+	}
+}
+
+func histservReadExport(pathname string, format history.ExportFormat) (msgids []string, err error) {
+	infile, err := os.Open(pathname)
+	if err != nil {
+		return
+	}
+	defer infile.Close()
+	return history.ReadExportMsgids(infile, format)
+}
+
+const histservStatsLimit = 50
+
+func histservStatsHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	isOper := client.HasRoleCapabs("history")
+	var counts []history.Count
+	var channel *Channel
+	var err error
+	switch strings.ToLower(params[0]) {
+	case "channel":
+		channel = server.channels.Get(params[1])
+		if channel == nil {
+			service.Fail(rb, command, serviceErrNoSuchChannel, client.t("No such channel"))
+			return
+		}
+		if !isOper && !channel.ClientIsAtLeast(client, modes.ChannelOperator) {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
+			return
+		}
+		counts, err = server.ChannelHistoryStats(channel, histservStatsLimit)
+		if err == nil {
+			service.ChannelNotice(rb, channel, fmt.Sprintf(client.t("Message counts by account for %s:"), channel.Name()))
+		}
+	case "account":
+		if !isOper {
+			service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
+			return
+		}
+		accountName := server.accounts.AccountToAccountName(params[1])
+		if accountName == "" {
+			service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
+			return
+		}
+		counts, err = server.AccountHistoryStats(accountName, histservStatsLimit)
+		if err == nil {
+			service.Notice(rb, fmt.Sprintf(client.t("Message counts by channel for %s:"), accountName))
+		}
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+
+	if err == errFeatureDisabled {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Message statistics are not available for this history configuration"))
+		return
+	} else if err != nil {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Could not retrieve history"))
+		return
+	}
+	for i, count := range counts {
+		service.ChannelNotice(rb, channel, fmt.Sprintf("%d: %s (%d)", i+1, count.Name, count.Count))
+	}
+	service.ChannelNotice(rb, channel, client.t("End of message statistics"))
+}
+
+func histservQuotaHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	count, err := server.AccountHistoryCount(client.AccountName())
+	if err == errFeatureDisabled {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Message statistics are not available for this history configuration"))
+		return
+	} else if err != nil {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Could not retrieve history"))
+		return
+	}
+
+	service.Notice(rb, fmt.Sprintf(client.t("Messages stored: %d"), count))
+}
+
+// resolves a nickname to the account that owns it, for the purposes of read receipts
+func histservLookupCorrespondent(server *Server, nick string) (account string) {
+	if target := server.clients.Get(nick); target != nil && target.Account() != "" {
+		return target.Account()
+	}
+	return server.accounts.NickToAccount(nick)
+}
+
+func histservReadReceiptHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	correspondent := histservLookupCorrespondent(server, params[0])
+	if correspondent == "" {
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
+		return
+	}
+	account := client.Account()
+	if correspondent == account {
+		service.Notice(rb, client.t("You can't send a read receipt to yourself"))
+		return
+	}
+	msgid := history.NormalizeMsgid(params[1])
+
+	// the msgid must be from your conversation with the correspondent
+	_, sequence, err := server.GetHistorySequence(nil, client, params[0])
+	if err != nil || sequence == nil {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Could not retrieve history"))
+		return
+	}
+	if found, err := history.SequenceContains(sequence, msgid); err != nil {
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Could not retrieve history"))
+		return
+	} else if !found {
+		service.Fail(rb, command, serviceErrInvalidParams, fmt.Sprintf(client.t("Message %[1]s is not part of your conversation with %[2]s"), msgid, params[0]))
+		return
+	}
+
+	err = server.accounts.SetReadReceipt(account, correspondent, msgid)
+	if err != nil {
+		service.Notice(rb, client.t("Could not record read receipt"))
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Recorded read receipt for your conversation with %[1]s, up to message %[2]s"), params[0], msgid))
+
+	nick := client.Nick()
+	for _, target := range server.accounts.AccountToClients(correspondent) {
+		if target.AccountSettings().ReadReceipts {
+			target.Send(nil, service.prefix, "NOTICE", target.Nick(), fmt.Sprintf(target.t("%[1]s has read your direct messages, up to message %[2]s"), nick, msgid))
+		}
+	}
+}
+
+func histservReadReceiptsHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	correspondent := histservLookupCorrespondent(server, params[0])
+	if correspondent == "" {
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
+		return
+	}
+	account := client.Account()
+
+	if receipt, found := server.accounts.LoadReadReceipt(account, correspondent); found {
+		service.Notice(rb, fmt.Sprintf(client.t("You read messages from %[1]s up to %[2]s at %[3]s"), params[0], receipt.Msgid, receipt.Time.Format(time.RFC1123)))
+	} else {
+		service.Notice(rb, fmt.Sprintf(client.t("You have not sent a read receipt to %s"), params[0]))
+	}
+	if receipt, found := server.accounts.LoadReadReceipt(correspondent, account); found {
+		service.Notice(rb, fmt.Sprintf(client.t("%[1]s read your messages up to %[2]s at %[3]s"), params[0], receipt.Msgid, receipt.Time.Format(time.RFC1123)))
+	} else {
+		service.Notice(rb, fmt.Sprintf(client.t("%s has not sent you a read receipt"), params[0]))
+	}
+}
+
 func histservPlayHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
-	items, _, err := easySelectHistory(server, client, params)
+	items, channel, err := easySelectHistory(server, client, params)
 	if err != nil {
-		service.Notice(rb, client.t("Could not retrieve history"))
+		service.Fail(rb, command, serviceErrHistoryUnavailable, client.t("Could not retrieve history"))
 		return
 	}
 
 	playMessage := func(timestamp time.Time, nick, message string) {
-		service.Notice(rb, fmt.Sprintf("%s <%s> %s", timestamp.Format("15:04:05"), NUHToNick(nick), message))
+		service.ChannelNotice(rb, channel, fmt.Sprintf("%s <%s> %s", timestamp.Format("15:04:05"), NUHToNick(nick), message))
 	}
 
 	for _, item := range items {
@@ -194,7 +465,7 @@ func histservPlayHandler(service *ircService, server *Server, client *Client, co
 		}
 	}
 
-	service.Notice(rb, client.t("End of history playback"))
+	service.ChannelNotice(rb, channel, client.t("End of history playback"))
 }
 
 // handles parameter parsing and history queries for /HISTORY and /HISTSERV PLAY
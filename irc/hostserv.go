@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/ergochat/irc-go/ircfmt"
 
@@ -30,6 +31,10 @@ func hostservEnabled(config *Config) bool {
 	return config.Accounts.VHosts.Enabled
 }
 
+func hostservRequestsEnabled(config *Config) bool {
+	return config.Accounts.VHosts.Enabled && config.Accounts.VHosts.UserRequests.Enabled
+}
+
 var (
 	hostservCommands = map[string]*serviceCommand{
 		"on": {
@@ -59,6 +64,51 @@ disabled. A server operator can view someone else's status.`,
 			helpShort: `$bSTATUS$b shows your vhost status.`,
 			enabled:   hostservEnabled,
 		},
+		"request": {
+			handler: hsRequestHandler,
+			help: `Syntax: $bREQUEST <vhost>$b
+
+REQUEST requests that a new vhost be assigned to your account. The request must
+then be approved by a server operator.`,
+			helpShort:    `$bREQUEST$b requests a new vhost, pending operator approval.`,
+			authRequired: true,
+			enabled:      hostservRequestsEnabled,
+			minParams:    1,
+		},
+		"waiting": {
+			handler: hsWaitingHandler,
+			help: `Syntax: $bWAITING$b
+
+WAITING shows a list of pending vhost requests, which can then be approved
+or rejected.`,
+			helpShort: `$bWAITING$b shows a list of pending vhost requests.`,
+			capabs:    []string{"vhosts"},
+			enabled:   hostservEnabled,
+		},
+		"approve": {
+			handler: hsApproveHandler,
+			help: `Syntax: $bAPPROVE <user> [vhost]$b
+
+APPROVE approves a user's vhost request. If a vhost is given, it is assigned
+instead of the requested one.`,
+			helpShort: `$bAPPROVE$b approves a user's vhost request.`,
+			capabs:    []string{"vhosts"},
+			enabled:   hostservEnabled,
+			minParams: 1,
+			maxParams: 2,
+		},
+		"reject": {
+			handler: hsRejectHandler,
+			help: `Syntax: $bREJECT <user> [reason]$b
+
+REJECT rejects a user's vhost request, optionally giving them a reason
+for the rejection.`,
+			helpShort: `$bREJECT$b rejects a user's vhost request.`,
+			capabs:    []string{"vhosts"},
+			enabled:   hostservEnabled,
+			minParams: 1,
+			maxParams: 2,
+		},
 		"set": {
 			handler: hsSetHandler,
 			help: `Syntax: $bSET <user> <vhost>$b
@@ -105,7 +155,7 @@ func hsOnOffHandler(service *ircService, server *Server, client *Client, command
 	if err == errNoVhost {
 		service.Notice(rb, client.t(err.Error()))
 	} else if err != nil {
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	} else if enable {
 		service.Notice(rb, client.t("Successfully enabled your vhost"))
 	} else {
@@ -117,14 +167,14 @@ func hsStatusHandler(service *ircService, server *Server, client *Client, comman
 	var accountName string
 	if len(params) > 0 {
 		if !client.HasRoleCapabs("vhosts") {
-			service.Notice(rb, client.t("Command restricted"))
+			service.Fail(rb, command, serviceErrCommandRestricted, client.t("Command restricted"))
 			return
 		}
 		accountName = params[0]
 	} else {
 		accountName = client.Account()
 		if accountName == "" {
-			service.Notice(rb, client.t("You're not logged into an account"))
+			service.Fail(rb, command, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
 			return
 		}
 	}
@@ -134,7 +184,7 @@ func hsStatusHandler(service *ircService, server *Server, client *Client, comman
 		if err != errAccountDoesNotExist {
 			server.logger.Warning("internal", "error loading account info", accountName, err.Error())
 		}
-		service.Notice(rb, client.t("No such account"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
 		return
 	}
 
@@ -146,6 +196,98 @@ func hsStatusHandler(service *ircService, server *Server, client *Client, comman
 	} else {
 		service.Notice(rb, fmt.Sprintf(client.t("Account %s has no vhost"), accountName))
 	}
+	if account.VHost.RequestedVHost != "" {
+		service.Notice(rb, fmt.Sprintf(client.t("A request is pending for vhost: %s"), account.VHost.RequestedVHost))
+	}
+	if account.VHost.RejectedReason != "" {
+		service.Notice(rb, fmt.Sprintf(client.t("The previous vhost request was rejected with the reason: %s"), account.VHost.RejectedReason))
+	}
+}
+
+func hsRequestHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	vhost := params[0]
+	if validateVhost(server, vhost, false) != nil {
+		service.Fail(rb, command, serviceErrInvalidVhost, client.t("Invalid vhost"))
+		return
+	}
+
+	accountName := client.Account()
+	cooldown := time.Duration(server.Config().Accounts.VHosts.UserRequests.Cooldown)
+	_, err := server.accounts.VHostRequest(accountName, vhost, cooldown)
+	if err != nil {
+		if throttled, ok := err.(*vhostThrottleExceeded); ok {
+			service.Notice(rb, fmt.Sprintf(client.t("You must wait an additional %v before making another request"), throttled.timeRemaining))
+		} else if err == errAccountUnverified {
+			service.Notice(rb, client.t(err.Error()))
+		} else {
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		}
+	} else {
+		service.Notice(rb, client.t("Your vhost request will be reviewed by an administrator"))
+		server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Account %[1]s (nick %[2]s) requested vhost %[3]s", accountName, client.Nick(), vhost))
+	}
+}
+
+func hsWaitingHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	requests, total := server.accounts.VHostListRequests(10)
+	service.Notice(rb, fmt.Sprintf(client.t("There are %[1]d pending requests for vhosts (%[2]d displayed)"), total, len(requests)))
+	for i, request := range requests {
+		service.Notice(rb, fmt.Sprintf(client.t("%[1]d. User %[2]s requests vhost: %[3]s"), i+1, request.Account, request.RequestedVHost))
+	}
+}
+
+func hsApproveHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	user := params[0]
+	var vhost string
+	if len(params) > 1 {
+		vhost = params[1]
+		if validateVhost(server, vhost, true) != nil {
+			service.Fail(rb, command, serviceErrInvalidVhost, client.t("Invalid vhost"))
+			return
+		}
+	}
+
+	vhostInfo, err := server.accounts.VHostApprove(user, vhost)
+	if err != nil {
+		if err == errNoVhostRequest || err == errAccountDoesNotExist {
+			service.Notice(rb, client.t(err.Error()))
+		} else {
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		}
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Successfully approved vhost request for %s"), user))
+	server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Operator %[1]s approved vhost %[2]s for account %[3]s", client.Oper().Name, vhostInfo.ApprovedVHost, user))
+	for _, target := range server.accounts.AccountToClients(user) {
+		target.Send(nil, service.prefix, "NOTICE", target.Nick(), fmt.Sprintf(target.t("Your vhost request was approved; your vhost is now %s"), vhostInfo.ApprovedVHost))
+	}
+}
+
+func hsRejectHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	user := params[0]
+	var reason string
+	if len(params) > 1 {
+		reason = params[1]
+	}
+
+	_, err := server.accounts.VHostReject(user, reason)
+	if err != nil {
+		if err == errNoVhostRequest || err == errAccountDoesNotExist {
+			service.Notice(rb, client.t(err.Error()))
+		} else {
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		}
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Successfully rejected vhost request for %s"), user))
+	server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Operator %[1]s rejected vhost request for account %[2]s, with the reason: %[3]s", client.Oper().Name, user, reason))
+	for _, target := range server.accounts.AccountToClients(user) {
+		message := target.t("Your vhost request was rejected")
+		if reason != "" {
+			message = fmt.Sprintf(target.t("Your vhost request was rejected with the reason: %s"), reason)
+		}
+		target.Send(nil, service.prefix, "NOTICE", target.Nick(), message)
+	}
 }
 
 func validateVhost(server *Server, vhost string, oper bool) error {
@@ -167,7 +309,7 @@ func hsSetHandler(service *ircService, server *Server, client *Client, command s
 	if command == "set" {
 		vhost = params[1]
 		if validateVhost(server, vhost, true) != nil {
-			service.Notice(rb, client.t("Invalid vhost"))
+			service.Fail(rb, command, serviceErrInvalidVhost, client.t("Invalid vhost"))
 			return
 		}
 	}
@@ -175,7 +317,7 @@ func hsSetHandler(service *ircService, server *Server, client *Client, command s
 
 	_, err := server.accounts.VHostSet(user, vhost)
 	if err != nil {
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	} else if vhost != "" {
 		service.Notice(rb, client.t("Successfully set vhost"))
 		server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Operator %[1]s set vhost %[2]s on account %[3]s", oper.Name, vhost, user))
@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// server-side ignore lists (NS SET IGNORE, or SILENCE): direct messages and
+// invites from matching senders are silently dropped, without being stored in history.
+
+const (
+	ignoreAccountPrefix = "$a:"
+)
+
+// canonicalizeIgnoreEntry validates an ignore list entry, which is either
+// $a:account or a nick!user@host mask, returning its stored form.
+func canonicalizeIgnoreEntry(entry string) (result string, err error) {
+	if len(entry) > len(ignoreAccountPrefix) && strings.EqualFold(entry[:len(ignoreAccountPrefix)], ignoreAccountPrefix) {
+		cfAccount, err := CasefoldName(entry[len(ignoreAccountPrefix):])
+		if err != nil {
+			return "", errInvalidParams
+		}
+		return ignoreAccountPrefix + cfAccount, nil
+	}
+	result, err = CanonicalizeMaskWildcard(entry)
+	if err != nil {
+		return "", errInvalidParams
+	}
+	return
+}
+
+// ignoreMatcher matches senders against an ignore list; it's compiled
+// whenever the client's account settings are loaded or changed.
+type ignoreMatcher struct {
+	accounts map[string]bool
+	masks    *regexp.Regexp
+}
+
+func compileIgnores(entries []string) (result *ignoreMatcher) {
+	if len(entries) == 0 {
+		return nil
+	}
+	result = new(ignoreMatcher)
+	var masks []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, ignoreAccountPrefix) {
+			if result.accounts == nil {
+				result.accounts = make(map[string]bool)
+			}
+			result.accounts[entry[len(ignoreAccountPrefix):]] = true
+		} else {
+			masks = append(masks, entry)
+		}
+	}
+	if len(masks) != 0 {
+		result.masks, _ = utils.CompileMasks(masks)
+	}
+	return
+}
+
+func (m *ignoreMatcher) match(details *ClientDetails) bool {
+	if m == nil {
+		return false
+	}
+	if details.account != "" && m.accounts[details.account] {
+		return true
+	}
+	return m.masks != nil && m.masks.MatchString(details.nickMaskCasefolded)
+}
+
+// ignoreEntryMatches checks a single stored ignore list entry against a sender.
+func ignoreEntryMatches(entry string, details *ClientDetails) bool {
+	return compileIgnores([]string{entry}).match(details)
+}
+
+// isIgnoring returns whether the client's ignore list matches `sender`,
+// in which case a message or invite of the given type should be dropped.
+func (client *Client) isIgnoring(sender *Client, histType history.ItemType) bool {
+	if client == sender {
+		return false
+	}
+	client.stateMutex.RLock()
+	ignores := client.ignores
+	client.stateMutex.RUnlock()
+	if ignores == nil {
+		return false
+	}
+	// operators can always reach users with notices
+	if histType == history.Notice && sender.Oper() != nil {
+		return false
+	}
+	details := sender.Details()
+	return ignores.match(&details)
+}
+
+// ignoreListMunger returns a settingsMunger that adds or removes an ignore list entry.
+func ignoreListMunger(add bool, entry string, maxEntries int) settingsMunger {
+	return func(in AccountSettings) (out AccountSettings, err error) {
+		out = in
+		var ignores []string
+		for _, existing := range in.Ignores {
+			if existing != entry {
+				ignores = append(ignores, existing)
+			}
+		}
+		if add {
+			if maxEntries <= len(ignores) {
+				return in, errLimitExceeded
+			}
+			ignores = append(ignores, entry)
+		} else if len(ignores) == len(in.Ignores) {
+			return in, errNoop
+		}
+		out.Ignores = ignores
+		return
+	}
+}
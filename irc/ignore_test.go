@@ -0,0 +1,35 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestIgnoreEntries(t *testing.T) {
+	entry, err := canonicalizeIgnoreEntry("$a:Shivaram")
+	assertEqual(err, nil, t)
+	assertEqual(entry, "$a:shivaram", t)
+	entry, err = canonicalizeIgnoreEntry("Troll")
+	assertEqual(err, nil, t)
+	assertEqual(entry, "troll!*@*", t)
+
+	var details ClientDetails
+	details.account = "shivaram"
+	details.nickMaskCasefolded = "slingamn!~u@example.com"
+	assertEqual(ignoreEntryMatches("$a:shivaram", &details), true, t)
+	assertEqual(ignoreEntryMatches("$a:troll", &details), false, t)
+	assertEqual(ignoreEntryMatches("*!*@example.com", &details), true, t)
+	assertEqual(ignoreEntryMatches("troll!*@*", &details), false, t)
+
+	munger := ignoreListMunger(true, "troll!*@*", 1)
+	settings, err := munger(AccountSettings{})
+	assertEqual(err, nil, t)
+	assertEqual(settings.Ignores, []string{"troll!*@*"}, t)
+	_, err = ignoreListMunger(true, "other!*@*", 1)(settings)
+	assertEqual(err, errLimitExceeded, t)
+	settings, err = ignoreListMunger(false, "troll!*@*", 1)(settings)
+	assertEqual(err, nil, t)
+	assertEqual(len(settings.Ignores), 0, t)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+
+	"github.com/ergochat/ergo/irc/sno"
+)
+
+const (
+	// sent to operators on messages from a client under NS IMPERSONATE
+	impersonatedByTagName = "ergo.chat/impersonated-by"
+)
+
+// impersonation records an operator's NS IMPERSONATE session, so that it
+// can be reverted with NS IMPERSONATE END
+type impersonation struct {
+	oper            string // name of the impersonating oper
+	originalAccount string // casefolded account to restore, or "" if none
+	originalVHost   string
+	account         string // display name of the impersonated account
+	started         time.Time
+}
+
+// Impersonate implements NS IMPERSONATE: it logs an operator's client into
+// another account, replacing its hostname with one associated with that account.
+func (am *AccountManager) Impersonate(client *Client, target string) (account ClientAccount, err error) {
+	oper := client.Oper()
+	if !oper.HasRoleCapab("impersonate") {
+		return account, errInsufficientPrivs
+	} else if client.AlwaysOn() {
+		return account, errImpersonateAlwaysOn
+	} else if client.Impersonation() != nil {
+		return account, errAlreadyImpersonating
+	}
+
+	account, err = am.LoadAccount(target)
+	if err != nil {
+		return
+	} else if !account.Verified {
+		return account, errAccountUnverified
+	} else if account.Suspended != nil {
+		return account, &AccountSuspendedError{*account.Suspended}
+	}
+	if clientAlready := am.server.clients.Get(account.Name); clientAlready != nil && clientAlready.AlwaysOn() {
+		return account, errNickAccountMismatch
+	}
+
+	details := client.Details()
+	record := &impersonation{
+		oper:            oper.Name,
+		originalAccount: details.account,
+		originalVHost:   client.VHost(),
+		account:         account.Name,
+		started:         time.Now().UTC(),
+	}
+
+	if details.account != "" {
+		am.Logout(client)
+	}
+	am.Login(client, account)
+	client.setImpersonation(record)
+	if vhost := am.impersonationVHost(account); vhost != "" {
+		oldNickmask := client.NickMaskString()
+		if client.SetVHost(vhost) {
+			client.sendChghost(oldNickmask, client.Hostname())
+		}
+	}
+
+	am.server.logger.Warning("opers", fmt.Sprintf("Oper %s (client %s, account %s) began impersonating account %s, from %s", oper.Name, details.nickMask, details.accountName, account.Name, client.IP().String()))
+	am.server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Oper $c[grey][$r%s$c[grey]] began impersonating account $c[grey][$r%s$c[grey]]"), oper.Name, account.Name))
+	return
+}
+
+// EndImpersonation implements NS IMPERSONATE END, restoring the operator's
+// original account (if any) and hostname.
+func (am *AccountManager) EndImpersonation(client *Client) (restored string, err error) {
+	record := client.Impersonation()
+	if record == nil {
+		return "", errNotImpersonating
+	}
+
+	var original ClientAccount
+	if record.originalAccount != "" {
+		original, err = am.LoadAccount(record.originalAccount)
+		if err != nil {
+			am.server.logger.Error("internal", "couldn't restore account after impersonation", record.originalAccount, err.Error())
+		}
+	}
+
+	am.Logout(client)
+	client.setImpersonation(nil)
+	if err == nil && original.NameCasefolded != "" {
+		am.Login(client, original)
+		restored = original.Name
+	}
+	oldNickmask := client.NickMaskString()
+	if client.SetVHost(record.originalVHost) {
+		client.sendChghost(oldNickmask, client.Hostname())
+	}
+
+	duration := time.Since(record.started).Round(time.Second)
+	am.server.logger.Warning("opers", fmt.Sprintf("Oper %s (client %s) stopped impersonating account %s after %v", record.oper, client.NickMaskString(), record.account, duration))
+	am.server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Oper $c[grey][$r%s$c[grey]] stopped impersonating account $c[grey][$r%s$c[grey]]"), record.oper, record.account))
+	return restored, nil
+}
+
+// impersonationVHost returns the hostname displayed while impersonating an
+// account: its vhost if it has one, otherwise its account cloak.
+func (am *AccountManager) impersonationVHost(account ClientAccount) string {
+	config := am.server.Config()
+	if config.Accounts.VHosts.Enabled && account.VHost.Enabled && account.VHost.ApprovedVHost != "" {
+		return account.VHost.ApprovedVHost
+	}
+	if config.Server.Cloaks.Enabled {
+		return config.Server.Cloaks.ComputeAccountCloak(account.Name)
+	}
+	return ""
+}
+
+// impersonationTags returns the tags sent to operators on a message from
+// a client under NS IMPERSONATE, or nil if the client isn't impersonating.
+func impersonationTags(client *Client, tags map[string]string) (result map[string]string) {
+	record := client.Impersonation()
+	if record == nil {
+		return nil
+	}
+	result = make(map[string]string, len(tags)+1)
+	for key, value := range tags {
+		result[key] = value
+	}
+	result[impersonatedByTagName] = record.oper
+	return
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// +d: members can't send messages to the channel until they've been in it
+// for the given number of seconds (channel operators are exempt)
+
+const (
+	maxJoinDelay = 3600
+)
+
+// ParseJoinDelay parses the parameter of channel mode +d
+func ParseJoinDelay(param string) (delay int, err error) {
+	delay, err = strconv.Atoi(param)
+	if err != nil || delay < 1 || maxJoinDelay < delay {
+		return 0, errInvalidParams
+	}
+	return delay, nil
+}
+
+// joinDelayWait returns how many more seconds (rounded up) a member who joined
+// at joinTime (in nanoseconds) must wait before speaking, or 0 if none.
+func joinDelayWait(joinTime int64, delay int, now time.Time) (seconds int) {
+	remaining := time.Duration(joinTime + int64(time.Duration(delay)*time.Second) - now.UnixNano())
+	if remaining <= 0 {
+		return 0
+	}
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+// joinDelayRemaining returns how many more seconds the client must wait
+// before speaking in the channel under +d, or 0 if none.
+func (channel *Channel) joinDelayRemaining(client *Client) (seconds int) {
+	channel.stateMutex.RLock()
+	delay := channel.joinDelay
+	memberData, ok := channel.members[client]
+	channel.stateMutex.RUnlock()
+
+	if delay == 0 || !ok {
+		return 0
+	}
+	if channel.ClientIsAtLeast(client, modes.ChannelOperator) {
+		return 0
+	}
+	return joinDelayWait(memberData.joinTime, delay, time.Now())
+}
+
+func (channel *Channel) setJoinDelay(delay int) {
+	channel.stateMutex.Lock()
+	channel.joinDelay = delay
+	channel.stateMutex.Unlock()
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/ergo/irc/connection_limits"
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+const (
+	maxJoinThrottleJoins  = 1000
+	maxJoinThrottlePeriod = 24 * time.Hour
+)
+
+// JoinThrottle is the parameter of channel mode +j, in the Charybdis-style
+// format `n:t`: at most n joins every t seconds.
+type JoinThrottle struct {
+	Joins  int
+	Period time.Duration
+}
+
+func ParseJoinThrottle(param string) (result JoinThrottle, err error) {
+	parts := strings.SplitN(param, ":", 2)
+	if len(parts) != 2 {
+		return result, errInvalidParams
+	}
+	joins, err := strconv.Atoi(parts[0])
+	if err != nil || joins < 1 || maxJoinThrottleJoins < joins {
+		return result, errInvalidParams
+	}
+	seconds, err := strconv.Atoi(parts[1])
+	if err != nil || seconds < 1 || int(maxJoinThrottlePeriod/time.Second) < seconds {
+		return result, errInvalidParams
+	}
+	result.Joins = joins
+	result.Period = time.Duration(seconds) * time.Second
+	return result, nil
+}
+
+// String returns the `n:t` form of the throttle, or "" if it is disabled.
+func (jt JoinThrottle) String() string {
+	if jt.Joins == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", jt.Joins, int(jt.Period/time.Second))
+}
+
+// parseJoinThrottleProtection validates the mode that a channel receives
+// while its join throttle is exceeded.
+func parseJoinThrottleProtection(modeStr string) (result modes.Mode, err error) {
+	modeStr = strings.TrimPrefix(modeStr, "+")
+	if len(modeStr) == 1 {
+		switch mode := modes.Mode(modeStr[0]); mode {
+		case modes.InviteOnly, modes.RegisteredOnly, modes.Moderated, modes.RegisteredOnlySpeak:
+			return mode, nil
+		}
+	}
+	return result, fmt.Errorf("invalid join-throttle protection-mode: %s", modeStr)
+}
+
+func (channel *Channel) setJoinThrottle(jt JoinThrottle) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.joinThrottle = connection_limits.GenericThrottle{
+		Duration: jt.Period,
+		Limit:    jt.Joins,
+	}
+}
+
+func (channel *Channel) getJoinThrottleNoMutex() JoinThrottle {
+	return JoinThrottle{
+		Joins:  channel.joinThrottle.Limit,
+		Period: channel.joinThrottle.Duration,
+	}
+}
+
+// touchJoinThrottle records a join to the channel. If this exceeds the join
+// throttle, it sets the configured protection mode, schedules its removal,
+// and returns the resulting mode change, which the caller should announce.
+func (channel *Channel) touchJoinThrottle() (applied modes.ModeChanges) {
+	config := channel.server.Config().Channels.JoinThrottle
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	if channel.joinProtection != modes.Mode(0) {
+		return // already protected
+	}
+	if throttled, _ := channel.joinThrottle.Touch(); !throttled {
+		return
+	}
+	if !channel.flags.SetMode(config.protectionMode, true) {
+		return // the mode is already set manually
+	}
+	channel.joinProtection = config.protectionMode
+	time.AfterFunc(config.Cooldown, channel.expireJoinProtection)
+	return modes.ModeChanges{{Op: modes.Add, Mode: config.protectionMode}}
+}
+
+// expireJoinProtection removes the protection mode set by touchJoinThrottle,
+// unless a channel operator has changed it in the meantime.
+func (channel *Channel) expireJoinProtection() {
+	defer channel.server.HandlePanic()
+
+	channel.stateMutex.Lock()
+	mode := channel.joinProtection
+	channel.joinProtection = modes.Mode(0)
+	removed := mode != modes.Mode(0) && channel.flags.SetMode(mode, false)
+	channel.stateMutex.Unlock()
+
+	if removed {
+		announceCmodeChanges(channel, modes.ModeChanges{{Op: modes.Remove, Mode: mode}}, channel.server.name, "*", "", false, nil)
+	}
+}
+
+// releaseJoinProtection is called when a mode is changed manually: if it is
+// the protection mode, it will no longer be removed automatically.
+func (channel *Channel) releaseJoinProtection(mode modes.Mode) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	if channel.joinProtection == mode {
+		channel.joinProtection = modes.Mode(0)
+	}
+}
+
+// noticeJoinThrottleExceeded informs channel operators that the channel is
+// being protected from a join flood.
+func (channel *Channel) noticeJoinThrottleExceeded(mode modes.Mode) {
+	config := channel.server.Config().Channels.JoinThrottle
+	channel.stateMutex.RLock()
+	chname := channel.name
+	jt := channel.getJoinThrottleNoMutex()
+	channel.stateMutex.RUnlock()
+
+	for _, member := range channel.Members() {
+		if !channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			continue
+		}
+		message := fmt.Sprintf(member.t("Join throttle (+j %[1]s) exceeded; the channel will be +%[2]s for %[3]v"), jt.String(), mode.String(), config.Cooldown)
+		for _, session := range member.Sessions() {
+			session.Send(nil, channel.server.name, "NOTICE", "@"+chname, message)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseJoinThrottle(t *testing.T) {
+	jt, err := ParseJoinThrottle("5:10")
+	assertEqual(err, nil, t)
+	assertEqual(jt, JoinThrottle{Joins: 5, Period: 10 * time.Second}, t)
+	assertEqual(jt.String(), "5:10", t)
+
+	for _, invalid := range []string{"", "5", "5:", ":10", "0:10", "5:0", "-1:10", "a:b", "5:10:15"} {
+		_, err := ParseJoinThrottle(invalid)
+		assertEqual(err, errInvalidParams, t)
+	}
+
+	assertEqual(JoinThrottle{}.String(), "", t)
+}
+
+func TestJoinDelay(t *testing.T) {
+	delay, err := ParseJoinDelay("30")
+	assertEqual(err, nil, t)
+	assertEqual(delay, 30, t)
+	for _, param := range []string{"0", "-5", "3601", "30s", ""} {
+		if _, err := ParseJoinDelay(param); err == nil {
+			t.Errorf("join delay %q should have been rejected", param)
+		}
+	}
+
+	joined := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assertEqual(joinDelayWait(joined.UnixNano(), 30, joined), 30, t)
+	assertEqual(joinDelayWait(joined.UnixNano(), 30, joined.Add(500*time.Millisecond)), 30, t)
+	assertEqual(joinDelayWait(joined.UnixNano(), 30, joined.Add(29*time.Second)), 1, t)
+	assertEqual(joinDelayWait(joined.UnixNano(), 30, joined.Add(30*time.Second)), 0, t)
+	assertEqual(joinDelayWait(joined.UnixNano(), 30, joined.Add(time.Hour)), 0, t)
+}
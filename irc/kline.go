@@ -13,6 +13,7 @@ import (
 
 	"github.com/tidwall/buntdb"
 
+	"github.com/ergochat/ergo/irc/flatip"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -26,10 +27,41 @@ type KLineInfo struct {
 	Mask string
 	// Matcher, to facilitate fast matching.
 	Matcher *regexp.Regexp
+	// HostNet is set if the host part of the mask is a CIDR network,
+	// e.g., *!*@2001:db8::/32; Matcher then matches only the nick!user part.
+	HostNet *flatip.IPNet
 	// Info contains information on the ban.
 	Info IPBanInfo
 }
 
+// compileKLineMask compiles a canonicalized KLINE mask for matching
+func compileKLineMask(mask string) (matcher *regexp.Regexp, hostNet *flatip.IPNet, err error) {
+	atIndex := strings.LastIndexByte(mask, '@')
+	if atIndex != -1 && strings.IndexByte(mask[atIndex+1:], '/') != -1 {
+		_, network, err := flatip.ParseCIDR(mask[atIndex+1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		hostNet = &network
+		mask = mask[:atIndex]
+	}
+	matcher, err = utils.CompileGlob(mask, false)
+	return
+}
+
+// matches tests a nick!user@host mask against the KLINE
+func (kln *KLineInfo) matches(clientMask string) bool {
+	if kln.HostNet == nil {
+		return kln.Matcher.MatchString(clientMask)
+	}
+	atIndex := strings.LastIndexByte(clientMask, '@')
+	if atIndex == -1 {
+		return false
+	}
+	ip, err := flatip.ParseIP(clientMask[atIndex+1:])
+	return err == nil && kln.HostNet.Contains(ip) && kln.Matcher.MatchString(clientMask[:atIndex])
+}
+
 // KLineManager manages and klines.
 type KLineManager struct {
 	sync.RWMutex                // tier 1
@@ -82,7 +114,7 @@ func (km *KLineManager) AddMask(mask string, duration time.Duration, reason, ope
 }
 
 func (km *KLineManager) addMaskInternal(mask string, info IPBanInfo) {
-	re, err := utils.CompileGlob(mask, false)
+	re, hostNet, err := compileKLineMask(mask)
 	// this is validated externally and shouldn't fail regardless
 	if err != nil {
 		return
@@ -90,6 +122,7 @@ func (km *KLineManager) addMaskInternal(mask string, info IPBanInfo) {
 	kln := KLineInfo{
 		Mask:    mask,
 		Matcher: re,
+		HostNet: hostNet,
 		Info:    info,
 	}
 
@@ -121,6 +154,7 @@ func (km *KLineManager) addMaskInternal(mask string, info IPBanInfo) {
 		if ok && maskBan.Info.TimeCreated.Equal(timeCreated) {
 			delete(km.entries, mask)
 			delete(km.expirationTimers, mask)
+			km.server.logger.Info("opers", "temporary K-Line expired", mask)
 		}
 	}
 	km.expirationTimers[mask] = time.AfterFunc(timeLeft, processExpiration)
@@ -202,19 +236,24 @@ func (km *KLineManager) ContainsMask(mask string) (isBanned bool, info IPBanInfo
 
 // CheckMasks returns whether or not the hostmask(s) are banned, and how long they are banned for.
 func (km *KLineManager) CheckMasks(masks ...string) (isBanned bool, info IPBanInfo) {
+	isBanned, _, info = km.MatchMasks(masks...)
+	return
+}
+
+// MatchMasks is like CheckMasks, but also returns the K-Line mask that matched.
+func (km *KLineManager) MatchMasks(masks ...string) (isBanned bool, klineMask string, info IPBanInfo) {
 	km.RLock()
 	defer km.RUnlock()
 
-	for _, entryInfo := range km.entries {
+	for klineMask, entryInfo := range km.entries {
 		for _, mask := range masks {
-			if entryInfo.Matcher.MatchString(mask) {
-				return true, entryInfo.Info
+			if entryInfo.matches(mask) {
+				return true, klineMask, entryInfo.Info
 			}
 		}
 	}
 
 	// no matches!
-	isBanned = false
 	return
 }
 
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+
+	"github.com/ergochat/ergo/irc/flatip"
+)
+
+func compileTestKLine(mask string, t *testing.T) KLineInfo {
+	canonical, err := CanonicalizeMaskWildcard(mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher, hostNet, err := compileKLineMask(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return KLineInfo{Mask: canonical, Matcher: matcher, HostNet: hostNet}
+}
+
+func TestKLineCIDR(t *testing.T) {
+	kln := compileTestKLine("*!*@2001:DB8::/32", t)
+	assertEqual(kln.matches("alice!alice@2001:db8:1234::1"), true, t)
+	assertEqual(kln.matches("alice!alice@2001:db9::1"), false, t)
+	assertEqual(kln.matches("alice!alice@example.com"), false, t)
+
+	kln = compileTestKLine("bob*!*@192.0.2.0/24", t)
+	assertEqual(kln.matches("bobby!b@192.0.2.77"), true, t)
+	assertEqual(kln.matches("alice!b@192.0.2.77"), false, t)
+	assertEqual(kln.matches("bobby!b@192.0.3.77"), false, t)
+
+	// globs are unaffected
+	kln = compileTestKLine("*!*@*.example.com", t)
+	assertEqual(kln.HostNet == nil, true, t)
+	assertEqual(kln.matches("alice!alice@host.example.com"), true, t)
+
+	if _, _, err := compileKLineMask("*!*@2001:db8::/200"); err == nil {
+		t.Errorf("invalid CIDR should be rejected")
+	}
+}
+
+func TestKLineIPv4Mapped(t *testing.T) {
+	// an IPv4-mapped IPv6 network matches the corresponding IPv4 addresses, and vice versa
+	kln := compileTestKLine("*!*@::ffff:192.0.2.0/120", t)
+	assertEqual(kln.matches("alice!alice@192.0.2.5"), true, t)
+	assertEqual(kln.matches("alice!alice@::ffff:192.0.2.5"), true, t)
+	assertEqual(kln.matches("alice!alice@192.0.3.5"), false, t)
+
+	kln = compileTestKLine("*!*@192.0.2.0/24", t)
+	assertEqual(kln.matches("alice!alice@::ffff:192.0.2.5"), true, t)
+}
+
+func TestDLineIPv6(t *testing.T) {
+	network, err := flatip.ParseToNormalizedNet("2001:db8::/32")
+	assertEqual(err, nil, t)
+	ip, _ := flatip.ParseIP("2001:db8:ffff::1")
+	assertEqual(network.Contains(ip), true, t)
+	ip, _ = flatip.ParseIP("2001:db9::1")
+	assertEqual(network.Contains(ip), false, t)
+
+	network, err = flatip.ParseToNormalizedNet("::ffff:198.51.100.0/120")
+	assertEqual(err, nil, t)
+	ip, _ = flatip.ParseIP("198.51.100.9")
+	assertEqual(network.Contains(ip), true, t)
+	assertEqual(network.String(), "198.51.100.0/24", t)
+}
+
+func TestParseTestlineMask(t *testing.T) {
+	nick, username, hostname, err := parseTestlineMask("Alice!Ident@Example.COM")
+	assertEqual(err, nil, t)
+	assertEqual(nick, "alice", t)
+	assertEqual(username, "ident", t)
+	assertEqual(hostname, "example.com", t)
+
+	kln := compileTestKLine("*!ident@*.example.com", t)
+	_, _, hostname, _ = parseTestlineMask("bob!ident@host.example.com")
+	assertEqual(kln.matches("bob!ident@"+hostname), true, t)
+
+	for _, invalid := range []string{"alice", "alice@example.com", "alice!ident", "alice!@example.com", "alice!ident@", "a@b!c"} {
+		_, _, _, err = parseTestlineMask(invalid)
+		if err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestExpiredBansNotLoaded(t *testing.T) {
+	server := newTestAccountServer(t)
+	created := time.Now().UTC().Add(-time.Hour)
+	expired, _ := json.Marshal(IPBanInfo{Reason: "expired", TimeCreated: created, Duration: time.Minute})
+	active, _ := json.Marshal(IPBanInfo{Reason: "active", TimeCreated: created, Duration: 24 * time.Hour})
+	server.store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyDlineEntry, "192.0.2.0/24"), string(expired), nil)
+		tx.Set(fmt.Sprintf(keyDlineEntry, "198.51.100.0/24"), string(active), nil)
+		tx.Set(fmt.Sprintf(keyKlineEntry, "*!*@expired.example.com"), string(expired), nil)
+		tx.Set(fmt.Sprintf(keyKlineEntry, "*!*@active.example.com"), string(active), nil)
+		return nil
+	})
+
+	dlines := NewDLineManager(server).AllBans()
+	assertEqual(len(dlines), 1, t)
+	assertEqual(dlines["198.51.100.0/24"].Reason, "active", t)
+
+	klines := NewKLineManager(server).AllBans()
+	assertEqual(len(klines), 1, t)
+	assertEqual(klines["*!*@active.example.com"].Reason, "active", t)
+}
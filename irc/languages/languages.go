@@ -201,6 +201,26 @@ func (lm *Manager) Codes(codes []string) []string {
 	return newCodes
 }
 
+// Lookup returns the proper language code for the given language code,
+// if that language is available.
+func (lm *Manager) Lookup(code string) (properCode string, ok bool) {
+	info, ok := lm.Languages[strings.ToLower(code)]
+	return info.Code, ok
+}
+
+// ForChannel returns the languages to use for a message concerning a channel
+// whose language is channelLang (if any): the user's own languages if they
+// have chosen any, otherwise the channel's language.
+func (lm *Manager) ForChannel(languages []string, channelLang string) []string {
+	if channelLang == "" {
+		return languages
+	}
+	if len(languages) == 0 || (len(languages) == 1 && strings.EqualFold(languages[0], lm.defaultLang)) {
+		return []string{channelLang}
+	}
+	return languages
+}
+
 // Translate returns the given string, translated into the given language.
 func (lm *Manager) Translate(languages []string, originalString string) string {
 	// not using any special languages
@@ -0,0 +1,230 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// ErrInvalidCredentials means the server was reachable and rejected the login
+	ErrInvalidCredentials = errors.New("invalid LDAP credentials")
+	// ErrUnavailable means we couldn't get a definite answer from the server;
+	// it should be treated as a temporary failure
+	ErrUnavailable = errors.New("LDAP server unavailable")
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// we only care whether the search found exactly one entry
+	maxSearchResults = 2
+)
+
+type ServerConfig struct {
+	Enabled bool
+	// ldap:// or ldaps:// URL
+	URL      string
+	StartTLS bool   `yaml:"start-tls"`
+	CAFile   string `yaml:"ca-file"`
+	Timeout  time.Duration
+	// direct bind: `%s` is replaced by the (DN-escaped) account name
+	BindDNTemplate string `yaml:"bind-dn-template"`
+	// search+bind: bind as the service account, look up the user, then bind as them
+	ServiceBindDN       string `yaml:"service-bind-dn"`
+	ServiceBindPassword string `yaml:"service-bind-password"`
+	SearchBase          string `yaml:"search-base"`
+	// `%s` is replaced by the (filter-escaped) account name
+	SearchFilter string `yaml:"search-filter"`
+	// attribute of the user's entry to use as the account name (optional)
+	AccountAttribute string `yaml:"account-attribute"`
+	// attribute to sync as the account's verified email (optional)
+	EmailAttribute string `yaml:"email-attribute"`
+	Autocreate     bool
+
+	tlsConfig *tls.Config
+}
+
+func (config *ServerConfig) Postprocess() (err error) {
+	if !config.Enabled {
+		return nil
+	}
+	if !(strings.HasPrefix(config.URL, "ldap://") || strings.HasPrefix(config.URL, "ldaps://")) {
+		return fmt.Errorf("invalid LDAP URL: %s", config.URL)
+	}
+	if (config.BindDNTemplate == "") == (config.SearchFilter == "") {
+		return errors.New("exactly one of bind-dn-template and search-filter must be set")
+	}
+	if config.SearchFilter != "" {
+		if _, err := ldap.CompileFilter(strings.Replace(config.SearchFilter, "%s", "x", -1)); err != nil {
+			return err
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.CAFile != "" {
+		caBytes, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		config.tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	return nil
+}
+
+// Result describes a successful LDAP login.
+type Result struct {
+	AccountName string
+	Email       string
+}
+
+// CheckPassphrase attempts to bind to the LDAP server as the given user.
+// It returns ErrInvalidCredentials if the server rejected the credentials,
+// and an error wrapping ErrUnavailable on any other failure.
+func CheckPassphrase(config *ServerConfig, accountName, passphrase string) (result Result, err error) {
+	// an empty password is an "unauthenticated bind" (RFC 4513 section 5.1.2),
+	// which many servers accept without checking anything
+	if passphrase == "" || accountName == "" {
+		return result, ErrInvalidCredentials
+	}
+
+	c, err := dial(config)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer c.Close()
+
+	result.AccountName = accountName
+	var userDN string
+	var entry *ldap.Entry
+	if config.BindDNTemplate != "" {
+		userDN = strings.Replace(config.BindDNTemplate, "%s", EscapeDNValue(accountName), -1)
+	} else {
+		if config.ServiceBindDN != "" {
+			if err = c.Bind(config.ServiceBindDN, config.ServiceBindPassword); err != nil {
+				// this is a configuration problem, not the user's fault
+				return result, fmt.Errorf("%w: service bind failed: %v", ErrUnavailable, err)
+			}
+		}
+		filter := strings.Replace(config.SearchFilter, "%s", ldap.EscapeFilter(accountName), -1)
+		searchResult, err := c.Search(ldap.NewSearchRequest(config.SearchBase, ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases, maxSearchResults, 0, false, filter, config.attributes(), nil))
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			// the filter is ambiguous
+			return result, ErrInvalidCredentials
+		} else if err != nil {
+			return result, fmt.Errorf("%w: search failed: %v", ErrUnavailable, err)
+		}
+		// the user doesn't exist, or the filter is ambiguous
+		if len(searchResult.Entries) != 1 {
+			return result, ErrInvalidCredentials
+		}
+		entry = searchResult.Entries[0]
+		userDN = entry.DN
+	}
+
+	if err = c.Bind(userDN, passphrase); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return result, ErrInvalidCredentials
+		}
+		return result, fmt.Errorf("%w: bind failed: %v", ErrUnavailable, err)
+	}
+
+	// with a bind-dn template, read back the user's own entry if we need attributes
+	if entry == nil && len(config.attributes()) != 0 {
+		searchResult, err := c.Search(ldap.NewSearchRequest(userDN, ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases, 1, 0, false, "(objectClass=*)", config.attributes(), nil))
+		if err == nil && len(searchResult.Entries) == 1 {
+			entry = searchResult.Entries[0]
+		}
+	}
+	if entry != nil {
+		if config.AccountAttribute != "" {
+			if name := entry.GetAttributeValue(config.AccountAttribute); name != "" {
+				result.AccountName = name
+			}
+		}
+		if config.EmailAttribute != "" {
+			result.Email = entry.GetAttributeValue(config.EmailAttribute)
+		}
+	}
+	return result, nil
+}
+
+// dial connects to the configured server, negotiating STARTTLS if requested.
+func dial(config *ServerConfig) (c *ldap.Conn, err error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return
+	}
+	var tlsConfig *tls.Config
+	if config.tlsConfig != nil {
+		tlsConfig = config.tlsConfig.Clone()
+	} else {
+		tlsConfig = new(tls.Config)
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	c, err = ldap.DialURL(config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return
+	}
+	c.SetTimeout(config.Timeout)
+	if config.StartTLS && u.Scheme == "ldap" {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// EscapeDNValue escapes a string for inclusion as an attribute value
+// in a distinguished name (RFC 4514 section 2.4).
+func EscapeDNValue(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			buf.WriteString("\\00")
+		case strings.IndexByte(`"+,;<>\=`, c) != -1,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+func (config *ServerConfig) attributes() (result []string) {
+	if config.AccountAttribute != "" {
+		result = append(result, config.AccountAttribute)
+	}
+	if config.EmailAttribute != "" {
+		result = append(result, config.EmailAttribute)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package ldap
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestEscaping(t *testing.T) {
+	if result := EscapeDNValue(` #a,b+c=d `); result != `\ #a\,b\+c\=d\ ` {
+		t.Errorf("bad DN escape: %s", result)
+	}
+}
+
+// fakeServer answers binds: only `uid=alice,dc=example,dc=com` with `hunter2` succeeds
+func fakeServer(t *testing.T, listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		message, err := ber.ReadPacket(conn)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		msgID := message.Children[0].Value.(int64)
+		op := message.Children[1]
+		if op.Tag != ldap.ApplicationBindRequest || len(op.Children) < 3 {
+			continue
+		}
+		code := int64(ldap.LDAPResultInvalidCredentials)
+		if op.Children[1].Data.String() == "uid=alice,dc=example,dc=com" && op.Children[2].Data.String() == "hunter2" {
+			code = ldap.LDAPResultSuccess
+		}
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+		bindResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindResponse, nil, "")
+		bindResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+		bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		response.AppendChild(bindResponse)
+		conn.Write(response.Bytes())
+	}
+}
+
+func TestCheckPassphrase(t *testing.T) {
+	check := func(account, passphrase string) error {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go fakeServer(t, listener)
+
+		config := ServerConfig{
+			Enabled:        true,
+			URL:            "ldap://" + listener.Addr().String(),
+			BindDNTemplate: "uid=%s,dc=example,dc=com",
+			Timeout:        time.Second,
+		}
+		if err := config.Postprocess(); err != nil {
+			t.Fatal(err)
+		}
+		_, err = CheckPassphrase(&config, account, passphrase)
+		return err
+	}
+
+	if err := check("alice", "hunter2"); err != nil {
+		t.Errorf("valid login failed: %v", err)
+	}
+	if err := check("alice", "hunter3"); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+	if err := check("alice", ""); err != ErrInvalidCredentials {
+		t.Errorf("unauthenticated bind must fail, got %v", err)
+	}
+
+	// nothing listening: this is a temporary failure, not bad credentials
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+	config := ServerConfig{Enabled: true, URL: "ldap://" + addr, BindDNTemplate: "uid=%s,dc=example,dc=com", Timeout: time.Second}
+	config.Postprocess()
+	if _, err := CheckPassphrase(&config, "alice", "hunter2"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected unavailable, got %v", err)
+	}
+}
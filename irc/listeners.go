@@ -5,6 +5,7 @@ package irc
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/ergochat/ergo/irc/flatip"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -95,8 +97,9 @@ func (nl *NetListener) serve() {
 			// hand off the connection
 			wConn, ok := conn.(*utils.WrappedConn)
 			if ok {
-				confirmProxyData(wConn, "", "", "", nl.server.Config())
-				go nl.server.RunClient(NewIRCStreamConn(wConn))
+				config := nl.server.Config()
+				confirmProxyData(wConn, "", "", "", config)
+				go nl.server.runClientWithBackoff(config, wConn, NewIRCStreamConn(wConn))
 			} else {
 				nl.server.logger.Error("internal", "invalid connection type", nl.addr)
 			}
@@ -187,7 +190,28 @@ func (wl *WSListener) handle(w http.ResponseWriter, r *http.Request) {
 	// avoid a DoS attack from buffering excessively large messages:
 	conn.SetReadLimit(int64(maxReadQBytes()))
 
-	go wl.server.RunClient(NewIRCWSConn(conn))
+	go wl.server.runClientWithBackoff(config, wConn, NewIRCWSConn(conn))
+}
+
+// runClientWithBackoff rejects a new connection if its IP has been
+// reconnecting too often (see server.connection-throttle)
+func (server *Server) runClientWithBackoff(config *Config, wConn *utils.WrappedConn, conn IRCConn) {
+	if config.Server.ConnectionThrottle.Enabled && !wConn.Config.Tor {
+		ip := wConn.ProxiedIP
+		if ip == nil {
+			ip = utils.AddrToIP(wConn.RemoteAddr())
+		}
+		flat := flatip.FromNetIP(ip)
+		if !config.Server.IPLimits.IsExempt(flat) {
+			if delay := server.connectionBackoff.Delay(&config.Server.ConnectionThrottle, flat, time.Now()); delay > 0 {
+				server.logger.Info("connect-ip", "Rejecting connection due to reconnect backoff", ip.String(), delay.String())
+				conn.WriteLine([]byte(fmt.Sprintf(errorMsg, fmt.Sprintf("Reconnecting too fast; try again in %v", delay.Round(time.Millisecond)))))
+				conn.Close()
+				return
+			}
+		}
+	}
+	server.RunClient(conn)
 }
 
 // validate conn.ProxiedIP and conn.Secure against config, HTTP headers, etc.
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// MessageLogConfig controls logging of all PRIVMSG and NOTICE traffic
+// to a file, e.g., for compliance purposes.
+type MessageLogConfig struct {
+	Enabled  bool
+	Filename string
+}
+
+type messageLogEntry struct {
+	Time    string `json:"time"`
+	Command string `json:"command"`
+	Source  string `json:"source"`
+	Account string `json:"account,omitempty"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+// MessageLog writes messages to a file, one JSON object per line.
+// The file is reopened on a full rehash, so it can be rotated by logrotate
+// with a postrotate action that sends SIGHUP to the server.
+type MessageLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// ApplyConfig closes the current log file (if any) and opens the configured one.
+func (ml *MessageLog) ApplyConfig(config MessageLogConfig) (err error) {
+	var file *os.File
+	if config.Enabled {
+		file, err = os.OpenFile(config.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return
+		}
+	}
+
+	ml.Lock()
+	oldFile := ml.file
+	ml.file = file
+	ml.Unlock()
+
+	if oldFile != nil {
+		oldFile.Close()
+	}
+	return
+}
+
+// Close closes the log file.
+func (ml *MessageLog) Close() {
+	ml.ApplyConfig(MessageLogConfig{})
+}
+
+// Log records a PRIVMSG or NOTICE; the lines of a multiline message are
+// joined with newlines.
+func (ml *MessageLog) Log(command, source, account, target string, message utils.SplitMessage) {
+	ml.Lock()
+	defer ml.Unlock()
+
+	if ml.file == nil {
+		return
+	}
+
+	entry := messageLogEntry{
+		Time:    message.Time.Format(IRCv3TimestampFormat),
+		Command: command,
+		Source:  source,
+		Target:  target,
+		Message: messageLogText(message),
+	}
+	if account != "*" {
+		entry.Account = account
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	ml.file.Write(append(line, '\n'))
+}
+
+func messageLogText(message utils.SplitMessage) string {
+	if message.Is512() {
+		return message.Message
+	}
+	var buf strings.Builder
+	for i, pair := range message.Split {
+		if i != 0 && !pair.Concat {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(pair.Message)
+	}
+	return buf.String()
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestMessageLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.log")
+	var ml MessageLog
+	if err := ml.ApplyConfig(MessageLogConfig{Enabled: true, Filename: filename}); err != nil {
+		t.Fatal(err)
+	}
+
+	message := utils.MakeMessage("hi")
+	message.Time = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ml.Log("PRIVMSG", "alice!a@localhost", "alice", "#chat", message)
+
+	var multiline utils.SplitMessage
+	multiline.Append("hello", false)
+	multiline.Append("world", false)
+	multiline.Time = message.Time
+	ml.Log("NOTICE", "bob!b@localhost", "*", "alice", multiline)
+	ml.Close()
+	// logging to a closed log is a no-op
+	ml.Log("PRIVMSG", "alice!a@localhost", "alice", "#chat", message)
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(string(contents), `{"time":"2024-01-02T03:04:05.000Z","command":"PRIVMSG","source":"alice!a@localhost","account":"alice","target":"#chat","message":"hi"}
+{"time":"2024-01-02T03:04:05.000Z","command":"NOTICE","source":"bob!b@localhost","target":"alice","message":"hello\nworld"}
+`, t)
+}
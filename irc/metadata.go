@@ -0,0 +1,518 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ergochat/irc-go/ircmsg"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// implementation of draft/metadata-2:
+// https://github.com/ircv3/ircv3-specifications/pull/501
+
+const (
+	// all keys are currently public; per-key visibility is not implemented
+	metadataVisibility = "*"
+)
+
+var (
+	errMetadataLimitReached = errors.New("too many metadata keys")
+	errMetadataTooManySubs  = errors.New("too many metadata subscriptions")
+)
+
+// metadataKeyValid checks a key against the character set permitted by the spec.
+func metadataKeyValid(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '_' || c == '.' || c == '/' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataValueValid checks a value for length and for characters that
+// cannot be relayed as a trailing parameter.
+func metadataValueValid(value string, maxBytes int) bool {
+	return len(value) <= maxBytes && utf8.ValidString(value) && !strings.ContainsAny(value, "\x00\r\n")
+}
+
+// updateMetadata returns a copy of `current` with `key` set to `value`
+// (or deleted, if `value` is empty). Metadata maps are copy-on-write,
+// so they can be shared with serialization code without holding a lock.
+func updateMetadata(current map[string]string, key, value string, maxKeys int) (result map[string]string, err error) {
+	_, exists := current[key]
+	if value != "" && !exists && maxKeys <= len(current) {
+		return current, errMetadataLimitReached
+	}
+	result = make(map[string]string, len(current)+1)
+	for k, v := range current {
+		result[k] = v
+	}
+	if value == "" {
+		delete(result, key)
+	} else {
+		result[key] = value
+	}
+	if len(result) == 0 {
+		result = nil
+	}
+	return
+}
+
+// metadataSubscriptions tracks the keys a session has subscribed to with METADATA SUB.
+type metadataSubscriptions struct {
+	sync.Mutex
+	keys utils.StringSet
+}
+
+func (subs *metadataSubscriptions) Has(key string) bool {
+	subs.Lock()
+	defer subs.Unlock()
+	return subs.keys.Has(key)
+}
+
+// Add subscribes to the given keys, returning the ones that were newly added;
+// it fails without changes if the total would exceed `limit`.
+func (subs *metadataSubscriptions) Add(keys []string, limit int) (added []string, err error) {
+	subs.Lock()
+	defer subs.Unlock()
+	seen := make(utils.StringSet)
+	for _, key := range keys {
+		if !subs.keys.Has(key) && !seen.Has(key) {
+			seen.Add(key)
+			added = append(added, key)
+		}
+	}
+	if limit < len(subs.keys)+len(added) {
+		return nil, errMetadataTooManySubs
+	}
+	if subs.keys == nil {
+		subs.keys = make(utils.StringSet)
+	}
+	for _, key := range added {
+		subs.keys.Add(key)
+	}
+	return
+}
+
+// Remove unsubscribes from the given keys, returning the ones that were removed.
+func (subs *metadataSubscriptions) Remove(keys []string) (removed []string) {
+	subs.Lock()
+	defer subs.Unlock()
+	for _, key := range keys {
+		if subs.keys.Has(key) {
+			delete(subs.keys, key)
+			removed = append(removed, key)
+		}
+	}
+	return
+}
+
+func (subs *metadataSubscriptions) List() (result []string) {
+	subs.Lock()
+	defer subs.Unlock()
+	result = make([]string, 0, len(subs.keys))
+	for key := range subs.keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+func (client *Client) Metadata() (result map[string]string) {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.metadata
+}
+
+func (client *Client) setMetadata(key, value string, maxKeys int) (err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.metadata, err = updateMetadata(client.metadata, key, value, maxKeys)
+	return
+}
+
+func (client *Client) clearMetadata() (old map[string]string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	old = client.metadata
+	client.metadata = nil
+	return
+}
+
+// touchMetadataThrottle records a METADATA SET or CLEAR against the rate limit.
+func (client *Client) touchMetadataThrottle(config *Config) (throttled bool, remainingTime time.Duration) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	// pick up the current values, in case they changed across a rehash
+	client.metadataThrottle.Duration = config.Metadata.SetThrottle.Duration
+	client.metadataThrottle.Limit = config.Metadata.SetThrottle.MaxAttempts
+	return client.metadataThrottle.Touch()
+}
+
+// persistMetadata stores the client's metadata in their account, if any.
+func (client *Client) persistMetadata() {
+	account := client.Account()
+	if account == "" {
+		return
+	}
+	metadata := client.Metadata()
+	_, err := client.server.accounts.ModifyAccountSettings(account, func(in AccountSettings) (out AccountSettings, err error) {
+		out = in
+		out.Metadata = metadata
+		return
+	})
+	if err != nil {
+		client.server.logger.Error("internal", "couldn't persist metadata for", account, err.Error())
+	}
+}
+
+// metadataVisibleTo returns whether `viewer` can see the client's metadata:
+// this depends on the client's privacy setting (NS SET METADATA-PRIVACY).
+func (client *Client) metadataVisibleTo(viewer *Client) bool {
+	if viewer == client || !client.AccountSettings().HideMetadata || viewer.HasRoleCapabs("accreg") {
+		return true
+	}
+	for _, channel := range viewer.Channels() {
+		if channel.hasClient(client) {
+			return true
+		}
+	}
+	return false
+}
+
+func (channel *Channel) Metadata() (result map[string]string) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.settings.Metadata
+}
+
+func (channel *Channel) setMetadata(key, value string, maxKeys int) (err error) {
+	channel.stateMutex.Lock()
+	channel.settings.Metadata, err = updateMetadata(channel.settings.Metadata, key, value, maxKeys)
+	channel.stateMutex.Unlock()
+	if err == nil {
+		channel.MarkDirty(IncludeSettings)
+	}
+	return
+}
+
+func (channel *Channel) clearMetadata() (old map[string]string) {
+	channel.stateMutex.Lock()
+	old = channel.settings.Metadata
+	channel.settings.Metadata = nil
+	channel.stateMutex.Unlock()
+	channel.MarkDirty(IncludeSettings)
+	return
+}
+
+// channel metadata is visible to channel members
+func (channel *Channel) metadataVisibleTo(viewer *Client) bool {
+	return channel.hasClient(viewer) || viewer.HasRoleCapabs("chanreg")
+}
+
+// sendMetadataBurst sends the joining session the subscribed metadata for
+// the channel and its members.
+func (channel *Channel) sendMetadataBurst(client *Client, rb *ResponseBuffer) {
+	if !rb.session.capabilities.Has(caps.Metadata) {
+		return
+	}
+	sendSubscribedMetadata(rb, channel.Name(), channel.Metadata())
+	for _, member := range channel.Members() {
+		if member != client {
+			sendSubscribedMetadata(rb, member.Nick(), member.Metadata())
+		}
+	}
+}
+
+// sendMemberMetadata notifies an existing channel member of a joining client's metadata.
+func sendMemberMetadata(session *Session, client *Client) {
+	metadata := client.Metadata()
+	if len(metadata) == 0 {
+		return
+	}
+	nick := client.Nick()
+	for _, key := range sortedMetadataKeys(metadata) {
+		if session.metadataSubs.Has(key) {
+			session.Send(nil, client.server.name, "METADATA", nick, key, metadataVisibility, metadata[key])
+		}
+	}
+}
+
+func sendSubscribedMetadata(rb *ResponseBuffer, target string, metadata map[string]string) {
+	for _, key := range sortedMetadataKeys(metadata) {
+		if rb.session.metadataSubs.Has(key) {
+			rb.Add(nil, rb.target.server.name, "METADATA", target, key, metadataVisibility, metadata[key])
+		}
+	}
+}
+
+func sortedMetadataKeys(metadata map[string]string) (result []string) {
+	result = make([]string, 0, len(metadata))
+	for key := range metadata {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+// notifyMetadataChange sends a METADATA message to all subscribed sessions
+// that can see the target, other than the session that made the change.
+func notifyMetadataChange(recipients map[*Session]empty, origin *Session, source, target, key, value string) {
+	params := []string{target, key, metadataVisibility}
+	if value != "" {
+		params = append(params, value)
+	}
+	for session := range recipients {
+		if session != origin && session.metadataSubs.Has(key) {
+			session.Send(nil, source, "METADATA", params...)
+		}
+	}
+}
+
+func metadataRecipients(targetClient *Client, targetChannel *Channel) (result map[*Session]empty) {
+	if targetClient != nil {
+		return targetClient.Friends(caps.Metadata)
+	}
+	result = make(map[*Session]empty)
+	for _, member := range targetChannel.Members() {
+		addFriendsToSet(result, member, caps.Metadata)
+	}
+	return
+}
+
+// METADATA <target> <subcommand> [<params>...]
+func metadataHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	config := server.Config()
+	if !config.Metadata.Enabled {
+		rb.Add(nil, server.name, ERR_UNKNOWNCOMMAND, client.Nick(), "METADATA", client.t("Unknown command"))
+		return false
+	}
+
+	subcommand := strings.ToLower(msg.Params[1])
+	params := msg.Params[2:]
+	switch subcommand {
+	case "sub", "unsub", "subs":
+		metadataSubsHandler(server, client, subcommand, params, rb)
+		return false
+	case "get", "list", "set", "clear", "sync":
+	default:
+		rb.Add(nil, server.name, "FAIL", "METADATA", "SUBCOMMAND_INVALID", utils.SafeErrorParam(msg.Params[1]), client.t("Invalid subcommand"))
+		return false
+	}
+
+	// resolve the target: `*` means the client itself
+	var targetClient *Client
+	var targetChannel *Channel
+	target := msg.Params[0]
+	if target == "*" {
+		targetClient = client
+	} else if channel := server.channels.Get(target); channel != nil {
+		targetChannel = channel
+	} else {
+		targetClient = server.clients.Get(target)
+	}
+	if targetClient != nil {
+		target = targetClient.Nick()
+		if !targetClient.metadataVisibleTo(client) {
+			targetClient = nil
+		}
+	} else if targetChannel != nil {
+		target = targetChannel.Name()
+		if !targetChannel.metadataVisibleTo(client) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_NO_PERMISSION", target, "*", client.t("You're not on that channel"))
+			return false
+		}
+	}
+	if targetClient == nil && targetChannel == nil {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "INVALID_TARGET", utils.SafeErrorParam(msg.Params[0]), client.t("No such nick/channel"))
+		return false
+	}
+
+	var metadata map[string]string
+	if targetClient != nil {
+		metadata = targetClient.Metadata()
+	} else {
+		metadata = targetChannel.Metadata()
+	}
+
+	switch subcommand {
+	case "get":
+		if len(params) == 0 {
+			rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.Nick(), "METADATA", client.t("Not enough parameters"))
+			return false
+		}
+		batchID := metadataBatch(rb, target)
+		for _, key := range params {
+			key = strings.ToLower(key)
+			if !metadataKeyValid(key) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key"))
+			} else if value, ok := metadata[key]; ok {
+				rb.Add(nil, server.name, RPL_KEYVALUE, client.Nick(), target, key, metadataVisibility, value)
+			} else {
+				rb.Add(nil, server.name, RPL_KEYNOTSET, client.Nick(), target, key, client.t("Key not set"))
+			}
+		}
+		rb.EndNestedBatch(batchID)
+	case "list":
+		batchID := metadataBatch(rb, target)
+		for _, key := range sortedMetadataKeys(metadata) {
+			rb.Add(nil, server.name, RPL_KEYVALUE, client.Nick(), target, key, metadataVisibility, metadata[key])
+		}
+		rb.EndNestedBatch(batchID)
+	case "sync":
+		batchID := metadataBatch(rb, target)
+		sendSubscribedMetadata(rb, target, metadata)
+		if targetChannel != nil {
+			for _, member := range targetChannel.Members() {
+				sendSubscribedMetadata(rb, member.Nick(), member.Metadata())
+			}
+		}
+		rb.EndNestedBatch(batchID)
+	case "set", "clear":
+		metadataModifyHandler(server, client, config, subcommand, params, target, targetClient, targetChannel, rb)
+	}
+	return false
+}
+
+func metadataBatch(rb *ResponseBuffer, target string) (batchID string) {
+	if rb.session.capabilities.Has(caps.Batch) {
+		batchID = rb.StartNestedBatch("metadata", target)
+	}
+	return
+}
+
+// METADATA <target> SET <key> [<value>] and METADATA <target> CLEAR
+func metadataModifyHandler(server *Server, client *Client, config *Config, subcommand string, params []string, target string, targetClient *Client, targetChannel *Channel, rb *ResponseBuffer) {
+	var key, value string
+	if subcommand == "set" {
+		if len(params) == 0 {
+			rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.Nick(), "METADATA", client.t("Not enough parameters"))
+			return
+		}
+		key = strings.ToLower(params[0])
+		if 1 < len(params) {
+			value = params[1]
+		}
+		if !metadataKeyValid(key) || (config.Metadata.allowedKeys != nil && !config.Metadata.allowedKeys.Has(key)) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key"))
+			return
+		}
+		if !metadataValueValid(value, config.Metadata.MaxValueBytes) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "VALUE_INVALID", client.t("Value is too long or contains invalid characters"))
+			return
+		}
+	} else {
+		key = "*"
+	}
+
+	// users can modify their own metadata, channel operators can modify the channel's
+	var allowed bool
+	if targetClient != nil {
+		allowed = targetClient == client
+	} else {
+		allowed = targetChannel.ClientIsAtLeast(client, modes.ChannelOperator) || client.HasRoleCapabs("chanreg")
+	}
+	if !allowed {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_NO_PERMISSION", target, key, client.t("You don't have permission to modify metadata for that target"))
+		return
+	}
+
+	if throttled, remainingTime := client.touchMetadataThrottle(config); throttled {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "RATE_LIMITED", target, key, fmt.Sprintf("%d", int(remainingTime.Seconds())+1), client.t("You're modifying metadata too quickly"))
+		return
+	}
+
+	recipients := metadataRecipients(targetClient, targetChannel)
+	details := client.Details()
+
+	if subcommand == "clear" {
+		var old map[string]string
+		if targetClient != nil {
+			old = client.clearMetadata()
+			client.persistMetadata()
+		} else {
+			old = targetChannel.clearMetadata()
+		}
+		batchID := metadataBatch(rb, target)
+		for _, key := range sortedMetadataKeys(old) {
+			rb.Add(nil, server.name, RPL_KEYNOTSET, details.nick, target, key, client.t("Key not set"))
+			notifyMetadataChange(recipients, rb.session, details.nickMask, target, key, "")
+		}
+		rb.EndNestedBatch(batchID)
+		return
+	}
+
+	var err error
+	if targetClient != nil {
+		if err = client.setMetadata(key, value, config.Metadata.MaxKeys); err == nil {
+			client.persistMetadata()
+		}
+	} else {
+		err = targetChannel.setMetadata(key, value, config.Metadata.MaxKeys)
+	}
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "LIMIT_REACHED", target, client.t("Too many metadata keys"))
+		return
+	}
+
+	if value != "" {
+		rb.Add(nil, server.name, RPL_KEYVALUE, details.nick, target, key, metadataVisibility, value)
+	} else {
+		rb.Add(nil, server.name, RPL_KEYNOTSET, details.nick, target, key, client.t("Key not set"))
+	}
+	notifyMetadataChange(recipients, rb.session, details.nickMask, target, key, value)
+}
+
+// METADATA * SUB <key>..., METADATA * UNSUB <key>..., METADATA * SUBS
+func metadataSubsHandler(server *Server, client *Client, subcommand string, params []string, rb *ResponseBuffer) {
+	if subcommand == "subs" {
+		if subs := rb.session.metadataSubs.List(); len(subs) != 0 {
+			rb.Add(nil, server.name, RPL_METADATASUBS, client.Nick(), strings.Join(subs, " "))
+		}
+		return
+	}
+
+	if len(params) == 0 {
+		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.Nick(), "METADATA", client.t("Not enough parameters"))
+		return
+	}
+	keys := make([]string, 0, len(params))
+	for _, key := range params {
+		key = strings.ToLower(key)
+		if metadataKeyValid(key) {
+			keys = append(keys, key)
+		} else {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", utils.SafeErrorParam(key), client.t("Invalid key"))
+		}
+	}
+
+	if subcommand == "sub" {
+		added, err := rb.session.metadataSubs.Add(keys, server.Config().Metadata.MaxSubs)
+		if err != nil {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "TOO_MANY_SUBS", client.t("Too many subscriptions"))
+		} else if len(added) != 0 {
+			rb.Add(nil, server.name, RPL_METADATASUBOK, client.Nick(), strings.Join(added, " "))
+		}
+	} else {
+		if removed := rb.session.metadataSubs.Remove(keys); len(removed) != 0 {
+			rb.Add(nil, server.name, RPL_METADATAUNSUBOK, client.Nick(), strings.Join(removed, " "))
+		}
+	}
+}
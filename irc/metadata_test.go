@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestMetadataKeyValid(t *testing.T) {
+	assertEqual(metadataKeyValid("avatar"), true, t)
+	assertEqual(metadataKeyValid("chat.example/display-name_2"), true, t)
+	assertEqual(metadataKeyValid(""), false, t)
+	assertEqual(metadataKeyValid("Avatar"), false, t)
+	assertEqual(metadataKeyValid("avatar url"), false, t)
+	assertEqual(metadataKeyValid("*"), false, t)
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	original := map[string]string{"avatar": "https://example.com/a.png"}
+
+	updated, err := updateMetadata(original, "pronouns", "they/them", 2)
+	assertEqual(err, nil, t)
+	assertEqual(len(updated), 2, t)
+	// copy-on-write: the original map must be unchanged
+	assertEqual(len(original), 1, t)
+
+	_, err = updateMetadata(updated, "bio", "hi", 2)
+	assertEqual(err, errMetadataLimitReached, t)
+	// overwriting an existing key doesn't count against the limit
+	updated, err = updateMetadata(updated, "avatar", "https://example.com/b.png", 2)
+	assertEqual(err, nil, t)
+	assertEqual(updated["avatar"], "https://example.com/b.png", t)
+
+	updated, _ = updateMetadata(updated, "avatar", "", 2)
+	updated, _ = updateMetadata(updated, "pronouns", "", 2)
+	assertEqual(updated == nil, true, t)
+}
+
+func TestMetadataSubscriptions(t *testing.T) {
+	var subs metadataSubscriptions
+	added, err := subs.Add([]string{"avatar", "pronouns", "avatar"}, 3)
+	assertEqual(err, nil, t)
+	assertEqual(added, []string{"avatar", "pronouns"}, t)
+	_, err = subs.Add([]string{"bio", "website"}, 3)
+	assertEqual(err, errMetadataTooManySubs, t)
+	assertEqual(subs.Remove([]string{"avatar", "bio"}), []string{"avatar"}, t)
+	assertEqual(subs.List(), []string{"pronouns"}, t)
+	assertEqual(subs.Has("pronouns"), true, t)
+}
@@ -90,10 +90,18 @@ func ApplyUserModeChanges(client *Client, changes modes.ModeChanges, force bool,
 			success := false
 			if len(addMasks) != 0 {
 				oper := client.Oper()
-				// #1176: require special operator privileges to subscribe to snomasks
-				if force || oper.HasRoleCapab("snomasks") || oper.HasRoleCapab("ban") {
+				// #1176: require special operator privileges to subscribe to snomasks;
+				// masks the operator is not privileged for are silently dropped
+				var permittedMasks sno.Masks
+				for _, mask := range addMasks {
+					if force || operCanSubscribe(oper, mask) {
+						permittedMasks = append(permittedMasks, mask)
+					}
+				}
+				if len(permittedMasks) != 0 {
 					success = true
-					client.server.snomasks.AddMasks(client, addMasks...)
+					client.server.snomasks.AddMasks(client, permittedMasks...)
+					newArg = "+" + permittedMasks.String()
 				}
 			}
 			if len(removeMasks) != 0 {
@@ -267,7 +275,8 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 				} else if ch == channel {
 					rb.Add(nil, client.server.name, ERR_INVALIDMODEPARAM, details.nick, chname, string(change.Mode), utils.SafeErrorParam(change.Arg), fmt.Sprintf(client.t("You can't forward a channel to itself")))
 				} else {
-					if isSamode || ch.ClientIsAtLeast(client, modes.ChannelOperator) {
+					// +F on the target channel allows anyone to forward to it
+					if isSamode || ch.ClientIsAtLeast(client, modes.ChannelOperator) || ch.flags.HasMode(modes.FreeForward) {
 						change.Arg = ch.Name()
 						channel.setForward(change.Arg)
 						applied = append(applied, change)
@@ -280,6 +289,38 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 				applied = append(applied, change)
 			}
 
+		case modes.JoinThrottle:
+			switch change.Op {
+			case modes.Add:
+				jt, err := ParseJoinThrottle(change.Arg)
+				if err == nil {
+					change.Arg = jt.String()
+					channel.setJoinThrottle(jt)
+					applied = append(applied, change)
+				} else {
+					rb.Add(nil, client.server.name, ERR_INVALIDMODEPARAM, details.nick, chname, string(change.Mode), utils.SafeErrorParam(change.Arg), fmt.Sprintf(client.t("Invalid join throttle %s; the format is <joins>:<seconds>"), change.Arg))
+				}
+			case modes.Remove:
+				channel.setJoinThrottle(JoinThrottle{})
+				applied = append(applied, change)
+			}
+
+		case modes.JoinDelay:
+			switch change.Op {
+			case modes.Add:
+				delay, err := ParseJoinDelay(change.Arg)
+				if err == nil {
+					change.Arg = strconv.Itoa(delay)
+					channel.setJoinDelay(delay)
+					applied = append(applied, change)
+				} else {
+					rb.Add(nil, client.server.name, ERR_INVALIDMODEPARAM, details.nick, chname, string(change.Mode), utils.SafeErrorParam(change.Arg), fmt.Sprintf(client.t("Invalid join delay %s; it must be a number of seconds between 1 and %d"), change.Arg, maxJoinDelay))
+				}
+			case modes.Remove:
+				channel.setJoinDelay(0)
+				applied = append(applied, change)
+			}
+
 		case modes.Key:
 			switch change.Op {
 			case modes.Add:
@@ -310,6 +351,22 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 				applied = append(applied, change)
 			}
 
+		case modes.Permanent:
+			if change.Op == modes.List {
+				continue
+			}
+			if !client.HasRoleCapabs("chanreg") {
+				rb.Add(nil, client.server.name, ERR_NOPRIVILEGES, details.nick, client.t("Permission Denied"))
+				continue
+			}
+			if channel.IsRegistered() {
+				rb.Add(nil, client.server.name, ERR_INVALIDMODEPARAM, details.nick, chname, string(change.Mode), "*", client.t("Registered channels are already permanent"))
+				continue
+			}
+			if channel.flags.SetMode(change.Mode, change.Op == modes.Add) {
+				applied = append(applied, change)
+			}
+
 		default:
 			// all channel modes with no args, e.g., InviteOnly, Secret
 			if change.Op == modes.List {
@@ -317,6 +374,7 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 			}
 
 			if channel.flags.SetMode(change.Mode, change.Op == modes.Add) {
+				channel.releaseJoinProtection(change.Mode)
 				applied = append(applied, change)
 			}
 		}
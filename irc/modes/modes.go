@@ -25,6 +25,7 @@ var (
 		BanMask, ChanRoleplaying, ExceptMask, InviteMask, InviteOnly, Key,
 		Moderated, NoOutside, OpOnlyTopic, RegisteredOnly, RegisteredOnlySpeak,
 		Secret, UserLimit, NoCTCP, Auditorium, OpModerated, Forward,
+		JoinThrottle, FreeForward, Permanent, HiddenHistory, JoinDelay,
 	}
 )
 
@@ -130,6 +131,11 @@ const (
 	NoCTCP              Mode = 'C' // flag
 	OpModerated         Mode = 'U' // flag
 	Forward             Mode = 'f' // flag arg
+	JoinThrottle        Mode = 'j' // flag arg
+	FreeForward         Mode = 'F' // flag
+	Permanent           Mode = 'P' // flag
+	HiddenHistory       Mode = 'H' // flag
+	JoinDelay           Mode = 'd' // flag arg
 )
 
 var (
@@ -275,7 +281,7 @@ func ParseChannelModeChanges(params ...string) (ModeChanges, map[rune]bool) {
 				} else {
 					continue
 				}
-			case UserLimit, Forward:
+			case UserLimit, Forward, JoinThrottle, JoinDelay:
 				// don't require value when removing
 				if change.Op == Add {
 					if len(params) > skipArgs {
@@ -443,7 +449,7 @@ func RplMyInfo() (param1, param2, param3 string) {
 	sort.Sort(ByCodepoint(channelModes))
 
 	// XXX enumerate these by hand, i can't see any way to DRY this
-	channelParametrizedModes := Modes{BanMask, ExceptMask, InviteMask, Key, UserLimit, Forward}
+	channelParametrizedModes := Modes{BanMask, ExceptMask, InviteMask, Key, UserLimit, Forward, JoinThrottle, JoinDelay}
 	channelParametrizedModes = append(channelParametrizedModes, ChannelUserModes...)
 	sort.Sort(ByCodepoint(channelParametrizedModes))
 
@@ -457,9 +463,9 @@ func ChanmodesToken() (result string) {
 	// type B: modes with parameters
 	B := Modes{Key}
 	// type C: modes that take a parameter only when set, never when unset
-	C := Modes{UserLimit, Forward}
+	C := Modes{UserLimit, Forward, JoinThrottle, JoinDelay}
 	// type D: modes without parameters
-	D := Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret, NoCTCP, RegisteredOnly, RegisteredOnlySpeak, Auditorium, OpModerated}
+	D := Modes{InviteOnly, Moderated, NoOutside, OpOnlyTopic, ChanRoleplaying, Secret, NoCTCP, RegisteredOnly, RegisteredOnlySpeak, Auditorium, OpModerated, FreeForward, Permanent, HiddenHistory}
 
 	sort.Sort(ByCodepoint(A))
 	sort.Sort(ByCodepoint(B))
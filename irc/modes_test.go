@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/utils"
 )
 
 func TestParseDefaultChannelModes(t *testing.T) {
@@ -92,3 +94,46 @@ func TestChannelUserModeHasPrivsOver(t *testing.T) {
 	assertEqual(channelUserModeHasPrivsOver(modes.ChannelFounder, modes.ChannelAdmin), true, t)
 	assertEqual(channelUserModeHasPrivsOver(modes.ChannelOperator, modes.ChannelOperator), true, t)
 }
+
+func TestSnomaskPrivileges(t *testing.T) {
+	makeOper := func(capabs ...string) *Oper {
+		class := &OperClass{Capabilities: make(utils.StringSet)}
+		for _, capab := range capabs {
+			class.Capabilities.Add(capab)
+		}
+		return &Oper{Class: class}
+	}
+
+	admin := makeOper("snomasks")
+	moderator := makeOper("ban")
+	vhoster := makeOper("vhosts")
+
+	assertEqual(operCanSubscribe(admin, sno.LocalAccounts), true, t)
+	assertEqual(operCanSubscribe(moderator, sno.LocalFlood), true, t)
+	assertEqual(operCanSubscribe(moderator, sno.LocalAccounts), true, t)
+	assertEqual(operCanSubscribe(vhoster, sno.LocalVhosts), true, t)
+	assertEqual(operCanSubscribe(vhoster, sno.LocalKills), false, t)
+	assertEqual(operCanSubscribe(nil, sno.LocalKills), false, t)
+}
+
+func TestSnomaskSendLimited(t *testing.T) {
+	var m SnoManager
+	m.Initialize()
+	for i := 0; i < snoLimitCount+2; i++ {
+		m.SendLimited(sno.LocalFlood, "Client exceeded connection throttle")
+	}
+	assertEqual(m.limits[sno.LocalFlood].suppressed, 2, t)
+}
+
+func TestMemberModeParams(t *testing.T) {
+	channel := &Channel{name: "#test", members: make(MemberSet)}
+	client := new(Client)
+	assertEqual(channel.memberModeParams(client, "alice"), []string(nil), t)
+
+	channel.members.Add(client)
+	assertEqual(channel.memberModeParams(client, "alice"), []string(nil), t)
+
+	channel.members[client].modes.SetMode(modes.ChannelOperator, true)
+	channel.members[client].modes.SetMode(modes.Voice, true)
+	assertEqual(channel.memberModeParams(client, "alice"), []string{"#test", "+ov", "alice", "alice"}, t)
+}
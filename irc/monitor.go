@@ -4,60 +4,85 @@
 package irc
 
 import (
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ergochat/ergo/irc/caps"
 
 	"github.com/ergochat/irc-go/ircmsg"
 )
 
+// monitorList distinguishes a session's MONITOR list from its (legacy) WATCH list;
+// they are maintained separately but share the same notification path.
+type monitorList uint8
+
+const (
+	monitorListMonitor monitorList = iota
+	monitorListWatch
+)
+
+type monitorKey struct {
+	session *Session
+	list    monitorList
+}
+
 // MonitorManager keeps track of who's monitoring which nicks.
 type MonitorManager struct {
 	sync.RWMutex // tier 2
-	// client -> (casefolded nick it's watching -> uncasefolded nick)
-	watching map[*Session]map[string]string
-	// casefolded nick -> clients watching it
-	watchedby map[string]map[*Session]empty
+	// session and list -> (casefolded nick it's watching -> uncasefolded nick)
+	watching map[monitorKey]map[string]string
+	// casefolded nick -> sessions and lists watching it
+	watchedby map[string]map[monitorKey]empty
 }
 
 func (mm *MonitorManager) Initialize() {
-	mm.watching = make(map[*Session]map[string]string)
-	mm.watchedby = make(map[string]map[*Session]empty)
+	mm.watching = make(map[monitorKey]map[string]string)
+	mm.watchedby = make(map[string]map[monitorKey]empty)
 }
 
 // AddMonitors adds clients using extended-monitor monitoring `client`'s nick to the passed user set.
 func (manager *MonitorManager) AddMonitors(users map[*Session]empty, cfnick string, capabs ...caps.Capability) {
 	manager.RLock()
 	defer manager.RUnlock()
-	for session := range manager.watchedby[cfnick] {
-		if session.capabilities.Has(caps.ExtendedMonitor) && session.capabilities.HasAll(capabs...) {
+	for key := range manager.watchedby[cfnick] {
+		session := key.session
+		if key.list == monitorListMonitor && session.capabilities.Has(caps.ExtendedMonitor) && session.capabilities.HasAll(capabs...) {
 			users[session] = empty{}
 		}
 	}
 }
 
 // AlertAbout alerts everyone monitoring `client`'s nick that `client` is now {on,off}line.
-func (manager *MonitorManager) AlertAbout(nick, cfnick string, online bool) {
-	var watchers []*Session
+func (manager *MonitorManager) AlertAbout(nick, cfnick, username, hostname string, online bool) {
+	var watchers []monitorKey
 	// safely copy the list of clients watching our nick
 	manager.RLock()
-	for session := range manager.watchedby[cfnick] {
-		watchers = append(watchers, session)
+	for key := range manager.watchedby[cfnick] {
+		watchers = append(watchers, key)
 	}
 	manager.RUnlock()
 
 	command := RPL_MONOFFLINE
+	watchCommand, watchMessage := RPL_LOGOFF, "logged offline"
 	if online {
 		command = RPL_MONONLINE
+		watchCommand, watchMessage = RPL_LOGON, "logged online"
 	}
-
-	for _, session := range watchers {
-		session.Send(nil, session.client.server.name, command, session.client.Nick(), nick)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for _, key := range watchers {
+		session := key.session
+		if key.list == monitorListWatch {
+			session.Send(nil, session.client.server.name, watchCommand, session.client.Nick(), nick, username, hostname, now, session.client.t(watchMessage))
+		} else {
+			session.Send(nil, session.client.server.name, command, session.client.Nick(), nick)
+		}
 	}
 }
 
-// Add registers `client` to receive notifications about `nick`.
-func (manager *MonitorManager) Add(session *Session, nick string, limit int) error {
+// Add registers `session` to receive notifications about `nick`.
+func (manager *MonitorManager) Add(session *Session, list monitorList, nick string, limit int) error {
 	cfnick, err := CasefoldName(nick)
 	if err != nil {
 		return err
@@ -66,24 +91,25 @@ func (manager *MonitorManager) Add(session *Session, nick string, limit int) err
 	manager.Lock()
 	defer manager.Unlock()
 
-	if manager.watching[session] == nil {
-		manager.watching[session] = make(map[string]string)
+	key := monitorKey{session, list}
+	if manager.watching[key] == nil {
+		manager.watching[key] = make(map[string]string)
 	}
 	if manager.watchedby[cfnick] == nil {
-		manager.watchedby[cfnick] = make(map[*Session]empty)
+		manager.watchedby[cfnick] = make(map[monitorKey]empty)
 	}
 
-	if len(manager.watching[session]) >= limit {
+	if _, ok := manager.watching[key][cfnick]; !ok && len(manager.watching[key]) >= limit {
 		return errMonitorLimitExceeded
 	}
 
-	manager.watching[session][cfnick] = nick
-	manager.watchedby[cfnick][session] = empty{}
+	manager.watching[key][cfnick] = nick
+	manager.watchedby[cfnick][key] = empty{}
 	return nil
 }
 
-// Remove unregisters `client` from receiving notifications about `nick`.
-func (manager *MonitorManager) Remove(session *Session, nick string) (err error) {
+// Remove unregisters `session` from receiving notifications about `nick`.
+func (manager *MonitorManager) Remove(session *Session, list monitorList, nick string) (err error) {
 	cfnick, err := CasefoldName(nick)
 	if err != nil {
 		return
@@ -91,32 +117,59 @@ func (manager *MonitorManager) Remove(session *Session, nick string) (err error)
 
 	manager.Lock()
 	defer manager.Unlock()
-	delete(manager.watching[session], cfnick)
-	delete(manager.watchedby[cfnick], session)
+	key := monitorKey{session, list}
+	delete(manager.watching[key], cfnick)
+	delete(manager.watchedby[cfnick], key)
 	return nil
 }
 
-// RemoveAll unregisters `client` from receiving notifications about *all* nicks.
-func (manager *MonitorManager) RemoveAll(session *Session) {
+// Clear unregisters `session` from receiving notifications about all nicks on one list.
+func (manager *MonitorManager) Clear(session *Session, list monitorList) {
 	manager.Lock()
 	defer manager.Unlock()
 
-	for cfnick := range manager.watching[session] {
-		delete(manager.watchedby[cfnick], session)
+	manager.clearNoMutex(monitorKey{session, list})
+}
+
+func (manager *MonitorManager) clearNoMutex(key monitorKey) {
+	for cfnick := range manager.watching[key] {
+		delete(manager.watchedby[cfnick], key)
 	}
-	delete(manager.watching, session)
+	delete(manager.watching, key)
+}
+
+// RemoveAll unregisters `session` from receiving notifications about *all* nicks,
+// on both its MONITOR and WATCH lists.
+func (manager *MonitorManager) RemoveAll(session *Session) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	manager.clearNoMutex(monitorKey{session, monitorListMonitor})
+	manager.clearNoMutex(monitorKey{session, monitorListWatch})
 }
 
-// List lists all nicks that `client` is registered to receive notifications about.
-func (manager *MonitorManager) List(session *Session) (nicks []string) {
+// List lists all nicks that `session` is registered to receive notifications about.
+func (manager *MonitorManager) List(session *Session, list monitorList) (nicks []string) {
 	manager.RLock()
 	defer manager.RUnlock()
-	for _, nick := range manager.watching[session] {
+	for _, nick := range manager.watching[monitorKey{session, list}] {
 		nicks = append(nicks, nick)
 	}
 	return nicks
 }
 
+// CountWatchers returns the number of sessions with `cfnick` on the given list.
+func (manager *MonitorManager) CountWatchers(cfnick string, list monitorList) (count int) {
+	manager.RLock()
+	defer manager.RUnlock()
+	for key := range manager.watchedby[cfnick] {
+		if key.list == list {
+			count++
+		}
+	}
+	return
+}
+
 var (
 	monitorSubcommands = map[string]func(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool{
 		"-": monitorRemoveHandler,
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircutils"
+
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+const (
+	// minimum interval between CS SET MUTE-NOTIFY notices about a given member
+	muteNotifyInterval = time.Minute
+	// maximum length of the message text included in the notice
+	muteNotifyMaxLen = 100
+)
+
+// muteNotifyAllowed checks the per-member throttle for mute notifications;
+// last is the UnixNano timestamp of the previous notice (or 0).
+func muteNotifyAllowed(last int64, now time.Time) bool {
+	return last == 0 || now.Sub(time.Unix(0, last)) >= muteNotifyInterval
+}
+
+// takeMuteNotify returns whether a mute notification about this member
+// may be sent now, and if so, records it.
+func (channel *Channel) takeMuteNotify(client *Client, now time.Time) bool {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	memberData, ok := channel.members[client]
+	if !ok || !muteNotifyAllowed(memberData.lastMuted, now) {
+		return false
+	}
+	memberData.lastMuted = now.UnixNano()
+	channel.members[client] = memberData
+	return true
+}
+
+// noticeMutedMessage informs channel operators that a muted member
+// attempted to speak, if the channel has enabled CS SET MUTE-NOTIFY
+func (channel *Channel) noticeMutedMessage(client *Client, message utils.SplitMessage) {
+	if !channel.Settings().MuteNotify || !channel.takeMuteNotify(client, time.Now()) {
+		return
+	}
+
+	text := message.Message
+	if !message.Is512() {
+		lines := make([]string, len(message.Split))
+		for i, pair := range message.Split {
+			lines[i] = pair.Message
+		}
+		text = strings.Join(lines, " ")
+	}
+	text = ircutils.TruncateUTF8Safe(text, muteNotifyMaxLen)
+
+	nick := client.Nick()
+	chname := channel.Name()
+	for _, member := range channel.Members() {
+		if !channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			continue
+		}
+		notice := fmt.Sprintf(member.t("%[1]s attempted to speak while muted: %[2]s"), nick, text)
+		for _, session := range member.Sessions() {
+			session.Send(nil, chanservService.prefix, "NOTICE", "@"+chname, notice)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMuteNotifyAllowed(t *testing.T) {
+	now := time.Now()
+	assertEqual(muteNotifyAllowed(0, now), true, t)
+	assertEqual(muteNotifyAllowed(now.Add(-10*time.Second).UnixNano(), now), false, t)
+	assertEqual(muteNotifyAllowed(now.Add(-muteNotifyInterval).UnixNano(), now), true, t)
+}
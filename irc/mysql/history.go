@@ -6,10 +6,8 @@ package mysql
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -722,6 +720,65 @@ func (mysql *MySQL) AddDirectMessage(sender, senderAccount, recipient, recipient
 	return
 }
 
+// MigrateDirectMessages associates existing DM history of the nick `account`
+// (i.e., conversations it had with registered correspondents while it was
+// unregistered, or under a previous registration of the same name) with the
+// newly registered account of the same name. account is the casefolded name.
+func (mysql *MySQL) MigrateDirectMessages(account string) (count int64, err error) {
+	if mysql.db == nil || account == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	// the correspondent's side of the conversation is keyed by its account,
+	// which we take as the correspondent for our side:
+	result, err := mysql.db.ExecContext(ctx, `
+		INSERT INTO conversations (target, correspondent, nanotime, history_id)
+		SELECT ?, theirs.target, theirs.nanotime, theirs.history_id
+		FROM conversations theirs
+		WHERE theirs.correspondent = ? AND theirs.target != ?
+		AND NOT EXISTS (
+			SELECT 1 FROM conversations ours
+			WHERE ours.target = ? AND ours.history_id = theirs.history_id
+		);`, account, account, account, account)
+	if mysql.logError("could not migrate conversations", err) {
+		return
+	}
+	count, err = result.RowsAffected()
+	if err != nil || count == 0 {
+		return
+	}
+
+	_, err = mysql.db.ExecContext(ctx, `
+		INSERT INTO correspondents (target, correspondent, nanotime)
+		SELECT target, correspondent, MAX(nanotime)
+		FROM conversations WHERE target = ?
+		GROUP BY target, correspondent
+		ON DUPLICATE KEY UPDATE nanotime = GREATEST(correspondents.nanotime, VALUES(nanotime));`, account)
+	mysql.logError("could not migrate correspondents", err)
+	return
+}
+
+// UpdateAccountName re-points the account index of messages sent by
+// `oldAccount` to `newAccount`, e.g., when merging two accounts.
+// Both names are casefolded.
+func (mysql *MySQL) UpdateAccountName(oldAccount, newAccount string) (count int64, err error) {
+	if mysql.db == nil || !mysql.isTrackingAccountMessages() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	result, err := mysql.db.ExecContext(ctx, `UPDATE account_messages SET account = ? WHERE account = ?;`, newAccount, oldAccount)
+	if mysql.logError("could not update account messages", err) {
+		return
+	}
+	return result.RowsAffected()
+}
+
 // note that accountName is the unfolded name
 func (mysql *MySQL) DeleteMsgid(msgid, accountName string) (err error) {
 	if mysql.db == nil {
@@ -750,7 +807,7 @@ func (mysql *MySQL) DeleteMsgid(msgid, accountName string) (err error) {
 	return
 }
 
-func (mysql *MySQL) Export(account string, writer io.Writer) {
+func (mysql *MySQL) Export(account string, write func(history.Item) error) {
 	if mysql.db == nil {
 		return
 	}
@@ -775,7 +832,7 @@ func (mysql *MySQL) Export(account string, writer io.Writer) {
 			defer rows.Close()
 			for rows.Next() {
 				var id uint64
-				var blob, jsonBlob []byte
+				var blob []byte
 				var target string
 				var item history.Item
 				err = rows.Scan(&id, &blob, &target)
@@ -787,7 +844,7 @@ func (mysql *MySQL) Export(account string, writer io.Writer) {
 					return
 				}
 				item.CfCorrespondent = target
-				jsonBlob, err = json.Marshal(item)
+				err = write(item)
 				if err != nil {
 					return
 				}
@@ -795,8 +852,6 @@ func (mysql *MySQL) Export(account string, writer io.Writer) {
 				if lastSeen < id {
 					lastSeen = id
 				}
-				writer.Write(jsonBlob)
-				writer.Write([]byte{'\n'})
 			}
 			return
 		}()
@@ -1018,6 +1073,151 @@ func (mysql *MySQL) ListChannels(cfchannels []string) (results []history.TargetL
 	return
 }
 
+// RenameTarget moves the stored history of a channel to a new name,
+// e.g. after the channel is renamed.
+func (mysql *MySQL) RenameTarget(oldTarget, newTarget string) (err error) {
+	if mysql.db == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	_, err = mysql.db.ExecContext(ctx, `UPDATE sequence SET target = ? WHERE target = ?;`, newTarget, oldTarget)
+	mysql.logError("could not rename history target", err)
+	return
+}
+
+// messageItemFilter restricts a query joined with the history table to PRIVMSG
+// and NOTICE items, like the in-memory counts; the item type is only available
+// in the serialized item.
+var messageItemFilter = fmt.Sprintf(`JSON_EXTRACT(CONVERT(history.data USING utf8mb4), '$.Type') IN (%d, %d)`,
+	history.Privmsg, history.Notice)
+
+// ChannelStats returns the number of messages sent by each account
+// to a channel, in descending order. It requires account message tracking.
+func (mysql *MySQL) ChannelStats(target string, limit int) (results []history.Count, err error) {
+	if mysql.db == nil || !mysql.isTrackingAccountMessages() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	return mysql.selectCounts(ctx, fmt.Sprintf(`
+		SELECT account_messages.account, COUNT(*) AS count FROM sequence
+		INNER JOIN account_messages ON sequence.history_id = account_messages.history_id
+		INNER JOIN history ON sequence.history_id = history.id
+		WHERE sequence.target = ? AND %s
+		GROUP BY account_messages.account ORDER BY count DESC LIMIT ?;`, messageItemFilter), target, limit)
+}
+
+// AccountStats returns the number of messages sent by an account
+// to each channel, in descending order. It requires account message tracking.
+func (mysql *MySQL) AccountStats(account string, limit int) (results []history.Count, err error) {
+	if mysql.db == nil || !mysql.isTrackingAccountMessages() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	return mysql.selectCounts(ctx, fmt.Sprintf(`
+		SELECT sequence.target, COUNT(*) AS count FROM account_messages
+		INNER JOIN sequence ON account_messages.history_id = sequence.history_id
+		INNER JOIN history ON account_messages.history_id = history.id
+		WHERE account_messages.account = ? AND %s
+		GROUP BY sequence.target ORDER BY count DESC LIMIT ?;`, messageItemFilter), account, limit)
+}
+
+// ChannelActivity returns the number of messages (PRIVMSG and NOTICE) for a
+// channel, and the number of distinct accounts that sent them, since each of
+// the given cutoffs. Speakers are counted only with account message tracking.
+func (mysql *MySQL) ChannelActivity(target string, cutoffs []time.Time) (results []history.Activity, err error) {
+	if mysql.db == nil || len(cutoffs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	var columns []string
+	var args []interface{}
+	earliest := cutoffs[0]
+	for _, cutoff := range cutoffs {
+		columns = append(columns, "COALESCE(SUM(sequence.nanotime >= ?), 0)")
+		args = append(args, cutoff.UnixNano())
+		if cutoff.Before(earliest) {
+			earliest = cutoff
+		}
+	}
+	join := ""
+	if mysql.isTrackingAccountMessages() {
+		join = "LEFT JOIN account_messages ON sequence.history_id = account_messages.history_id"
+		for _, cutoff := range cutoffs {
+			columns = append(columns, "COUNT(DISTINCT CASE WHEN sequence.nanotime >= ? THEN account_messages.account END)")
+			args = append(args, cutoff.UnixNano())
+		}
+	}
+	args = append(args, target, earliest.UnixNano())
+
+	query := fmt.Sprintf(`SELECT %s FROM sequence
+		INNER JOIN history ON sequence.history_id = history.id %s
+		WHERE sequence.target = ? AND sequence.nanotime >= ? AND %s;`, strings.Join(columns, ", "), join, messageItemFilter)
+	counts := make([]int, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	err = mysql.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	if mysql.logError("could not query channel activity", err) {
+		return
+	}
+
+	results = make([]history.Activity, len(cutoffs))
+	for i := range results {
+		results[i].Messages = counts[i]
+		if len(cutoffs)+i < len(counts) {
+			results[i].Speakers = counts[len(cutoffs)+i]
+		}
+	}
+	return
+}
+
+// CountByAccount returns the total number of stored items sent by an account.
+// It requires account message tracking.
+func (mysql *MySQL) CountByAccount(account string) (count int, err error) {
+	if mysql.db == nil || !mysql.isTrackingAccountMessages() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mysql.getTimeout())
+	defer cancel()
+
+	row := mysql.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM account_messages WHERE account = ?;`, account)
+	err = row.Scan(&count)
+	mysql.logError("could not count account messages", err)
+	return
+}
+
+func (mysql *MySQL) selectCounts(ctx context.Context, query string, args ...interface{}) (results []history.Count, err error) {
+	rows, err := mysql.db.QueryContext(ctx, query, args...)
+	if mysql.logError("could not query history counts", err) {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var count history.Count
+		err = rows.Scan(&count.Name, &count.Count)
+		if mysql.logError("could not scan history counts", err) {
+			return
+		}
+		results = append(results, count)
+	}
+	return
+}
+
 func (mysql *MySQL) Close() {
 	// closing the database will close our prepared statements as well
 	if mysql.db != nil {
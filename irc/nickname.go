@@ -125,8 +125,9 @@ func performNickChange(server *Server, client *Client, target *Client, session *
 
 	newCfnick := target.NickCasefolded()
 	if newCfnick != details.nickCasefolded {
-		client.server.monitorManager.AlertAbout(details.nick, details.nickCasefolded, false)
-		client.server.monitorManager.AlertAbout(assignedNickname, newCfnick, true)
+		client.server.monitorManager.AlertAbout(details.nick, details.nickCasefolded, details.username, details.hostname, false)
+		client.server.monitorManager.AlertAbout(assignedNickname, newCfnick, details.username, details.hostname, true)
+		dispatchMonitorAway(target, newCfnick)
 	}
 	return nil
 }
@@ -32,6 +32,10 @@ func servCmdRequiresNickRes(config *Config) bool {
 	return config.Accounts.AuthenticationEnabled && config.Accounts.NickReservation.Enabled
 }
 
+func servCmdRequiresAccessEnabled(config *Config) bool {
+	return servCmdRequiresNickRes(config) && config.Accounts.NickReservation.Access.Enabled
+}
+
 func servCmdRequiresBouncerEnabled(config *Config) bool {
 	return config.Accounts.Multiclient.Enabled
 }
@@ -83,14 +87,17 @@ entry for $bSET$b for more information.`,
 		},
 		"ghost": {
 			handler: nsGhostHandler,
-			help: `Syntax: $bGHOST <nickname>$b
+			help: `Syntax: $bGHOST <nickname> [password] [--2fa <code>]$b
 
 GHOST disconnects the given user from the network if they're logged in with the
-same user account, letting you reclaim your nickname.`,
-			helpShort:    `$bGHOST$b reclaims your nickname.`,
-			enabled:      servCmdRequiresNickRes,
-			authRequired: true,
-			minParams:    1,
+same user account, letting you reclaim your nickname. If you're not logged in
+(for example, because your old connection is still holding your nickname), you
+can supply the password of the account that owns the nickname instead (and a
+two-factor authentication code, if the account has 2FA enabled).`,
+			helpShort: `$bGHOST$b reclaims your nickname.`,
+			enabled:   servCmdRequiresNickRes,
+			minParams: 1,
+			maxParams: 4,
 		},
 		"group": {
 			handler: nsGroupHandler,
@@ -102,12 +109,44 @@ will not be able to use it.`,
 			enabled:      servCmdRequiresNickRes,
 			authRequired: true,
 		},
+		"2fa": {
+			handler: ns2FAHandler,
+			help: `Syntax: $b2FA <ENABLE | DISABLE | BACKUP> [code]$b
+
+2FA manages two-factor authentication for your account. Once it is enabled,
+logging in with your password also requires a code from an authenticator app.
+
+$b2FA ENABLE$b generates a secret, which you can add to your authenticator app,
+either directly or with the otpauth:// URI. To complete enrollment, confirm
+with a code from the app: $b2FA ENABLE <code>$b.
+$b2FA DISABLE <code>$b disables two-factor authentication.
+$b2FA BACKUP$b generates one-time backup codes, which you can use instead of
+a code from the app (for example, if you lose your device). Generating new
+backup codes invalidates any old ones.
+
+Two-factor authentication does not apply to certificate (certfp) logins.
+SASL has no way to carry the code, so SASL logins with a password or a bearer
+token (PLAIN, SCRAM-SHA-256 and OAUTHBEARER) to an account with 2FA enabled
+are always refused. Log in with SASL EXTERNAL (certfp), or with
+$bIDENTIFY <username> <password> --2fa <code>$b instead.`,
+			helpShort:    `$b2FA$b manages two-factor authentication for your account.`,
+			enabled:      servCmdRequiresAuthEnabled,
+			authRequired: true,
+			minParams:    1,
+			maxParams:    2,
+		},
 		"identify": {
 			handler: nsIdentifyHandler,
-			help: `Syntax: $bIDENTIFY <username> [password]$b
+			help: `Syntax: $bIDENTIFY <username> [password] [--2fa <code>]$b
+        $bIDENTIFY <username> TOKEN <token>$b
 
 IDENTIFY lets you login to the given username using either password auth, or
-certfp (your client certificate) if a password is not given.`,
+certfp (your client certificate) if a password is not given. If you have
+enabled two-factor authentication (see $b2FA$b), you must also supply a code
+from your authenticator app (or a backup code) with --2fa. If the server
+is configured for single sign-on, you can instead supply a bearer token from
+the identity provider with the TOKEN keyword (use * as the username to accept
+whatever account the token identifies).`,
 			helpShort: `$bIDENTIFY$b lets you login to your account.`,
 			enabled:   servCmdRequiresAuthEnabled,
 			minParams: 1,
@@ -123,6 +162,22 @@ If no regex is provided, all registered nicknames are returned.`,
 			capabs:    []string{"accreg"},
 			minParams: 0,
 		},
+		"impersonate": {
+			handler: nsImpersonateHandler,
+			help: `Syntax: $bIMPERSONATE <account>$b
+        $bIMPERSONATE END$b
+
+IMPERSONATE logs you into another user's account, for example, to investigate
+a problem they reported. While you are impersonating an account, your hostname
+is replaced with the account's vhost or cloak, and your messages are marked
+as impersonated for other operators. $bIMPERSONATE END$b restores your own
+account. Every impersonation is logged.`,
+			helpShort: `$bIMPERSONATE$b lets operators log into another user's account.`,
+			enabled:   servCmdRequiresAuthEnabled,
+			capabs:    []string{"impersonate"},
+			minParams: 1,
+			maxParams: 1,
+		},
 		"info": {
 			handler: nsInfoHandler,
 			help: `Syntax: $bINFO [username]$b
@@ -205,13 +260,18 @@ without a code will display the necessary code.`,
 		},
 		"verify": {
 			handler: nsVerifyHandler,
-			help: `Syntax: $bVERIFY <username> <code>$b
+			help: `Syntax: $bVERIFY [username] <code>$b
+        $bVERIFY RESEND <username>$b
 
 VERIFY lets you complete an account registration, if the server requires email
-or other verification.`,
+or other verification. If you registered your current nickname, the username
+can be omitted. $bVERIFY RESEND$b generates a new verification code
+(invalidating the old one) and sends it to the e-mail address the account was
+registered with; this can be done at most 3 times per hour.`,
 			helpShort: `$bVERIFY$b lets you complete account registration.`,
 			enabled:   servCmdRequiresAccreg,
-			minParams: 2,
+			minParams: 1,
+			maxParams: 2,
 		},
 		"passwd": {
 			handler: nsPasswdHandler,
@@ -307,12 +367,40 @@ how the history of your direct messages is stored. Your options are:
 'auto-away' is only effective for always-on clients. If enabled, you will
 automatically be marked away when all your sessions are disconnected, and
 automatically return from away when you connect again.`,
+				`$bAWAY-MESSAGE$b
+'away-message' sets the away message used by 'auto-away' when all your
+sessions are disconnected. Your options are any message (up to the server's
+AWAYLEN), or 'default' to use the server's default message.`,
+				`$bREALNAME$b
+'realname' sets the realname (also known as gecos) used for all your
+connections, overriding the one sent by your client. Your options are any
+realname, or 'default' to use the one sent by your client. Changing your
+realname with the SETNAME command also updates this setting.`,
 				`$bEMAIL$b
 'email' controls the e-mail address associated with your account (if the
 server operator allows it, this address can be used for password resets).
 As an additional security measure, if you have a password set, you must
 provide it as an additional argument to $bSET$b, for example,
 SET EMAIL test@example.com hunter2`,
+				`$bREAD-RECEIPTS$b
+'read-receipts' controls whether HistServ will notify you when someone
+acknowledges reading your direct messages with $bHISTSERV READRECEIPT$b.
+Your options are 'on' and 'off'.`,
+				`$bCHANNEL-INFO$b
+'channel-info' controls whether $bINFO$b lists the channels where you hold
+persistent modes (as granted with ChanServ AMODE). Your options are 'owner'
+(the list is shown to you only) and 'hidden' (the list is not shown).
+Server administrators can always see this information.`,
+				`$bIGNORE$b
+'ignore' manages your server-side ignore list: direct messages, notices and
+invites from matching users are silently discarded, and are not stored in your
+history. Use $bSET IGNORE ADD <mask>$b or $bSET IGNORE DEL <mask>$b, where
+<mask> is a nick!user@host mask or $$a:account, and $bSET IGNORE LIST$b to
+view the list. Notices from server operators are never ignored.`,
+				`$bMETADATA-PRIVACY$b
+'metadata-privacy' controls who can see your METADATA (for example, an avatar
+or pronouns). Your options are 'public' (anyone can see it) and 'shared'
+(only users who share a channel with you can see it).`,
 			},
 			authRequired: true,
 			enabled:      servCmdRequiresAuthEnabled,
@@ -350,6 +438,21 @@ the result of a previous $bSENDPASS$b command.`,
 			enabled:   servCmdRequiresEmailReset,
 			minParams: 3,
 		},
+		"access": {
+			handler: nsAccessHandler,
+			help: `Syntax: $bACCESS <LIST | ADD | DEL> [account] [user@host]$b
+
+ACCESS controls the user@host masks from which you are recognized without
+logging in. A recognized connection can use your nickname despite nickname
+reservation, but is not logged into your account. $bACCESS LIST$b lists your
+masks, $bACCESS ADD <user@host>$b adds one (wildcards are allowed, but very
+broad masks are rejected), and $bACCESS DEL <user@host>$b removes one. IRC
+operators with the correct permissions can act on another user's account.`,
+			helpShort: `$bACCESS$b controls hostmasks that recognize you without login`,
+			enabled:   servCmdRequiresAccessEnabled,
+			minParams: 1,
+			maxParams: 3,
+		},
 		"cert": {
 			handler: nsCertHandler,
 			help: `Syntax: $bCERT <LIST | ADD | DEL> [account] [certfp]$b
@@ -368,17 +471,63 @@ for instructions on how to compute the fingerprint.`,
 		"suspend": {
 			handler: nsSuspendHandler,
 			help: `Syntax: $bSUSPEND ADD <nickname> [DURATION duration] [reason]$b
+        $bSUSPEND <nickname> [duration] [reason]$b
         $bSUSPEND DEL <nickname>$b
         $bSUSPEND LIST$b
 
 Suspending an account disables it (preventing new logins) and disconnects
-all associated clients. You can specify a time limit or a reason for
-the suspension. The $bDEL$b subcommand reverses a suspension, and the $bLIST$b
-command lists all current suspensions.`,
+all associated clients. You can specify a time limit (e.g., 7d) or a reason
+for the suspension; users attempting to log in will see both. The $bDEL$b
+subcommand (or $bUNSUSPEND$b) reverses a suspension, and the $bLIST$b
+command (or $bLISTSUSP$b) lists all current suspensions.`,
 			helpShort: `$bSUSPEND$b manages account suspensions`,
 			minParams: 1,
 			capabs:    []string{"ban"},
 		},
+		"unsuspend": {
+			handler: nsUnsuspendHandler,
+			help: `Syntax: $bUNSUSPEND <nickname>$b
+
+UNSUSPEND reverses a suspension; it is equivalent to $bSUSPEND DEL$b.`,
+			helpShort: `$bUNSUSPEND$b reverses an account suspension`,
+			minParams: 1,
+			capabs:    []string{"ban"},
+		},
+		"listsusp": {
+			handler: nsSuspendListHandler,
+			help: `Syntax: $bLISTSUSP$b
+
+LISTSUSP lists all current account suspensions; it is equivalent to
+$bSUSPEND LIST$b.`,
+			helpShort: `$bLISTSUSP$b lists account suspensions`,
+			capabs:    []string{"ban"},
+		},
+		"samerge": {
+			handler: nsSamergeHandler,
+			help: `Syntax: $bSAMERGE <from> <to>$b
+
+SAMERGE merges the account <from> into the account <to>: grouped nicks
+(including <from> itself), certificate fingerprints, the vhost, channel
+founderships, channel access (amodes and roles), and the history index are
+moved to <to>, then <from> is unregistered. Clients logged into <from> are
+logged into <to> instead. Where the two accounts conflict, <to> takes
+precedence; you'll receive a report of each item that was moved or dropped.
+Accounts with always-on clients can't be merged.`,
+			helpShort: `$bSAMERGE$b merges one account into another.`,
+			minParams: 2,
+			capabs:    []string{"accreg"},
+		},
+		"unlock": {
+			handler: nsUnlockHandler,
+			help: `Syntax: $bUNLOCK <account>$b
+
+UNLOCK removes a temporary lock placed on an account due to repeated
+failed login attempts.`,
+			helpShort: `$bUNLOCK$b unlocks an account locked after failed logins.`,
+			enabled:   servCmdRequiresAuthEnabled,
+			minParams: 1,
+			capabs:    []string{"accreg"},
+		},
 		"rename": {
 			handler: nsRenameHandler,
 			help: `Syntax: $bRENAME <account> <newname>$b
@@ -410,10 +559,10 @@ func nsGetHandler(service *ircService, server *Server, client *Client, command s
 
 	accountData, err := server.accounts.LoadAccount(account)
 	if err == errAccountDoesNotExist {
-		service.Notice(rb, client.t("No such account"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
 		return
 	} else if err != nil {
-		service.Notice(rb, client.t("Error loading account data"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("Error loading account data"))
 		return
 	}
 
@@ -502,6 +651,45 @@ func displaySetting(service *ircService, settingName string, settings AccountSet
 		} else {
 			service.Notice(rb, client.t("You have no stored e-mail address"))
 		}
+	case "channel-info":
+		if settings.HideChannelInfo {
+			service.Notice(rb, client.t("Your persistent channel modes are hidden from INFO"))
+		} else {
+			service.Notice(rb, client.t("Your persistent channel modes are shown to you in INFO"))
+		}
+	case "ignore":
+		if len(settings.Ignores) == 0 {
+			service.Notice(rb, client.t("Your ignore list is empty"))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Your ignore list has %d entries:"), len(settings.Ignores)))
+			for _, entry := range settings.Ignores {
+				service.Notice(rb, entry)
+			}
+		}
+	case "metadata-privacy":
+		if settings.HideMetadata {
+			service.Notice(rb, client.t("Your metadata is visible to users who share a channel with you"))
+		} else {
+			service.Notice(rb, client.t("Your metadata is visible to everyone"))
+		}
+	case "realname":
+		if settings.Realname == "" {
+			service.Notice(rb, client.t("Your realname is the one sent by your client when connecting"))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Your stored realname is: %s"), settings.Realname))
+		}
+	case "away-message":
+		if settings.AwayMessage == "" {
+			service.Notice(rb, client.t("Your auto-away message is the server default"))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Your auto-away message is: %s"), settings.AwayMessage))
+		}
+	case "read-receipts":
+		if settings.ReadReceipts {
+			service.Notice(rb, client.t("You will be notified when your direct messages are read"))
+		} else {
+			service.Notice(rb, client.t("You will not be notified when your direct messages are read"))
+		}
 	default:
 		service.Notice(rb, client.t("No such setting"))
 	}
@@ -644,6 +832,30 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 				return
 			}
 		}
+	case "realname":
+		newValue := strings.Join(params[1:], " ")
+		if strings.ToLower(newValue) == "default" {
+			newValue = ""
+		}
+		munger = func(in AccountSettings) (out AccountSettings, err error) {
+			out = in
+			out.Realname = newValue
+			return
+		}
+	case "away-message":
+		newValue := strings.Join(params[1:], " ")
+		if strings.ToLower(newValue) == "default" {
+			newValue = ""
+		}
+		if awayLen := server.Config().Limits.AwayLen; awayLen < len(newValue) {
+			service.Notice(rb, fmt.Sprintf(client.t("Away message is too long (the maximum is %d bytes)"), awayLen))
+			return
+		}
+		munger = func(in AccountSettings) (out AccountSettings, err error) {
+			out = in
+			out.AwayMessage = newValue
+			return
+		}
 	case "dm-history":
 		var newValue HistoryStatus
 		newValue, err = historyStatusFromString(params[1])
@@ -661,6 +873,73 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 			out.Email = newValue
 			return
 		}
+	case "channel-info":
+		var newValue bool
+		switch strings.ToLower(params[1]) {
+		case "owner":
+			newValue = false
+		case "hidden":
+			newValue = true
+		default:
+			err = errInvalidParams
+		}
+		if err == nil {
+			munger = func(in AccountSettings) (out AccountSettings, err error) {
+				out = in
+				out.HideChannelInfo = newValue
+				return
+			}
+		}
+	case "metadata-privacy":
+		var newValue bool
+		switch strings.ToLower(params[1]) {
+		case "public":
+			newValue = false
+		case "shared":
+			newValue = true
+		default:
+			err = errInvalidParams
+		}
+		if err == nil {
+			munger = func(in AccountSettings) (out AccountSettings, err error) {
+				out = in
+				out.HideMetadata = newValue
+				return
+			}
+		}
+	case "ignore":
+		switch strings.ToLower(params[1]) {
+		case "list":
+			accountData, err := server.accounts.LoadAccount(account)
+			if err != nil {
+				service.Fail(rb, command, serviceErrUnknown, client.t("Error loading account data"))
+			} else {
+				displaySetting(service, key, accountData.Settings, client, rb)
+			}
+			return
+		case "add", "del":
+			if len(params) < 3 {
+				err = errInvalidParams
+				break
+			}
+			var entry string
+			entry, err = canonicalizeIgnoreEntry(params[2])
+			if err == nil {
+				munger = ignoreListMunger(strings.ToLower(params[1]) == "add", entry, server.Config().Accounts.MaxIgnores)
+			}
+		default:
+			err = errInvalidParams
+		}
+	case "read-receipts":
+		var newValue bool
+		newValue, err = utils.StringToBool(params[1])
+		if err == nil {
+			munger = func(in AccountSettings) (out AccountSettings, err error) {
+				out = in
+				out.ReadReceipts = newValue
+				return
+			}
+		}
 	default:
 		err = errInvalidParams
 	}
@@ -668,6 +947,12 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 	if munger != nil {
 		finalSettings, err = server.accounts.ModifyAccountSettings(account, munger)
 	}
+	// apply a new realname immediately to the account's current connections
+	if err == nil && key == "realname" && finalSettings.Realname != "" {
+		for _, accountClient := range server.accounts.AccountToClients(account) {
+			dispatchSetname(accountClient, finalSettings.Realname, rb)
+		}
+	}
 
 	switch err {
 	case nil:
@@ -677,9 +962,13 @@ func nsSetHandler(service *ircService, server *Server, client *Client, command s
 		service.Notice(rb, client.t(err.Error()))
 	case errNickAccountMismatch:
 		service.Notice(rb, fmt.Sprintf(client.t("Your nickname must match your account name %s exactly to modify this setting. Try changing it with /NICK, or logging out and back in with the correct nickname."), client.AccountName()))
+	case errLimitExceeded:
+		service.Fail(rb, command, serviceErrLimitReached, fmt.Sprintf(client.t("Your ignore list is full (the limit is %d entries)"), server.Config().Accounts.MaxIgnores))
+	case errNoop:
+		service.Notice(rb, client.t("That entry is not on your ignore list"))
 	default:
 		// unknown error
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
@@ -708,13 +997,13 @@ func nsSetEmailHandler(service *ircService, client *Client, params []string, rb
 	case nil:
 		service.Notice(rb, client.t("Check your e-mail for instructions on how to confirm your change of address"))
 	case errLimitExceeded:
-		service.Notice(rb, client.t("Try again later"))
+		service.Fail(rb, "SET", serviceErrRateLimited, client.t("Try again later"))
 	default:
 		// if appropriate, show the client the error from the attempted email sending
 		if rErr := registrationCallbackErrorText(config, client, err); rErr != "" {
 			service.Notice(rb, rErr)
 		} else {
-			service.Notice(rb, client.t("An error occurred"))
+			service.Fail(rb, "SET", serviceErrUnknown, client.t("An error occurred"))
 		}
 	}
 }
@@ -728,7 +1017,7 @@ func nsVerifyEmailHandler(service *ircService, server *Server, client *Client, c
 	case errAccountVerificationInvalidCode:
 		service.Notice(rb, client.t(err.Error()))
 	default:
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
@@ -745,7 +1034,7 @@ func nsDropHandler(service *ircService, server *Server, client *Client, command
 	if err == nil {
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully ungrouped nick %s with your account"), nick))
 	} else if err == errAccountNotLoggedIn {
-		service.Notice(rb, client.t("You're not logged into an account"))
+		service.Fail(rb, command, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
 	} else if err == errAccountCantDropPrimaryNick {
 		service.Notice(rb, client.t("You can't ungroup your primary nickname (try unregistering your account instead)"))
 	} else {
@@ -753,12 +1042,27 @@ func nsDropHandler(service *ircService, server *Server, client *Client, command
 	}
 }
 
+// extractTwoFactorCode strips out `--2fa <code>`, wherever it appears in params
+func extractTwoFactorCode(params []string) (result []string, code string) {
+	for i := 0; i < len(params); i++ {
+		if strings.ToLower(params[i]) == "--2fa" && i+1 < len(params) {
+			return append(params[:i:i], params[i+2:]...), params[i+1]
+		}
+	}
+	return params, ""
+}
+
 func nsGhostHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	params, twoFactorCode := extractTwoFactorCode(params)
+	if len(params) == 0 || len(params) > 2 {
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
 	nick := params[0]
 
 	ghost := server.clients.Get(nick)
 	if ghost == nil {
-		service.Notice(rb, client.t("No such nick"))
+		service.Fail(rb, command, serviceErrNoSuchNick, client.t("No such nick"))
 		return
 	} else if ghost == client {
 		service.Notice(rb, client.t("You can't GHOST yourself (try /QUIT instead)"))
@@ -769,18 +1073,45 @@ func nsGhostHandler(service *ircService, server *Server, client *Client, command
 	}
 
 	authorized := false
+	owner := server.accounts.NickToAccount(nick)
+	ghostAccount := ghost.Account()
 	account := client.Account()
 	if account != "" {
 		// the user must either own the nick, or the target client
-		authorized = (server.accounts.NickToAccount(nick) == account) || (ghost.Account() == account)
+		authorized = (owner == account) || (ghostAccount == account)
+	}
+	if !authorized && len(params) > 1 {
+		// not logged in (or logged into a different account): check the password
+		// of the account that owns the nick, or failing that, of the ghost's account
+		target := owner
+		if target == "" {
+			target = ghostAccount
+		}
+		if target == "" {
+			service.Notice(rb, client.t("That nick is not registered"))
+			return
+		}
+		if !nsLoginThrottleCheck(service, client, rb) {
+			return
+		}
+		verified, err := server.accounts.VerifyPassphrase(client, target, params[1], rb.session.certfp)
+		if err == nil {
+			err = server.accounts.checkTOTP(verified.NameCasefolded, twoFactorCode)
+		}
+		if err != nil {
+			service.Notice(rb, fmt.Sprintf(client.t("Authentication failed: %s"), authErrorToMessage(server, err)))
+			return
+		}
+		authorized = verified.NameCasefolded == target
 	}
 	if !authorized {
-		service.Notice(rb, client.t("You don't own that nick"))
+		service.Fail(rb, command, serviceErrNotOwner, client.t("You don't own that nick"))
 		return
 	}
 
-	ghost.Quit(fmt.Sprintf(ghost.t("GHOSTed by %s"), client.Nick()), nil)
+	ghost.Quit(ghost.t("Killed (Ghosted by account owner)"), nil)
 	ghost.destroy(nil)
+	service.Notice(rb, fmt.Sprintf(client.t("Disconnected the user holding nickname %s"), nick))
 }
 
 func nsGroupHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
@@ -789,7 +1120,7 @@ func nsGroupHandler(service *ircService, server *Server, client *Client, command
 	if err == nil {
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully grouped nick %s with your account"), nick))
 	} else if err == errAccountTooManyNicks {
-		service.Notice(rb, client.t("You have too many nicks reserved already (you can remove some with /NS DROP)"))
+		service.Fail(rb, command, serviceErrLimitReached, client.t("You have too many nicks reserved already (you can remove some with /NS DROP)"))
 	} else if err == errNicknameReserved {
 		service.Notice(rb, client.t("That nickname is already reserved by someone else"))
 	} else {
@@ -814,6 +1145,36 @@ func nsIdentifyHandler(service *ircService, server *Server, client *Client, comm
 	var err error
 	loginSuccessful := false
 
+	if len(params) == 3 && strings.ToLower(params[1]) == "token" {
+		authzid := params[0]
+		if authzid == "*" {
+			authzid = ""
+		}
+		err = server.accounts.AuthenticateByBearerToken(rb.session, authzid, params[2], func(rb *ResponseBuffer, account ClientAccount, err error) {
+			if err == nil && client.LoggedIntoAccount() {
+				err = errAccountAlreadyLoggedIn
+			}
+			if err != nil {
+				service.Notice(rb, fmt.Sprintf(client.t("Authentication failed: %s"), authErrorToMessage(server, err)))
+				return
+			}
+			server.accounts.Login(client, account)
+			if fixupNickEqualsAccount(client, rb, server.Config(), service.prefix) {
+				sendSuccessfulAccountAuth(service, client, rb, true)
+			}
+		})
+		if err != nil {
+			service.Notice(rb, fmt.Sprintf(client.t("Authentication failed: %s"), authErrorToMessage(server, err)))
+		}
+		return
+	}
+
+	params, twoFactorCode := extractTwoFactorCode(params)
+	if len(params) == 0 {
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+
 	var username, passphrase string
 	if len(params) == 1 {
 		if rb.session.certfp != "" {
@@ -831,7 +1192,7 @@ func nsIdentifyHandler(service *ircService, server *Server, client *Client, comm
 
 	// try passphrase
 	if passphrase != "" {
-		err = server.accounts.AuthenticateByPassphrase(client, username, passphrase)
+		err = server.accounts.AuthenticateByPassphraseAndTOTP(client, username, passphrase, rb.session.certfp, twoFactorCode)
 		loginSuccessful = (err == nil)
 	}
 
@@ -859,7 +1220,7 @@ func nsIdentifyHandler(service *ircService, server *Server, client *Client, comm
 
 func nsListHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	if !client.HasRoleCapabs("accreg") {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	}
 
@@ -868,7 +1229,7 @@ func nsListHandler(service *ircService, server *Server, client *Client, command
 		var err error
 		searchRegex, err = regexp.Compile(params[0])
 		if err != nil {
-			service.Notice(rb, client.t("Invalid regex"))
+			service.Fail(rb, command, serviceErrInvalidRegex, client.t("Invalid regex"))
 			return
 		}
 	}
@@ -906,14 +1267,14 @@ func nsInfoHandler(service *ircService, server *Server, client *Client, command
 	} else {
 		accountName = client.Account()
 		if accountName == "" {
-			service.Notice(rb, client.t("You're not logged into an account"))
+			service.Fail(rb, command, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
 			return
 		}
 	}
 
 	account, err := server.accounts.LoadAccount(accountName)
 	if err != nil || !account.Verified {
-		service.Notice(rb, client.t("Account does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
 		return
 	}
 
@@ -932,6 +1293,18 @@ func nsInfoHandler(service *ircService, server *Server, client *Client, command
 		service.Notice(rb, fmt.Sprintf(client.t("Additional grouped nick: %s"), nick))
 	}
 	listRegisteredChannels(service, accountName, rb)
+	if client.HasRoleCapabs("accreg") || client.HasRoleCapabs("chanreg") {
+		limit, override := server.accounts.ChannelLimit(account.NameCasefolded)
+		if override {
+			service.Notice(rb, fmt.Sprintf(client.t("Channel registration limit: %d (set by an operator)"), limit))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Channel registration limit: %d"), limit))
+		}
+	}
+	if client.HasRoleCapabs("accreg") || client.HasRoleCapabs("chanreg") ||
+		(account.NameCasefolded == client.Account() && !account.Settings.HideChannelInfo) {
+		listChannelAmodes(service, account, rb)
+	}
 	if account.Suspended != nil {
 		service.Notice(rb, suspensionToString(client, *account.Suspended))
 	}
@@ -946,6 +1319,20 @@ func listRegisteredChannels(service *ircService, accountName string, rb *Respons
 	}
 }
 
+func listChannelAmodes(service *ircService, account ClientAccount, rb *ResponseBuffer) {
+	client := rb.session.client
+	amodes := client.server.channelRegistry.AmodesForAccount(account.NameCasefolded)
+	channels := make([]string, 0, len(amodes))
+	for channel := range amodes {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	service.Notice(rb, fmt.Sprintf(client.t("Account %[1]s has persistent modes in %[2]d channel(s)."), account.Name, len(channels)))
+	for _, channel := range channels {
+		service.Notice(rb, fmt.Sprintf(client.t("Channel %[1]s: +%[2]s"), channel, string(amodes[channel])))
+	}
+}
+
 func nsRegisterHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	details := client.Details()
 	passphrase := params[0]
@@ -977,14 +1364,22 @@ func nsRegisterHandler(service *ircService, server *Server, client *Client, comm
 	}
 
 	config := server.Config()
-	account := details.nick
-	if config.Accounts.NickReservation.ForceGuestFormat {
-		matches := config.Accounts.NickReservation.guestRegexp.FindStringSubmatch(account)
-		if matches == nil || len(matches) < 2 {
-			service.Notice(rb, client.t("Erroneous nickname"))
+	if passphrase != "" {
+		if err := config.Accounts.Registration.PasswordPolicy.Check(passphrase); err != nil {
+			service.Notice(rb, err.(*passwordPolicyError).describe(client.t))
 			return
 		}
-		account = matches[1]
+	}
+
+	account, ok := nickToRegistrationName(config, details.nick)
+	if !ok {
+		service.Fail(rb, command, serviceErrInvalidNick, client.t("Erroneous nickname"))
+		return
+	}
+
+	if err := config.Accounts.Registration.NamePolicy.Check(account); err != nil {
+		service.Notice(rb, client.t(err.Error()))
+		return
 	}
 
 	callbackNamespace, callbackValue, validationErr := parseCallback(email, config)
@@ -1019,6 +1414,10 @@ func nsSaregisterHandler(service *ircService, server *Server, client *Client, co
 	if 1 < len(params) && params[1] != "*" {
 		passphrase = params[1]
 	}
+	if err := server.Config().Accounts.Registration.NamePolicy.Check(account); err != nil {
+		service.Notice(rb, client.t(err.Error()))
+		return
+	}
 	err := server.accounts.SARegister(account, passphrase)
 
 	if err != nil {
@@ -1062,10 +1461,10 @@ func nsUnregisterHandler(service *ircService, server *Server, client *Client, co
 	} else {
 		account, err := server.accounts.LoadAccount(username)
 		if err == errAccountDoesNotExist {
-			service.Notice(rb, client.t("Invalid account name"))
+			service.Fail(rb, command, serviceErrInvalidAccountName, client.t("Invalid account name"))
 			return
 		} else if err != nil {
-			service.Notice(rb, client.t("Internal error"))
+			service.Fail(rb, command, serviceErrUnknown, client.t("Internal error"))
 			return
 		}
 		accountName = account.Name
@@ -1073,7 +1472,7 @@ func nsUnregisterHandler(service *ircService, server *Server, client *Client, co
 	}
 
 	if !(accountName == client.AccountName() || client.HasRoleCapabs("accreg")) {
-		service.Notice(rb, client.t("Insufficient oper privs"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient oper privs"))
 		return
 	}
 
@@ -1104,7 +1503,32 @@ func nsUnregisterHandler(service *ircService, server *Server, client *Client, co
 	}
 }
 
+// returns the account name that NS REGISTER would register for a nickname
+func nickToRegistrationName(config *Config, nick string) (account string, ok bool) {
+	if config.Accounts.NickReservation.ForceGuestFormat {
+		matches := config.Accounts.NickReservation.guestRegexp.FindStringSubmatch(nick)
+		if matches == nil || len(matches) < 2 {
+			return "", false
+		}
+		return matches[1], true
+	}
+	return nick, true
+}
+
 func nsVerifyHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	if len(params) == 1 {
+		// VERIFY <code>: the account is the one registered for the current nick
+		account, ok := nickToRegistrationName(server.Config(), client.Nick())
+		if !ok {
+			service.Fail(rb, command, serviceErrInvalidNick, client.t("Erroneous nickname"))
+			return
+		}
+		params = []string{account, params[0]}
+	} else if strings.ToLower(params[0]) == "resend" {
+		nsVerifyResendHandler(service, server, client, params[1], rb)
+		return
+	}
+
 	username, code := params[0], params[1]
 	err := server.accounts.Verify(client, username, code)
 
@@ -1128,6 +1552,31 @@ func nsVerifyHandler(service *ircService, server *Server, client *Client, comman
 	}
 }
 
+func nsVerifyResendHandler(service *ircService, server *Server, client *Client, username string, rb *ResponseBuffer) {
+	privileged := client.HasRoleCapabs("accreg")
+	if !privileged && !nsLoginThrottleCheck(service, client, rb) {
+		return
+	}
+
+	err := server.accounts.ResendVerificationCode(client, username, privileged)
+	switch err {
+	case nil:
+		service.Notice(rb, client.t("A new verification code has been sent to the e-mail address for the account"))
+	case errAccountDoesNotExist, errAccountAlreadyVerified, errFeatureDisabled:
+		service.Notice(rb, client.t(err.Error()))
+	case errLimitExceeded:
+		service.Notice(rb, client.t("Too many verification codes have been sent for this account recently; try again later"))
+	case errValidEmailRequired:
+		service.Notice(rb, client.t("This account has no e-mail address to send a verification code to"))
+	default:
+		if rErr := registrationCallbackErrorText(server.Config(), client, err); rErr != "" {
+			service.Notice(rb, rErr)
+		} else {
+			service.Fail(rb, "VERIFY", serviceErrUnknown, client.t("An error occurred"))
+		}
+	}
+}
+
 func nsConfirmPassword(server *Server, account, passphrase string) (errorMessage string) {
 	accountData, err := server.accounts.LoadAccount(account)
 	if err != nil {
@@ -1197,6 +1646,13 @@ func nsPasswdHandler(service *ircService, server *Server, client *Client, comman
 		return
 	}
 
+	if newPassword != "" {
+		if err := server.Config().Accounts.Registration.PasswordPolicy.Check(newPassword); err != nil {
+			service.Notice(rb, err.(*passwordPolicyError).describe(client.t))
+			return
+		}
+	}
+
 	err := server.accounts.setPassword(target, newPassword, oper != nil)
 	switch err {
 	case nil:
@@ -1204,11 +1660,11 @@ func nsPasswdHandler(service *ircService, server *Server, client *Client, comman
 	case errEmptyCredentials:
 		service.Notice(rb, client.t("You can't delete your password unless you add a certificate fingerprint"))
 	case errCredsExternallyManaged:
-		service.Notice(rb, client.t("Your account credentials are managed externally and cannot be changed here"))
+		service.Fail(rb, command, serviceErrCredentialsExternal, client.t("Your account credentials are managed externally and cannot be changed here"))
 	case errCASFailed:
-		service.Notice(rb, client.t("Try again later"))
+		service.Fail(rb, command, serviceErrRateLimited, client.t("Try again later"))
 	case errAccountDoesNotExist:
-		service.Notice(rb, client.t("Account does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
 	default:
 		server.logger.Error("internal", "could not upgrade user password:", err.Error())
 		service.Notice(rb, client.t("Password could not be changed due to server error"))
@@ -1242,7 +1698,7 @@ func nsClientsHandler(service *ircService, server *Server, client *Client, comma
 	case "logout":
 		nsClientsLogoutHandler(service, server, client, params, rb)
 	default:
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 	}
 }
 
@@ -1252,11 +1708,11 @@ func nsClientsListHandler(service *ircService, server *Server, client *Client, p
 	if 0 < len(params) {
 		target = server.clients.Get(params[0])
 		if target == nil {
-			service.Notice(rb, client.t("No such nick"))
+			service.Fail(rb, "CLIENTS", serviceErrNoSuchNick, client.t("No such nick"))
 			return
 		}
 		if target != client && !hasPrivs {
-			service.Notice(rb, client.t("Command restricted"))
+			service.Fail(rb, "CLIENTS", serviceErrCommandRestricted, client.t("Command restricted"))
 			return
 		}
 	}
@@ -1301,14 +1757,14 @@ func nsClientsLogoutHandler(service *ircService, server *Server, client *Client,
 		// CLIENTS LOGOUT [nickname] [client ID]
 		target = server.clients.Get(params[0])
 		if target == nil {
-			service.Notice(rb, client.t("No such nick"))
+			service.Fail(rb, "CLIENTS", serviceErrNoSuchNick, client.t("No such nick"))
 			return
 		}
 		// User must have "kill" privileges to logout other user sessions.
 		if target != client {
 			oper := client.Oper()
 			if !oper.HasRoleCapab("kill") {
-				service.Notice(rb, client.t("Insufficient oper privs"))
+				service.Fail(rb, "CLIENTS", serviceErrInsufficientPrivs, client.t("Insufficient oper privs"))
 				return
 			}
 		}
@@ -1363,35 +1819,41 @@ func nsCertHandler(service *ircService, server *Server, client *Client, command
 		} else if len(params) == 0 && verb == "add" && rb.session.certfp != "" {
 			certfp = rb.session.certfp // #1059
 		} else {
-			service.Notice(rb, client.t("Invalid parameters"))
+			service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 			return
 		}
 	default:
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 		return
 	}
 
 	hasPrivs := client.HasRoleCapabs("accreg")
 	if target != "" && !hasPrivs {
-		service.Notice(rb, client.t("Insufficient privileges"))
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
 		return
 	} else if target == "" {
 		target = client.Account()
 		if target == "" {
-			service.Notice(rb, client.t("You're not logged into an account"))
+			service.Fail(rb, command, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
 			return
 		}
 	}
 
+	certfpCommand(service, server, client, command, verb, target, certfp, hasPrivs, rb)
+}
+
+// certfpCommand implements CERT LIST, ADD, and DEL (for both NickServ and
+// ChanServ) on the target account, once the parameters have been validated.
+func certfpCommand(service *ircService, server *Server, client *Client, command, verb, target, certfp string, hasPrivs bool, rb *ResponseBuffer) {
 	var err error
 	switch verb {
 	case "list":
 		accountData, err := server.accounts.LoadAccount(target)
 		if err == errAccountDoesNotExist {
-			service.Notice(rb, client.t("Account does not exist"))
+			service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
 			return
 		} else if err != nil {
-			service.Notice(rb, client.t("An error occurred"))
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 			return
 		}
 		certfps := accountData.Credentials.Certfps
@@ -1420,9 +1882,9 @@ func nsCertHandler(service *ircService, server *Server, client *Client, command
 			service.Notice(rb, client.t("Certificate fingerprint not found"))
 		}
 	case errAccountDoesNotExist:
-		service.Notice(rb, client.t("Account does not exist"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
 	case errLimitExceeded:
-		service.Notice(rb, client.t("You already have too many certificate fingerprints"))
+		service.Fail(rb, command, serviceErrLimitReached, client.t("You already have too many certificate fingerprints"))
 	case utils.ErrInvalidCertfp:
 		service.Notice(rb, client.t("Invalid certificate fingerprint"))
 	case errCertfpAlreadyExists:
@@ -1430,16 +1892,103 @@ func nsCertHandler(service *ircService, server *Server, client *Client, command
 	case errEmptyCredentials:
 		service.Notice(rb, client.t("You can't remove all your certificate fingerprints unless you add a password"))
 	case errCredsExternallyManaged:
-		service.Notice(rb, client.t("Your account credentials are managed externally and cannot be changed here"))
+		service.Fail(rb, command, serviceErrCredentialsExternal, client.t("Your account credentials are managed externally and cannot be changed here"))
 	case errCASFailed:
-		service.Notice(rb, client.t("Try again later"))
+		service.Fail(rb, command, serviceErrRateLimited, client.t("Try again later"))
 	default:
 		server.logger.Error("internal", "could not modify certificates:", err.Error())
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+	}
+}
+
+func nsAccessHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	verb := strings.ToLower(params[0])
+	params = params[1:]
+	var target, mask string
+
+	switch verb {
+	case "list":
+		if 1 <= len(params) {
+			target = params[0]
+		}
+	case "add", "del":
+		if 2 <= len(params) {
+			target, mask = params[0], params[1]
+		} else if len(params) == 1 {
+			mask = params[0]
+		} else {
+			service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+			return
+		}
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+
+	if target != "" && !client.HasRoleCapabs("accreg") {
+		service.Fail(rb, command, serviceErrInsufficientPrivs, client.t("Insufficient privileges"))
+		return
+	} else if target == "" {
+		target = client.Account()
+		if target == "" {
+			service.Fail(rb, command, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
+			return
+		}
+	}
+
+	var err error
+	switch verb {
+	case "list":
+		accountName := server.accounts.AccountToAccountName(target)
+		if accountName == "" {
+			service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
+			return
+		}
+		masks, err := server.accounts.AccessMasks(target)
+		if err != nil {
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+			return
+		}
+		service.Notice(rb, fmt.Sprintf(client.t("There are %[1]d access mask(s) for account %[2]s."), len(masks), accountName))
+		for i, mask := range masks {
+			service.Notice(rb, fmt.Sprintf("%d: %s", i+1, mask))
+		}
+		return
+	case "add":
+		err = server.accounts.ModifyAccessMasks(target, mask, true)
+	case "del":
+		err = server.accounts.ModifyAccessMasks(target, mask, false)
+	}
+
+	switch err {
+	case nil:
+		if verb == "add" {
+			service.Notice(rb, client.t("Access mask successfully added"))
+		} else {
+			service.Notice(rb, client.t("Access mask successfully removed"))
+		}
+	case errNoop:
+		if verb == "add" {
+			service.Notice(rb, client.t("That access mask was already present"))
+		} else {
+			service.Notice(rb, client.t("Access mask not found"))
+		}
+	case errAccountDoesNotExist:
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
+	case errLimitExceeded:
+		service.Fail(rb, command, serviceErrLimitReached, client.t("You already have too many access masks"))
+	case errInvalidParams:
+		service.Notice(rb, client.t("Invalid access mask; it must be of the form user@host"))
+	case errAccessMaskTooBroad:
+		service.Notice(rb, fmt.Sprintf(client.t("That access mask is too broad; the host must contain at least %d non-wildcard characters"), accessMaskMinHostChars))
+	default:
+		server.logger.Error("internal", "could not modify access masks:", err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
 func nsSuspendHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	allParams := params
 	subCmd := strings.ToLower(params[0])
 	params = params[1:]
 	switch subCmd {
@@ -1450,13 +1999,18 @@ func nsSuspendHandler(service *ircService, server *Server, client *Client, comma
 	case "list":
 		nsSuspendListHandler(service, server, client, command, params, rb)
 	default:
-		service.Notice(rb, client.t("Invalid parameters"))
+		// SUSPEND <nickname> [duration] [reason]
+		nsSuspendAddHandler(service, server, client, command, allParams, rb)
 	}
 }
 
+func nsUnsuspendHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	nsSuspendRemoveHandler(service, server, client, command, params, rb)
+}
+
 func nsSuspendAddHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	if len(params) == 0 {
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 		return
 	}
 
@@ -1473,6 +2027,11 @@ func nsSuspendAddHandler(service *ircService, server *Server, client *Client, co
 		}
 		duration = time.Duration(cDuration)
 		params = params[2:]
+	} else if 1 <= len(params) {
+		if cDuration, err := custime.ParseDuration(params[0]); err == nil {
+			duration = time.Duration(cDuration)
+			params = params[1:]
+		}
 	}
 
 	var reason string
@@ -1487,15 +2046,15 @@ func nsSuspendAddHandler(service *ircService, server *Server, client *Client, co
 	case nil:
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully suspended account %s"), account))
 	case errAccountDoesNotExist:
-		service.Notice(rb, client.t("No such account"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
 	default:
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
 func nsSuspendRemoveHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	if len(params) == 0 {
-		service.Notice(rb, client.t("Invalid parameters"))
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
 		return
 	}
 
@@ -1504,11 +2063,11 @@ func nsSuspendRemoveHandler(service *ircService, server *Server, client *Client,
 	case nil:
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully un-suspended account %s"), params[0]))
 	case errAccountDoesNotExist:
-		service.Notice(rb, client.t("No such account"))
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("No such account"))
 	case errNoop:
 		service.Notice(rb, client.t("Account was not suspended"))
 	default:
-		service.Notice(rb, client.t("An error occurred"))
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
 	}
 }
 
@@ -1576,6 +2135,11 @@ func nsResetpassHandler(service *ircService, server *Server, client *Client, com
 		return
 	}
 
+	if err := server.Config().Accounts.Registration.PasswordPolicy.Check(params[2]); err != nil {
+		service.Notice(rb, err.(*passwordPolicyError).describe(client.t))
+		return
+	}
+
 	var message string
 	err := server.accounts.NsResetpass(client, params[0], params[1], params[2])
 	switch err {
@@ -1592,12 +2156,47 @@ func nsResetpassHandler(service *ircService, server *Server, client *Client, com
 	rb.Notice(client.t(message))
 }
 
+func nsSamergeHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	from, to := params[0], params[1]
+	items, err := server.accounts.Merge(from, to)
+	if err != nil {
+		service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Couldn't merge accounts: %s"), client.t(err.Error())))
+		return
+	}
+
+	for _, item := range items {
+		if item.Moved {
+			service.Notice(rb, fmt.Sprintf(client.t("Moved %[1]s: %[2]s"), item.Kind, item.Item))
+		} else if item.Reason == errNoop {
+			service.Notice(rb, fmt.Sprintf(client.t("Dropped %[1]s %[2]s: the destination account's takes precedence"), item.Kind, item.Item))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Dropped %[1]s %[2]s: %[3]s"), item.Kind, item.Item, client.t(item.Reason.Error())))
+		}
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Successfully merged account %[1]s into %[2]s"), from, to))
+	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] merged account $c[grey][$r%s$c[grey]] into $c[grey][$r%s$c[grey]] with SAMERGE"), client.Oper().Name, from, to))
+}
+
+func nsUnlockHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	switch err := server.accounts.ClearLockout(params[0]); err {
+	case nil:
+		service.Notice(rb, fmt.Sprintf(client.t("Successfully unlocked account %s"), params[0]))
+		server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] unlocked account $c[grey][$r%s$c[grey]]"), client.Oper().Name, params[0]))
+	case errNoop:
+		service.Notice(rb, fmt.Sprintf(client.t("Account %s is not locked"), params[0]))
+	case errAccountDoesNotExist:
+		service.Fail(rb, command, serviceErrNoSuchAccount, client.t("Account does not exist"))
+	default:
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+	}
+}
+
 func nsRenameHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	oldName, newName := params[0], params[1]
 	err := server.accounts.Rename(oldName, newName)
 
 	if err != nil {
-		service.Notice(rb, fmt.Sprintf(client.t("Couldn't rename account: %s"), client.t(err.Error())))
+		service.Fail(rb, command, serviceErrUnknown, fmt.Sprintf(client.t("Couldn't rename account: %s"), client.t(err.Error())))
 		return
 	}
 
@@ -1611,3 +2210,97 @@ func nsRenameHandler(service *ircService, server *Server, client *Client, comman
 		}
 	}
 }
+
+func ns2FAHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	var code string
+	if len(params) > 1 {
+		code = params[1]
+	}
+
+	var err error
+	switch strings.ToLower(params[0]) {
+	case "enable":
+		if code == "" {
+			var secret string
+			secret, err = server.accounts.BeginTOTPEnrollment(account)
+			if err == nil {
+				service.Notice(rb, fmt.Sprintf(client.t("Your two-factor authentication secret is: %s"), secret))
+				service.Notice(rb, fmt.Sprintf(client.t("Enrollment URI: %s"), totpURI(server.Config().Network.Name, client.AccountName(), secret)))
+				service.Notice(rb, client.t("To confirm, add it to your authenticator app, then run: /NS 2FA ENABLE <code>"))
+				return
+			}
+		} else {
+			err = server.accounts.ConfirmTOTPEnrollment(account, code)
+			if err == nil {
+				service.Notice(rb, client.t("Two-factor authentication is now enabled for your account"))
+				service.Notice(rb, client.t("You can generate backup codes with /NS 2FA BACKUP"))
+				return
+			}
+		}
+	case "disable":
+		if code == "" {
+			service.Notice(rb, client.t("To disable two-factor authentication, supply a code: /NS 2FA DISABLE <code>"))
+			return
+		}
+		err = server.accounts.DisableTOTP(account, code)
+		if err == nil {
+			service.Notice(rb, client.t("Two-factor authentication is now disabled for your account"))
+			return
+		}
+	case "backup":
+		var codes []string
+		codes, err = server.accounts.GenerateTOTPBackupCodes(account)
+		if err == nil {
+			service.Notice(rb, client.t("Your backup codes are listed below; each one can be used once. Any previous backup codes no longer work."))
+			for _, backupCode := range codes {
+				service.Notice(rb, backupCode)
+			}
+			return
+		}
+	default:
+		service.Fail(rb, command, serviceErrInvalidParams, client.t("Invalid parameters"))
+		return
+	}
+
+	switch err {
+	case errTOTPAlreadyEnabled, errTOTPNotEnabled, errInvalidTOTP:
+		service.Notice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't update 2fa", account, err.Error())
+		service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+	}
+}
+
+func nsImpersonateHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	if strings.ToLower(params[0]) == "end" {
+		restored, err := server.accounts.EndImpersonation(client)
+		if err != nil {
+			service.Notice(rb, client.t(err.Error()))
+			return
+		}
+		if restored != "" {
+			sendSuccessfulAccountAuth(service, client, rb, false)
+			return
+		}
+		service.Notice(rb, client.t("You're no longer logged into an account"))
+		dispatchAccountNotify(client, rb)
+		return
+	}
+
+	_, err := server.accounts.Impersonate(client, params[0])
+	switch err {
+	case nil:
+		sendSuccessfulAccountAuth(service, client, rb, false)
+		service.Notice(rb, client.t("To stop impersonating this account, use /NS IMPERSONATE END"))
+	case errAccountDoesNotExist, errAccountUnverified, errNickAccountMismatch, errInsufficientPrivs,
+		errImpersonateAlwaysOn, errAlreadyImpersonating:
+		service.Notice(rb, client.t(err.Error()))
+	default:
+		if suspended, ok := err.(*AccountSuspendedError); ok {
+			service.Notice(rb, suspended.Details())
+		} else {
+			service.Fail(rb, command, serviceErrUnknown, client.t("An error occurred"))
+		}
+	}
+}
@@ -52,6 +52,8 @@ const (
 	RPL_TRYAGAIN                  = "263"
 	RPL_LOCALUSERS                = "265"
 	RPL_GLOBALUSERS               = "266"
+	RPL_SILELIST                  = "271"
+	RPL_ENDOFSILELIST             = "272"
 	RPL_WHOISCERTFP               = "276"
 	RPL_AWAY                      = "301"
 	RPL_USERHOST                  = "302"
@@ -66,6 +68,7 @@ const (
 	RPL_WHOISIDLE                 = "317"
 	RPL_ENDOFWHOIS                = "318"
 	RPL_WHOISCHANNELS             = "319"
+	RPL_WHOISSPECIAL              = "320"
 	RPL_LIST                      = "322"
 	RPL_LISTEND                   = "323"
 	RPL_CHANNELMODEIS             = "324"
@@ -77,6 +80,7 @@ const (
 	RPL_TOPICTIME                 = "333"
 	RPL_WHOISBOT                  = "335"
 	RPL_WHOISACTUALLY             = "338"
+	RPL_USERIP                    = "340"
 	RPL_INVITING                  = "341"
 	RPL_SUMMONING                 = "342"
 	RPL_INVITELIST                = "346"
@@ -166,8 +170,19 @@ const (
 	ERR_NOOPERHOST                = "491"
 	ERR_UMODEUNKNOWNFLAG          = "501"
 	ERR_USERSDONTMATCH            = "502"
+	ERR_SILELISTFULL              = "511"
+	ERR_TOOMANYWATCH              = "512"
 	ERR_HELPNOTFOUND              = "524"
 	ERR_CANNOTSENDRP              = "573"
+	RPL_LOGON                     = "600"
+	RPL_LOGOFF                    = "601"
+	RPL_WATCHOFF                  = "602"
+	RPL_WATCHSTAT                 = "603"
+	RPL_NOWON                     = "604"
+	RPL_NOWOFF                    = "605"
+	RPL_WATCHLIST                 = "606"
+	RPL_ENDOFWATCHLIST            = "607"
+	RPL_CLEARWATCH                = "608"
 	RPL_WHOWASIP                  = "652"
 	RPL_WHOISSECURE               = "671"
 	RPL_YOURLANGUAGESARE          = "687"
@@ -183,6 +198,14 @@ const (
 	RPL_MONLIST                   = "732"
 	RPL_ENDOFMONLIST              = "733"
 	ERR_MONLISTFULL               = "734"
+	RPL_RSACHALLENGE2             = "740"
+	RPL_ENDOFRSACHALLENGE2        = "741"
+	RPL_WHOISKEYVALUE             = "760"
+	RPL_KEYVALUE                  = "761"
+	RPL_KEYNOTSET                 = "766"
+	RPL_METADATASUBOK             = "770"
+	RPL_METADATAUNSUBOK           = "771"
+	RPL_METADATASUBS              = "772"
 	RPL_LOGGEDIN                  = "900"
 	RPL_LOGGEDOUT                 = "901"
 	ERR_NICKLOCKED                = "902"
@@ -0,0 +1,411 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrInvalidToken means the token was well-formed but rejected (expired,
+	// revoked, wrong issuer or audience, bad signature, etc.)
+	ErrInvalidToken = errors.New("Invalid bearer token")
+	// ErrMissingClaim means the token was valid but didn't identify a user
+	ErrMissingClaim = errors.New("Bearer token does not contain the configured username claim")
+)
+
+const (
+	defaultTimeout       = 10 * time.Second
+	jwksRefreshInterval  = time.Hour
+	jwksMinFetchInterval = time.Minute
+)
+
+type OAuth2BearerConfig struct {
+	Enabled bool
+	// RFC 7662 token introspection endpoint, authenticated with client-id and client-secret
+	IntrospectionURL string `yaml:"introspection-url"`
+	ClientID         string `yaml:"client-id"`
+	ClientSecret     string `yaml:"client-secret"`
+	// alternately, validate JWTs locally against the IdP's published keys
+	JWKSURL string `yaml:"jwks-url"`
+	// if set, the `iss` and `aud` claims must match
+	Issuer   string
+	Audience string
+	// claim to use as the account name, e.g. `preferred_username` or `sub`
+	UsernameClaim string `yaml:"username-claim"`
+	// advertised to clients in the RFC 7628 error response
+	OpenIDConfiguration string `yaml:"openid-configuration"`
+	Timeout             time.Duration
+
+	keys *keySet
+}
+
+func (c *OAuth2BearerConfig) Postprocess() (err error) {
+	if !c.Enabled {
+		return nil
+	}
+	if (c.IntrospectionURL == "") == (c.JWKSURL == "") {
+		return errors.New("exactly one of introspection-url and jwks-url must be set")
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "preferred_username"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.JWKSURL != "" {
+		c.keys = &keySet{url: c.JWKSURL}
+	}
+	return nil
+}
+
+// Introspect validates a bearer token, returning the value of the username claim.
+func (c *OAuth2BearerConfig) Introspect(ctx context.Context, token string) (username string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var claims map[string]interface{}
+	if c.IntrospectionURL != "" {
+		claims, err = c.introspect(ctx, token)
+	} else {
+		claims, err = c.verifyJWT(ctx, token)
+	}
+	if err != nil {
+		return
+	}
+
+	if c.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != c.Issuer {
+			return "", ErrInvalidToken
+		}
+	}
+	if c.Audience != "" && !audienceMatches(claims["aud"], c.Audience) {
+		return "", ErrInvalidToken
+	}
+
+	username, _ = claims[c.UsernameClaim].(string)
+	if username == "" && c.UsernameClaim == "preferred_username" {
+		// RFC 7662 calls this `username`
+		username, _ = claims["username"].(string)
+	}
+	if username == "" {
+		return "", ErrMissingClaim
+	}
+	return username, nil
+}
+
+func audienceMatches(aud interface{}, expected string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == expected
+	case []interface{}:
+		for _, a := range aud {
+			if a, ok := a.(string); ok && a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *OAuth2BearerConfig) introspect(ctx context.Context, token string) (claims map[string]interface{}, err error) {
+	body := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.IntrospectionURL, strings.NewReader(body.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientID != "" {
+		req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrInvalidToken
+	}
+	// the IdP should check this, but be defensive:
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return nil, ErrInvalidToken
+	}
+	return
+}
+
+func (c *OAuth2BearerConfig) verifyJWT(ctx context.Context, token string) (claims map[string]interface{}, err error) {
+	// fetch keys (if necessary) outside the jwt keyfunc, so the context applies
+	parser := jwt.Parser{}
+	unverified, _, err := parser.ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	kid, _ := unverified.Header["kid"].(string)
+	key, err := c.keys.Get(ctx, kid)
+	if err != nil {
+		return
+	}
+
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			if _, ok := key.(*rsa.PublicKey); ok {
+				return key, nil
+			}
+		case *jwt.SigningMethodECDSA:
+			if _, ok := key.(*ecdsa.PublicKey); ok {
+				return key, nil
+			}
+		}
+		return nil, ErrInvalidToken
+	})
+	// this validates exp, nbf, and iat
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+	return parsed.Claims.(jwt.MapClaims), nil
+}
+
+// keySet is a cache of the public keys published at a JWKS endpoint
+type keySet struct {
+	url string
+
+	sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	fetchErr  error
+	// non-nil while a fetch is in progress; closed when it completes
+	fetching chan struct{}
+}
+
+func (ks *keySet) Get(ctx context.Context, kid string) (key interface{}, err error) {
+	ks.Lock()
+	now := time.Now()
+	key, ok := ks.keys[kid]
+	// refetch periodically, and also when we see an unknown key id (the IdP
+	// may have rotated its keys), but don't let clients force us to hammer the IdP
+	stale := jwksRefreshInterval < now.Sub(ks.fetchedAt)
+	if !(stale || (!ok && jwksMinFetchInterval < now.Sub(ks.fetchedAt))) {
+		ks.Unlock()
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		return key, nil
+	}
+	// only one request at a time talks to the IdP; the others wait for it,
+	// without holding the lock
+	fetching := ks.fetching
+	if fetching == nil {
+		fetching = make(chan struct{})
+		ks.fetching = fetching
+		ks.Unlock()
+		keys, fetchErr := fetchJWKS(ctx, ks.url)
+		ks.Lock()
+		if fetchErr == nil {
+			ks.keys, ks.fetchedAt = keys, now
+		}
+		ks.fetchErr = fetchErr
+		ks.fetching = nil
+		close(fetching)
+	} else {
+		ks.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		ks.Lock()
+	}
+	defer ks.Unlock()
+
+	if ks.keys == nil && ks.fetchErr != nil {
+		return nil, ks.fetchErr
+	}
+	key, ok = ks.keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func fetchJWKS(ctx context.Context, jwksURL string) (keys map[string]interface{}, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return
+	}
+	return parseJWKS(jwks.Keys), nil
+}
+
+func parseJWKS(jwks []jsonWebKey) (keys map[string]interface{}) {
+	keys = make(map[string]interface{}, len(jwks))
+	for _, jwk := range jwks {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// skip keys we don't understand, rather than failing the whole set
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return
+}
+
+func (jwk *jsonWebKey) publicKey() (key interface{}, err error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ErrorResponse is the JSON error report the server sends on failure,
+// per RFC 7628 section 3.2.2.
+type ErrorResponse struct {
+	Status              string `json:"status"`
+	Scope               string `json:"scope,omitempty"`
+	OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+}
+
+// SASLFailure returns the serialized error report for a failed authentication.
+func (c *OAuth2BearerConfig) SASLFailure() []byte {
+	result, _ := json.Marshal(ErrorResponse{
+		Status:              "invalid_token",
+		Scope:               "openid",
+		OpenIDConfiguration: c.OpenIDConfiguration,
+	})
+	return result
+}
+
+// ParseSASLMessage parses the client's initial response for the OAUTHBEARER
+// SASL mechanism (RFC 7628 section 3.1), returning the authzid (possibly
+// empty) and the bearer token.
+func ParseSASLMessage(message []byte) (authzid, token string, err error) {
+	fields := strings.Split(string(message), "\x01")
+	// gs2-header, at least one key-value pair, and the terminating empty fields
+	if len(fields) < 3 || fields[len(fields)-1] != "" || fields[len(fields)-2] != "" {
+		return "", "", ErrInvalidToken
+	}
+
+	gs2Header := strings.Split(fields[0], ",")
+	if len(gs2Header) != 3 || gs2Header[0] != "n" && gs2Header[0] != "y" {
+		return "", "", ErrInvalidToken
+	}
+	if strings.HasPrefix(gs2Header[1], "a=") {
+		authzid = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(gs2Header[1][2:])
+	} else if gs2Header[1] != "" {
+		return "", "", ErrInvalidToken
+	}
+
+	for _, kv := range fields[1 : len(fields)-2] {
+		if strings.HasPrefix(kv, "auth=") {
+			scheme, value, found := cutSpace(kv[len("auth="):])
+			if found && strings.EqualFold(scheme, "Bearer") && value != "" {
+				return authzid, value, nil
+			}
+			return "", "", ErrInvalidToken
+		}
+	}
+	return "", "", ErrInvalidToken
+}
+
+func cutSpace(s string) (before, after string, found bool) {
+	if i := strings.IndexByte(s, ' '); i != -1 {
+		return s[:i], strings.TrimLeft(s[i+1:], " "), true
+	}
+	return s, "", false
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package oauth2
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSASLMessage(t *testing.T) {
+	authzid, token, err := ParseSASLMessage([]byte("n,a=user@example.com,\x01host=server.example.com\x01port=143\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==\x01\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authzid != "user@example.com" || token != "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==" {
+		t.Errorf("incorrect parse: %s %s", authzid, token)
+	}
+
+	authzid, token, err = ParseSASLMessage([]byte("n,,\x01auth=bearer abc\x01\x01"))
+	if err != nil || authzid != "" || token != "abc" {
+		t.Errorf("incorrect parse: %s %s %v", authzid, token, err)
+	}
+
+	invalid := []string{
+		"",
+		"\x01",
+		"n,,\x01\x01",
+		"n,,\x01auth=Bearer abc\x01",
+		"n,,\x01auth=Basic abc\x01\x01",
+		"n,,\x01auth=Bearer \x01\x01",
+		"p=tls-unique,,\x01auth=Bearer abc\x01\x01",
+		"n,x=foo,\x01auth=Bearer abc\x01\x01",
+	}
+	for _, message := range invalid {
+		if _, _, err := ParseSASLMessage([]byte(message)); err == nil {
+			t.Errorf("accepted invalid message %q", message)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys := parseJWKS([]jsonWebKey{
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: "sXchDaQebHnPiGvyDOAT4saGEUetSyo9MKLOoWFsueri23bOdgWp4Dy1WlUzewbgBHod5pcM9H95GQRV3JDXboIRROSBigeC5yjU1hGzHHyXss8UDprecbAYxknTcQkhslANGRUZmdTOQ5qTRsLAt6BTYuyvVRdhS8exSZEy_c4gs_7svlJJQ4H9_NxsiIoLwAEk7-Q3UXERGYw_75IDrGA84-lA_-Ct4eTlXHBIY2EaV7t7LjJaynVJCpkv4LKjTTAumiGUIuQhrNhZLuF_RJLqHpM2kgWFLU7-VTdL1VbC2tejvcI2BlMkEpk1BzBZI0KQB0GaDWFLN-aEAw3vRw", E: "AQAB"},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+		{Kty: "RSA", Kid: "enc", Use: "enc", N: "AQAB", E: "AQAB"},
+		{Kty: "oct", Kid: "symmetric"},
+	})
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if rsaKey, ok := keys["rsa"].(*rsa.PublicKey); !ok || rsaKey.E != 65537 {
+		t.Errorf("bad rsa key: %v", keys["rsa"])
+	}
+	if _, ok := keys["ec"]; !ok {
+		t.Errorf("missing ec key")
+	}
+}
+
+func TestAudienceMatches(t *testing.T) {
+	if !audienceMatches("ergo", "ergo") || audienceMatches("other", "ergo") {
+		t.Error("bad string audience matching")
+	}
+	if !audienceMatches([]interface{}{"other", "ergo"}, "ergo") || audienceMatches([]interface{}{"other"}, "ergo") {
+		t.Error("bad list audience matching")
+	}
+	if audienceMatches(nil, "ergo") {
+		t.Error("missing audience should not match")
+	}
+}
+
+func TestKeySetSingleFetch(t *testing.T) {
+	var fetches uint32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&fetches, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"keys": [{"kty": "EC", "kid": "ec", "crv": "P-256", "x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", "y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}]}`))
+	}))
+	defer jwks.Close()
+
+	ks := keySet{url: jwks.URL}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ks.Get(context.Background(), "ec"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := atomic.LoadUint32(&fetches); count != 1 {
+		t.Errorf("concurrent lookups should share a single fetch, got %d", count)
+	}
+	if _, err := ks.Get(context.Background(), "unknown"); err != ErrInvalidToken {
+		t.Errorf("expected invalid token for an unknown key id, got %v", err)
+	}
+}
@@ -32,3 +32,8 @@ func CompareHashAndPassword(hashedPassword, password []byte) error {
 	sum := sha3.Sum512(password)
 	return bcrypt.CompareHashAndPassword(hashedPassword, sum[:])
 }
+
+// Cost returns the bcrypt cost used to create a hash.
+func Cost(hashedPassword []byte) (int, error) {
+	return bcrypt.Cost(hashedPassword)
+}
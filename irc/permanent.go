@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"time"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+// +P: unregistered channels that persist (with their topic, modes, and lists)
+// even when empty and across restarts, at an oper's request. Registering a
+// +P channel supersedes the +P record.
+
+// performPermanentWrite stores the +P record for the channel, or deletes it
+// if the channel is no longer +P (or has been registered).
+func (channel *Channel) performPermanentWrite(isPermanent bool, dirtyBits uint) (err error) {
+	reg := channel.server.channelRegistry
+	if isPermanent {
+		if dirtyBits == 0 {
+			return
+		}
+		info := channel.ExportRegistration(IncludeTopic | IncludeModes | IncludeLists)
+		info.RegisteredAt = channel.Ctime()
+		err = reg.StorePermanentChannel(info)
+	} else {
+		err = reg.DeletePermanentChannel(channel.NameCasefolded())
+	}
+
+	channel.stateMutex.Lock()
+	if err == nil {
+		channel.permanentStored = isPermanent
+	} else {
+		channel.dirtyBits = channel.dirtyBits | dirtyBits
+	}
+	channel.stateMutex.Unlock()
+	return
+}
+
+// applyPermanentInfo initializes a newly created channel from its +P record
+func (channel *Channel) applyPermanentInfo(info RegisteredChannel) {
+	// the stored modes replace the defaults
+	channel.flags = modes.ModeSet{}
+	channel.applyRegInfo(info)
+
+	channel.stateMutex.Lock()
+	channel.registeredTime = time.Time{}
+	channel.permanentStored = true
+	channel.stateMutex.Unlock()
+}
+
+// loadPermanentChannels recreates the +P channels at startup
+func (cm *ChannelManager) loadPermanentChannels() {
+	infos := cm.server.channelRegistry.PermanentChannels()
+
+	var loaded, collisions []string
+	defer func() {
+		for _, name := range loaded {
+			cm.server.logger.Debug("channels", "initialized permanent channel", name)
+		}
+		for _, name := range collisions {
+			cm.server.logger.Warning("channels", "permanent channel collides with existing channel", name)
+		}
+	}()
+
+	cm.Lock()
+	defer cm.Unlock()
+
+	for _, info := range infos {
+		cfname := info.NameCasefolded
+		skeleton, err := Skeleton(info.Name)
+		if err != nil || cm.purgedChannels.Has(cfname) {
+			continue
+		}
+		if _, ok := cm.chans[cfname]; ok || cm.chansSkeletons.Has(skeleton) || cm.registeredSkeletons.Has(skeleton) {
+			collisions = append(collisions, info.Name)
+			continue
+		}
+		ch := NewChannel(cm.server, info.Name, cfname, false)
+		ch.applyPermanentInfo(info)
+		cm.chans[cfname] = &channelManagerEntry{
+			channel:  ch,
+			skeleton: skeleton,
+		}
+		cm.chansSkeletons.Add(skeleton)
+		loaded = append(loaded, info.Name)
+	}
+}
@@ -81,6 +81,10 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, targetString, fmt.Sprintf(client.t("Cannot send to channel (+%s)"), mode))
 			return
 		}
+		if wait := channel.joinDelayRemaining(client); wait != 0 {
+			rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, targetString, fmt.Sprintf(client.t("You must wait %d seconds after joining before sending messages"), wait))
+			return
+		}
 
 		if !channel.flags.HasMode(modes.ChanRoleplaying) {
 			rb.Add(nil, client.server.name, ERR_CANNOTSENDRP, targetString, client.t("Channel doesn't have roleplaying mode available"))
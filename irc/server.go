@@ -32,6 +32,7 @@ import (
 	"github.com/ergochat/ergo/irc/mysql"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webhooks"
 	"github.com/tidwall/buntdb"
 )
 
@@ -69,6 +70,7 @@ type Server struct {
 	config            unsafe.Pointer
 	configFilename    string
 	connectionLimiter connection_limits.Limiter
+	connectionBackoff connection_limits.Backoff
 	ctime             time.Time
 	dlines            *DLineManager
 	helpIndexManager  HelpIndexManager
@@ -85,6 +87,9 @@ type Server struct {
 	snomasks          SnoManager
 	store             *buntdb.DB
 	historyDB         mysql.MySQL
+	messageLog        MessageLog
+	authEndpoint      AuthEndpoint
+	webhooks          *webhooks.Dispatcher
 	torLimiter        connection_limits.TorLimiter
 	whoWas            WhoWasList
 	stats             Stats
@@ -110,6 +115,8 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 	server.whoWas.Initialize(config.Limits.WhowasEntries)
 	server.monitorManager.Initialize()
 	server.snomasks.Initialize()
+	server.authEndpoint.Initialize(server)
+	server.webhooks = webhooks.NewDispatcher(logger)
 
 	if err := server.applyConfig(config); err != nil {
 		return nil, err
@@ -142,6 +149,8 @@ func (server *Server) Shutdown() {
 	}
 
 	server.historyDB.Close()
+	server.messageLog.Close()
+	server.authEndpoint.Close()
 	server.logger.Info("server", fmt.Sprintf("%s exiting", Ver))
 }
 
@@ -192,9 +201,11 @@ func (server *Server) checkBans(config *Config, ipaddr net.IP, checkScripts bool
 	if err == connection_limits.ErrLimitExceeded {
 		// too many connections from one client, tell the client and close the connection
 		server.logger.Info("connect-ip", "Client rejected for connection limit", ipaddr.String())
+		server.snomasks.SendLimited(sno.LocalFlood, fmt.Sprintf("Client rejected for connection limit [ip:%s]", ipaddr.String()))
 		return true, false, "Too many clients from your network"
 	} else if err == connection_limits.ErrThrottleExceeded {
 		server.logger.Info("connect-ip", "Client exceeded connection throttle", ipaddr.String())
+		server.snomasks.SendLimited(sno.LocalFlood, fmt.Sprintf("Client exceeded connection throttle [ip:%s]", ipaddr.String()))
 		return true, false, throttleMessage
 	} else if err != nil {
 		server.logger.Warning("internal", "unexpected ban result", err.Error())
@@ -420,6 +431,10 @@ func (server *Server) playRegistrationBurst(session *Session) {
 
 	c.attemptAutoOper(session)
 
+	if d.account != "" {
+		server.sendSuccessionNotices(c, session)
+	}
+
 	if server.logger.IsLoggingRawIO() {
 		session.Send(nil, c.server.name, "NOTICE", d.nick, c.t("This server is in debug mode and is logging all user I/O. If you do not wish for everything you send to be readable by the server owner(s), please disconnect."))
 	}
@@ -510,6 +525,9 @@ func (client *Client) getWhoisOf(target *Client, hasPrivs bool, rb *ResponseBuff
 	if client == target || oper.HasRoleCapab("ban") {
 		ip, hostname := target.getWhoisActually()
 		rb.Add(nil, client.server.name, RPL_WHOISACTUALLY, cnick, tnick, fmt.Sprintf("%s@%s", targetInfo.username, hostname), utils.IPStringToHostname(ip.String()), client.t("Actual user@host, Actual IP"))
+		if gateway := target.WebIRCGateway(); gateway != "" && oper.HasRoleCapab("ban") {
+			rb.Add(nil, client.server.name, RPL_WHOISSPECIAL, cnick, tnick, fmt.Sprintf(client.t("is connecting via WEBIRC gateway %[1]s (%[2]s)"), gateway, target.realIP.String()))
+		}
 	}
 	if client == target || oper.HasRoleCapab("samode") {
 		rb.Add(nil, client.server.name, RPL_WHOISMODES, cnick, tnick, fmt.Sprintf(client.t("is using modes +%s"), target.modes.String()))
@@ -520,6 +538,11 @@ func (client *Client) getWhoisOf(target *Client, hasPrivs bool, rb *ResponseBuff
 	if targetInfo.accountName != "*" {
 		rb.Add(nil, client.server.name, RPL_WHOISACCOUNT, cnick, tnick, targetInfo.accountName, client.t("is logged in as"))
 	}
+	if oper.HasRoleCapab("ban") {
+		if recognized := target.RecognizedAccount(); recognized != "" {
+			rb.Add(nil, client.server.name, RPL_WHOISSPECIAL, cnick, tnick, fmt.Sprintf(client.t("is recognized by access mask as the owner of %s, but is not logged in"), client.server.accounts.AccountToAccountName(recognized)))
+		}
+	}
 	if target.HasMode(modes.Bot) {
 		rb.Add(nil, client.server.name, RPL_WHOISBOT, cnick, tnick, fmt.Sprintf(ircfmt.Unescape(client.t("is a $bBot$b on %s")), client.server.Config().Network.Name))
 	}
@@ -567,6 +590,24 @@ func (server *Server) rehash() error {
 	return nil
 }
 
+// rehashMOTD reloads only the MOTD file, leaving the rest of the config as-is.
+func (server *Server) rehashMOTD() error {
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	// configs are immutable once applied; swap in a copy with the new MOTD
+	config := new(Config)
+	*config = *server.Config()
+	config.Server.motdLines = nil
+	if err := config.loadMOTD(); err != nil {
+		server.logger.Error("server", "failed to reload MOTD", err.Error())
+		return err
+	}
+	server.SetConfig(config)
+	server.logger.Info("server", "MOTD reloaded successfully")
+	return nil
+}
+
 func (server *Server) applyConfig(config *Config) (err error) {
 	oldConfig := server.Config()
 	initial := oldConfig == nil
@@ -623,15 +664,22 @@ func (server *Server) applyConfig(config *Config) (err error) {
 
 	// first, reload config sections for functionality implemented in subpackages:
 	wasLoggingRawIO := !initial && server.logger.IsLoggingRawIO()
-	err = server.logger.ApplyConfig(config.Logging)
+	err = server.logger.ApplyConfig(config.Logging.Outputs)
 	if err != nil {
 		return err
 	}
 	nowLoggingRawIO := server.logger.IsLoggingRawIO()
+	// reopening the message log on every rehash allows it to be rotated
+	err = server.messageLog.ApplyConfig(config.Logging.MessageLog)
+	if err != nil {
+		return err
+	}
 	// notify existing clients if raw i/o logging was enabled by a rehash
 	sendRawOutputNotice := !wasLoggingRawIO && nowLoggingRawIO
 
 	server.connectionLimiter.ApplyConfig(&config.Server.IPLimits)
+	server.authEndpoint.ApplyConfig(config.Accounts.AuthExternal.Endpoint)
+	server.webhooks.SetConfig(config.Accounts.Webhooks)
 
 	tlConf := &config.Server.TorListeners
 	server.torLimiter.Configure(tlConf.MaxConnections, tlConf.ThrottleDuration, tlConf.MaxConnectionsPerDuration)
@@ -1020,6 +1068,26 @@ func (server *Server) ForgetHistory(accountName string) {
 	}
 }
 
+// MigrateDirectMessages attaches existing persistent DM history of the nick
+// to a newly registered account of the same name, if so configured.
+func (server *Server) MigrateDirectMessages(cfAccount string) {
+	config := server.Config()
+	persistent := config.History.Persistent
+	if !(config.History.Enabled && persistent.Enabled && persistent.MigrateOnReregister) ||
+		persistent.DirectMessages == PersistentDisabled {
+		return
+	}
+
+	go func() {
+		defer server.HandlePanic()
+
+		count, err := server.historyDB.MigrateDirectMessages(cfAccount)
+		if err == nil && count != 0 {
+			server.logger.Info("history", "migrated", strconv.FormatInt(count, 10), "direct messages to account", cfAccount)
+		}
+	}()
+}
+
 // deletes a message. target is a hint about what buffer it's in (not required for
 // persistent history, where all the msgids are indexed together). if accountName
 // is anything other than "*", it must match the recorded AccountName of the message
@@ -1059,36 +1127,120 @@ func (server *Server) DeleteMessage(target, msgid, accountName string) (err erro
 	return
 }
 
-func (server *Server) UnfoldName(cfname string) (name string) {
-	if strings.HasPrefix(cfname, "#") {
-		return server.channels.UnfoldName(cfname)
+// ChannelHistoryStats returns per-account message counts for a channel's history
+func (server *Server) ChannelHistoryStats(channel *Channel, limit int) (results []history.Count, err error) {
+	config := server.Config()
+	status, target, _ := channel.historyStatus(config)
+	switch status {
+	case HistoryEphemeral:
+		results = channel.history.CountByAccount()
+		if limit < len(results) {
+			results = results[:limit]
+		}
+	case HistoryPersistent:
+		if !config.History.Retention.EnableAccountIndexing {
+			return nil, errFeatureDisabled
+		}
+		results, err = server.historyDB.ChannelStats(target, limit)
+	default:
+		err = errFeatureDisabled
 	}
-	return server.clients.UnfoldNick(cfname)
+	return
 }
 
-// elistMatcher takes and matches ELIST conditions
-type elistMatcher struct {
-	MinClientsActive bool
-	MinClients       int
-	MaxClientsActive bool
-	MaxClients       int
+// ChannelActivity returns message and speaker counts for a channel's history
+// since each of the given cutoffs; speakersKnown is false if the backend
+// cannot attribute messages to accounts.
+func (server *Server) ChannelActivity(channel *Channel, cutoffs []time.Time) (results []history.Activity, speakersKnown bool, err error) {
+	config := server.Config()
+	status, target, _ := channel.historyStatus(config)
+	switch status {
+	case HistoryEphemeral:
+		return channel.history.ActivitySince(cutoffs), true, nil
+	case HistoryPersistent:
+		results, err = server.historyDB.ChannelActivity(target, cutoffs)
+		return results, config.History.Retention.EnableAccountIndexing, err
+	default:
+		return nil, false, errFeatureDisabled
+	}
 }
 
-// Matches checks whether the given channel matches our matches.
-func (matcher *elistMatcher) Matches(channel *Channel) bool {
-	if matcher.MinClientsActive {
-		if len(channel.Members()) < matcher.MinClients {
-			return false
+// AccountHistoryStats returns per-channel message counts for an account
+func (server *Server) AccountHistoryStats(accountName string, limit int) (results []history.Count, err error) {
+	config := server.Config()
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return nil, errAccountDoesNotExist
+	}
+
+	counts := make(map[string]int)
+	if config.History.Persistent.Enabled && config.History.Retention.EnableAccountIndexing {
+		persistentCounts, err := server.historyDB.AccountStats(cfAccount, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range persistentCounts {
+			counts[count.Name] += count.Count
 		}
 	}
+	for _, channel := range server.channels.Channels() {
+		if status, _, _ := channel.historyStatus(config); status != HistoryEphemeral {
+			continue
+		}
+		for _, count := range channel.history.CountByAccount() {
+			if count.Name == accountName {
+				counts[channel.NameCasefolded()] += count.Count
+			}
+		}
+	}
+
+	results = make([]history.Count, 0, len(counts))
+	for name, count := range counts {
+		results = append(results, history.Count{Name: name, Count: count})
+	}
+	history.SortCounts(results)
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return
+}
+
+// AccountHistoryCount returns the total number of stored messages sent by an account
+func (server *Server) AccountHistoryCount(accountName string) (count int, err error) {
+	config := server.Config()
+	cfAccount, err := CasefoldName(accountName)
+	if err != nil {
+		return 0, errAccountDoesNotExist
+	}
 
-	if matcher.MaxClientsActive {
-		if len(channel.Members()) < len(channel.members) {
-			return false
+	if config.History.Persistent.Enabled {
+		// without the account index, persistent messages can't be counted
+		if !config.History.Retention.EnableAccountIndexing {
+			return 0, errFeatureDisabled
+		}
+		count, err = server.historyDB.CountByAccount(cfAccount)
+		if err != nil {
+			return
 		}
 	}
+	for _, channel := range server.channels.Channels() {
+		if status, _, _ := channel.historyStatus(config); status != HistoryEphemeral {
+			continue
+		}
+		for _, c := range channel.history.CountByAccount() {
+			if c.Name == accountName {
+				count += c.Count
+			}
+		}
+	}
+	return
+}
 
-	return true
+func (server *Server) UnfoldName(cfname string) (name string) {
+	if strings.HasPrefix(cfname, "#") {
+		return server.channels.UnfoldName(cfname)
+	}
+	return server.clients.UnfoldNick(cfname)
 }
 
 var (
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ergochat/ergo/irc/caps"
 	"github.com/ergochat/ergo/irc/utils"
 	"github.com/ergochat/irc-go/ircfmt"
 	"github.com/ergochat/irc-go/ircmsg"
@@ -103,6 +104,72 @@ func (service *ircService) Notice(rb *ResponseBuffer, text string) {
 	rb.Add(nil, service.prefix, "NOTICE", rb.target.Nick(), text)
 }
 
+// ChannelNotice sends a notice pertaining to `channel` (which may be nil),
+// tagged with +draft/channel-context so that the client can display it
+// in the channel's window.
+func (service *ircService) ChannelNotice(rb *ResponseBuffer, channel *Channel, text string) {
+	var tags map[string]string
+	if channel != nil {
+		tags = channelContextTags(rb.session, channel.Name())
+	}
+	rb.Add(tags, service.prefix, "NOTICE", rb.target.Nick(), text)
+}
+
+// channelContextTags returns the +draft/channel-context tag for a service
+// notice about `chname`, if the session can receive client-only tags.
+func channelContextTags(session *Session, chname string) map[string]string {
+	if session.capabilities.Has(caps.MessageTags) {
+		return map[string]string{"+draft/channel-context": chname}
+	}
+	return nil
+}
+
+// standard-replies codes for service command errors. These are part of the
+// client-facing protocol, so they must not be changed once released.
+const (
+	serviceErrUnknown              = "UNKNOWN_ERROR"
+	serviceErrUnknownCommand       = "UNKNOWN_COMMAND"
+	serviceErrCommandDisabled      = "COMMAND_DISABLED"
+	serviceErrInvalidParams        = "INVALID_PARAMS"
+	serviceErrInsufficientPrivs    = "INSUFFICIENT_PRIVS"
+	serviceErrNoSuchAccount        = "NO_SUCH_ACCOUNT"
+	serviceErrNoSuchChannel        = "NO_SUCH_CHANNEL"
+	serviceErrNoSuchNick           = "NO_SUCH_NICK"
+	serviceErrChannelNotRegistered = "CHANNEL_NOT_REGISTERED"
+	serviceErrNotLoggedIn          = "NOT_LOGGED_IN"
+	serviceErrRateLimited          = "RATE_LIMITED"
+	serviceErrNoChanges            = "NO_CHANGES"
+	serviceErrHistoryUnavailable   = "HISTORY_UNAVAILABLE"
+	serviceErrInvalidAccountName   = "INVALID_ACCOUNT_NAME"
+	serviceErrInvalidNick          = "INVALID_NICK"
+	serviceErrInvalidMask          = "INVALID_MASK"
+	serviceErrInvalidVhost         = "INVALID_VHOST"
+	serviceErrInvalidRegex         = "INVALID_REGEX"
+	serviceErrInvalidTemplate      = "INVALID_TEMPLATE"
+	serviceErrInvalidFormat        = "INVALID_FORMAT"
+	serviceErrInvalidFilename      = "INVALID_FILENAME"
+	serviceErrCredentialsExternal  = "CREDENTIALS_EXTERNAL"
+	serviceErrLimitReached         = "LIMIT_REACHED"
+	serviceErrNotOwner             = "NOT_OWNER"
+	serviceErrCommandRestricted    = "COMMAND_RESTRICTED"
+)
+
+// StandardReply sends a NOTICE with the (translated) text, followed by a
+// standard reply of type `replyType` (FAIL, WARN, or NOTE) if the client
+// has negotiated standard-replies, e.g.,
+// FAIL NICKSERV INSUFFICIENT_PRIVS SAREGISTER :Insufficient privileges
+func (service *ircService) StandardReply(rb *ResponseBuffer, replyType, command, code, text string) {
+	service.Notice(rb, text)
+	if rb.session.capabilities.Has(caps.StandardReplies) {
+		rb.Add(nil, service.prefix, replyType, strings.ToUpper(service.Name), code, utils.SafeErrorParam(strings.ToUpper(command)), text)
+	}
+}
+
+// Fail reports an error in a service command; see StandardReply.
+func (service *ircService) Fail(rb *ResponseBuffer, command, code, text string) {
+	service.StandardReply(rb, "FAIL", command, code, text)
+}
+
 // all service commands at the protocol level, by uppercase command name
 // e.g., NICKSERV, NS
 var oragonoServicesByCommandAlias map[string]*ircService
@@ -143,7 +210,7 @@ func serviceCmdHandler(server *Server, client *Client, msg ircmsg.Message, rb *R
 // generic handler for service PRIVMSG, like `/msg NickServ INFO`
 func servicePrivmsgHandler(service *ircService, server *Server, client *Client, message string, rb *ResponseBuffer) {
 	if strings.HasPrefix(message, "\x01") {
-		serviceCTCPHandler(service, client, message)
+		serviceCTCPHandler(service, message, rb)
 		return
 	}
 
@@ -164,7 +231,7 @@ func servicePrivmsgHandler(service *ircService, server *Server, client *Client,
 	serviceRunCommand(service, server, client, cmd, commandName, params, rb)
 }
 
-func serviceCTCPHandler(service *ircService, client *Client, message string) {
+func serviceCTCPHandler(service *ircService, message string, rb *ResponseBuffer) {
 	ctcp := strings.TrimSuffix(message[1:], "\x01")
 
 	ctcpSplit := utils.FieldsN(ctcp, 2)
@@ -183,39 +250,34 @@ func serviceCTCPHandler(service *ircService, client *Client, message string) {
 	}
 
 	if ctcpOut != "" {
-		client.Send(nil, service.prefix, "NOTICE", client.Nick(), fmt.Sprintf("\x01%s %s\x01", ctcpCmd, ctcpOut))
+		service.Notice(rb, fmt.Sprintf("\x01%s %s\x01", ctcpCmd, ctcpOut))
 	}
 }
 
 // actually execute a service command
 func serviceRunCommand(service *ircService, server *Server, client *Client, cmd *serviceCommand, commandName string, params []string, rb *ResponseBuffer) {
-	nick := rb.target.Nick()
-	sendNotice := func(notice string) {
-		rb.Add(nil, service.prefix, "NOTICE", nick, notice)
-	}
-
 	if cmd == nil {
-		sendNotice(fmt.Sprintf(client.t("Unknown command. To see available commands, run: /%s HELP"), service.ShortName))
+		service.Fail(rb, commandName, serviceErrUnknownCommand, fmt.Sprintf(client.t("Unknown command. To see available commands, run: /%s HELP"), service.ShortName))
 		return
 	}
 
 	if len(params) < cmd.minParams || (0 < cmd.maxParams && cmd.maxParams < len(params)) {
-		sendNotice(fmt.Sprintf(client.t("Invalid parameters. For usage, do /msg %[1]s HELP %[2]s"), service.Name, strings.ToUpper(commandName)))
+		service.Fail(rb, commandName, serviceErrInvalidParams, fmt.Sprintf(client.t("Invalid parameters. For usage, do /msg %[1]s HELP %[2]s"), service.Name, strings.ToUpper(commandName)))
 		return
 	}
 
 	if cmd.enabled != nil && !cmd.enabled(server.Config()) {
-		sendNotice(client.t("This command has been disabled by the server administrators"))
+		service.Fail(rb, commandName, serviceErrCommandDisabled, client.t("This command has been disabled by the server administrators"))
 		return
 	}
 
 	if 0 < len(cmd.capabs) && !client.HasRoleCapabs(cmd.capabs...) {
-		sendNotice(client.t("Command restricted"))
+		service.Fail(rb, commandName, serviceErrCommandRestricted, client.t("Command restricted"))
 		return
 	}
 
 	if cmd.authRequired && client.Account() == "" {
-		sendNotice(client.t("You're not logged into an account"))
+		service.Fail(rb, commandName, serviceErrNotLoggedIn, client.t("You're not logged into an account"))
 		return
 	}
 
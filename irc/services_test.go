@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// testConn is an IRCConn that records the lines written to it
+type testConn struct {
+	lines []string
+}
+
+func (c *testConn) UnderlyingConn() *utils.WrappedConn { return nil }
+
+func (c *testConn) WriteLine(line []byte) error {
+	c.lines = append(c.lines, strings.TrimSuffix(string(line), "\r\n"))
+	return nil
+}
+
+func (c *testConn) WriteLines(lines [][]byte) error {
+	for _, line := range lines {
+		c.WriteLine(line)
+	}
+	return nil
+}
+
+func (c *testConn) ReadLine() ([]byte, error) { return nil, io.EOF }
+
+func (c *testConn) Close() error { return nil }
+
+func newTestServiceSession(t *testing.T) (*Session, *testConn) {
+	var config Config
+	config.languageManager, _ = languages.NewManager(false, "", "")
+	server := &Server{name: "ergo.test"}
+	server.SetConfig(&config)
+	var err error
+	server.logger, err = logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := new(testConn)
+	client := &Client{server: server, nick: "alice", registered: true}
+	session := &Session{client: client, socket: NewSocket(conn, 1<<20)}
+	session.capabilities.Enable(caps.LabeledResponse, caps.Batch, caps.MessageTags)
+	return session, conn
+}
+
+func parseTestLines(t *testing.T, lines []string) (msgs []ircmsg.Message) {
+	for _, line := range lines {
+		msg, err := ircmsg.ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+func TestServiceLabeledResponse(t *testing.T) {
+	for _, service := range []*ircService{nickservService, chanservService, hostservService, histservService} {
+		session, conn := newTestServiceSession(t)
+		rb := NewResponseBuffer(session)
+		rb.Label = "abc"
+		servicePrivmsgHandler(service, session.client.server, session.client, "HELP", rb)
+		rb.Send(true)
+
+		msgs := parseTestLines(t, conn.lines)
+		if len(msgs) < 3 {
+			t.Fatalf("%s: expected a labeled batch, got %v", service.Name, conn.lines)
+		}
+		// all response notices are enclosed in the labeled-response batch:
+		first, last := msgs[0], msgs[len(msgs)-1]
+		assertEqual(first.Command, "BATCH", t)
+		assertEqual(first.Params[1], "labeled-response", t)
+		_, label := first.GetTag(caps.LabelTagName)
+		assertEqual(label, "abc", t)
+		batchID := first.Params[0][1:]
+		assertEqual(last.Command, "BATCH", t)
+		assertEqual(last.Params[0], "-"+batchID, t)
+		for _, msg := range msgs[1 : len(msgs)-1] {
+			assertEqual(msg.Command, "NOTICE", t)
+			assertEqual(msg.Source, service.prefix, t)
+			_, batchTag := msg.GetTag("batch")
+			assertEqual(batchTag, batchID, t)
+		}
+	}
+}
+
+func TestServiceCTCPLabeledResponse(t *testing.T) {
+	session, conn := newTestServiceSession(t)
+	rb := NewResponseBuffer(session)
+	rb.Label = "xyz"
+	servicePrivmsgHandler(nickservService, session.client.server, session.client, "\x01PING 1234\x01", rb)
+	rb.Send(true)
+
+	// a single response is labeled directly, without a batch:
+	msgs := parseTestLines(t, conn.lines)
+	assertEqual(len(msgs), 1, t)
+	assertEqual(msgs[0].Command, "NOTICE", t)
+	assertEqual(msgs[0].Params[1], "\x01PING 1234\x01", t)
+	_, label := msgs[0].GetTag(caps.LabelTagName)
+	assertEqual(label, "xyz", t)
+}
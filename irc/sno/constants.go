@@ -14,6 +14,7 @@ const (
 	LocalAnnouncements Mask = 'a'
 	LocalConnects      Mask = 'c'
 	LocalDisconnects   Mask = 'd'
+	LocalFlood         Mask = 'f'
 	LocalChannels      Mask = 'j'
 	LocalKills         Mask = 'k'
 	LocalNicks         Mask = 'n'
@@ -31,6 +32,7 @@ var (
 		LocalAnnouncements: "ANNOUNCEMENT",
 		LocalConnects:      "CONNECT",
 		LocalDisconnects:   "DISCONNECT",
+		LocalFlood:         "FLOOD",
 		LocalChannels:      "CHANNEL",
 		LocalKills:         "KILL",
 		LocalNicks:         "NICK",
@@ -47,6 +49,7 @@ var (
 		LocalAnnouncements,
 		LocalConnects,
 		LocalDisconnects,
+		LocalFlood,
 		LocalChannels,
 		LocalKills,
 		LocalNicks,
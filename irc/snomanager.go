@@ -3,19 +3,58 @@ package irc
 import (
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/ergochat/ergo/irc/connection_limits"
 	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/irc-go/ircfmt"
 )
 
+const (
+	// limits on SendLimited
+	snoLimitWindow = 10 * time.Second
+	snoLimitCount  = 5
+)
+
 // SnoManager keeps track of which clients to send snomasks to.
 type SnoManager struct {
 	sendListMutex sync.RWMutex // tier 2
 	sendLists     map[sno.Mask]map[*Client]bool
+
+	limitMutex sync.Mutex // tier 1
+	limits     map[sno.Mask]*snoLimit
+}
+
+// snoLimit tracks the notices sent and suppressed by SendLimited for one mask
+type snoLimit struct {
+	throttle   connection_limits.GenericThrottle
+	suppressed int
+}
+
+// snomaskCapabs gives the operator capability required to subscribe to
+// each snomask (the `snomasks` and `ban` capabilities allow subscribing to
+// any of them); masks not listed here require one of those two.
+var snomaskCapabs = map[sno.Mask]string{
+	sno.LocalAccounts: "accreg",
+	sno.LocalVhosts:   "vhosts",
+}
+
+// operCanSubscribe returns whether the operator may subscribe to the snomask.
+func operCanSubscribe(oper *Oper, mask sno.Mask) bool {
+	// `ban` granted every snomask before the per-mask capabilities existed
+	if oper.HasRoleCapab("snomasks") || oper.HasRoleCapab("ban") {
+		return true
+	}
+	capab, ok := snomaskCapabs[mask]
+	if !ok {
+		capab = "ban"
+	}
+	return oper.HasRoleCapab(capab)
 }
 
 func (m *SnoManager) Initialize() {
 	m.sendLists = make(map[sno.Mask]map[*Client]bool)
+	m.limits = make(map[sno.Mask]*snoLimit)
 }
 
 // AddMasks adds the given snomasks to the client.
@@ -96,6 +135,39 @@ func (m *SnoManager) Send(mask sno.Mask, content string) {
 	}
 }
 
+// SendLimited is like Send, for notices that clients can trigger at will
+// (e.g., rejected connections): at most snoLimitCount of them are sent per
+// snoLimitWindow, and the next one sent reports how many were suppressed.
+func (m *SnoManager) SendLimited(mask sno.Mask, content string) {
+	m.limitMutex.Lock()
+	limit := m.limits[mask]
+	if limit == nil {
+		limit = &snoLimit{
+			throttle: connection_limits.GenericThrottle{
+				Duration: snoLimitWindow,
+				Limit:    snoLimitCount,
+			},
+		}
+		m.limits[mask] = limit
+	}
+	throttled, _ := limit.throttle.Touch()
+	suppressed := 0
+	if throttled {
+		limit.suppressed++
+	} else {
+		suppressed, limit.suppressed = limit.suppressed, 0
+	}
+	m.limitMutex.Unlock()
+
+	if throttled {
+		return
+	}
+	if suppressed != 0 {
+		content = fmt.Sprintf("%s (%d similar notices suppressed)", content, suppressed)
+	}
+	m.Send(mask, content)
+}
+
 // MasksEnabled returns the snomasks currently enabled.
 func (m *SnoManager) MasksEnabled(client *Client) (result sno.Masks) {
 	m.sendListMutex.RLock()
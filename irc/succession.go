@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
+)
+
+// founder succession: when the founder's account is unregistered, the channel
+// passes to its designated successor (CS SET SUCCESSOR), or failing that,
+// to the holder of its highest AMODE, instead of being unregistered.
+
+const (
+	// reasons for a change of founder, as recorded in the founder history
+	founderChangeTransfer   = "transfer"
+	founderChangeMerge      = "merge"
+	founderChangeSuccession = "succession"
+
+	maxFounderHistory = 16
+)
+
+// FounderChange is an entry in a channel's founder history
+type FounderChange struct {
+	From   string
+	To     string
+	Reason string
+	Time   time.Time
+	// for successions, whether the new founder has been notified
+	Notified bool `json:",omitempty"`
+}
+
+// successionCandidates returns the accounts that could succeed the departing
+// founder, in order of preference: the designated successor, then AMODE
+// holders from the highest mode down (ties broken alphabetically).
+func (channel *Channel) successionCandidates(departing string) (candidates []string) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+
+	if successor := channel.settings.Successor; successor != "" && successor != departing {
+		candidates = append(candidates, successor)
+	}
+	var amodeHolders []string
+	for account, mode := range channel.accountToUMode {
+		if account != departing && mode != modes.Mode(0) {
+			amodeHolders = append(amodeHolders, account)
+		}
+	}
+	sort.Slice(amodeHolders, func(i, j int) bool {
+		iMode, jMode := channel.accountToUMode[amodeHolders[i]], channel.accountToUMode[amodeHolders[j]]
+		if iMode != jMode {
+			return umodeGreaterThan(iMode, jMode)
+		}
+		return amodeHolders[i] < amodeHolders[j]
+	})
+	return append(candidates, amodeHolders...)
+}
+
+// succeed transfers the channel from the departing founder to the first
+// candidate whose account still exists, returning the new founder (or ""
+// if there is none, in which case the caller should unregister the channel).
+func (channel *Channel) succeed(departing string) (successor string) {
+	am := &channel.server.accounts
+	for _, candidate := range channel.successionCandidates(departing) {
+		if _, err := am.LoadAccount(candidate); err == nil {
+			successor = candidate
+			break
+		}
+	}
+	if successor == "" {
+		return
+	}
+
+	channel.stateMutex.Lock()
+	if channel.registeredFounder != departing {
+		channel.stateMutex.Unlock()
+		return ""
+	}
+	channel.transferOwnership(successor, founderChangeSuccession)
+	if channel.settings.Successor == successor {
+		channel.settings.Successor = ""
+	}
+	chname := channel.name
+	channel.stateMutex.Unlock()
+
+	channel.Store(IncludeAllAttrs)
+
+	server := channel.server
+	server.logger.Info("services", fmt.Sprintf("Channel %s passed from unregistered account %s to successor %s", chname, departing, successor))
+	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Channel $c[grey][$r%s$c[grey]] passed from unregistered account $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), chname, departing, successor))
+	for _, client := range am.AccountToClients(successor) {
+		channel.sendSuccessionNotice(client, nil)
+	}
+	return
+}
+
+// recordFounderChange appends to the founder history; the caller must hold stateMutex
+func (channel *Channel) recordFounderChange(from, to, reason string) {
+	channel.founderHistory = append(channel.founderHistory, FounderChange{
+		From:   from,
+		To:     to,
+		Reason: reason,
+		Time:   time.Now().UTC(),
+	})
+	if len(channel.founderHistory) > maxFounderHistory {
+		channel.founderHistory = channel.founderHistory[len(channel.founderHistory)-maxFounderHistory:]
+	}
+}
+
+// FounderHistory returns a copy of the channel's founder history
+func (channel *Channel) FounderHistory() (result []FounderChange) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	result = make([]FounderChange, len(channel.founderHistory))
+	copy(result, channel.founderHistory)
+	return
+}
+
+// takeSuccessionNotice returns the previous founder if account became the
+// founder by succession and hasn't been notified yet, marking it as notified.
+func (channel *Channel) takeSuccessionNotice(account string) (from string, ok bool) {
+	channel.stateMutex.Lock()
+	if len(channel.founderHistory) != 0 && channel.registeredFounder == account {
+		last := &channel.founderHistory[len(channel.founderHistory)-1]
+		if last.Reason == founderChangeSuccession && last.To == account && !last.Notified {
+			last.Notified = true
+			from, ok = last.From, true
+		}
+	}
+	channel.stateMutex.Unlock()
+
+	if ok {
+		channel.MarkDirty(IncludeInitial)
+	}
+	return
+}
+
+// sendSuccessionNotice notifies the client (via session, or all of its
+// sessions if session is nil) that it has succeeded to the channel's founder status.
+func (channel *Channel) sendSuccessionNotice(client *Client, session *Session) {
+	from, ok := channel.takeSuccessionNotice(client.Account())
+	if !ok {
+		return
+	}
+	message := fmt.Sprintf(client.t("You are now the founder of %[1]s, which passed to you when its founder's account (%[2]s) was unregistered"), channel.Name(), from)
+	sessions := []*Session{session}
+	if session == nil {
+		sessions = client.Sessions()
+	}
+	chname := channel.Name()
+	for _, s := range sessions {
+		s.Send(channelContextTags(s, chname), chanservService.prefix, "NOTICE", client.Nick(), message)
+	}
+}
+
+// sendSuccessionNotices is called on login, to notify the client of
+// any channels it succeeded to while it was offline
+func (server *Server) sendSuccessionNotices(client *Client, session *Session) {
+	for _, chname := range server.accounts.ChannelsForAccount(client.Account()) {
+		if channel := server.channels.Get(chname); channel != nil {
+			channel.sendSuccessionNotice(client, session)
+		}
+	}
+}
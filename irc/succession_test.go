@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ergochat/ergo/irc/modes"
+)
+
+func TestSuccessionCandidates(t *testing.T) {
+	channel := &Channel{
+		registeredFounder: "alice",
+		accountToUMode: map[string]modes.Mode{
+			"alice": modes.ChannelFounder,
+			"bob":   modes.Voice,
+			"carol": modes.ChannelOperator,
+			"dan":   modes.ChannelAdmin,
+			"erin":  modes.ChannelOperator,
+		},
+	}
+	candidates := channel.successionCandidates("alice")
+	if !reflect.DeepEqual(candidates, []string{"dan", "carol", "erin", "bob"}) {
+		t.Errorf("bad succession order %v", candidates)
+	}
+
+	channel.settings.Successor = "erin"
+	candidates = channel.successionCandidates("alice")
+	if !reflect.DeepEqual(candidates[:2], []string{"erin", "dan"}) {
+		t.Errorf("designated successor should come first, got %v", candidates)
+	}
+}
+
+func TestFounderHistory(t *testing.T) {
+	channel := &Channel{registeredFounder: "alice", accountToUMode: make(map[string]modes.Mode)}
+	for i := 0; i < maxFounderHistory+4; i++ {
+		channel.transferOwnership("bob", founderChangeTransfer)
+		channel.transferOwnership("alice", founderChangeSuccession)
+	}
+	history := channel.FounderHistory()
+	assertEqual(len(history), maxFounderHistory, t)
+	assertEqual(history[len(history)-1].To, "alice", t)
+	assertEqual(channel.accountToUMode["alice"], modes.ChannelFounder, t)
+	_, isMember := channel.accountToUMode["bob"]
+	assertEqual(isMember, false, t)
+}
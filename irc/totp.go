@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// TOTP (RFC 6238) two-factor authentication for NickServ, with the
+// parameters that authenticator apps assume by default: HMAC-SHA1,
+// 6 digits, and a 30-second time step.
+
+const (
+	totpDigits          = 6
+	totpPeriod          = 30
+	totpSkew            = 1 // accept codes from one time step before or after the current one
+	totpSecretLength    = 20
+	totpBackupCodeCount = 10
+)
+
+var (
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// totpRecord is the stored 2FA state of an account
+type totpRecord struct {
+	Secret      string   // base32-encoded
+	Enabled     bool     // false until enrollment is confirmed with a valid code
+	LastStep    int64    // last time step accepted, to prevent code reuse
+	BackupCodes []string // hex-encoded SHA-256 hashes of unused backup codes
+}
+
+func generateTOTPSecret() string {
+	var buf [totpSecretLength]byte
+	rand.Read(buf[:])
+	return totpEncoding.EncodeToString(buf[:])
+}
+
+// totpCode computes the HOTP (RFC 4226) value of the secret for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus)
+}
+
+// totpValidate checks a code against the secret, returning the matching time
+// step. Steps at or before lastStep are rejected, so each code works only once.
+func totpValidate(secretB32, code string, now time.Time, lastStep int64) (step int64, ok bool) {
+	secret, err := totpEncoding.DecodeString(strings.ToUpper(secretB32))
+	if err != nil || len(code) != totpDigits {
+		return
+	}
+	current := now.Unix() / totpPeriod
+	for candidate := current - totpSkew; candidate <= current+totpSkew; candidate++ {
+		if candidate <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, candidate)), []byte(code)) == 1 {
+			return candidate, true
+		}
+	}
+	return
+}
+
+// totpURI returns the otpauth:// URI for enrolling the secret in an authenticator app
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+		params.Set("issuer", issuer)
+	}
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+func hashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (am *AccountManager) loadTOTP(tx *buntdb.Tx, cfAccount string) (record totpRecord, found bool) {
+	recordStr, err := tx.Get(fmt.Sprintf(keyAccountTOTP, cfAccount))
+	if err != nil {
+		return
+	}
+	found = json.Unmarshal([]byte(recordStr), &record) == nil
+	return
+}
+
+func (am *AccountManager) storeTOTP(tx *buntdb.Tx, cfAccount string, record totpRecord) {
+	recordBytes, _ := json.Marshal(record)
+	tx.Set(fmt.Sprintf(keyAccountTOTP, cfAccount), string(recordBytes), nil)
+}
+
+// BeginTOTPEnrollment implements NS 2FA ENABLE without a code: it generates a
+// new secret, which must then be confirmed with ConfirmTOTPEnrollment.
+func (am *AccountManager) BeginTOTPEnrollment(cfAccount string) (secret string, err error) {
+	secret = generateTOTPSecret()
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if record, found := am.loadTOTP(tx, cfAccount); found && record.Enabled {
+			return errTOTPAlreadyEnabled
+		}
+		am.storeTOTP(tx, cfAccount, totpRecord{Secret: secret})
+		return nil
+	})
+	return
+}
+
+// ConfirmTOTPEnrollment implements NS 2FA ENABLE <code>
+func (am *AccountManager) ConfirmTOTPEnrollment(cfAccount, code string) (err error) {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		record, found := am.loadTOTP(tx, cfAccount)
+		if !found {
+			return errTOTPNotEnabled
+		} else if record.Enabled {
+			return errTOTPAlreadyEnabled
+		}
+		step, ok := totpValidate(record.Secret, code, time.Now(), record.LastStep)
+		if !ok {
+			return errInvalidTOTP
+		}
+		record.Enabled = true
+		record.LastStep = step
+		am.storeTOTP(tx, cfAccount, record)
+		return nil
+	})
+}
+
+// DisableTOTP implements NS 2FA DISABLE; it requires a valid code or backup code.
+func (am *AccountManager) DisableTOTP(cfAccount, code string) (err error) {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if err := am.consumeTOTPCode(tx, cfAccount, code); err != nil {
+			return err
+		}
+		tx.Delete(fmt.Sprintf(keyAccountTOTP, cfAccount))
+		return nil
+	})
+}
+
+// GenerateTOTPBackupCodes implements NS 2FA BACKUP, replacing any existing
+// backup codes with new ones.
+func (am *AccountManager) GenerateTOTPBackupCodes(cfAccount string) (codes []string, err error) {
+	codes = make([]string, totpBackupCodeCount)
+	hashes := make([]string, totpBackupCodeCount)
+	for i := range codes {
+		var buf [6]byte
+		rand.Read(buf[:])
+		codes[i] = utils.B32Encoder.EncodeToString(buf[:])
+		hashes[i] = hashBackupCode(codes[i])
+	}
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		record, found := am.loadTOTP(tx, cfAccount)
+		if !found || !record.Enabled {
+			return errTOTPNotEnabled
+		}
+		record.BackupCodes = hashes
+		am.storeTOTP(tx, cfAccount, record)
+		return nil
+	})
+	return
+}
+
+// consumeTOTPCode checks a TOTP code or backup code for an account with 2FA
+// enabled, recording its use so that it can't be used again.
+func (am *AccountManager) consumeTOTPCode(tx *buntdb.Tx, cfAccount, code string) error {
+	record, found := am.loadTOTP(tx, cfAccount)
+	if !found || !record.Enabled {
+		return errTOTPNotEnabled
+	}
+	if step, ok := totpValidate(record.Secret, code, time.Now(), record.LastStep); ok {
+		record.LastStep = step
+		am.storeTOTP(tx, cfAccount, record)
+		return nil
+	}
+	hash := hashBackupCode(code)
+	for i, backupCode := range record.BackupCodes {
+		if subtle.ConstantTimeCompare([]byte(backupCode), []byte(hash)) == 1 {
+			record.BackupCodes = append(record.BackupCodes[:i], record.BackupCodes[i+1:]...)
+			am.storeTOTP(tx, cfAccount, record)
+			return nil
+		}
+	}
+	return errInvalidTOTP
+}
+
+// totpEnabled returns whether the account has completed 2FA enrollment.
+func (am *AccountManager) totpEnabled(cfAccount string) (enabled bool) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		record, found := am.loadTOTP(tx, cfAccount)
+		enabled = found && record.Enabled
+		return nil
+	})
+	return
+}
+
+// checkTOTP enforces two-factor authentication on a password login: if the
+// account has 2FA enabled, a valid code (or backup code) is required.
+// SASL has no way to carry the code, so SASL logins pass "" and are refused.
+// It must be called after the password was verified; on success, it clears
+// the account's login failures.
+func (am *AccountManager) checkTOTP(cfAccount, code string) (err error) {
+	if am.totpEnabled(cfAccount) {
+		if code == "" {
+			return errTOTPRequired
+		}
+		err = am.server.store.Update(func(tx *buntdb.Tx) error {
+			return am.consumeTOTPCode(tx, cfAccount, code)
+		})
+	}
+	if err == nil {
+		am.clearLoginFailures(cfAccount)
+	} else if err == errInvalidTOTP {
+		am.recordLoginFailure(cfAccount)
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestTOTP(t *testing.T) {
+	// test vectors from RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	assertEqual(totpCode(secret, 59/totpPeriod), "287082", t)
+	assertEqual(totpCode(secret, 1111111109/totpPeriod), "081804", t)
+
+	secretB32 := totpEncoding.EncodeToString(secret)
+	now := time.Unix(1111111109, 0)
+	step, ok := totpValidate(secretB32, "081804", now, 0)
+	assertEqual(ok, true, t)
+	assertEqual(step, int64(1111111109/totpPeriod), t)
+	// codes can't be reused:
+	_, ok = totpValidate(secretB32, "081804", now, step)
+	assertEqual(ok, false, t)
+	_, ok = totpValidate(secretB32, "000000", now, 0)
+	assertEqual(ok, false, t)
+
+	assertEqual(totpURI("Example Net", "alice", "ABC"), "otpauth://totp/Example%20Net:alice?issuer=Example+Net&secret=ABC", t)
+}
+
+func TestTOTPBlocksSCRAM(t *testing.T) {
+	server := newTestAccountServer(t)
+	am := &server.accounts
+	if err := am.SARegister("alice", "hunter2hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testSCRAMLogin(am, "alice", "hunter2hunter2"); err != nil {
+		t.Fatalf("SCRAM should succeed without 2FA: %v", err)
+	}
+
+	server.store.Update(func(tx *buntdb.Tx) error {
+		am.storeTOTP(tx, "alice", totpRecord{Secret: generateTOTPSecret(), Enabled: true})
+		return nil
+	})
+	assertEqual(testSCRAMLogin(am, "alice", "hunter2hunter2"), errTOTPRequired, t)
+}
+
+func TestTOTPLoginLockout(t *testing.T) {
+	server := newTestAccountServer(t)
+	config := *server.Config()
+	config.Accounts.LoginLockout = LoginLockoutConfig{Enabled: true, MaxAttempts: 3, Window: time.Minute, Duration: time.Minute}
+	server.SetConfig(&config)
+	am := &server.accounts
+	if err := am.SARegister("alice", "hunter2hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	server.store.Update(func(tx *buntdb.Tx) error {
+		am.storeTOTP(tx, "alice", totpRecord{Secret: generateTOTPSecret(), Enabled: true})
+		return nil
+	})
+
+	// the correct password must not reset the failures from wrong codes
+	for i := 0; i < 3; i++ {
+		_, err := am.checkPassphrase("alice", "hunter2hunter2")
+		assertEqual(err, nil, t)
+		assertEqual(am.checkTOTP("alice", "12345"), errInvalidTOTP, t)
+	}
+	if _, ok := am.checkLockout("alice").(*AccountLockedError); !ok {
+		t.Fatal("repeated wrong TOTP codes should lock the account")
+	}
+	if _, err := am.checkPassphrase("alice", "hunter2hunter2"); err == nil {
+		t.Error("the correct password should be rejected while the account is locked")
+	}
+}
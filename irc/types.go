@@ -33,8 +33,11 @@ func (clients ClientSet) Has(client *Client) bool {
 }
 
 type memberData struct {
-	modes    *modes.ModeSet
-	joinTime int64
+	modes      *modes.ModeSet
+	joinTime   int64
+	flood      *floodBucket
+	amodeTimer *time.Timer // pending application of persistent modes (CS SET AMODE-DELAY)
+	lastMuted  int64       // last CS SET MUTE-NOTIFY notice about this member
 }
 
 // MemberSet is a set of members with modes.
@@ -45,11 +48,15 @@ func (members MemberSet) Add(member *Client) {
 	members[member] = memberData{
 		modes:    modes.NewModeSet(),
 		joinTime: time.Now().UnixNano(),
+		flood:    new(floodBucket),
 	}
 }
 
 // Remove removes the given client from this set.
 func (members MemberSet) Remove(member *Client) {
+	if data, ok := members[member]; ok && data.amodeTimer != nil {
+		data.amodeTimer.Stop()
+	}
 	delete(members, member)
 }
 
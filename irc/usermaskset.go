@@ -29,6 +29,8 @@ type UserMaskSet struct {
 	masks                  map[string]MaskInfo
 	regexp                 unsafe.Pointer
 	muteRegexp             unsafe.Pointer
+	extbans                unsafe.Pointer // *extbanMatcher
+	muteExtbans            unsafe.Pointer // *extbanMatcher
 }
 
 func NewUserMaskSet() *UserMaskSet {
@@ -37,7 +39,7 @@ func NewUserMaskSet() *UserMaskSet {
 
 // Add adds the given mask to this set.
 func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskAdded string, err error) {
-	casefoldedMask, err := CanonicalizeMaskWildcard(mask)
+	casefoldedMask, err := CanonicalizeBanMask(mask)
 	if err != nil {
 		return
 	}
@@ -68,7 +70,7 @@ func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskA
 
 // Remove removes the given mask from this set.
 func (set *UserMaskSet) Remove(mask string) (maskRemoved string, err error) {
-	mask, err = CanonicalizeMaskWildcard(mask)
+	mask, err = CanonicalizeBanMask(mask)
 	if err != nil {
 		return
 	}
@@ -132,6 +134,19 @@ func (set *UserMaskSet) MuteRegexp() *regexp.Regexp {
 	return (*regexp.Regexp)(atomic.LoadPointer(&set.muteRegexp))
 }
 
+// MatchClient matches a client against the standard bans and the extbans;
+// it takes the casefolded n!u@h, the casefolded account name, and the realname.
+func (set *UserMaskSet) MatchClient(userhost, account, realname string) bool {
+	return set.Match(userhost) ||
+		(*extbanMatcher)(atomic.LoadPointer(&set.extbans)).match(account, realname)
+}
+
+// MatchMuteClient is like MatchClient, but for the mute extbans.
+func (set *UserMaskSet) MatchMuteClient(userhost, account, realname string) bool {
+	return set.MatchMute(userhost) ||
+		(*extbanMatcher)(atomic.LoadPointer(&set.muteExtbans)).match(account, realname)
+}
+
 func (set *UserMaskSet) Length() int {
 	set.RLock()
 	defer set.RUnlock()
@@ -141,10 +156,16 @@ func (set *UserMaskSet) Length() int {
 func (set *UserMaskSet) setRegexp() {
 	set.RLock()
 	maskExprs := make([]string, 0, len(set.masks))
-	var muteExprs []string
+	var muteExprs, extbans, muteExtbans []string
 	for mask := range set.masks {
 		if strings.HasPrefix(mask, "m:") {
-			muteExprs = append(muteExprs, mask[2:])
+			if isExtban(mask[2:]) {
+				muteExtbans = append(muteExtbans, mask[2:])
+			} else {
+				muteExprs = append(muteExprs, mask[2:])
+			}
+		} else if isExtban(mask) {
+			extbans = append(extbans, mask)
 		} else {
 			maskExprs = append(maskExprs, mask)
 		}
@@ -164,4 +185,6 @@ func (set *UserMaskSet) setRegexp() {
 
 	atomic.StorePointer(&set.regexp, unsafe.Pointer(re))
 	atomic.StorePointer(&set.muteRegexp, unsafe.Pointer(muteRe))
+	atomic.StorePointer(&set.extbans, unsafe.Pointer(compileExtbans(extbans)))
+	atomic.StorePointer(&set.muteExtbans, unsafe.Pointer(compileExtbans(muteExtbans)))
 }
@@ -137,6 +137,12 @@ func ParseProxyLine(line []byte) (ip net.IP, err error) {
 // ParseProxyLineV1 parses a PROXY protocol (v1) line and returns the remote IP.
 func ParseProxyLineV1(line string) (ip net.IP, err error) {
 	params := strings.Fields(line)
+	// "PROXY UNKNOWN" is the v1 equivalent of the v2 LOCAL command (e.g., a health check
+	// from the proxy itself); "the receiver must ignore anything presented before the
+	// CRLF is found", and use the real connection endpoints
+	if len(params) >= 2 && params[0] == "PROXY" && params[1] == "UNKNOWN" {
+		return nil, nil
+	}
 	if len(params) != 6 || params[0] != "PROXY" {
 		return nil, ErrBadProxyLine
 	}
@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package utils
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+const proxyV2Signature = "\x0d\x0a\x0d\x0a\x00\x0d\x0a\x51\x55\x49\x54\x0a"
+
+func appendUint16(buf []byte, val uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], val)
+	return append(buf, b[:]...)
+}
+
+// build a PROXY v2 header for a TCP connection from src to dst
+func makeProxyV2Header(command byte, src, dst net.IP, srcPort, dstPort uint16) []byte {
+	var family byte
+	var addrs []byte
+	if src4 := src.To4(); src4 != nil {
+		family = 0x11 // AF_INET, STREAM
+		addrs = append(append(addrs, src4...), dst.To4()...)
+	} else if src != nil {
+		family = 0x21 // AF_INET6, STREAM
+		addrs = append(append(addrs, src.To16()...), dst.To16()...)
+	}
+	if addrs != nil {
+		addrs = appendUint16(addrs, srcPort)
+		addrs = appendUint16(addrs, dstPort)
+	}
+	header := []byte(proxyV2Signature)
+	header = append(header, 0x20|command, family)
+	header = appendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestParseProxyLineV1(t *testing.T) {
+	ip, err := ParseProxyLineV1("PROXY TCP4 192.168.1.100 10.0.0.1 56324 6697\r\n")
+	assertEqual(err, nil, t)
+	assertEqual(ip, net.ParseIP("192.168.1.100").To16(), t)
+
+	ip, err = ParseProxyLineV1("PROXY TCP6 2001:db8::1 2001:db8::2 56324 6697\r\n")
+	assertEqual(err, nil, t)
+	assertEqual(ip, net.ParseIP("2001:db8::1"), t)
+
+	// health check from the proxy: use the real IP
+	ip, err = ParseProxyLineV1("PROXY UNKNOWN\r\n")
+	assertEqual(err, nil, t)
+	assertEqual(ip == nil, true, t)
+	ip, err = ParseProxyLineV1("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n")
+	assertEqual(err, nil, t)
+	assertEqual(ip == nil, true, t)
+
+	_, err = ParseProxyLineV1("PROXY TCP4 192.168.1.100 10.0.0.1 56324\r\n")
+	assertEqual(err, ErrBadProxyLine, t)
+	_, err = ParseProxyLineV1("PROXY TCP4 192.168.1.x 10.0.0.1 56324 6697\r\n")
+	assertEqual(err, ErrBadProxyLine, t)
+}
+
+func TestParseProxyLineV2(t *testing.T) {
+	ip, err := ParseProxyLine(makeProxyV2Header(1, net.ParseIP("192.168.1.100"), net.ParseIP("10.0.0.1"), 56324, 6697))
+	assertEqual(err, nil, t)
+	assertEqual(ip, net.ParseIP("192.168.1.100").To16(), t)
+
+	ip, err = ParseProxyLine(makeProxyV2Header(1, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 6697))
+	assertEqual(err, nil, t)
+	assertEqual(ip, net.ParseIP("2001:db8::1"), t)
+
+	// LOCAL command, e.g., a health check
+	ip, err = ParseProxyLine(makeProxyV2Header(0, nil, nil, 0, 0))
+	assertEqual(err, nil, t)
+	assertEqual(ip == nil, true, t)
+
+	// unknown command
+	_, err = ParseProxyLine(makeProxyV2Header(2, net.ParseIP("192.168.1.100"), net.ParseIP("10.0.0.1"), 56324, 6697))
+	assertEqual(err, ErrBadProxyLine, t)
+	// truncated addresses
+	header := makeProxyV2Header(1, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 6697)
+	_, err = ParseProxyLine(header[:30])
+	assertEqual(err, ErrBadProxyLine, t)
+}
+
+func TestReadRawProxyLine(t *testing.T) {
+	v2Header := makeProxyV2Header(1, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 6697)
+	for _, header := range [][]byte{
+		[]byte("PROXY TCP4 192.168.1.100 10.0.0.1 56324 6697\r\n"),
+		v2Header,
+		makeProxyV2Header(0, nil, nil, 0, 0),
+	} {
+		client, server := net.Pipe()
+		go func() {
+			// the header must be consumed without reading into the following data
+			client.Write(header)
+			client.Write([]byte("NICK foo\r\n"))
+			client.Close()
+		}()
+		line, err := readRawProxyLine(server, time.Second)
+		assertEqual(err, nil, t)
+		assertEqual(line, header, t)
+		rest := make([]byte, 10)
+		n, _ := server.Read(rest)
+		assertEqual(string(rest[:n]), "NICK foo\r\n", t)
+		server.Close()
+	}
+}
@@ -6,8 +6,25 @@ package utils
 import (
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// TrimIncompleteUTF8 removes an incomplete UTF-8 sequence from the end of
+// a string that was truncated at an arbitrary byte offset. At most
+// utf8.UTFMax-1 bytes are removed, so a string that was not UTF-8 to begin
+// with is not truncated further than that.
+func TrimIncompleteUTF8(message string) string {
+	for i := 0; i < utf8.UTFMax-1; i++ {
+		r, n := utf8.DecodeLastRuneInString(message)
+		if r == utf8.RuneError && n == 1 {
+			message = message[:len(message)-1]
+		} else {
+			break
+		}
+	}
+	return message
+}
+
 func IsRestrictedCTCPMessage(message string) bool {
 	// block all CTCP privmsgs to Tor clients except for ACTION
 	// DCC can potentially be used for deanonymization, the others for fingerprinting
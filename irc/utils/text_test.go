@@ -43,3 +43,40 @@ func TestBuildTokenLines(t *testing.T) {
 	val = BuildTokenLines(10, []string{"abcd", "efgh", "ijkl"}, ",")
 	assertEqual(val, []string{"abcd,efgh", "ijkl"}, t)
 }
+
+func TestIsRestrictedCTCPMessage(t *testing.T) {
+	assertEqual(IsRestrictedCTCPMessage("\x01VERSION\x01"), true, t)
+	assertEqual(IsRestrictedCTCPMessage("\x01PING 1234\x01"), true, t)
+	assertEqual(IsRestrictedCTCPMessage("\x01ACTION waves\x01"), false, t)
+	assertEqual(IsRestrictedCTCPMessage("hello \x01world\x01"), false, t)
+
+	sm := MakeMessage("hi")
+	assertEqual(sm.IsRestrictedCTCPMessage(), false, t)
+	sm.Append("\x01DCC SEND x\x01", false)
+	assertEqual(sm.IsRestrictedCTCPMessage(), true, t)
+}
+
+func TestTrimIncompleteUTF8(t *testing.T) {
+	assertEqual(TrimIncompleteUTF8(""), "", t)
+	assertEqual(TrimIncompleteUTF8("abc"), "abc", t)
+
+	// 2-byte sequence: é is c3 a9
+	assertEqual(TrimIncompleteUTF8("caf\xc3"), "caf", t)
+	assertEqual(TrimIncompleteUTF8("café"), "café", t)
+
+	// 3-byte sequence: € is e2 82 ac
+	assertEqual(TrimIncompleteUTF8("5\xe2"), "5", t)
+	assertEqual(TrimIncompleteUTF8("5\xe2\x82"), "5", t)
+	assertEqual(TrimIncompleteUTF8("5€"), "5€", t)
+
+	// 4-byte sequence: 😀 is f0 9f 98 80
+	assertEqual(TrimIncompleteUTF8("hi\xf0"), "hi", t)
+	assertEqual(TrimIncompleteUTF8("hi\xf0\x9f"), "hi", t)
+	assertEqual(TrimIncompleteUTF8("hi\xf0\x9f\x98"), "hi", t)
+	assertEqual(TrimIncompleteUTF8("hi😀"), "hi😀", t)
+
+	// a literal replacement character is valid and is kept
+	assertEqual(TrimIncompleteUTF8("a\uFFFD"), "a\uFFFD", t)
+	// non-UTF-8 input loses at most 3 bytes
+	assertEqual(TrimIncompleteUTF8("a\xff\xff\xff\xff"), "a\xff", t)
+}
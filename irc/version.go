@@ -15,11 +15,14 @@ var (
 	Ver = fmt.Sprintf("ergo-%s", SemVer)
 	// Commit is the full git hash, if available
 	Commit string
+	// BuildDate is the time the binary was built, if available
+	BuildDate string
 )
 
 // initialize version strings (these are set in package main via linker flags)
-func SetVersionString(version, commit string) {
+func SetVersionString(version, commit, buildDate string) {
 	Commit = commit
+	BuildDate = buildDate
 	if version != "" {
 		Ver = fmt.Sprintf("ergo-%s", version)
 	} else if len(Commit) == 40 {
@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+const (
+	// account lifecycle events:
+	EventRegister   = "register"
+	EventVerify     = "verify"
+	EventSuspend    = "suspend"
+	EventUnregister = "unregister"
+
+	// SignatureHeader carries `sha256=<hex HMAC-SHA256 of the body, keyed with the secret>`
+	SignatureHeader = "X-Ergo-Signature"
+
+	defaultQueueSize   = 1024
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	requestTimeout     = 10 * time.Second
+)
+
+// Config is one entry of `accounts.webhooks`.
+type Config struct {
+	URL    string
+	Events []string
+	Secret string
+}
+
+func (config *Config) wants(event string) bool {
+	// no list of events means all of them
+	if len(config.Events) == 0 {
+		return true
+	}
+	for _, e := range config.Events {
+		if strings.EqualFold(e, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateConfig(configs []Config) error {
+	for _, config := range configs {
+		if !(strings.HasPrefix(config.URL, "http://") || strings.HasPrefix(config.URL, "https://")) {
+			return fmt.Errorf("invalid webhook URL: %s", config.URL)
+		}
+		for _, event := range config.Events {
+			switch strings.ToLower(event) {
+			case EventRegister, EventVerify, EventSuspend, EventUnregister:
+			default:
+				return fmt.Errorf("invalid webhook event: %s", event)
+			}
+		}
+	}
+	return nil
+}
+
+// Payload is the JSON body POSTed to the webhook. To limit the exposure of
+// personal data, it contains only the domain of the email address and the
+// network (/24 or /64) of the IP.
+type Payload struct {
+	Event       string    `json:"event"`
+	Account     string    `json:"account"`
+	EmailDomain string    `json:"emailDomain,omitempty"`
+	Network     string    `json:"network,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+func NewPayload(event, account, email string, ip net.IP) (payload Payload) {
+	payload.Event = event
+	payload.Account = account
+	if at := strings.LastIndexByte(email, '@'); at != -1 {
+		payload.EmailDomain = email[at+1:]
+	}
+	if ip != nil {
+		payload.Network = anonymizeIP(ip)
+	}
+	payload.Timestamp = time.Now().UTC()
+	return
+}
+
+func anonymizeIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// Sign computes the value of the signature header for a body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type delivery struct {
+	config Config
+	body   []byte
+}
+
+// Dispatcher delivers webhooks from a bounded queue per endpoint, each with
+// its own worker goroutine, so that a slow or failing endpoint never blocks
+// the caller or delays deliveries to the other endpoints. If an endpoint's
+// queue fills up (e.g., during an extended outage), new events are dropped.
+type Dispatcher struct {
+	logger      *logger.Manager
+	queueSize   int
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+
+	sync.Mutex
+	configs []Config
+	queues  map[string]chan delivery // endpoint URL to its worker's queue
+}
+
+func NewDispatcher(logger *logger.Manager) *Dispatcher {
+	return newDispatcher(logger, defaultQueueSize, defaultMaxAttempts, defaultBackoff)
+}
+
+func newDispatcher(logger *logger.Manager, queueSize, maxAttempts int, backoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		logger:      logger,
+		queueSize:   queueSize,
+		client:      &http.Client{Timeout: requestTimeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		queues:      make(map[string]chan delivery),
+	}
+}
+
+// SetConfig starts a worker for each newly configured endpoint, and stops
+// the workers of removed endpoints once their queues are drained.
+func (d *Dispatcher) SetConfig(configs []Config) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.configs = configs
+	urls := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		urls[config.URL] = true
+		if _, ok := d.queues[config.URL]; !ok {
+			queue := make(chan delivery, d.queueSize)
+			d.queues[config.URL] = queue
+			go d.run(queue)
+		}
+	}
+	for url, queue := range d.queues {
+		if !urls[url] {
+			close(queue)
+			delete(d.queues, url)
+		}
+	}
+}
+
+// Dispatch enqueues a payload for every webhook subscribed to its event.
+func (d *Dispatcher) Dispatch(payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	// hold the lock while enqueueing (which never blocks),
+	// so that SetConfig can't close a queue out from under us
+	d.Lock()
+	defer d.Unlock()
+	for _, config := range d.configs {
+		if !config.wants(payload.Event) {
+			continue
+		}
+		select {
+		case d.queues[config.URL] <- delivery{config: config, body: body}:
+		default:
+			d.logger.Warning("webhooks", "queue full, dropping event", payload.Event, "for", config.URL)
+		}
+	}
+}
+
+func (d *Dispatcher) run(queue chan delivery) {
+	for delivery := range queue {
+		d.deliver(delivery)
+	}
+}
+
+func (d *Dispatcher) deliver(delivery delivery) {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		err := d.post(delivery)
+		if err == nil {
+			return
+		}
+		if attempt == d.maxAttempts {
+			d.logger.Error("webhooks", "giving up on delivery to", delivery.config.URL, err.Error())
+			return
+		}
+		d.logger.Warning("webhooks", "failed delivery to", delivery.config.URL, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) post(delivery delivery) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.config.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if delivery.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(delivery.config.Secret, delivery.body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if !(200 <= resp.StatusCode && resp.StatusCode < 300) {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Ergo contributors
+// released under the MIT license
+
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+func testLogger(t *testing.T) *logger.Manager {
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+func TestNewPayload(t *testing.T) {
+	payload := NewPayload(EventRegister, "alice", "alice@example.com", net.ParseIP("192.0.2.77"))
+	if payload.EmailDomain != "example.com" || payload.Network != "192.0.2.0/24" {
+		t.Errorf("bad anonymization: %#v", payload)
+	}
+	payload = NewPayload(EventRegister, "alice", "", net.ParseIP("2001:db8:1:2:3:4:5:6"))
+	if payload.EmailDomain != "" || payload.Network != "2001:db8:1:2::/64" {
+		t.Errorf("bad anonymization: %#v", payload)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	const secret = "sesame"
+	var mutex sync.Mutex
+	attempts := 0
+	received := make(chan Payload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, body) {
+			t.Errorf("bad signature %s", r.Header.Get(SignatureHeader))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mutex.Lock()
+		attempts++
+		fail := attempts < 3
+		mutex.Unlock()
+		// fail the first two attempts to exercise retry
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	d := newDispatcher(testLogger(t), 8, 5, time.Millisecond)
+	d.SetConfig([]Config{
+		{URL: server.URL, Secret: secret, Events: []string{EventVerify}},
+		// not subscribed to verify; must not be called
+		{URL: server.URL + "/other", Secret: "wrong", Events: []string{EventSuspend}},
+	})
+	d.Dispatch(NewPayload(EventVerify, "alice", "alice@example.com", nil))
+
+	select {
+	case payload := <-received:
+		if payload.Event != EventVerify || payload.Account != "alice" {
+			t.Errorf("bad payload: %#v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook never delivered")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestDispatcherNonBlocking(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	d := newDispatcher(testLogger(t), 2, 1, time.Millisecond)
+	d.SetConfig([]Config{{URL: server.URL}})
+	done := make(chan struct{})
+	go func() {
+		// with the endpoint hung, this must overflow the queue and drop, not block
+		for i := 0; i < 10; i++ {
+			d.Dispatch(NewPayload(EventRegister, "alice", "", nil))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dispatch blocked")
+	}
+}
+
+func TestDispatcherIndependentEndpoints(t *testing.T) {
+	block := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer hung.Close()
+	defer close(block)
+	received := make(chan struct{}, 1)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer healthy.Close()
+
+	d := newDispatcher(testLogger(t), 8, 1, time.Millisecond)
+	d.SetConfig([]Config{{URL: hung.URL}, {URL: healthy.URL}})
+	// a hung endpoint must not delay deliveries to the others:
+	d.Dispatch(NewPayload(EventRegister, "alice", "", nil))
+	d.Dispatch(NewPayload(EventRegister, "bob", "", nil))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("delivery to the healthy endpoint was delayed")
+		}
+	}
+
+	// removing an endpoint stops its worker:
+	d.SetConfig([]Config{{URL: healthy.URL}})
+	d.Lock()
+	numQueues := len(d.queues)
+	d.Unlock()
+	if numQueues != 1 {
+		t.Errorf("expected 1 queue, got %d", numQueues)
+	}
+}